@@ -0,0 +1,113 @@
+package ci
+
+import (
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/image"
+)
+
+// Analysis is the analysis of a single image (see image.InitializeData), as evaluated by the rules.
+type Analysis struct {
+	Image          string
+	Layers         []*image.Layer
+	Trees          []*filetree.FileTree
+	Efficiency     float64
+	Inefficiencies filetree.EfficiencySlice
+	Platform       string
+}
+
+// SizeBytes returns the total size of the layers of the image
+func (analysis *Analysis) SizeBytes() uint64 {
+	var size uint64
+	for _, layer := range analysis.Layers {
+		if layer != nil {
+			size += layer.History.Size
+		}
+	}
+	return size
+}
+
+// UserSizeBytes returns the total size of the layers above the base (first) layer, the layers added by the user
+func (analysis *Analysis) UserSizeBytes() uint64 {
+	var size uint64
+	for _, layer := range analysis.Layers {
+		if layer != nil && layer.Index > 0 {
+			size += layer.History.Size
+		}
+	}
+	return size
+}
+
+// CompressedSizeBytes returns the total size of the layer blobs of the image: the sizes given by the image manifest
+// (see image.Layer.BlobSize), falling back to the size of the blobs as read (see filetree.FileTree.BlobSize) for the
+// layers of the sources not providing them (e.g. a docker save archive, whose layers are not compressed)
+func (analysis *Analysis) CompressedSizeBytes() uint64 {
+	var size uint64
+	for _, layer := range analysis.Layers {
+		switch {
+		case layer == nil:
+		case layer.BlobSize > 0:
+			size += layer.BlobSize
+		case layer.Tree != nil:
+			size += layer.Tree.BlobSize
+		}
+	}
+	return size
+}
+
+// UncompressedSizeBytes returns the total size of the entries of the layers of the image (see
+// filetree.FileTree.ContentSize)
+func (analysis *Analysis) UncompressedSizeBytes() uint64 {
+	var size uint64
+	for _, layer := range analysis.Layers {
+		if layer != nil && layer.Tree != nil {
+			size += layer.Tree.ContentSize
+		}
+	}
+	return size
+}
+
+// LayerCount returns the number of layers of the image, including the metadata-only history entries (e.g. ENV, CMD)
+// when asked to
+func (analysis *Analysis) LayerCount(includeEmpty bool) int {
+	count := len(analysis.Layers)
+	if includeEmpty {
+		for _, layer := range analysis.Layers {
+			if layer != nil {
+				count += len(layer.Metadata)
+			}
+		}
+	}
+	return count
+}
+
+// WastedBytes returns the bytes wasted across the layers (see filetree.Efficiency), as shown in the details pane
+func (analysis *Analysis) WastedBytes() uint64 {
+	return analysis.wastedBytes(nil)
+}
+
+// WastedUserPercent returns the wasted bytes as a fraction of the size of the layers added by the user (0 when they
+// hold nothing)
+func (analysis *Analysis) WastedUserPercent() float64 {
+	return analysis.wastedUserPercent(nil)
+}
+
+// wastedBytes returns the bytes wasted across the layers, leaving out the paths the given filter (if any) excludes
+func (analysis *Analysis) wastedBytes(exclude func(data *filetree.EfficiencyData) bool) uint64 {
+	var wasted int64
+	for _, data := range analysis.Inefficiencies {
+		if exclude == nil || !exclude(data) {
+			wasted += data.CumulativeSize
+		}
+	}
+	return uint64(wasted)
+}
+
+// wastedUserPercent returns the wasted bytes (see wastedBytes) as a fraction of the size of the layers added by the
+// user
+func (analysis *Analysis) wastedUserPercent(exclude func(data *filetree.EfficiencyData) bool) float64 {
+	userSize := analysis.UserSizeBytes()
+	if userSize == 0 {
+		return 0
+	}
+	return float64(analysis.wastedBytes(exclude)) / float64(userSize)
+}
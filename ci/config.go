@@ -0,0 +1,256 @@
+package ci
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+// ConfigFileName is the name of the CI config file found in the working directory
+const ConfigFileName = ".dive-ci"
+
+// Config is a CI config file: the thresholds of the rules
+type Config struct {
+	Path  string
+	Rules map[string]RuleConfig
+}
+
+// RuleConfig is the configuration of a rule in a CI config file, with the line it is set on. A rule is set to its
+// threshold ("lowestEfficiency: 0.9"), or to a block of options holding the threshold ("threshold: 0.9"), the
+// severity ("severity: warn", SeverityError when empty), the allowlist of the rules measuring paths (the glob patterns
+// of the accepted exceptions, see Rule.Paths) and the options of the rule (see Rule.Options), by name.
+type RuleConfig struct {
+	Threshold string
+	Severity  Severity
+	Allow     []string
+	Options   map[string]string
+	Line      int
+}
+
+// FindConfig returns the path of the CI config file to load: the given path, or ConfigFileName in the working
+// directory when it exists (empty when there is none). A given path that does not exist is an error.
+func FindConfig(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("cannot read CI config '%s': %v", path, err)
+		}
+		return path, nil
+	}
+	if _, err := os.Stat(ConfigFileName); err == nil {
+		return ConfigFileName, nil
+	}
+	return "", nil
+}
+
+// LoadConfig reads a CI config file (see ParseConfig).
+func LoadConfig(path string) (*Config, []string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read CI config '%s': %v", path, err)
+	}
+	return ParseConfig(path, string(contents))
+}
+
+// ParseConfig parses (and validates) the contents of a CI config file, read from the given path. Unknown keys are
+// returned as warnings, malformed contents or thresholds are errors, both naming the offending line.
+func ParseConfig(path, contents string) (*Config, []string, error) {
+	config := &Config{Path: path, Rules: make(map[string]RuleConfig)}
+	var warnings []string
+	errorAt := func(line int, err error) error {
+		return fmt.Errorf("%s:%d: %v", path, line, err)
+	}
+	warn := func(line int, format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf("%s:%d: ", path, line)+fmt.Sprintf(format, args...))
+	}
+
+	root, err := parseConfigYAML(contents)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if !root.isMap {
+		return nil, nil, errorAt(root.line, fmt.Errorf("expected a mapping holding 'rules'"))
+	}
+
+	for _, section := range root.mapping {
+		if section.key != "rules" {
+			warn(section.value.line, "unknown key '%s' (ignored)", section.key)
+			continue
+		}
+		if !section.value.isMap {
+			if section.value.scalar == "" && !section.value.isList {
+				continue
+			}
+			return nil, nil, errorAt(section.value.line, fmt.Errorf("expected the rules beneath 'rules'"))
+		}
+
+		for _, entry := range section.value.mapping {
+			rule, ok := LookupRule(entry.key)
+			if !ok {
+				warn(entry.value.line, "unknown rule '%s' (ignored)", entry.key)
+				continue
+			}
+			ruleConfig, line, err := parseRuleConfig(rule, entry.value, warn)
+			if err != nil {
+				return nil, nil, errorAt(line, err)
+			}
+			if err := rule.Validate(ruleConfig.Threshold); err != nil {
+				return nil, nil, errorAt(ruleConfig.Line, err)
+			}
+			config.Rules[rule.Name] = ruleConfig
+		}
+	}
+	return config, warnings, nil
+}
+
+// parseRuleConfig returns the configuration of a rule, given as its threshold or as a block of options. An error is
+// returned along with the line it is found on.
+func parseRuleConfig(rule Rule, value *configValue, warn func(line int, format string, args ...interface{})) (RuleConfig, int, error) {
+	ruleConfig := RuleConfig{Threshold: value.scalar, Line: value.line}
+	switch {
+	case value.isList && rule.List:
+		threshold, line, err := joinList(value)
+		if err != nil {
+			return ruleConfig, line, err
+		}
+		ruleConfig.Threshold = threshold
+	case value.isList:
+		return ruleConfig, value.line, fmt.Errorf("expected a threshold, or a block of options")
+	case value.isMap:
+		ruleConfig.Threshold = ""
+		for _, option := range value.mapping {
+			switch option.key {
+			case "threshold":
+				if option.value.isList && rule.List {
+					threshold, line, err := joinList(option.value)
+					if err != nil {
+						return ruleConfig, line, err
+					}
+					ruleConfig.Threshold, ruleConfig.Line = threshold, option.value.line
+					continue
+				}
+				if option.value.isList || option.value.isMap {
+					return ruleConfig, option.value.line, fmt.Errorf("expected a threshold for 'threshold'")
+				}
+				ruleConfig.Threshold, ruleConfig.Line = option.value.scalar, option.value.line
+			case "severity":
+				if option.value.isList || option.value.isMap {
+					return ruleConfig, option.value.line, fmt.Errorf("expected warn or error for 'severity'")
+				}
+				severity, err := ParseSeverity(option.value.scalar)
+				if err != nil {
+					return ruleConfig, option.value.line, err
+				}
+				ruleConfig.Severity = severity
+			case "allow":
+				if !rule.Paths {
+					return ruleConfig, option.value.line, fmt.Errorf("rule '%s' measures no paths, it takes no 'allow' list", rule.Name)
+				}
+				allow, line, err := parseAllowlist(option.value)
+				if err != nil {
+					return ruleConfig, line, err
+				}
+				ruleConfig.Allow = allow
+			default:
+				ruleOption, ok := rule.LookupOption(option.key)
+				if !ok {
+					warn(option.value.line, "unknown rule option '%s' (ignored)", option.key)
+					continue
+				}
+				if option.value.isList || option.value.isMap {
+					return ruleConfig, option.value.line, fmt.Errorf("expected a value for '%s'", option.key)
+				}
+				if err := ruleOption.Validate(option.value.scalar); err != nil {
+					return ruleConfig, option.value.line, err
+				}
+				if ruleConfig.Options == nil {
+					ruleConfig.Options = make(map[string]string)
+				}
+				ruleConfig.Options[option.key] = option.value.scalar
+			}
+		}
+	}
+	return ruleConfig, value.line, nil
+}
+
+// parseAllowlist returns the glob patterns of an allowlist, given as a list or comma separated. An error is returned
+// along with the line it is found on.
+func parseAllowlist(value *configValue) ([]string, int, error) {
+	if value.isMap {
+		return nil, value.line, fmt.Errorf("expected a list of glob patterns for 'allow'")
+	}
+	list := value.scalar
+	if value.isList {
+		joined, line, err := joinList(value)
+		if err != nil {
+			return nil, line, err
+		}
+		list = joined
+	}
+	patterns := SplitList(list)
+	for _, pattern := range patterns {
+		if err := filetree.ValidateGlob(pattern); err != nil {
+			return nil, value.line, fmt.Errorf("invalid glob pattern '%s' in 'allow': %v", pattern, err)
+		}
+	}
+	return patterns, value.line, nil
+}
+
+// joinList returns the threshold of a List rule given as a list: its items, comma separated. An error is returned
+// along with the line it is found on.
+func joinList(value *configValue) (string, int, error) {
+	items := make([]string, 0, len(value.list))
+	for _, item := range value.list {
+		if item.isList || item.isMap {
+			return "", item.line, fmt.Errorf("expected a list of values")
+		}
+		if strings.Contains(item.scalar, ",") {
+			return "", item.line, fmt.Errorf("unsupported comma in '%s'", item.scalar)
+		}
+		items = append(items, item.scalar)
+	}
+	return strings.Join(items, ","), value.line, nil
+}
+
+// Thresholds returns the thresholds the config file sets, by rule name (see also Rules, for their options)
+func (config *Config) Thresholds() map[string]string {
+	thresholds := make(map[string]string, len(config.Rules))
+	for name, rule := range config.Rules {
+		if rule.Threshold != "" {
+			thresholds[name] = rule.Threshold
+		}
+	}
+	return thresholds
+}
+
+// ExampleConfig returns a commented CI config file setting every rule to its default threshold.
+func ExampleConfig() string {
+	var example strings.Builder
+	example.WriteString("# The rules evaluated by \"dive <image> --ci\" and their thresholds, e.g. in a " + ConfigFileName + " file next to the\n")
+	example.WriteString("# Dockerfile (found in the working directory, or given with --ci-config). Set a rule to \"disabled\" to skip it.\n")
+	example.WriteString("# Thresholds given on the command line override the ones set here.\n")
+	example.WriteString("# A rule exceeding its threshold fails the run, unless it is set to a block with \"severity: warn\" to only warn\n")
+	example.WriteString("# (--strict makes warnings fail again), e.g.:\n")
+	example.WriteString("#   highestUserWastedPercent:\n#     threshold: 0.1\n#     severity: warn\n")
+	example.WriteString("rules:\n")
+	for idx, rule := range Rules {
+		if idx > 0 {
+			example.WriteString("\n")
+		}
+		fmt.Fprintf(&example, "  # %s%s\n", strings.ToUpper(rule.Description[:1]), rule.Description[1:])
+		fmt.Fprintf(&example, "  %s: %s\n", rule.Name, rule.Default)
+		if len(rule.Options) > 0 || rule.Paths {
+			example.WriteString("  # or, setting its options (see below):\n")
+			fmt.Fprintf(&example, "  # %s:\n  #   threshold: %s\n", rule.Name, rule.Default)
+			if rule.Paths {
+				example.WriteString("  #   allow: [...]  # the glob patterns of the paths the rule leaves out (listed as excluded by allowlist)\n")
+			}
+			for _, option := range rule.Options {
+				fmt.Fprintf(&example, "  #   %s: ...  # %s\n", option.Name, option.Description)
+			}
+		}
+	}
+	return example.String()
+}
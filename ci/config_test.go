@@ -0,0 +1,233 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, warnings, err := ParseConfig(".dive-ci", `
+# thresholds of the image
+rules:
+  lowestEfficiency: 0.95   # stricter than the default
+  highestWastedBytes: "20MB"
+  highestUserWastedPercent:
+    threshold: disabled
+    color: blue
+  noSuchRule: 3
+plugins: []
+`)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	expected := map[string]string{
+		"lowestEfficiency":         "0.95",
+		"highestWastedBytes":       "20MB",
+		"highestUserWastedPercent": "disabled",
+	}
+	if thresholds := config.Thresholds(); !reflect.DeepEqual(thresholds, expected) {
+		t.Errorf("expected thresholds %v, got %v", expected, thresholds)
+	}
+	if config.Rules["highestWastedBytes"].Line != 5 || config.Rules["highestUserWastedPercent"].Line != 7 {
+		t.Errorf("unexpected lines: %+v", config.Rules)
+	}
+	expectedWarnings := []string{
+		".dive-ci:8: unknown rule option 'color' (ignored)",
+		".dive-ci:9: unknown rule 'noSuchRule' (ignored)",
+		".dive-ci:10: unknown key 'plugins' (ignored)",
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("expected warnings %v, got %v", expectedWarnings, warnings)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	cases := map[string]string{
+		"rules:\n  lowestEfficiency: 0.9\n  highestWastedBytes: lots\n":  ".dive-ci:3: invalid threshold 'lots' for rule 'highestWastedBytes'",
+		"rules:\n  lowestEfficiency:\n    threshold: 95\n":               ".dive-ci:3: invalid threshold '95' for rule 'lowestEfficiency'",
+		"rules:\n  lowestEfficiency: 0.9\n    highestWastedBytes: 1MB\n": ".dive-ci: line 3: mapping values are not allowed",
+		"rules:\n  lowestEfficiency: 0.9\n  lowestEfficiency: 0.8\n":     ".dive-ci: line 3: duplicate key 'lowestEfficiency'",
+		"rules:\n  lowestEfficiency 0.9\n":                               ".dive-ci:2: expected the rules beneath 'rules'",
+		"rules:\n  lowestEfficiency:\n    - 0.9\n":                       ".dive-ci:3: expected a threshold, or a block of options",
+		"- rules\n":             ".dive-ci:1: expected a mapping holding 'rules'",
+		"rules:\n  - &a [*a]\n": ".dive-ci: line 2: recursive alias 'a'",
+		"rules:\n  maxLayerCount:\n    threshold: 50\n    includeEmptyLayers: maybe\n": ".dive-ci:4: invalid value 'maybe' for option 'includeEmptyLayers'",
+	}
+	for contents, expected := range cases {
+		_, _, err := ParseConfig(".dive-ci", contents)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected an error starting with %q for:\n%s\ngot: %v", expected, contents, err)
+		}
+	}
+}
+
+func TestParseConfigRuleOptions(t *testing.T) {
+	config, warnings, err := ParseConfig(".dive-ci", `
+rules:
+  maxLayerCount:
+    threshold: 50
+    includeEmptyLayers: true
+  lowestEfficiency:
+    includeEmptyLayers: true
+`)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	rule := config.Rules["maxLayerCount"]
+	if rule.Threshold != "50" || !reflect.DeepEqual(rule.Options, map[string]string{"includeEmptyLayers": "true"}) {
+		t.Errorf("unexpected rule config: %+v", rule)
+	}
+	// options are specific to their rule
+	if len(warnings) != 1 || warnings[0] != ".dive-ci:7: unknown rule option 'includeEmptyLayers' (ignored)" {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestParseConfigSeverity(t *testing.T) {
+	config, _, err := ParseConfig(".dive-ci", `
+rules:
+  lowestEfficiency:
+    threshold: 0.9
+    severity: warn
+  maxLayerCount: 50
+`)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	if rule := config.Rules["lowestEfficiency"]; rule.Threshold != "0.9" || rule.Severity != SeverityWarn || len(rule.Options) != 0 {
+		t.Errorf("unexpected rule config: %+v", rule)
+	}
+	if rule := config.Rules["maxLayerCount"]; rule.Severity != "" {
+		t.Errorf("expected no severity, got %+v", rule)
+	}
+
+	_, _, err = ParseConfig(".dive-ci", "rules:\n  lowestEfficiency:\n    severity: fatal\n")
+	if err == nil || !strings.Contains(err.Error(), "invalid severity 'fatal'") {
+		t.Errorf("expected an invalid severity error, got %v", err)
+	}
+}
+
+func TestParseConfigAllowlist(t *testing.T) {
+	config, _, err := ParseConfig(".dive-ci", `
+rules:
+  forbiddenPaths:
+    threshold: ["**/id_rsa"]
+    allow:
+      - test/fixtures/**
+      - "**/testdata/*"
+  highestWastedBytes:
+    threshold: 20MB
+    allow: /data/*.bin, /opt/vendor/**
+`)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	if rule := config.Rules["forbiddenPaths"]; !reflect.DeepEqual(rule.Allow, []string{"test/fixtures/**", "**/testdata/*"}) {
+		t.Errorf("unexpected rule config: %+v", rule)
+	}
+	if rule := config.Rules["highestWastedBytes"]; !reflect.DeepEqual(rule.Allow, []string{"/data/*.bin", "/opt/vendor/**"}) {
+		t.Errorf("unexpected rule config: %+v", rule)
+	}
+
+	for contents, expected := range map[string]string{
+		"rules:\n  lowestEfficiency:\n    allow: [/data/**]\n":  ".dive-ci:3: rule 'lowestEfficiency' measures no paths",
+		"rules:\n  highestWastedBytes:\n    allow: [\"[a-\"]\n": ".dive-ci:3: invalid glob pattern '[a-'",
+	} {
+		if _, _, err := ParseConfig(".dive-ci", contents); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected an error starting with %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestParseConfigListThreshold(t *testing.T) {
+	config, warnings, err := ParseConfig(".dive-ci", `
+rules:
+  forbiddenPaths:
+    threshold:
+    - "**/.env"
+    - /root/.aws/**
+    checkAllLayers: true
+`)
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("could not parse: %v %v", err, warnings)
+	}
+	if rule := config.Rules["forbiddenPaths"]; rule.Threshold != "**/.env,/root/.aws/**" || rule.Options["checkAllLayers"] != "true" || rule.Line != 5 {
+		t.Errorf("unexpected rule config: %+v", rule)
+	}
+
+	config, _, err = ParseConfig(".dive-ci", "rules:\n  forbiddenPaths: ['**/*.pem', '**/id_rsa']\n")
+	if err != nil || config.Rules["forbiddenPaths"].Threshold != "**/*.pem,**/id_rsa" {
+		t.Errorf("unexpected config %+v (%v)", config, err)
+	}
+
+	// only list rules take lists
+	if _, _, err := ParseConfig(".dive-ci", "rules:\n  maxLayerCount: [1, 2]\n"); err == nil {
+		t.Error("expected an error for a list threshold")
+	}
+}
+
+func TestParseConfigYAML(t *testing.T) {
+	value, err := parseConfigYAML("a:\n  b: 'x: #y' # comment\n  c: [1, \"2, 3\"]\n  d:\n  - e\n  -   f\n")
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	a := value.mapping[0].value
+	if a.mapping[0].value.scalar != "x: #y" {
+		t.Errorf("unexpected scalar %q", a.mapping[0].value.scalar)
+	}
+	if list := a.mapping[1].value.list; len(list) != 2 || list[1].scalar != "2, 3" {
+		t.Errorf("unexpected flow list %+v", list)
+	}
+	if list := a.mapping[2].value.list; len(list) != 2 || list[0].scalar != "e" || list[1].scalar != "f" || list[1].line != 6 {
+		t.Errorf("unexpected block list %+v", list)
+	}
+
+	// flow mappings, aliases and nulls
+	value, err = parseConfigYAML("a: &x {b: 1}\nc: *x\nd: ~\n")
+	if err != nil || value.mapping[1].value.mapping[0].value.scalar != "1" || value.mapping[2].value.scalar != "" {
+		t.Errorf("unexpected value %+v (%v)", value, err)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	config, warnings, err := ParseConfig("example", ExampleConfig())
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("could not parse the example config: %v %v", err, warnings)
+	}
+	for _, rule := range Rules {
+		if config.Rules[rule.Name].Threshold != rule.Default {
+			t.Errorf("expected the default threshold of %s in the example, got %+v", rule.Name, config.Rules[rule.Name])
+		}
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dive-ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	if path, err := FindConfig(""); err != nil || path != "" {
+		t.Errorf("expected no config, got %q (%v)", path, err)
+	}
+	if _, err := FindConfig(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing config")
+	}
+	ioutil.WriteFile(ConfigFileName, []byte("rules:\n  lowestEfficiency: 0.5\n"), 0644)
+	path, err := FindConfig("")
+	if err != nil || path != ConfigFileName {
+		t.Fatalf("expected the config of the working directory, got %q (%v)", path, err)
+	}
+	config, _, err := LoadConfig(path)
+	if err != nil || config.Thresholds()["lowestEfficiency"] != "0.5" {
+		t.Errorf("unexpected config %+v (%v)", config, err)
+	}
+}
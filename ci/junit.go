@@ -0,0 +1,134 @@
+package ci
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// junitSuite is the JUnit XML representation of the results of the rules for an image
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// junitCase is the JUnit XML representation of the result of a single rule
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitSuites is the JUnit XML representation of the results of the rules for several images, a suite per image
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+// WriteJUnit writes the results of the rules for the given image as a JUnit XML test suite (named after the image), a
+// test case per rule: failed rules carry a failure with the measured value and threshold (and the details), disabled
+// rules are skipped, and warned rules pass with the warning in their output (which also lists the paths excluded by
+// the allowlist of the rule).
+func WriteJUnit(writer io.Writer, image string, results []Result) error {
+	return writeJUnitXML(writer, junitSuiteOf(image, results))
+}
+
+// WriteJUnitImages writes the results of the rules for several images as JUnit XML test suites, one per image (see
+// WriteJUnit), within a single "dive" element holding their totals.
+func WriteJUnitImages(writer io.Writer, images []ImageResults) error {
+	suites := junitSuites{Name: "dive", Suites: make([]junitSuite, 0, len(images))}
+	for _, image := range images {
+		suite := junitSuiteOf(image.Image, image.Results)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+	return writeJUnitXML(writer, suites)
+}
+
+// junitSuiteOf returns the test suite of the results of the rules for an image (see WriteJUnit)
+func junitSuiteOf(image string, results []Result) junitSuite {
+	suite := junitSuite{
+		Name:  image,
+		Tests: len(results),
+		Cases: make([]junitCase, 0, len(results)),
+	}
+	for _, result := range results {
+		testCase := junitCase{
+			Name:      result.Rule,
+			ClassName: "dive.rules",
+			Properties: []junitProperty{
+				{Name: "measured", Value: result.Measured},
+				{Name: "threshold", Value: result.Threshold},
+				{Name: "severity", Value: string(result.Severity)},
+			},
+			SystemOut: result.Description,
+		}
+		switch result.Status {
+		case Failed:
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s: measured %s, threshold %s", result.Rule, result.Measured, result.Threshold),
+				Type:    "RuleFailed",
+				Text:    strings.Join(result.Details, "\n"),
+			}
+		case Skipped:
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{Message: "rule " + Disabled}
+		case Warned:
+			// JUnit has no warnings: the case passes, with the warning in its output
+			testCase.SystemOut = strings.Join(append([]string{
+				fmt.Sprintf("WARNING: %s: measured %s, threshold %s", result.Rule, result.Measured, result.Threshold),
+			}, result.Details...), "\n") + "\n" + result.Description
+		}
+		if len(result.Excluded) > 0 {
+			testCase.SystemOut += "\nexcluded by allowlist:\n" + strings.Join(result.Excluded, "\n")
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	return suite
+}
+
+// writeJUnitXML writes the given (indented) JUnit XML element, after the XML header
+func writeJUnitXML(writer io.Writer, element interface{}) error {
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(element); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
@@ -0,0 +1,70 @@
+package ci
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	results, err := Evaluate(testAnalysis(), map[string]string{
+		"lowestEfficiency":         "0.8",
+		"highestWastedBytes":       "disabled",
+		"highestUserWastedPercent": "0.5",
+		"highestNewSetuidFiles":    "0",
+	})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteJUnit(&out, `registry.example.com/app:"<latest>"&`, results); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	report := out.String()
+
+	if !strings.HasPrefix(report, xml.Header) {
+		t.Errorf("expected an XML header:\n%s", report)
+	}
+	for _, expected := range []string{
+		// every rule but the three given a threshold is disabled
+		fmt.Sprintf(`<testsuite name="registry.example.com/app:&#34;&lt;latest&gt;&#34;&amp;" tests="%d" failures="1" errors="0" skipped="%d">`, len(Rules), len(Rules)-3),
+		`<testcase name="lowestEfficiency" classname="dive.rules">`,
+		`<failure message="highestNewSetuidFiles: measured 1, threshold &lt;= 0" type="RuleFailed">/usr/bin/tool (0755→4755, layer 1)</failure>`,
+		`<skipped message="rule disabled"></skipped>`,
+		`<property name="severity" value="error"></property>`,
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %s in the report:\n%s", expected, report)
+		}
+	}
+
+	var decoded junitSuite
+	if err := xml.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if decoded.Name != `registry.example.com/app:"<latest>"&` || len(decoded.Cases) != len(Rules) {
+		t.Errorf("unexpected suite: %+v", decoded)
+	}
+}
+
+func TestWriteJUnitImages(t *testing.T) {
+	passing, _ := Evaluate(testAnalysis(), map[string]string{"lowestEfficiency": "0.8", "highestUserWastedPercent": "disabled"})
+	failing, _ := Evaluate(testAnalysis(), map[string]string{"highestNewSetuidFiles": "0"})
+	images := []ImageResults{{Image: "api:1.0", Results: passing}, {Image: "worker:1.0", Results: failing}}
+
+	var out bytes.Buffer
+	if err := WriteJUnitImages(&out, images); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	var decoded junitSuites
+	if err := xml.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	// the defaults fail the efficiency and the wasted fraction of the second image
+	if len(decoded.Suites) != 2 || decoded.Suites[1].Name != "worker:1.0" || decoded.Tests != 2*len(Rules) || decoded.Failures != 3 {
+		t.Errorf("unexpected suites: %+v", decoded)
+	}
+}
@@ -0,0 +1,96 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// ExitRulesFailed is the exit code of a CI run where some rule failed, analysis errors exit with 1
+	ExitRulesFailed = 2
+)
+
+var statusColor = map[Status]*color.Color{
+	Passed:  color.New(color.FgGreen, color.Bold),
+	Failed:  color.New(color.FgRed, color.Bold),
+	Skipped: color.New(color.Faint),
+	Warned:  color.New(color.FgYellow, color.Bold),
+}
+
+// WriteTable writes the results of the rules as a table (rule, measured value, threshold, severity, result), followed
+// by the details of the failed (and warned) rules, the paths excluded by the allowlists, and the overall result.
+func WriteTable(writer io.Writer, results []Result) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "Rule\tMeasured\tThreshold\tSeverity\tResult")
+	for _, result := range results {
+		measured := result.Measured
+		if result.Status == Skipped {
+			measured = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", result.Rule, measured, result.Threshold, result.Severity, statusColor[result.Status].Sprint(result.Status))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if (result.Status == Failed || result.Status == Warned) && len(result.Details) > 0 {
+			fmt.Fprintf(writer, "\n%s:\n", result.Rule)
+			for _, detail := range result.Details {
+				fmt.Fprintf(writer, "  %s\n", detail)
+			}
+		}
+		if len(result.Excluded) > 0 {
+			fmt.Fprintf(writer, "\n%s, excluded by allowlist:\n", result.Rule)
+			for _, excluded := range result.Excluded {
+				fmt.Fprintf(writer, "  %s\n", excluded)
+			}
+		}
+	}
+
+	failed, warned := CountStatus(results, Failed), CountStatus(results, Warned)
+	var warnings string
+	if warned > 0 {
+		warnings = fmt.Sprintf("%d %s", warned, Pluralize(warned, "warning", "warnings"))
+	}
+	switch {
+	case failed > 0 && warned > 0:
+		_, err := fmt.Fprintln(writer, "\n"+statusColor[Failed].Sprintf("Result: FAIL (%d of %d rules failed, %s)", failed, len(results), warnings))
+		return err
+	case failed > 0:
+		_, err := fmt.Fprintln(writer, "\n"+statusColor[Failed].Sprintf("Result: FAIL (%d of %d rules failed)", failed, len(results)))
+		return err
+	case warned > 0:
+		_, err := fmt.Fprintln(writer, "\n"+statusColor[Warned].Sprintf("Result: PASS (%s)", warnings))
+		return err
+	}
+	_, err := fmt.Fprintln(writer, "\n"+statusColor[Passed].Sprint("Result: PASS"))
+	return err
+}
+
+// WriteImagesTable writes the overall results of the rules for several images as a table (image, failed rules,
+// warnings, result), followed by the aggregate result: failing when a rule failed on any image.
+func WriteImagesTable(writer io.Writer, images []ImageResults) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "Image\tFailed\tWarnings\tResult")
+	for _, image := range images {
+		status := Passed
+		if !AllPassed(image.Results) {
+			status = Failed
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%s\n", image.Image, CountStatus(image.Results, Failed), CountStatus(image.Results, Warned), statusColor[status].Sprint(status))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if failed := len(FailedImages(images)); failed > 0 {
+		_, err := fmt.Fprintln(writer, "\n"+statusColor[Failed].Sprintf("Result: FAIL (%d of %d images failed)", failed, len(images)))
+		return err
+	}
+	_, err := fmt.Fprintln(writer, "\n"+statusColor[Passed].Sprintf("Result: PASS (%d %s)", len(images), Pluralize(len(images), "image", "images")))
+	return err
+}
@@ -0,0 +1,610 @@
+package ci
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/wagoodman/dive/filetree"
+)
+
+// Disabled is the threshold of a rule that is not evaluated
+const Disabled = "disabled"
+
+// Status is the outcome of evaluating a rule
+type Status int
+
+const (
+	// Passed rules are within their threshold
+	Passed Status = iota
+	// Failed rules exceed their threshold
+	Failed
+	// Skipped rules are disabled
+	Skipped
+	// Warned rules exceed their threshold, but only warn (see SeverityWarn)
+	Warned
+)
+
+// Severity is how a rule exceeding its threshold affects a CI run
+type Severity string
+
+const (
+	// SeverityError rules fail the run when they exceed their threshold (the default)
+	SeverityError Severity = "error"
+	// SeverityWarn rules are reported as Warned when they exceed their threshold, without failing the run
+	SeverityWarn Severity = "warn"
+)
+
+// ParseSeverity returns the severity with the given name (warn or error), ignoring case
+func ParseSeverity(value string) (Severity, error) {
+	switch severity := Severity(strings.ToLower(strings.TrimSpace(value))); severity {
+	case SeverityError, SeverityWarn:
+		return severity, nil
+	}
+	return "", fmt.Errorf("invalid severity '%s' (expected warn or error)", value)
+}
+
+// String of a Status
+func (status Status) String() string {
+	switch status {
+	case Passed:
+		return "PASS"
+	case Failed:
+		return "FAIL"
+	case Skipped:
+		return "SKIP"
+	case Warned:
+		return "WARN"
+	default:
+		return fmt.Sprintf("%d", int(status))
+	}
+}
+
+// MarshalText encodes a Status as its name
+func (status Status) MarshalText() ([]byte, error) {
+	return []byte(status.String()), nil
+}
+
+// Result is the evaluation of a rule against an image. The measured value and the threshold are given both as raw
+// numbers (Value and Limit, e.g. bytes or a fraction) and formatted for people (Measured and Threshold). Details list
+// what makes a rule fail, when there is more to it than the measured value (e.g. the offending paths), and Excluded
+// the paths left out of the measurement by the allowlist of the rule. The Severity tells if exceeding the threshold
+// fails the run (Failed) or warns (Warned).
+type Result struct {
+	Rule        string   `json:"rule"`
+	Description string   `json:"description"`
+	Status      Status   `json:"status"`
+	Severity    Severity `json:"severity"`
+	Value       float64  `json:"value"`
+	Limit       float64  `json:"limit"`
+	Measured    string   `json:"measured"`
+	Threshold   string   `json:"threshold"`
+	Details     []string `json:"details,omitempty"`
+	Excluded    []string `json:"excluded,omitempty"`
+}
+
+// evaluator evaluates a rule (with a parsed threshold) against an image, leaving out the paths of the allowlist, and
+// fills in the result
+type evaluator func(analysis *Analysis, allow allowlist, result *Result)
+
+// allowlist holds the glob patterns of the paths a rule leaves out of its measurement (see RuleConfig.Allow)
+type allowlist []string
+
+// excludes indicates if the allowlist holds a pattern matching the given path, listing the path (with the given
+// detail of what it counts for) as excluded by the result when it does
+func (allow allowlist) excludes(result *Result, path, detail string) bool {
+	for _, pattern := range allow {
+		if matched, _ := filetree.MatchGlob(pattern, path); matched {
+			result.Excluded = append(result.Excluded, fmt.Sprintf("%s (%s, allowed by '%s')", path, detail, pattern))
+			return true
+		}
+	}
+	return false
+}
+
+// Rule is a check of an image against a configurable threshold (set with "rules.<name>" in the config, the command
+// line flag of the rule, or the CI config file; "disabled" skips it). The parse function validates a threshold,
+// returning the evaluator of the rule with that threshold and the (validated) options set in the CI config file.
+type Rule struct {
+	Name        string
+	Flag        string
+	Description string
+	Default     string
+	// List rules take a list (e.g. of patterns) as their threshold: comma separated, or a list in the CI config file
+	List bool
+	// Paths rules measure paths, which an allowlist in the CI config file may leave out (see RuleConfig.Allow)
+	Paths   bool
+	Options []RuleOption
+	parse   func(threshold string, options map[string]string) (evaluator, error)
+}
+
+// RuleOption is an option of a rule, set in the block of the rule in the CI config file
+type RuleOption struct {
+	Name        string
+	Description string
+	validate    func(value string) error
+}
+
+// Rules are all the rules evaluated in CI mode, in the order they are reported
+var Rules = []Rule{
+	{
+		Name:        "lowestEfficiency",
+		Flag:        "lowest-efficiency",
+		Description: "the lowest allowed image efficiency score (0-1)",
+		Default:     "0.9",
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := parseFraction(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, _ allowlist, result *Result) {
+				result.Value, result.Limit = analysis.Efficiency, limit
+				result.Measured, result.Threshold = FormatPercent(analysis.Efficiency), ">= "+FormatPercent(limit)
+				result.Status = statusOf(analysis.Efficiency >= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "highestWastedBytes",
+		Flag:        "highest-wasted-bytes",
+		Description: "the highest allowed bytes wasted across the layers (e.g. 20MB)",
+		Default:     Disabled,
+		Paths:       true,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := humanize.ParseBytes(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, allow allowlist, result *Result) {
+				wasted := analysis.wastedBytes(excludeWasted(allow, result))
+				result.Value, result.Limit = float64(wasted), float64(limit)
+				result.Measured, result.Threshold = humanize.Bytes(wasted), "<= "+humanize.Bytes(limit)
+				result.Status = statusOf(wasted <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "highestUserWastedPercent",
+		Flag:        "highest-user-wasted-percent",
+		Description: "the highest allowed wasted fraction (0-1) of the layers above the base layer",
+		Default:     "0.1",
+		Paths:       true,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := parseFraction(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, allow allowlist, result *Result) {
+				wasted := analysis.wastedUserPercent(excludeWasted(allow, result))
+				result.Value, result.Limit = wasted, limit
+				result.Measured, result.Threshold = FormatPercent(wasted), "<= "+FormatPercent(limit)
+				result.Status = statusOf(wasted <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "highestNewSetuidFiles",
+		Flag:        "highest-new-setuid-files",
+		Description: "the highest allowed number of files made setuid or setgid above the base layer",
+		Default:     Disabled,
+		Paths:       true,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := strconv.Atoi(threshold)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("expected a count of files")
+			}
+			return func(analysis *Analysis, allow allowlist, result *Result) {
+				var count int
+				for _, change := range filetree.FindPermissionChanges(analysis.Trees).Risky(0) {
+					if !change.BecameSetuid && !change.BecameSetgid {
+						continue
+					}
+					detail := fmt.Sprintf("%s, layer %d", change.ModeTransition(), change.Layer)
+					if allow.excludes(result, change.Path, detail) {
+						continue
+					}
+					count++
+					result.Details = append(result.Details, fmt.Sprintf("%s (%s)", change.Path, detail))
+				}
+				result.Value, result.Limit = float64(count), float64(limit)
+				result.Measured, result.Threshold = strconv.Itoa(count), "<= "+strconv.Itoa(limit)
+				result.Status = statusOf(count <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "highestCompressedImageSize",
+		Flag:        "highest-compressed-image-size",
+		Description: "the highest allowed sum of the (compressed) layer blob sizes (e.g. 500MB)",
+		Default:     Disabled,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := parseSize(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, _ allowlist, result *Result) {
+				size := analysis.CompressedSizeBytes()
+				result.Value, result.Limit = float64(size), float64(limit)
+				result.Measured = fmt.Sprintf("%s (%s uncompressed)", humanize.Bytes(size), humanize.Bytes(analysis.UncompressedSizeBytes()))
+				result.Threshold = "<= " + threshold
+				result.Status = statusOf(size <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "highestUncompressedImageSize",
+		Flag:        "highest-uncompressed-image-size",
+		Description: "the highest allowed sum of the sizes of the layer contents (e.g. 1.2GiB)",
+		Default:     Disabled,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := parseSize(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, _ allowlist, result *Result) {
+				size := analysis.UncompressedSizeBytes()
+				result.Value, result.Limit = float64(size), float64(limit)
+				result.Measured = fmt.Sprintf("%s (%s compressed)", humanize.Bytes(size), humanize.Bytes(analysis.CompressedSizeBytes()))
+				result.Threshold = "<= " + threshold
+				result.Status = statusOf(size <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "maxIndividualFileSize",
+		Flag:        "max-individual-file-size",
+		Description: "the largest allowed size of a single file stored by any layer, including removed files (e.g. 50MB)",
+		Default:     Disabled,
+		Paths:       true,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := parseSize(threshold)
+			if err != nil {
+				return nil, err
+			}
+			return func(analysis *Analysis, allow allowlist, result *Result) {
+				var count int
+				for _, file := range filetree.LargeFiles(analysis.Trees, int64(limit)+1) {
+					detail := fmt.Sprintf("%s, layer %d", humanize.Bytes(uint64(file.Size)), file.Layer)
+					if file.Removed {
+						detail += ", removed later but still stored"
+					}
+					if allow.excludes(result, file.Path, detail) {
+						continue
+					}
+					count++
+					result.Details = append(result.Details, fmt.Sprintf("%s (%s)", file.Path, detail))
+				}
+				result.Value, result.Limit = float64(count), 0
+				result.Measured = fmt.Sprintf("%d %s", count, Pluralize(count, "file", "files"))
+				result.Threshold = "none over " + threshold
+				result.Status = statusOf(count == 0)
+			}, nil
+		},
+	},
+	{
+		Name:        "maxLayerCount",
+		Flag:        "max-layer-count",
+		Description: "the highest allowed number of layers, not counting metadata-only history entries",
+		Default:     Disabled,
+		Options: []RuleOption{
+			{
+				Name:        "includeEmptyLayers",
+				Description: "count the metadata-only history entries (e.g. ENV, CMD) too: true or false",
+				validate:    validateBool,
+			},
+		},
+		parse: func(threshold string, options map[string]string) (evaluator, error) {
+			limit, err := strconv.Atoi(threshold)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("expected a count of layers")
+			}
+			includeEmpty, _ := strconv.ParseBool(options["includeEmptyLayers"])
+			return func(analysis *Analysis, _ allowlist, result *Result) {
+				count := analysis.LayerCount(includeEmpty)
+				result.Value, result.Limit = float64(count), float64(limit)
+				if includeEmpty {
+					result.Measured = fmt.Sprintf("%d history entries", count)
+				} else {
+					result.Measured = fmt.Sprintf("%d layers", count)
+				}
+				result.Threshold = "<= " + strconv.Itoa(limit)
+				result.Status = statusOf(count <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "maxLayerFileCount",
+		Flag:        "max-layer-file-count",
+		Description: "the highest allowed number of entries (files, directories, links) in a single layer",
+		Default:     Disabled,
+		parse: func(threshold string, _ map[string]string) (evaluator, error) {
+			limit, err := strconv.Atoi(threshold)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("expected a count of entries")
+			}
+			return func(analysis *Analysis, _ allowlist, result *Result) {
+				largest, largestLayer := 0, -1
+				for _, layer := range analysis.Layers {
+					if layer == nil || layer.Tree == nil {
+						continue
+					}
+					entries := layer.Tree.EntryStats().Entries
+					if entries > largest || largestLayer < 0 {
+						largest, largestLayer = entries, layer.Index
+					}
+					if entries > limit {
+						result.Details = append(result.Details, fmt.Sprintf("layer %d (%d entries, %s)", layer.Index, entries, layer.Command()))
+					}
+				}
+				result.Value, result.Limit = float64(largest), float64(limit)
+				result.Measured = fmt.Sprintf("%d %s", largest, Pluralize(largest, "entry", "entries"))
+				if largestLayer >= 0 {
+					result.Measured += fmt.Sprintf(" (layer %d)", largestLayer)
+				}
+				result.Threshold = "<= " + strconv.Itoa(limit)
+				result.Status = statusOf(largest <= limit)
+			}, nil
+		},
+	},
+	{
+		Name:        "forbiddenPaths",
+		Flag:        "forbidden-paths",
+		Description: "the glob patterns of the paths that must not be in the final filesystem (e.g. **/.env,**/*.pem)",
+		Default:     Disabled,
+		List:        true,
+		Paths:       true,
+		Options: []RuleOption{
+			{
+				Name:        "checkAllLayers",
+				Description: "check the paths of every layer, including those removed by a later layer: true or false",
+				validate:    validateBool,
+			},
+		},
+		parse: func(threshold string, options map[string]string) (evaluator, error) {
+			patterns := SplitList(threshold)
+			if len(patterns) == 0 {
+				return nil, fmt.Errorf("expected a list of glob patterns")
+			}
+			for _, pattern := range patterns {
+				if err := filetree.ValidateGlob(pattern); err != nil {
+					return nil, fmt.Errorf("invalid glob pattern '%s': %v", pattern, err)
+				}
+			}
+			allLayers, _ := strconv.ParseBool(options["checkAllLayers"])
+			return func(analysis *Analysis, allow allowlist, result *Result) {
+				paths, _ := filetree.FindForbiddenPaths(analysis.Trees, patterns, allLayers)
+				var count int
+				for _, path := range paths {
+					detail := fmt.Sprintf("matches '%s', added in layer %d", path.Pattern, path.Layer)
+					if path.Removed {
+						detail += ", removed later but still stored"
+					}
+					if allow.excludes(result, path.Path, detail) {
+						continue
+					}
+					count++
+					result.Details = append(result.Details, fmt.Sprintf("%s (%s)", path.Path, detail))
+				}
+				result.Value, result.Limit = float64(count), 0
+				result.Measured = fmt.Sprintf("%d %s", count, Pluralize(count, "path", "paths"))
+				result.Threshold = fmt.Sprintf("none of %d %s", len(patterns), Pluralize(len(patterns), "pattern", "patterns"))
+				result.Status = statusOf(count == 0)
+			}, nil
+		},
+	},
+}
+
+// LookupRule returns the rule with the given name
+func LookupRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Validate parses a threshold of the rule, returning an error for a malformed one ("disabled" is always valid)
+func (rule Rule) Validate(threshold string) error {
+	_, err := rule.prepare(threshold, nil)
+	return err
+}
+
+// LookupOption returns the option of the rule with the given name
+func (rule Rule) LookupOption(name string) (RuleOption, bool) {
+	for _, option := range rule.Options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	return RuleOption{}, false
+}
+
+// Validate checks a value of the option, returning an error for a malformed one
+func (option RuleOption) Validate(value string) error {
+	if option.validate == nil {
+		return nil
+	}
+	if err := option.validate(value); err != nil {
+		return fmt.Errorf("invalid value '%s' for option '%s': %v", value, option.Name, err)
+	}
+	return nil
+}
+
+// prepare returns the evaluator of the rule for the given threshold and options, nil when the rule is disabled
+func (rule Rule) prepare(threshold string, options map[string]string) (evaluator, error) {
+	threshold = strings.TrimSpace(threshold)
+	if threshold == "" || strings.EqualFold(threshold, Disabled) {
+		return nil, nil
+	}
+	evaluate, err := rule.parse(threshold, options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold '%s' for rule '%s': %v", threshold, rule.Name, err)
+	}
+	return evaluate, nil
+}
+
+// Validate checks the thresholds given by rule name, returning an error naming the first malformed one
+func Validate(thresholds map[string]string) error {
+	for _, rule := range Rules {
+		if threshold, ok := thresholds[rule.Name]; ok {
+			if err := rule.Validate(threshold); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate checks the analysis against every rule, with the thresholds given by rule name (rules without a threshold
+// use their default). A malformed threshold is an error, naming the rule.
+func Evaluate(analysis *Analysis, thresholds map[string]string) ([]Result, error) {
+	rules := make(map[string]RuleConfig, len(thresholds))
+	for name, threshold := range thresholds {
+		rules[name] = RuleConfig{Threshold: threshold}
+	}
+	return EvaluateRules(analysis, rules)
+}
+
+// EvaluateRules checks the analysis against every rule, configured by rule name (see Evaluate).
+func EvaluateRules(analysis *Analysis, rules map[string]RuleConfig) ([]Result, error) {
+	results := make([]Result, 0, len(Rules))
+	for _, rule := range Rules {
+		config, ok := rules[rule.Name]
+		if !ok {
+			config.Threshold = rule.Default
+		}
+		evaluate, err := rule.prepare(config.Threshold, config.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		result := Result{Rule: rule.Name, Description: rule.Description, Status: Skipped, Severity: SeverityError, Threshold: Disabled}
+		if config.Severity != "" {
+			result.Severity = config.Severity
+		}
+		if evaluate != nil {
+			evaluate(analysis, allowlist(config.Allow), &result)
+		}
+		if result.Status == Failed && result.Severity == SeverityWarn {
+			result.Status = Warned
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CountStatus returns the number of results with the given status
+func CountStatus(results []Result, status Status) int {
+	var count int
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// ImageResults are the results of the rules for one of several images evaluated in a single run
+type ImageResults struct {
+	Image   string
+	Results []Result
+}
+
+// FailedImages returns the images a rule failed on, in order
+func FailedImages(images []ImageResults) []string {
+	var failed []string
+	for _, image := range images {
+		if !AllPassed(image.Results) {
+			failed = append(failed, image.Image)
+		}
+	}
+	return failed
+}
+
+// AllPassed indicates if none of the results failed (warnings do not fail)
+func AllPassed(results []Result) bool {
+	for _, result := range results {
+		if result.Status == Failed {
+			return false
+		}
+	}
+	return true
+}
+
+// excludeWasted returns a filter of the wasted paths (see Analysis.wastedBytes) leaving out those of the allowlist,
+// listing them as excluded by the result
+func excludeWasted(allow allowlist, result *Result) func(data *filetree.EfficiencyData) bool {
+	return func(data *filetree.EfficiencyData) bool {
+		return allow.excludes(result, data.Path, humanize.Bytes(uint64(data.CumulativeSize))+" wasted")
+	}
+}
+
+// statusOf returns the status of a rule within (or not) its threshold
+func statusOf(within bool) Status {
+	if within {
+		return Passed
+	}
+	return Failed
+}
+
+// SplitList returns the (trimmed, non-empty) items of a comma separated list, the threshold of a List rule
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Pluralize returns the singular or plural form for the given count
+func Pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// validateBool checks a boolean option
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("expected true or false")
+	}
+	return nil
+}
+
+// parseFraction parses a threshold between 0 and 1
+func parseFraction(value string) (float64, error) {
+	fraction, err := strconv.ParseFloat(value, 64)
+	if err != nil || fraction < 0 || fraction > 1 {
+		return 0, fmt.Errorf("expected a number between 0 and 1")
+	}
+	return fraction, nil
+}
+
+// sizePattern is a size with a unit of bytes: a number (without thousands separators) followed by B or a decimal (kB,
+// MB, ...) or binary (KiB, MiB, ...) multiple of it
+var sizePattern = regexp.MustCompile(`(?i)^[0-9]+(\.[0-9]+)?\s*([kmgtpe]i?)?b$`)
+
+// parseSize strictly parses a size threshold (e.g. 500MB, 1.2GiB): unlike humanize.ParseBytes, bare numbers and
+// abbreviated units (500M) are rejected, so a typo cannot silently change the unit
+func parseSize(value string) (uint64, error) {
+	if !sizePattern.MatchString(value) {
+		return 0, fmt.Errorf("expected a size with a unit (e.g. 500MB, 1.2GiB)")
+	}
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("expected a size with a unit (e.g. 500MB, 1.2GiB): %v", err)
+	}
+	return size, nil
+}
+
+// FormatPercent formats a fraction (0 to 1) as a percentage, with two decimals (e.g. "85.00 %"), as every report shows
+// them
+func FormatPercent(fraction float64) string {
+	return strconv.FormatFloat(100*fraction, 'f', 2, 64) + " %"
+}
@@ -0,0 +1,412 @@
+package ci
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/image"
+)
+
+func testAnalysis() *Analysis {
+	base := filetree.NewFileTree()
+	base.AddPath("/usr/bin/tool", filetree.FileInfo{Path: "usr/bin/tool", TypeFlag: tar.TypeReg, Mode: 0755})
+	upper := filetree.NewFileTree()
+	upper.AddPath("/usr/bin/tool", filetree.FileInfo{Path: "usr/bin/tool", TypeFlag: tar.TypeReg, Mode: os.ModeSetuid | 0755})
+	return &Analysis{
+		Image: "app:latest",
+		Layers: []*image.Layer{
+			{Index: 0, History: image.ImageHistoryEntry{Size: 1000}},
+			{Index: 1, History: image.ImageHistoryEntry{Size: 400}},
+		},
+		Trees:          []*filetree.FileTree{base, upper},
+		Efficiency:     0.85,
+		Inefficiencies: filetree.EfficiencySlice{{Path: "/usr/bin/tool", CumulativeSize: 100}},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	analysis := testAnalysis()
+	if analysis.SizeBytes() != 1400 || analysis.UserSizeBytes() != 400 || analysis.WastedBytes() != 100 || analysis.WastedUserPercent() != 0.25 {
+		t.Fatalf("unexpected measures: %d %d %d %v", analysis.SizeBytes(), analysis.UserSizeBytes(), analysis.WastedBytes(), analysis.WastedUserPercent())
+	}
+
+	results, err := Evaluate(analysis, map[string]string{
+		"lowestEfficiency":         "0.8",
+		"highestWastedBytes":       "50B",
+		"highestUserWastedPercent": "disabled",
+		"highestNewSetuidFiles":    "0",
+	})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	expected := map[string]Status{
+		"lowestEfficiency":         Passed,
+		"highestWastedBytes":       Failed,
+		"highestUserWastedPercent": Skipped,
+		"highestNewSetuidFiles":    Failed,
+		// disabled by default
+		"highestCompressedImageSize":   Skipped,
+		"highestUncompressedImageSize": Skipped,
+		"maxIndividualFileSize":        Skipped,
+		"maxLayerCount":                Skipped,
+		"maxLayerFileCount":            Skipped,
+		"forbiddenPaths":               Skipped,
+	}
+	if len(results) != len(Rules) {
+		t.Fatalf("expected a result per rule, got %+v", results)
+	}
+	for _, result := range results {
+		if result.Status != expected[result.Rule] {
+			t.Errorf("%s: expected %v, got %v (%+v)", result.Rule, expected[result.Rule], result.Status, result)
+		}
+	}
+	if results[1].Value != 100 || results[1].Limit != 50 || results[1].Measured != "100 B" || results[1].Threshold != "<= 50 B" {
+		t.Errorf("unexpected wasted bytes result: %+v", results[1])
+	}
+	if len(results[3].Details) != 1 || !strings.Contains(results[3].Details[0], "/usr/bin/tool (0755→4755, layer 1)") {
+		t.Errorf("unexpected setuid details: %+v", results[3].Details)
+	}
+	if AllPassed(results) {
+		t.Error("expected the results to fail")
+	}
+
+	// defaults: an efficiency of 85% fails the default of 90%
+	results, err = Evaluate(analysis, nil)
+	if err != nil || results[0].Status != Failed || results[1].Status != Skipped {
+		t.Errorf("unexpected default results (%v): %+v", err, results)
+	}
+}
+
+func TestEvaluateInvalidThreshold(t *testing.T) {
+	for name, threshold := range map[string]string{
+		"lowestEfficiency":      "95",
+		"highestWastedBytes":    "lots",
+		"highestNewSetuidFiles": "-1",
+	} {
+		_, err := Evaluate(testAnalysis(), map[string]string{name: threshold})
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected an error naming %s, got %v", name, err)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	color.NoColor = true
+	results, _ := Evaluate(testAnalysis(), map[string]string{"lowestEfficiency": "0.8", "highestNewSetuidFiles": "0"})
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, results); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	for _, expected := range []string{
+		"Rule                          Measured  Threshold   Severity  Result",
+		"lowestEfficiency              85.00 %   >= 80.00 %  error     PASS",
+		"/usr/bin/tool (0755→4755, layer 1)",
+		fmt.Sprintf("Result: FAIL (2 of %d rules failed)", len(Rules)),
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestEvaluateSeverity(t *testing.T) {
+	color.NoColor = true
+	results, err := EvaluateRules(testAnalysis(), map[string]RuleConfig{
+		"lowestEfficiency":         {Threshold: "0.9", Severity: SeverityWarn},
+		"highestNewSetuidFiles":    {Threshold: "1", Severity: SeverityWarn},
+		"highestUserWastedPercent": {Threshold: Disabled},
+	})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	// only rules exceeding their threshold warn
+	if results[0].Status != Warned || results[0].Severity != SeverityWarn || results[3].Status != Passed {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if !AllPassed(results) {
+		t.Error("expected warnings not to fail the results")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, results); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	for _, expected := range []string{
+		"lowestEfficiency              85.00 %   >= 90.00 %  warn      WARN",
+		"Result: PASS (1 warning)",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	for value, expected := range map[string]Severity{"warn": SeverityWarn, " Error": SeverityError} {
+		if severity, err := ParseSeverity(value); err != nil || severity != expected {
+			t.Errorf("expected %q for %q, got %q (%v)", expected, value, severity, err)
+		}
+	}
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Error("expected an error for an unknown severity")
+	}
+}
+
+func TestWriteImagesTable(t *testing.T) {
+	color.NoColor = true
+	passing, _ := Evaluate(testAnalysis(), map[string]string{"lowestEfficiency": "0.8", "highestUserWastedPercent": "disabled"})
+	failing, _ := Evaluate(testAnalysis(), nil)
+	images := []ImageResults{{Image: "api:1.0", Results: passing}, {Image: "worker:1.0", Results: failing}}
+	if failed := FailedImages(images); len(failed) != 1 || failed[0] != "worker:1.0" {
+		t.Errorf("unexpected failed images: %v", failed)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteImagesTable(&buf, images); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	for _, expected := range []string{
+		"Image       Failed  Warnings  Result",
+		"api:1.0     0       0         PASS",
+		"worker:1.0  2       0         FAIL",
+		"Result: FAIL (1 of 2 images failed)",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	buf.Reset()
+	WriteImagesTable(&buf, images[:1])
+	if !strings.Contains(buf.String(), "Result: PASS (1 image)") {
+		t.Errorf("expected the images to pass:\n%s", buf.String())
+	}
+}
+
+func TestImageSizeRules(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Layers[0].Tree = &filetree.FileTree{BlobSize: 300 * 1000 * 1000, ContentSize: 800 * 1000 * 1000}
+	analysis.Layers[1].Tree = &filetree.FileTree{BlobSize: 100 * 1000 * 1000, ContentSize: 500 * 1000 * 1000}
+	if analysis.CompressedSizeBytes() != 400*1000*1000 || analysis.UncompressedSizeBytes() != 1300*1000*1000 {
+		t.Fatalf("unexpected sizes: %d %d", analysis.CompressedSizeBytes(), analysis.UncompressedSizeBytes())
+	}
+
+	results, err := Evaluate(analysis, map[string]string{
+		"highestCompressedImageSize":   "500MB",
+		"highestUncompressedImageSize": "1.2GiB",
+	})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	compressed, uncompressed := results[4], results[5]
+	if compressed.Status != Passed || compressed.Measured != "400 MB (1.3 GB uncompressed)" || compressed.Threshold != "<= 500MB" {
+		t.Errorf("unexpected compressed size result: %+v", compressed)
+	}
+	if uncompressed.Status != Failed || uncompressed.Measured != "1.3 GB (400 MB compressed)" || uncompressed.Limit != 1288490188 {
+		t.Errorf("unexpected uncompressed size result: %+v", uncompressed)
+	}
+	// the blob size given by the manifest wins over the size of the blob as read
+	analysis.Layers[1].BlobSize = 80 * 1000 * 1000
+	if analysis.CompressedSizeBytes() != 380*1000*1000 {
+		t.Errorf("expected the manifest blob size to be used, got %d", analysis.CompressedSizeBytes())
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]uint64{
+		"500MB":  500 * 1000 * 1000,
+		"500 mb": 500 * 1000 * 1000,
+		"1.5KiB": 1536,
+		"10B":    10,
+		"2GiB":   2 << 30,
+		"0.5 TB": 500 * 1000 * 1000 * 1000,
+	} {
+		if actual, err := parseSize(value); err != nil || actual != expected {
+			t.Errorf("%s: expected %d, got %d (%v)", value, expected, actual, err)
+		}
+	}
+	for _, value := range []string{"500", "500M", "lots", "-1GB", "1,000MB", "500MBs", "MB", "1.GB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestMaxIndividualFileSizeRule(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Trees[0].AddPath("/opt/model.bin", filetree.FileInfo{Path: "opt/model.bin", TypeFlag: tar.TypeReg, LogicalBytes: 3000, StoredBytes: 3000})
+	analysis.Trees[0].AddPath("/tmp/core", filetree.FileInfo{Path: "tmp/core", TypeFlag: tar.TypeReg, LogicalBytes: 2000, StoredBytes: 2000})
+	analysis.Trees[0].AddPath("/etc/app.conf", filetree.FileInfo{Path: "etc/app.conf", TypeFlag: tar.TypeReg, LogicalBytes: 1000, StoredBytes: 1000})
+	analysis.Trees[1].AddPath("/tmp/.wh.core", filetree.FileInfo{Path: "tmp/.wh.core", TypeFlag: tar.TypeReg})
+	for idx, tree := range analysis.Trees {
+		tree.SetLayer(idx)
+	}
+
+	results, err := EvaluateRules(analysis, map[string]RuleConfig{"maxIndividualFileSize": {Threshold: "1kB"}})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	// a file of exactly the threshold is allowed, removed files are still stored by the layer adding them
+	result := resultOf(results, "maxIndividualFileSize")
+	expected := []string{"/opt/model.bin (3.0 kB, layer 0)", "/tmp/core (2.0 kB, layer 0, removed later but still stored)"}
+	if result.Status != Failed || result.Measured != "2 files" || result.Threshold != "none over 1kB" || !reflect.DeepEqual(result.Details, expected) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	results, _ = EvaluateRules(analysis, map[string]RuleConfig{"maxIndividualFileSize": {Threshold: "1kB", Allow: []string{"/opt/**", "/tmp/*"}}})
+	if result = resultOf(results, "maxIndividualFileSize"); result.Status != Passed || result.Measured != "0 files" || len(result.Excluded) != 2 {
+		t.Errorf("expected the allowed files to pass: %+v", result)
+	}
+
+	if _, err := EvaluateRules(analysis, map[string]RuleConfig{"maxIndividualFileSize": {Threshold: "1000"}}); err == nil {
+		t.Error("expected an error for a size without a unit")
+	}
+}
+
+func TestMaxLayerCountRule(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Layers[1].Metadata = []image.ImageHistoryEntry{{CreatedBy: "ENV A=b", EmptyLayer: true}, {CreatedBy: "CMD [\"app\"]", EmptyLayer: true}}
+
+	results, err := EvaluateRules(analysis, map[string]RuleConfig{"maxLayerCount": {Threshold: "3"}})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	result := resultOf(results, "maxLayerCount")
+	if result.Status != Passed || result.Measured != "2 layers" || result.Threshold != "<= 3" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	results, err = EvaluateRules(analysis, map[string]RuleConfig{"maxLayerCount": {Threshold: "3", Options: map[string]string{"includeEmptyLayers": "true"}}})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	result = resultOf(results, "maxLayerCount")
+	if result.Status != Failed || result.Measured != "4 history entries" || result.Value != 4 {
+		t.Errorf("unexpected result counting the empty layers: %+v", result)
+	}
+}
+
+func TestMaxLayerFileCountRule(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Trees[1].AddPath("/usr/share/doc/a", filetree.FileInfo{Path: "usr/share/doc/a", TypeFlag: tar.TypeReg})
+	analysis.Trees[1].AddPath("/usr/share/doc/b", filetree.FileInfo{Path: "usr/share/doc/b", TypeFlag: tar.TypeReg})
+	for idx, layer := range analysis.Layers {
+		layer.Tree = analysis.Trees[idx]
+		layer.History.CreatedBy = "/bin/sh -c make install"
+	}
+
+	results, err := EvaluateRules(analysis, map[string]RuleConfig{"maxLayerFileCount": {Threshold: "2"}})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	result := resultOf(results, "maxLayerFileCount")
+	if result.Status != Failed || result.Value != 3 || result.Measured != "3 entries (layer 1)" || result.Threshold != "<= 2" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(result.Details) != 1 || !strings.HasPrefix(result.Details[0], "layer 1 (3 entries, ") {
+		t.Errorf("unexpected details: %v", result.Details)
+	}
+
+	results, _ = EvaluateRules(analysis, map[string]RuleConfig{"maxLayerFileCount": {Threshold: "3"}})
+	if result = resultOf(results, "maxLayerFileCount"); result.Status != Passed || len(result.Details) != 0 {
+		t.Errorf("expected the layers to pass: %+v", result)
+	}
+
+	if _, err := EvaluateRules(analysis, map[string]RuleConfig{"maxLayerFileCount": {Threshold: "many"}}); err == nil {
+		t.Error("expected an error for a malformed count")
+	}
+}
+
+func TestForbiddenPathsRule(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Trees[0].AddPath("/app/.env", filetree.FileInfo{Path: "app/.env", TypeFlag: tar.TypeReg})
+	analysis.Trees[0].AddPath("/root/.ssh/id_rsa", filetree.FileInfo{Path: "root/.ssh/id_rsa", TypeFlag: tar.TypeReg})
+	analysis.Trees[1].AddPath("/root/.ssh/.wh.id_rsa", filetree.FileInfo{Path: "root/.ssh/.wh.id_rsa", TypeFlag: tar.TypeReg})
+	for idx, tree := range analysis.Trees {
+		tree.SetLayer(idx)
+	}
+
+	results, err := EvaluateRules(analysis, map[string]RuleConfig{"forbiddenPaths": {Threshold: "**/.env, **/id_rsa"}})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	result := resultOf(results, "forbiddenPaths")
+	if result.Status != Failed || result.Measured != "1 path" || result.Threshold != "none of 2 patterns" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(result.Details) != 1 || result.Details[0] != "/app/.env (matches '**/.env', added in layer 0)" {
+		t.Errorf("unexpected details: %v", result.Details)
+	}
+
+	// removed files are still stored by the layer adding them
+	results, _ = EvaluateRules(analysis, map[string]RuleConfig{"forbiddenPaths": {Threshold: "**/id_rsa", Options: map[string]string{"checkAllLayers": "true"}}})
+	result = resultOf(results, "forbiddenPaths")
+	if result.Status != Failed || len(result.Details) != 1 || !strings.Contains(result.Details[0], "/root/.ssh/id_rsa (matches '**/id_rsa', added in layer 0, removed later") {
+		t.Errorf("unexpected result checking all layers: %+v", result)
+	}
+
+	results, _ = EvaluateRules(analysis, map[string]RuleConfig{"forbiddenPaths": {Threshold: "**/*.pem"}})
+	if result = resultOf(results, "forbiddenPaths"); result.Status != Passed || result.Measured != "0 paths" {
+		t.Errorf("expected no forbidden paths: %+v", result)
+	}
+
+	if _, err := EvaluateRules(analysis, map[string]RuleConfig{"forbiddenPaths": {Threshold: "[a-"}}); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func resultOf(results []Result, rule string) Result {
+	for _, result := range results {
+		if result.Rule == rule {
+			return result
+		}
+	}
+	return Result{}
+}
+
+func TestRuleAllowlist(t *testing.T) {
+	color.NoColor = true
+	analysis := testAnalysis()
+	analysis.Trees[0].AddPath("/test/fixtures/id_rsa", filetree.FileInfo{Path: "test/fixtures/id_rsa", TypeFlag: tar.TypeReg})
+	analysis.Trees[0].SetLayer(0)
+
+	results, err := EvaluateRules(analysis, map[string]RuleConfig{
+		"highestWastedBytes":    {Threshold: "50B", Allow: []string{"/usr/bin/*"}},
+		"highestNewSetuidFiles": {Threshold: "0", Allow: []string{"/usr/bin/tool"}},
+		"forbiddenPaths":        {Threshold: "**/id_rsa", Allow: []string{"test/fixtures/**"}},
+	})
+	if err != nil {
+		t.Fatalf("could not evaluate: %v", err)
+	}
+	for name, excluded := range map[string]string{
+		"highestWastedBytes":    "/usr/bin/tool (100 B wasted, allowed by '/usr/bin/*')",
+		"highestNewSetuidFiles": "/usr/bin/tool (0755→4755, layer 1, allowed by '/usr/bin/tool')",
+		"forbiddenPaths":        "/test/fixtures/id_rsa (matches '**/id_rsa', added in layer 0, allowed by 'test/fixtures/**')",
+	} {
+		result := resultOf(results, name)
+		if result.Status != Passed || result.Value != 0 || len(result.Details) != 0 || !reflect.DeepEqual(result.Excluded, []string{excluded}) {
+			t.Errorf("%s: unexpected result: %+v", name, result)
+		}
+	}
+	// the allowlist of a rule leaves the others (and the analysis) alone
+	if result := resultOf(results, "highestUserWastedPercent"); result.Status != Failed || len(result.Excluded) != 0 {
+		t.Errorf("unexpected user wasted result: %+v", result)
+	}
+	if analysis.WastedBytes() != 100 {
+		t.Errorf("expected the analysis to keep its wasted bytes, got %d", analysis.WastedBytes())
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, results); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if expected := "forbiddenPaths, excluded by allowlist:\n  /test/fixtures/id_rsa"; !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in:\n%s", expected, buf.String())
+	}
+}
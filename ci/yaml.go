@@ -0,0 +1,88 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configValue is a value of the CI config file: a scalar, a list, or a mapping (keeping the order of its keys), along
+// with the line it starts on, so problems can be reported where they are.
+type configValue struct {
+	line    int
+	scalar  string
+	list    []*configValue
+	mapping []configEntry
+	isList  bool
+	isMap   bool
+}
+
+// configEntry is a key of a mapping and its value
+type configEntry struct {
+	key   string
+	value *configValue
+}
+
+// parseConfigYAML parses the CI config file (YAML), keeping the line of every value. An empty file is an empty
+// mapping, and duplicate keys are rejected. Errors name the offending line.
+func parseConfigYAML(contents string) (*configValue, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(contents), &document); err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return &configValue{isMap: true}, nil
+	}
+	return convertConfigNode(document.Content[0], make(map[*yaml.Node]bool))
+}
+
+// convertConfigNode converts a node of the YAML document (and the nodes beneath it) to a configValue, resolving
+// aliases (the given ones are being resolved, an alias to one of them is recursive). Null scalars are empty.
+func convertConfigNode(node *yaml.Node, resolving map[*yaml.Node]bool) (*configValue, error) {
+	if node.Kind == yaml.AliasNode {
+		if resolving[node.Alias] {
+			return nil, fmt.Errorf("line %d: recursive alias '%s'", node.Line, node.Value)
+		}
+		resolving[node.Alias] = true
+		defer delete(resolving, node.Alias)
+		return convertConfigNode(node.Alias, resolving)
+	}
+	value := &configValue{line: node.Line}
+	switch node.Kind {
+	case yaml.MappingNode:
+		value.isMap = true
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			keyNode := node.Content[idx]
+			if keyNode.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a scalar key", keyNode.Line)
+			}
+			for _, entry := range value.mapping {
+				if entry.key == keyNode.Value {
+					return nil, fmt.Errorf("line %d: duplicate key '%s'", keyNode.Line, keyNode.Value)
+				}
+			}
+			entry, err := convertConfigNode(node.Content[idx+1], resolving)
+			if err != nil {
+				return nil, err
+			}
+			value.mapping = append(value.mapping, configEntry{key: keyNode.Value, value: entry})
+		}
+	case yaml.SequenceNode:
+		value.isList = true
+		for _, itemNode := range node.Content {
+			item, err := convertConfigNode(itemNode, resolving)
+			if err != nil {
+				return nil, err
+			}
+			value.list = append(value.list, item)
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!null" {
+			value.scalar = node.Value
+		}
+	default:
+		return nil, fmt.Errorf("line %d: unexpected YAML node", node.Line)
+	}
+	return value, nil
+}
@@ -1,17 +1,22 @@
 package cmd
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
 	"github.com/wagoodman/dive/image"
+	"github.com/wagoodman/dive/report"
 	"github.com/wagoodman/dive/ui"
 	"github.com/wagoodman/dive/utils"
 )
 
 // analyze takes a docker image tag, digest, or id and displays the
-// image analysis to the screen
+// image analysis to the screen (several images are analyzed without the UI, see analyzeImages)
 func analyze(cmd *cobra.Command, args []string) {
 	defer utils.Cleanup()
 	if len(args) == 0 {
@@ -21,18 +26,356 @@ func analyze(cmd *cobra.Command, args []string) {
 			return
 		}
 
-		fmt.Println("No image argument given")
+		logrus.Error("No image argument given")
 		cmd.Help()
 		utils.Exit(1)
 	}
 
 	userImage := args[0]
 	if userImage == "" {
-		fmt.Println("No image argument given")
+		logrus.Error("No image argument given")
 		cmd.Help()
 		utils.Exit(1)
 	}
-	color.New(color.Bold).Println("Analyzing Image")
-	manifest, refTrees, efficiency, inefficiencies := image.InitializeData(userImage)
-	ui.Run(manifest, refTrees, efficiency, inefficiencies)
+	if noColor, _ := cmd.Flags().GetBool("no-color"); noColor {
+		color.NoColor = true
+	}
+	if len(args) > 1 {
+		analyzeImages(cmd, args)
+		return
+	}
+	analyzeImage(cmd, userImage)
+}
+
+// singleImageFlags are the flags of modes holding a single image: the comparison and the exports
+var singleImageFlags = []string{"compare", "export-csv", "export-json", "export-inventory", "export-layers", "export-wasted"}
+
+// analyzeImages analyzes the given images one after the other in the non-interactive modes, as set by the flags of the
+// given (root) command: the CI rules are evaluated against every image, and the reports (and the JUnit report) combine
+// them all. The images share the layer cache, so the layers they have in common are read once. The run exits with the
+// worst result across the images.
+func analyzeImages(cmd *cobra.Command, userImages []string) {
+	ciMode, _ := cmd.Flags().GetBool("ci")
+	junitPath, _ := cmd.Flags().GetString("junit")
+	ciMode = ciMode || junitPath != ""
+	outputs := reportOutputs(cmd)
+	if !ciMode && len(outputs) == 0 {
+		logrus.Error("Several images can only be analyzed with --ci, --junit, --json, --summary, or --report (the UI shows a single image)")
+		utils.Exit(1)
+	}
+	for _, name := range singleImageFlags {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			logrus.Error("Invalid value for '--" + name + "': only a single image can be given")
+			utils.Exit(1)
+		}
+	}
+	var fromStdin int
+	for _, userImage := range userImages {
+		if userImage == "" {
+			logrus.Error("No image argument given")
+			utils.Exit(1)
+		}
+		if userImage == "-" {
+			fromStdin++
+		}
+	}
+	if fromStdin > 1 {
+		logrus.Error("Invalid image '-': stdin can only be read once")
+		utils.Exit(1)
+	}
+
+	// the CI config is checked before the (lengthy) analysis
+	var rules map[string]ci.RuleConfig
+	if ciMode {
+		rules = ruleConfigs(cmd)
+	}
+
+	// reports may be written to stdout, so everything else goes to stderr (see writeReports)
+	stdout := os.Stdout
+	if outputs.toStdout() {
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+	}
+	utils.HideCursor()
+
+	images := make([]ci.ImageResults, 0, len(userImages))
+	reports := make([]*report.Report, 0, len(userImages))
+	for idx, userImage := range userImages {
+		logrus.WithField(utils.HeadingField, true).Infof("Analyzing Image %d of %d: %s", idx+1, len(userImages), userImage)
+		manifest, refTrees, efficiency, inefficiencies, platform := image.InitializeData(userImage)
+		analysis := &ci.Analysis{
+			Image:          userImage,
+			Layers:         manifest,
+			Trees:          refTrees,
+			Efficiency:     efficiency,
+			Inefficiencies: inefficiencies,
+			Platform:       platform,
+		}
+		var results []ci.Result
+		if ciMode {
+			results = evaluateCI(analysis, rules)
+		}
+		images = append(images, ci.ImageResults{Image: userImage, Results: results})
+		// only the report (holding the final tree) is kept, not the trees of every layer
+		if len(outputs) > 0 {
+			reports = append(reports, buildReport(cmd, analysis, results))
+		}
+	}
+
+	if len(outputs) > 0 {
+		writeOutputs(cmd, outputs, stdout, report.NewCombined(reports))
+	}
+	if junitPath != "" {
+		exportJUnitImages(junitPath, images)
+	}
+	if ciMode {
+		reportCIImages(images)
+	}
+}
+
+// analyzeImage analyzes the given image as set by the flags of the given (root) command: showing the UI, or evaluating
+// the CI rules and writing the reports and exports asked for
+func analyzeImage(cmd *cobra.Command, userImage string) {
+	baseImage, err := cmd.Flags().GetString("compare")
+	if err == nil && baseImage != "" {
+		utils.HideCursor()
+		compareImages(cmd, baseImage, userImage)
+		return
+	}
+
+	// the CI config is checked before the (lengthy) analysis
+	ciMode, _ := cmd.Flags().GetBool("ci")
+	junitPath, _ := cmd.Flags().GetString("junit")
+	ciMode = ciMode || junitPath != ""
+	var rules map[string]ci.RuleConfig
+	if ciMode {
+		rules = ruleConfigs(cmd)
+	}
+
+	// reports may be written to stdout, so everything else goes to stderr (see writeReports)
+	outputs := reportOutputs(cmd)
+	stdout := os.Stdout
+	if outputs.toStdout() {
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+	}
+	utils.HideCursor()
+
+	logrus.WithField(utils.HeadingField, true).Info("Analyzing Image")
+	manifest, refTrees, efficiency, inefficiencies, platform := image.InitializeData(userImage)
+
+	if ciMode || len(outputs) > 0 {
+		analysis := &ci.Analysis{
+			Image:          userImage,
+			Layers:         manifest,
+			Trees:          refTrees,
+			Efficiency:     efficiency,
+			Inefficiencies: inefficiencies,
+			Platform:       platform,
+		}
+		var results []ci.Result
+		if ciMode {
+			results = evaluateCI(analysis, rules)
+		}
+		if len(outputs) > 0 {
+			writeReports(cmd, outputs, stdout, analysis, results)
+		}
+		if junitPath != "" {
+			exportJUnit(junitPath, userImage, results)
+		}
+		if ciMode {
+			reportCI(userImage, results)
+		}
+		return
+	}
+
+	csvPath, err := cmd.Flags().GetString("export-csv")
+	if err == nil && csvPath != "" {
+		exportCSV(cmd, csvPath, refTrees, platform)
+		return
+	}
+
+	jsonPath, err := cmd.Flags().GetString("export-json")
+	if err == nil && jsonPath != "" {
+		exportJSON(jsonPath, func(file *os.File) error {
+			return filetree.StackRange(refTrees, 0, len(refTrees)-1).ExportJSON(file)
+		})
+		return
+	}
+
+	inventoryPath, err := cmd.Flags().GetString("export-inventory")
+	if err == nil && inventoryPath != "" {
+		exportInventory(cmd, inventoryPath, refTrees)
+		return
+	}
+
+	layersPath, err := cmd.Flags().GetString("export-layers")
+	if err == nil && layersPath != "" {
+		exportJSON(layersPath, func(file *os.File) error {
+			return image.ExportLayerStatsJSON(file, manifest, layerLimits())
+		})
+		return
+	}
+
+	wastedPath, err := cmd.Flags().GetString("export-wasted")
+	if err == nil && wastedPath != "" {
+		exportWasted(wastedPath, refTrees, inefficiencies)
+		return
+	}
+
+	ui.Run(manifest, refTrees, efficiency, inefficiencies, platform)
+}
+
+// exportCSV writes the per-layer file changes of the analyzed image to the given path
+func exportCSV(cmd *cobra.Command, path string, trees []*filetree.FileTree, platform string) {
+	var options filetree.CSVOptions
+	options.IncludeUnchanged, _ = cmd.Flags().GetBool("export-csv-unchanged")
+	options.ExcludeDirs, _ = cmd.Flags().GetBool("export-csv-no-dirs")
+	options.DetectMoves = viper.GetBool("diff.detect-moves")
+	options.Platform = platform
+
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the CSV export: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	err = filetree.ExportLayersCSV(file, trees, options)
+	if err != nil {
+		logrus.Error("Could not write the CSV export: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported layer changes to " + path)
+}
+
+// exportWasted writes the paths (and directories) wasting the most space across the layers of the analyzed image, every
+// file added then removed by a later layer, and the likely removable paths of the final filesystem, to the given path
+func exportWasted(path string, trees []*filetree.FileTree, inefficiencies filetree.EfficiencySlice) {
+	limit, err := filetree.ParseWastedLimit(viper.GetString("efficiency.wasted-files"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.wasted-files': " + err.Error())
+		utils.Exit(1)
+	}
+
+	final := filetree.StackRange(trees, 0, len(trees)-1)
+	removable, err := filetree.FindRemovable(final, filetree.RemovablePatterns(viper.GetStringSlice("efficiency.removable-paths")), filetree.MinRemovableSize)
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.removable-paths': " + err.Error())
+		utils.Exit(1)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the wasted files export: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	wasted := filetree.WastedFiles(trees, inefficiencies, filetree.FindDuplicates(trees))
+	err = filetree.ExportWastedJSON(file, filetree.WastedReport{
+		Files:            wasted.Top(limit),
+		Directories:      wasted.Directories().Top(limit),
+		Churn:            filetree.FindChurn(trees),
+		Removable:        removable,
+		MetadataRewrites: filetree.FindMetadataRewrites(trees),
+	})
+	if err != nil {
+		logrus.Error("Could not write the wasted files export: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported wasted files to " + path)
+}
+
+// compareImages compares the final filesystems of the given images, showing (or exporting) the differences
+func compareImages(cmd *cobra.Command, baseImage, userImage string) {
+	comparison, trees, platform := loadComparison(baseImage, userImage)
+
+	jsonPath, err := cmd.Flags().GetString("export-json")
+	if err == nil && jsonPath != "" {
+		exportJSON(jsonPath, func(file *os.File) error {
+			return filetree.ExportComparisonJSON(file, comparison)
+		})
+		return
+	}
+
+	runCompare(comparison, trees, platform)
+}
+
+// loadComparison analyzes the given images and compares their final filesystems, returning the comparison along with
+// the final trees of both images and the platform of the compared image
+func loadComparison(baseImage, userImage string) (filetree.ImageComparison, []*filetree.FileTree, string) {
+	logrus.WithField(utils.HeadingField, true).Info("Analyzing Base Image")
+	_, baseTrees, _, _, _ := image.InitializeData(baseImage)
+	logrus.WithField(utils.HeadingField, true).Info("Analyzing Image")
+	_, refTrees, _, _, platform := image.InitializeData(userImage)
+
+	logrus.Info("  Comparing images...")
+	base := filetree.StackRange(baseTrees, 0, len(baseTrees)-1)
+	target := filetree.StackRange(refTrees, 0, len(refTrees)-1)
+	comparison, err := filetree.NewImageComparison(baseImage, userImage, base, target, viper.GetBool("diff.detect-moves"))
+	if err != nil {
+		logrus.Error("Could not compare the images: " + err.Error())
+		utils.Exit(1)
+	}
+	return comparison, []*filetree.FileTree{base, target}, platform
+}
+
+// runCompare shows the given comparison in the UI
+func runCompare(comparison filetree.ImageComparison, trees []*filetree.FileTree, platform string) {
+	ui.RunCompare(ui.Comparison{
+		Base:      comparison.Base,
+		Image:     comparison.Image,
+		Tree:      comparison.Tree,
+		Summaries: comparison.Directories,
+		Platform:  platform,
+	}, trees)
+}
+
+// exportJSON creates the given path and writes a JSON export to it
+func exportJSON(path string, export func(file *os.File) error) {
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the JSON export: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	if err := export(file); err != nil {
+		logrus.Error("Could not write the JSON export: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported JSON to " + path)
+}
+
+// exportInventory writes a listing of every file of the final filesystem of the analyzed image to the given path
+func exportInventory(cmd *cobra.Command, path string, trees []*filetree.FileTree) {
+	value, _ := cmd.Flags().GetString("export-inventory-format")
+	format, err := filetree.ParseInventoryFormat(value)
+	if err != nil {
+		logrus.Error("Invalid value for '--export-inventory-format': " + err.Error())
+		utils.Exit(1)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the inventory export: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	err = filetree.StackRange(trees, 0, len(trees)-1).ExportInventory(file, format)
+	if err != nil {
+		logrus.Error("Could not write the inventory export: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported the file inventory to " + path)
+}
+
+// layerLimits returns the per-layer thresholds of the config
+func layerLimits() image.LayerLimits {
+	return image.LayerLimits{
+		MaxFiles:       viper.GetInt("layer.max-files"),
+		SizeDivergence: viper.GetFloat64("layer.size-divergence"),
+	}
 }
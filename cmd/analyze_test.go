@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// captureStdout runs the given function with stdout redirected to a file (so not a terminal), returning what was
+// written to it.
+func captureStdout(t *testing.T, run func()) []byte {
+	file, err := ioutil.TempFile("", "dive-stdout")
+	if err != nil {
+		t.Fatalf("could not create the file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	stdout, output := os.Stdout, color.Output
+	os.Stdout = file
+	defer func() { os.Stdout, color.Output = stdout, output }()
+	run()
+
+	contents, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("could not read the output: %v", err)
+	}
+	return contents
+}
+
+func TestReportsOnStdout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dive-rootfs")
+	if err != nil {
+		t.Fatalf("could not create the directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "hosts"), []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatalf("could not write the file: %v", err)
+	}
+
+	// only the report goes to stdout, so it can be piped (e.g. to jq)
+	for name, args := range map[string][]string{
+		"analyze": {"dir:" + dir, "--no-cache", "--json", "-"},
+		"diff":    {"diff", "dir:" + dir, "dir:" + dir, "--no-cache", "--json", "-"},
+	} {
+		out := captureStdout(t, func() {
+			rootCmd.SetArgs(args)
+			if err := rootCmd.Execute(); err != nil {
+				t.Errorf("[%s] could not run: %v", name, err)
+			}
+		})
+		if !bytes.HasPrefix(out, []byte("{")) {
+			t.Errorf("[%s] expected the JSON report alone on stdout, got: %q", name, out)
+		}
+	}
+}
@@ -1,19 +1,36 @@
 package cmd
 
 import (
+	"os/exec"
+	"strings"
+
+	"github.com/fatih/color"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/wagoodman/dive/image"
-	"github.com/wagoodman/dive/ui"
 	"github.com/wagoodman/dive/utils"
-	"io/ioutil"
-	"os"
 )
 
+// builderFlags are the flags of dive that the builders (docker and podman) have as well: given to "dive build", they
+// are passed on to the builder
+var builderFlags = map[string]bool{
+	"no-cache": true,
+	"output":   true,
+	"platform": true,
+}
+
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
-	Use:                "build [any valid `docker build` arguments]",
-	Short:              "Builds and analyzes a docker image from a Dockerfile (this is a thin wrapper for the `docker build` command).",
+	Use:   "build [dive flags] [any valid `docker build` or `podman build` arguments]",
+	Short: "Builds and analyzes a docker image from a Dockerfile (this is a thin wrapper for the `docker build` command).",
+	Long: `Build an image with docker (or podman, depending on the source) and analyze it. The arguments are passed on to
+the builder, apart from the (long) flags of dive given before any "--" (e.g. --ci, --json, --source), which apply to the
+analysis:
+
+  dive build --ci -t app:latest .
+
+A failed build exits with the exit code of the builder.`,
 	DisableFlagParsing: true,
 	Run:                doBuild,
 }
@@ -24,27 +41,75 @@ func init() {
 
 // doBuild implements the steps taken for the build command
 func doBuild(cmd *cobra.Command, args []string) {
+	utils.HideCursor()
 	defer utils.Cleanup()
-	iidfile, err := ioutil.TempFile("/tmp", "dive.*.iid")
-	if err != nil {
-		utils.Cleanup()
-		log.Fatal(err)
+
+	diveArgs, buildArgs := splitBuildArgs(cmd.Root(), args)
+	flags := diveFlags(cmd.Root())
+	if err := flags.Parse(diveArgs); err != nil {
+		log.Error("Invalid dive flags: " + err.Error())
+		utils.Exit(1)
+	}
+	// the config and logging depend on the flags of dive, which are only known now
+	initConfig()
+	initLogging()
+	if noColor, _ := flags.GetBool("no-color"); noColor {
+		color.NoColor = true
 	}
-	defer os.Remove(iidfile.Name())
 
-	allArgs := append([]string{"--iidfile", iidfile.Name()}, args...)
-	err = utils.RunDockerCmd("build", allArgs...)
+	engine, err := image.BuildEngine()
 	if err != nil {
-		utils.Cleanup()
-		log.Fatal(err)
+		log.Error("Could not find a builder: " + err.Error())
+		utils.Exit(1)
+	}
+	imageID, err := utils.RunEngineBuild(engine, buildArgs...)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// the builder has shown why
+		utils.Exit(exitErr.ExitCode())
 	}
-
-	imageId, err := ioutil.ReadFile(iidfile.Name())
 	if err != nil {
-		utils.Cleanup()
-		log.Fatal(err)
+		log.Error("Could not build the image: " + err.Error())
+		utils.Exit(1)
 	}
+	if imageID == "" {
+		log.Error("Could not find the ID of the built image in the output of " + engine)
+		utils.Exit(1)
+	}
+
+	analyzeImage(cmd.Root(), engine+"://"+imageID)
+}
+
+// diveFlags returns the flags of the given (root) command, persistent ones included, sharing their values.
+func diveFlags(root *cobra.Command) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(root.Name(), pflag.ContinueOnError)
+	flags.AddFlagSet(root.Flags())
+	flags.AddFlagSet(root.PersistentFlags())
+	return flags
+}
 
-	manifest, refTrees, efficiency, inefficiencies := image.InitializeData(string(imageId))
-	ui.Run(manifest, refTrees, efficiency, inefficiencies)
+// splitBuildArgs splits the arguments of the build command into the flags of dive (the long forms of those of the given
+// root command, apart from builderFlags) and the arguments of the builder. Flags are only taken up to a "--", which is
+// dropped.
+func splitBuildArgs(root *cobra.Command, args []string) ([]string, []string) {
+	flags := diveFlags(root)
+	var diveArgs, buildArgs []string
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "--" {
+			buildArgs = append(buildArgs, args[idx+1:]...)
+			break
+		}
+		name := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		flag := flags.Lookup(name)
+		if !strings.HasPrefix(arg, "--") || flag == nil || builderFlags[name] {
+			buildArgs = append(buildArgs, arg)
+			continue
+		}
+		diveArgs = append(diveArgs, arg)
+		if takesValue(flag) && !strings.Contains(arg, "=") && idx+1 < len(args) {
+			idx++
+			diveArgs = append(diveArgs, args[idx])
+		}
+	}
+	return diveArgs, buildArgs
 }
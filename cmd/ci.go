@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/utils"
+)
+
+// ciInitCmd prints an example CI config file
+var ciInitCmd = &cobra.Command{
+	Use:   "ci-init",
+	Short: "Print a commented example CI config file (" + ci.ConfigFileName + ") with the default thresholds of the rules",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(ci.ExampleConfig())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ciInitCmd)
+}
+
+// ruleConfigs returns the configuration of every CI rule (see ci.Rules). The threshold is the one given on the command
+// line, or else the one set by the CI config file (the --ci-config file, or ci.ConfigFileName in the working
+// directory), or else the one of the dive config (or the default); the options and severity are those of the CI config
+// file (every rule is an error with --strict). Exits when the CI config file cannot be read or holds invalid values.
+func ruleConfigs(cmd *cobra.Command) map[string]ci.RuleConfig {
+	thresholds := make(map[string]string, len(ci.Rules))
+	for _, rule := range ci.Rules {
+		if rule.List {
+			// the dive config may hold a (yaml) list
+			thresholds[rule.Name] = strings.Join(viper.GetStringSlice("rules."+rule.Name), ",")
+			continue
+		}
+		thresholds[rule.Name] = viper.GetString("rules." + rule.Name)
+	}
+	if err := ci.Validate(thresholds); err != nil {
+		logrus.Error("Invalid config value for 'rules': " + err.Error())
+		utils.Exit(1)
+	}
+	configs := make(map[string]ci.RuleConfig, len(ci.Rules))
+	for name, threshold := range thresholds {
+		configs[name] = ci.RuleConfig{Threshold: threshold}
+	}
+
+	configPath, _ := cmd.Flags().GetString("ci-config")
+	path, err := ci.FindConfig(configPath)
+	if err != nil {
+		logrus.Error(err.Error())
+		utils.Exit(1)
+	}
+	if path == "" {
+		return configs
+	}
+	config, warnings, err := ci.LoadConfig(path)
+	if err != nil {
+		logrus.Error("Invalid CI config: " + err.Error())
+		utils.Exit(1)
+	}
+	for _, warning := range warnings {
+		logrus.Warn(warning)
+	}
+	logrus.Info("  Using CI config: " + path)
+
+	for name, ruleConfig := range config.Rules {
+		rule, _ := ci.LookupRule(name)
+		if flag := cmd.Root().PersistentFlags().Lookup(rule.Flag); ruleConfig.Threshold == "" || (flag != nil && flag.Changed) {
+			ruleConfig.Threshold = configs[name].Threshold
+		}
+		configs[name] = ruleConfig
+	}
+	if strict, _ := cmd.Flags().GetBool("strict"); strict {
+		for name, ruleConfig := range configs {
+			ruleConfig.Severity = ci.SeverityError
+			configs[name] = ruleConfig
+		}
+	}
+	return configs
+}
+
+// evaluateCI evaluates the CI rules (with the given configuration) against the analysis of an image, exiting when a
+// threshold is invalid
+func evaluateCI(analysis *ci.Analysis, rules map[string]ci.RuleConfig) []ci.Result {
+	results, err := ci.EvaluateRules(analysis, rules)
+	if err != nil {
+		logrus.Error("Invalid config value for 'rules': " + err.Error())
+		utils.Exit(1)
+	}
+	return results
+}
+
+// exportJUnit writes the results of the CI rules for an image as JUnit XML to the given path, before the run exits
+// (with ci.ExitRulesFailed) on failed rules so the file can always be collected
+func exportJUnit(path, imageName string, results []ci.Result) {
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the JUnit report: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	if err := ci.WriteJUnit(file, imageName, results); err != nil {
+		logrus.Error("Could not write the JUnit report: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported the JUnit report to " + path)
+}
+
+// reportCI prints the results of the CI rules for an image, exiting with ci.ExitRulesFailed when a rule failed
+func reportCI(imageName string, results []ci.Result) {
+	logrus.Info("  Evaluating CI rules for " + imageName)
+	if err := ci.WriteTable(os.Stdout, results); err != nil {
+		logrus.Error("Could not write the CI results: " + err.Error())
+		utils.Exit(1)
+	}
+	if !ci.AllPassed(results) {
+		utils.Exit(ci.ExitRulesFailed)
+	}
+}
+
+// exportJUnitImages writes the results of the CI rules for several images as JUnit XML to the given path, a test suite
+// per image (see exportJUnit)
+func exportJUnitImages(path string, images []ci.ImageResults) {
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the JUnit report: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	if err := ci.WriteJUnitImages(file, images); err != nil {
+		logrus.Error("Could not write the JUnit report: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported the JUnit report to " + path)
+}
+
+// reportCIImages prints the results of the CI rules for every image, then their overall results, exiting with
+// ci.ExitRulesFailed when a rule failed on any image
+func reportCIImages(images []ci.ImageResults) {
+	for _, image := range images {
+		logrus.Info("  Evaluating CI rules for " + image.Image)
+		if err := ci.WriteTable(os.Stdout, image.Results); err != nil {
+			logrus.Error("Could not write the CI results: " + err.Error())
+			utils.Exit(1)
+		}
+		fmt.Fprintln(os.Stdout)
+	}
+	if err := ci.WriteImagesTable(os.Stdout, images); err != nil {
+		logrus.Error("Could not write the CI results: " + err.Error())
+		utils.Exit(1)
+	}
+	if len(ci.FailedImages(images)) > 0 {
+		utils.Exit(ci.ExitRulesFailed)
+	}
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/wagoodman/dive/image"
+	"github.com/wagoodman/dive/utils"
+)
+
+// imageCompletionTimeout bounds the lookup of image names while completing, so an unreachable engine never blocks the
+// shell (there are no suggestions then)
+const imageCompletionTimeout = 500 * time.Millisecond
+
+// completeImagesCmd is the hidden command the completion scripts run to complete image arguments
+const completeImagesCmd = "__complete-images"
+
+// completionCmd prints a shell completion script
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Print a shell completion script (completing subcommands, flags, and the image names of the configured source)",
+	Long: `Print a shell completion script for bash, zsh, or fish. To load completions in the current shell:
+
+  source <(dive completion bash)
+  source <(dive completion zsh)
+  dive completion fish | source
+
+Options and subcommands are completed offline, image names are looked up from the configured source (docker or
+podman) and left out when it cannot be reached within 500ms.`,
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Args:      cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = cmd.Root().GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = writeZshCompletion(os.Stdout, cmd.Root())
+		case "fish":
+			err = writeFishCompletion(os.Stdout, cmd.Root())
+		default:
+			logrus.Error("Invalid shell '" + args[0] + "' (supported: bash, zsh, fish)")
+			utils.Exit(1)
+		}
+		if err != nil {
+			logrus.Error("Could not write the completion script: " + err.Error())
+			utils.Exit(1)
+		}
+	},
+}
+
+// imageCompletionCmd prints the completions of a partial image argument, one per line (see image.CompleteImage)
+var imageCompletionCmd = &cobra.Command{
+	Use:    completeImagesCmd + " [partial image]",
+	Hidden: true,
+	Args:   cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var word string
+		if len(args) > 0 {
+			word = args[0]
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), imageCompletionTimeout)
+		defer cancel()
+		for _, candidate := range image.CompleteImage(ctx, word) {
+			fmt.Println(candidate)
+		}
+	},
+}
+
+// bashCompletionFunction completes the image argument of the root command, the rest is left to the script cobra
+// generates. Colons are part of image names, so the whole word is completed (then trimmed as bash expects).
+const bashCompletionFunction = `__dive_custom_func() {
+    if [[ ${last_command} != "dive" ]]; then
+        return
+    fi
+    local word
+    _get_comp_words_by_ref -n : -c word
+    local IFS=$'\n'
+    COMPREPLY=( $("${words[0]}" ` + completeImagesCmd + ` "${word}" 2>/dev/null) )
+    # a source prefix is followed by the image name
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == *:// ]]; then
+        compopt -o nospace
+    fi
+    __ltrim_colon_completions "${word}"
+}
+`
+
+func init() {
+	rootCmd.BashCompletionFunction = bashCompletionFunction
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(imageCompletionCmd)
+}
+
+// completionFlags returns the flags (including the inherited ones) offered when completing the given command, by name.
+func completionFlags(cmd *cobra.Command) []*pflag.Flag {
+	var flags []*pflag.Flag
+	seen := make(map[string]bool)
+	for _, set := range []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags(), cmd.InheritedFlags()} {
+		set.VisitAll(func(flag *pflag.Flag) {
+			if flag.Hidden || flag.Deprecated != "" || seen[flag.Name] {
+				return
+			}
+			seen[flag.Name] = true
+			flags = append(flags, flag)
+		})
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags
+}
+
+// completionCommands returns the subcommands offered when completing the given command.
+func completionCommands(cmd *cobra.Command) []*cobra.Command {
+	var commands []*cobra.Command
+	for _, sub := range cmd.Commands() {
+		if !sub.Hidden && sub.Name() != "help" {
+			commands = append(commands, sub)
+		}
+	}
+	return commands
+}
+
+// takesValue indicates if a flag is given a value (e.g. "--source podman"), rather than being a switch
+func takesValue(flag *pflag.Flag) bool {
+	return flag.Value.Type() != "bool"
+}
+
+// writeZshCompletion writes a zsh completion script for the given (root) command: its subcommands and their flags,
+// and the image argument of the root command.
+func writeZshCompletion(writer io.Writer, root *cobra.Command) error {
+	name := root.Name()
+	// escapes a description for an _arguments spec or a _describe entry
+	escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`, "\n", " ").Replace
+	arguments := func(cmd *cobra.Command) string {
+		var specs []string
+		for _, flag := range completionFlags(cmd) {
+			spec := "--" + flag.Name + "[" + escape(flag.Usage) + "]"
+			if takesValue(flag) {
+				spec += ":" + flag.Name + ":"
+				if flag.Name == "config" || flag.Name == "ci-config" || strings.HasPrefix(flag.Name, "export-") || flag.Name == "output" {
+					spec += "_files"
+				}
+			}
+			specs = append(specs, "'"+spec+"'")
+			if flag.Shorthand != "" {
+				specs = append(specs, "'-"+flag.Shorthand+"["+escape(flag.Usage)+"]'")
+			}
+		}
+		return strings.Join(specs, " \\\n            ")
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "#compdef %s\n\n", name)
+	fmt.Fprintf(&out, "__%s_images() {\n", name)
+	fmt.Fprintf(&out, "    local -a images\n")
+	fmt.Fprintf(&out, "    images=(\"${(@f)$(${words[1]} %s \"${PREFIX}\" 2>/dev/null)}\")\n", completeImagesCmd)
+	fmt.Fprintf(&out, "    compadd -Q -S '' -- ${(M)images:#*://}\n")
+	fmt.Fprintf(&out, "    compadd -Q -- ${images:#*://}\n")
+	fmt.Fprintf(&out, "}\n\n")
+
+	fmt.Fprintf(&out, "_%s() {\n", name)
+	fmt.Fprintf(&out, "    local -a commands\n    commands=(\n")
+	for _, sub := range completionCommands(root) {
+		fmt.Fprintf(&out, "        '%s:%s'\n", sub.Name(), escape(sub.Short))
+	}
+	fmt.Fprintf(&out, "    )\n")
+	fmt.Fprintf(&out, "    local subcommand\n")
+	fmt.Fprintf(&out, "    (( ${#words} > 2 )) && subcommand=${words[2]}\n")
+	fmt.Fprintf(&out, "    case ${subcommand} in\n")
+	for _, sub := range completionCommands(root) {
+		fmt.Fprintf(&out, "        %s)\n", sub.Name())
+		if sub.DisableFlagParsing {
+			// the arguments are passed on to another tool
+			fmt.Fprintf(&out, "            _files\n            ;;\n")
+			continue
+		}
+		fmt.Fprintf(&out, "            _arguments \\\n            %s \\\n            '*: :'\n            ;;\n", arguments(sub))
+	}
+	fmt.Fprintf(&out, "        *)\n")
+	fmt.Fprintf(&out, "            _arguments \\\n            %s \\\n            '*: :->image'\n", arguments(root))
+	fmt.Fprintf(&out, "            if [[ ${state} == image ]]; then\n")
+	fmt.Fprintf(&out, "                (( CURRENT == 2 )) && _describe -t commands '%s commands' commands\n", name)
+	fmt.Fprintf(&out, "                __%s_images\n", name)
+	fmt.Fprintf(&out, "            fi\n            ;;\n")
+	fmt.Fprintf(&out, "    esac\n}\n\n")
+	fmt.Fprintf(&out, "compdef _%s %s\n", name, name)
+
+	_, err := io.WriteString(writer, out.String())
+	return err
+}
+
+// writeFishCompletion writes a fish completion script for the given (root) command: its subcommands and their flags,
+// and the image argument of the root command.
+func writeFishCompletion(writer io.Writer, root *cobra.Command) error {
+	name := root.Name()
+	quote := func(value string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", " ").Replace(value) + "'"
+	}
+	flagLines := func(out *strings.Builder, condition string, cmd *cobra.Command) {
+		for _, flag := range completionFlags(cmd) {
+			line := fmt.Sprintf("complete -c %s -n %s -l %s", name, quote(condition), flag.Name)
+			if flag.Shorthand != "" {
+				line += " -s " + flag.Shorthand
+			}
+			if takesValue(flag) {
+				line += " -r"
+			}
+			fmt.Fprintf(out, "%s -d %s\n", line, quote(flag.Usage))
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# fish completion for %s\n", name)
+	fmt.Fprintf(&out, "complete -c %s -f\n", name)
+	var subcommands []string
+	for _, sub := range completionCommands(root) {
+		subcommands = append(subcommands, sub.Name())
+	}
+	rootCondition := "not __fish_seen_subcommand_from " + strings.Join(subcommands, " ")
+	for _, sub := range completionCommands(root) {
+		fmt.Fprintf(&out, "complete -c %s -n %s -a %s -d %s\n", name, quote("__fish_use_subcommand"), sub.Name(), quote(sub.Short))
+	}
+	// several images may be given, so every argument of the root command completes images
+	fmt.Fprintf(&out, "complete -c %s -n %s -a %s\n", name, quote(rootCondition),
+		quote("("+name+" "+completeImagesCmd+" (commandline -ct) 2>/dev/null)"))
+	flagLines(&out, rootCondition, root)
+	for _, sub := range completionCommands(root) {
+		if sub.DisableFlagParsing {
+			// the arguments are passed on to another tool
+			fmt.Fprintf(&out, "complete -c %s -n %s -F\n", name, quote("__fish_seen_subcommand_from "+sub.Name()))
+			continue
+		}
+		flagLines(&out, "__fish_seen_subcommand_from "+sub.Name(), sub)
+	}
+
+	_, err := io.WriteString(writer, out.String())
+	return err
+}
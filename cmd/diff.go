@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/report"
+	"github.com/wagoodman/dive/utils"
+)
+
+// diffCompareAttributes are the metadata differences the diff command reports as changes, unless the config selects
+// others (diff.compare-attributes): modification times are left out, as every build changes them
+var diffCompareAttributes = []string{"mode", "uid", "gid"}
+
+// diffCmd compares the final filesystems of two images
+var diffCmd = &cobra.Command{
+	Use:   "diff BASE IMAGE",
+	Short: "Compare the final filesystems of two images: the files added, removed, and changed, and the net size change",
+	Long: `Compare the final (squashed) filesystems of two images, read from any source (e.g. dive diff app:v1 podman://app:v2).
+The compared tree is shown in the UI, or written as JSON with --json, or summarized as text with --summary. Links are
+changed when their target is, and files with the same contents are changed when their mode or owner is (see
+diff.compare-attributes). Directories found in one image only are reported as a whole.`,
+	Args: cobra.ExactArgs(2),
+	Run:  doDiff,
+}
+
+func init() {
+	diffCmd.Flags().String("json", "", "write the comparison (sizes, changed paths, and the compared tree) as JSON to the given path, or \"-\" for stdout (and skip the UI)")
+	diffCmd.Flags().Bool("summary", false, "print a plain text summary of the comparison to stdout (and skip the UI)")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// doDiff implements the steps taken for the diff command
+func doDiff(cmd *cobra.Command, args []string) {
+	defer utils.Cleanup()
+	if noColor, _ := cmd.Flags().GetBool("no-color"); noColor {
+		color.NoColor = true
+	}
+
+	jsonPath, _ := cmd.Flags().GetString("json")
+	summary, _ := cmd.Flags().GetBool("summary")
+	if jsonPath == "-" && summary {
+		logrus.Error("Only one of --json - and --summary can be written to stdout")
+		utils.Exit(1)
+	}
+	if len(viper.GetStringSlice("diff.compare-attributes")) == 0 {
+		viper.Set("diff.compare-attributes", diffCompareAttributes)
+	}
+
+	// the comparison may be written to stdout, so everything else goes to stderr
+	stdout := os.Stdout
+	if jsonPath == "-" || summary {
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+	}
+	utils.HideCursor()
+
+	comparison, trees, platform := loadComparison(args[0], args[1])
+	switch {
+	case jsonPath == "-":
+		if err := filetree.ExportComparisonJSON(stdout, comparison); err != nil {
+			logrus.Error("Could not write the JSON export: " + err.Error())
+			utils.Exit(1)
+		}
+	case jsonPath != "":
+		exportJSON(jsonPath, func(file *os.File) error {
+			return filetree.ExportComparisonJSON(file, comparison)
+		})
+	}
+	if summary {
+		if err := report.WriteComparisonSummary(stdout, comparison); err != nil {
+			logrus.Error("Could not write the summary: " + err.Error())
+			utils.Exit(1)
+		}
+	}
+	if jsonPath == "" && !summary {
+		runCompare(comparison, trees, platform)
+	}
+}
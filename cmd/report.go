@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/report"
+	"github.com/wagoodman/dive/utils"
+)
+
+// reportOutput is a report to write: its format and path ("-" for stdout)
+type reportOutput struct {
+	format string
+	path   string
+}
+
+// reportOutputList is the set of reports requested on the command line
+type reportOutputList []reportOutput
+
+// toStdout indicates if a report is written to stdout
+func (outputs reportOutputList) toStdout() bool {
+	for _, output := range outputs {
+		if output.path == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// reportOutputs returns the reports requested on the command line: the JSON report of --json, the text summary of
+// --summary (written to stdout), and the report of --report (written to --output, stdout by default). Exits when the
+// format of --report is unknown, or when several reports are written to stdout.
+func reportOutputs(cmd *cobra.Command) reportOutputList {
+	var outputs reportOutputList
+	if path, _ := cmd.Flags().GetString("json"); path != "" {
+		outputs = append(outputs, reportOutput{format: report.FormatJSON, path: path})
+	}
+	if summary, _ := cmd.Flags().GetBool("summary"); summary {
+		if outputs.toStdout() {
+			logrus.Error("Invalid value for '--summary': only one report can be written to stdout")
+			utils.Exit(1)
+		}
+		outputs = append(outputs, reportOutput{format: report.FormatText, path: "-"})
+	}
+	if value, _ := cmd.Flags().GetString("report"); value != "" {
+		format, err := report.ParseFormat(value)
+		if err != nil {
+			logrus.Error("Invalid value for '--report': " + err.Error())
+			utils.Exit(1)
+		}
+		path, _ := cmd.Flags().GetString("output")
+		if path == "" {
+			path = "-"
+		}
+		if path == "-" && outputs.toStdout() {
+			logrus.Error("Invalid value for '--report': only one report can be written to stdout")
+			utils.Exit(1)
+		}
+		outputs = append(outputs, reportOutput{format: format, path: path})
+	}
+	return outputs
+}
+
+// reportWriter writes a report in every format: the report of an image (see report.Report), or the combined report of
+// several (see report.Combined)
+type reportWriter interface {
+	WriteJSON(writer io.Writer) error
+	WriteMarkdown(writer io.Writer, options report.MarkdownOptions) error
+	WriteHTML(writer io.Writer) error
+	WriteSummary(writer io.Writer) error
+}
+
+// writeReports builds the report of the analysis (see report.Report) and writes it in every requested format, using
+// the given (original) stdout for the reports written to "-"
+func writeReports(cmd *cobra.Command, outputs reportOutputList, stdout *os.File, analysis *ci.Analysis, results []ci.Result) {
+	writeOutputs(cmd, outputs, stdout, buildReport(cmd, analysis, results))
+}
+
+// buildReport builds the report of the analysis (see report.Report), with the given results of the CI rules
+func buildReport(cmd *cobra.Command, analysis *ci.Analysis, results []ci.Result) *report.Report {
+	limit, err := filetree.ParseWastedLimit(viper.GetString("efficiency.wasted-files"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.wasted-files': " + err.Error())
+		utils.Exit(1)
+	}
+
+	largeFileSize, err := humanize.ParseBytes(viper.GetString("efficiency.large-file-size"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.large-file-size': " + err.Error())
+		utils.Exit(1)
+	}
+
+	includeTree, _ := cmd.Flags().GetBool("json-tree")
+	built, err := report.New(analysis, report.Options{
+		WastedLimit:       limit,
+		LargeFileSize:     largeFileSize,
+		RemovablePatterns: filetree.RemovablePatterns(viper.GetStringSlice("efficiency.removable-paths")),
+		LayerLimits:       layerLimits(),
+		DetectMoves:       viper.GetBool("diff.detect-moves"),
+		IncludeTree:       includeTree,
+		Rules:             results,
+	})
+	if err != nil {
+		logrus.Error("Could not build the report: " + err.Error())
+		utils.Exit(1)
+	}
+	return built
+}
+
+// writeOutputs writes the given report in every requested format, using the given (original) stdout for the reports
+// written to "-"
+func writeOutputs(cmd *cobra.Command, outputs reportOutputList, stdout *os.File, built reportWriter) {
+	var markdown report.MarkdownOptions
+	markdown.Rows, _ = cmd.Flags().GetInt("report-rows")
+	markdown.NoLayers, _ = cmd.Flags().GetBool("report-no-layers")
+
+	for _, output := range outputs {
+		write := func(writer io.Writer) error {
+			switch output.format {
+			case report.FormatMarkdown:
+				return built.WriteMarkdown(writer, markdown)
+			case report.FormatHTML:
+				return built.WriteHTML(writer)
+			case report.FormatText:
+				return built.WriteSummary(writer)
+			default:
+				return built.WriteJSON(writer)
+			}
+		}
+
+		if output.path == "-" {
+			if err := write(stdout); err != nil {
+				logrus.Error("Could not write the report: " + err.Error())
+				utils.Exit(1)
+			}
+			continue
+		}
+		exportReport(output.path, write)
+	}
+}
+
+// exportReport creates the given path and writes a report to it
+func exportReport(path string, write func(writer io.Writer) error) {
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.Error("Could not create the report: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		logrus.Error("Could not write the report: " + err.Error())
+		utils.Exit(1)
+	}
+	logrus.Info("  Exported the report to " + path)
+}
@@ -2,11 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/report"
 	"github.com/wagoodman/dive/utils"
-	"io/ioutil"
 	"os"
 
-	"github.com/k0kubun/go-ansi"
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -15,27 +16,35 @@ import (
 
 var cfgFile string
 
+// configFileUsed is the path of the config file read, if any
+var configFileUsed string
+
+// loggedFile and loggedConfigFile are the log file and the config file set up by the last initLogging, which runs
+// again once the flags of the build command are known (see doBuild)
+var loggedFile, loggedConfigFile string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "dive [IMAGE]",
+	Use:   "dive [IMAGE...]",
 	Short: "Docker Image Visualizer & Explorer",
 	Long: `This tool provides a way to discover and explore the contents of a docker image. Additionally the tool estimates
 the amount of wasted space and identifies the offending files from the image.`,
-	Args: cobra.MaximumNArgs(1),
+	Args: cobra.ArbitraryArgs,
 	Run:  analyze,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		log.Error(err)
 		utils.Exit(1)
 	}
 	utils.Cleanup()
 }
 
 func init() {
-	ansi.CursorHide()
+	log.SetOutput(utils.Console)
+	log.SetFormatter(utils.ConsoleFormatter{})
 
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(initLogging)
@@ -43,6 +52,53 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dive.yaml, ~/.config/dive.yaml, or $XDG_CONFIG_HOME/dive.yaml)")
 
 	rootCmd.PersistentFlags().BoolP("version", "v", false, "display version number")
+	rootCmd.PersistentFlags().Bool("no-color", false, "disable colors in the output")
+	rootCmd.PersistentFlags().String("log-level", "", "the level of the log entries shown: error, warn, info, debug, or trace (default is error while the UI is shown, info otherwise)")
+	viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
+	rootCmd.PersistentFlags().String("log-file", "", "also write the log entries to the given file")
+	viper.BindPFlag("log.path", rootCmd.PersistentFlags().Lookup("log-file"))
+
+	rootCmd.Flags().String("export-csv", "", "write a CSV table of the file changes in every layer to the given path (and skip the UI)")
+	rootCmd.Flags().Bool("export-csv-unchanged", false, "include unchanged files in the CSV export")
+	rootCmd.Flags().Bool("export-csv-no-dirs", false, "exclude directories from the CSV export")
+	rootCmd.Flags().String("export-json", "", "write the final file tree (or the compared tree, with --compare) as JSON to the given path (and skip the UI)")
+	rootCmd.Flags().String("compare", "", "compare the final filesystem of the image against that of the given (base) image, instead of showing its layers")
+	rootCmd.Flags().String("export-inventory", "", "write a listing of every file of the final filesystem (with its metadata and content hash) to the given path (and skip the UI)")
+	rootCmd.Flags().String("export-inventory-format", filetree.InventoryJSONLines, "the format of the inventory export: jsonl or csv")
+	rootCmd.Flags().String("export-layers", "", "write a JSON list of the entry counts (files, directories, links) and bytes of every layer to the given path (and skip the UI)")
+	rootCmd.Flags().String("export-wasted", "", "write a JSON list of the paths wasting the most space across layers to the given path (and skip the UI)")
+
+	rootCmd.Flags().String("json", "", "write the full analysis (layers, efficiency, wasted files) as a versioned JSON report to the given path, or \"-\" for stdout (and skip the UI)")
+	rootCmd.Flags().Bool("json-tree", false, "include the final file tree in the JSON report")
+	rootCmd.Flags().Bool("summary", false, "print a plain text summary of the analysis (size, efficiency, largest layers and wasted files) to stdout (and skip the UI)")
+	rootCmd.Flags().String("report", "", "write a report of the analysis in the given format: json, markdown, html, or text (and skip the UI)")
+	rootCmd.Flags().String("output", "", "the path the --report is written to (default is stdout)")
+	rootCmd.Flags().Int("report-rows", report.DefaultMarkdownRows, "the number of layers (the largest ones) and wasted files listed in the markdown report, 0 for all")
+	rootCmd.Flags().Bool("report-no-layers", false, "leave out the layer table of the markdown report")
+
+	rootCmd.Flags().Bool("ci", false, "evaluate the CI rules against the image instead of opening the UI, exiting with 2 when a rule fails (and 1 on errors)")
+	rootCmd.Flags().String("junit", "", "write the results of the CI rules as JUnit XML to the given path (implies --ci)")
+	rootCmd.Flags().String("ci-config", "", "the CI config file setting the thresholds of the rules (default is ./"+ci.ConfigFileName+" when it exists)")
+	rootCmd.Flags().Bool("strict", false, "fail on the CI rules set to only warn (see their severity in the CI config)")
+	for _, rule := range ci.Rules {
+		rootCmd.PersistentFlags().String(rule.Flag, "", fmt.Sprintf("%s in CI mode, or \"disabled\" (default is %s)", rule.Description, rule.Default))
+		viper.BindPFlag("rules."+rule.Name, rootCmd.PersistentFlags().Lookup(rule.Flag))
+	}
+
+	rootCmd.PersistentFlags().String("wasted-files", "", "how many of the paths wasting the most space are reported: a count or \"all\" (default is 10)")
+	viper.BindPFlag("efficiency.wasted-files", rootCmd.PersistentFlags().Lookup("wasted-files"))
+
+	rootCmd.PersistentFlags().String("source", "", "where images are read from: docker, podman, containerd, registry, oci, docker-archive, or dir (detected when empty: local archives and OCI layouts, then docker, then podman)")
+	viper.BindPFlag("source", rootCmd.PersistentFlags().Lookup("source"))
+
+	rootCmd.PersistentFlags().String("platform", "", "the platform (os/arch[/variant]) analyzed from multi-platform images (default is linux and the architecture of the host)")
+	viper.BindPFlag("platform", rootCmd.PersistentFlags().Lookup("platform"))
+
+	rootCmd.PersistentFlags().Bool("ignore-errors", false, "keep analyzing past layer entries that cannot be read (e.g. truncated files), marking them as unreadable, instead of failing")
+	viper.BindPFlag("ignore-errors", rootCmd.PersistentFlags().Lookup("ignore-errors"))
+
+	rootCmd.PersistentFlags().Bool("no-cache", false, "read every layer, without using (or filling) the cache of layer trees")
+	viper.BindPFlag("cache.disabled", rootCmd.PersistentFlags().Lookup("no-cache"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -54,7 +110,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			log.Error(err)
 			utils.Exit(1)
 		}
 
@@ -78,8 +134,8 @@ func initConfig() {
 		}
 	}
 
-	viper.SetDefault("log.level", log.InfoLevel.String())
-	viper.SetDefault("log.path", "./dive.log")
+	viper.SetDefault("log.level", "")
+	viper.SetDefault("log.path", "")
 	viper.SetDefault("log.enabled", true)
 	// keybindings: status view / global
 	viper.SetDefault("keybinding.quit", "ctrl+c")
@@ -94,51 +150,87 @@ func initConfig() {
 	viper.SetDefault("keybinding.toggle-removed-files", "ctrl+r")
 	viper.SetDefault("keybinding.toggle-modified-files", "ctrl+m")
 	viper.SetDefault("keybinding.toggle-unchanged-files", "ctrl+u")
+	viper.SetDefault("keybinding.cycle-min-size", "ctrl+b")
+	viper.SetDefault("keybinding.cycle-sort-order", "ctrl+o")
+	viper.SetDefault("keybinding.toggle-dotfiles", "ctrl+d")
 	viper.SetDefault("keybinding.page-up", "pgup")
 	viper.SetDefault("keybinding.page-down", "pgdn")
 
+	viper.SetDefault("source", "")
+	viper.SetDefault("ignore-errors", false)
+	viper.SetDefault("containerd.address", "")
+	viper.SetDefault("containerd.namespace", "")
+	viper.SetDefault("registry.username", "")
+	viper.SetDefault("registry.password", "")
+	viper.SetDefault("platform", "")
+	viper.SetDefault("cache.disabled", false)
+	viper.SetDefault("cache.dir", "")
+	viper.SetDefault("cache.max-size", "1GB")
+
 	viper.SetDefault("diff.hide", "")
+	viper.SetDefault("diff.compare-attributes", []string{})
+	viper.SetDefault("diff.strict-compare", false)
+	viper.SetDefault("diff.comparator", "default")
+	viper.SetDefault("diff.detect-moves", false)
 
 	viper.SetDefault("layer.show-aggregated-changes", false)
+	viper.SetDefault("layer.workers", 0)
+	viper.SetDefault("layer.max-files", 100000)
+	viper.SetDefault("layer.size-divergence", 2.0)
+
+	viper.SetDefault("efficiency.wasted-files", "10")
+	viper.SetDefault("efficiency.large-file-size", "50MB")
+	viper.SetDefault("efficiency.removable-paths", []string{})
+
+	for _, rule := range ci.Rules {
+		viper.SetDefault("rules."+rule.Name, rule.Default)
+	}
 
 	viper.SetDefault("filetree.collapse-dir", false)
 	viper.SetDefault("filetree.pane-width", 0.5)
+	viper.SetDefault("filetree.hide", []string{})
+	viper.SetDefault("filetree.ignore", []string{"/proc/*", "/sys/*", "/dev/*"})
+	viper.SetDefault("filetree.compress-chains", false)
+	viper.SetDefault("filetree.hide-dotfiles", false)
+	viper.SetDefault("filetree.hash-algorithm", filetree.XXHash)
+	viper.SetDefault("filetree.metadata-only", false)
+	viper.SetDefault("filetree.read-chunk-size", 2*1024*1024)
+	viper.SetDefault("filetree.max-hash-size", 0)
+	viper.SetDefault("filetree.size-mode", "logical")
 
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		configFileUsed = viper.ConfigFileUsed()
 	}
 }
 
-// initLogging sets up the logging object with a formatter and location
+// initLogging sets up the logging object: every entry at the configured level (the default depends on whether the UI
+// is shown, see ui.Run) is written to the terminal, and to the log file when there is one.
 func initLogging() {
-
-	if viper.GetBool("log.enabled") == false {
-		log.SetOutput(ioutil.Discard)
-	}
-
-	logFileObj, err := os.OpenFile(viper.GetString("log.path"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
-
-	Formatter := new(log.TextFormatter)
-	Formatter.DisableTimestamp = true
-	log.SetFormatter(Formatter)
-
-	level, err := log.ParseLevel(viper.GetString("log.level"))
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if value := viper.GetString("log.level"); value != "" {
+		level, err := log.ParseLevel(value)
+		if err != nil {
+			log.Error("Invalid config value for 'log.level': " + err.Error())
+			utils.Exit(1)
+		}
+		log.SetLevel(level)
 	}
-	log.SetLevel(level)
 
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	} else {
-		log.SetOutput(logFileObj)
+	if path := viper.GetString("log.path"); path != "" && path != loggedFile && viper.GetBool("log.enabled") {
+		logFile, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Error("Could not open the log file: " + err.Error())
+			utils.Exit(1)
+		}
+		log.AddHook(utils.NewFileHook(logFile))
+		loggedFile = path
 	}
 
 	log.Debug("Starting Dive...")
+	if configFileUsed != "" && configFileUsed != loggedConfigFile {
+		log.Info("Using config file: " + configFileUsed)
+		loggedConfigFile = configFileUsed
+	}
 }
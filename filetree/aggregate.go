@@ -0,0 +1,61 @@
+package filetree
+
+import (
+	"archive/tar"
+)
+
+// aggregate is the cached size and file count of a node along with everything beneath it
+type aggregate struct {
+	size  int64
+	count int
+}
+
+// invalidateAggregates drops the cached aggregate sizes and file counts (and diff and entry stats) of the tree, they
+// are recomputed (for the whole tree, in a single pass) the next time they are needed.
+func (tree *FileTree) invalidateAggregates() {
+	if tree != nil {
+		tree.aggregates = nil
+		tree.diffStats = nil
+		tree.entryStats = nil
+	}
+}
+
+// aggregate returns the cached aggregate of the given node, computing the aggregates of the whole tree if needed.
+func (tree *FileTree) aggregate(node *FileNode) aggregate {
+	if tree.aggregates == nil {
+		tree.aggregates = make(map[*FileNode]aggregate)
+		tree.cacheAggregate(tree.Root)
+	}
+	return tree.aggregates[node]
+}
+
+// cacheAggregate records the aggregate of the given node (and every node beneath it).
+func (tree *FileTree) cacheAggregate(node *FileNode) aggregate {
+	var result aggregate
+	if node != tree.Root && !node.IsWhiteout() && !node.Data.FileInfo.IsDir() && node.IsLeaf() {
+		// a hardlink shares the contents of its target, which is already accounted for
+		if node.Data.FileInfo.TypeFlag != tar.TypeLink || node.linkTarget() == nil {
+			result.size = node.Data.FileInfo.Size()
+			result.count = 1
+		}
+	}
+	for _, child := range node.Children {
+		childResult := tree.cacheAggregate(child)
+		result.size += childResult.size
+		result.count += childResult.count
+	}
+	tree.aggregates[node] = result
+	return result
+}
+
+// AggregateSize returns the size (per SetSizeMode) of the files at and beneath this node. Hardlinks to files within
+// the tree and whiteout entries do not add to the size. The sizes are cached until the tree is modified.
+func (node *FileNode) AggregateSize() int64 {
+	return node.Tree.aggregate(node).size
+}
+
+// FileCount returns the number of files (anything but directories) at and beneath this node, counted the same way
+// as AggregateSize.
+func (node *FileNode) FileCount() int {
+	return node.Tree.aggregate(node).count
+}
@@ -0,0 +1,54 @@
+package filetree
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestAggregateSize(t *testing.T) {
+	tree := NewFileTree()
+	tree.AddPath("/usr/bin/busybox", FileInfo{Path: "/usr/bin/busybox", TypeFlag: tar.TypeReg, LogicalBytes: 1000})
+	tree.AddPath("/usr/bin/sh", FileInfo{Path: "/usr/bin/sh", TypeFlag: tar.TypeLink, Linkname: "/usr/bin/busybox"})
+	tree.AddPath("/usr/lib/libc.so", FileInfo{Path: "/usr/lib/libc.so", TypeFlag: tar.TypeReg, LogicalBytes: 500})
+	tree.AddPath("/usr/lib/.wh.libold.so", FileInfo{Path: "/usr/lib/.wh.libold.so", TypeFlag: tar.TypeReg, LogicalBytes: 50})
+	tree.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, LogicalBytes: 20})
+
+	cases := []struct {
+		path  string
+		size  int64
+		count int
+	}{
+		{"/usr/bin", 1000, 1},
+		{"/usr/lib", 500, 1},
+		{"/usr", 1500, 2},
+		{"/usr/bin/busybox", 1000, 1},
+		{"/usr/bin/sh", 0, 0},
+		{"/", 1520, 3},
+	}
+	check := func(name string) {
+		for _, test := range cases {
+			node, err := tree.GetNode(test.path)
+			if err != nil {
+				t.Fatalf("[%s] Expected to find '%s': %v", name, test.path, err)
+			}
+			if node.AggregateSize() != test.size || node.FileCount() != test.count {
+				t.Errorf("[%s] Expected '%s' to hold %d bytes in %d files, got %d bytes in %d files", name, test.path, test.size, test.count, node.AggregateSize(), node.FileCount())
+			}
+		}
+	}
+	check("built")
+
+	// the cache follows modifications of the tree
+	tree.RemovePath("/usr/bin/busybox")
+	tree.AddPath("/usr/lib/libm.so", FileInfo{Path: "/usr/lib/libm.so", TypeFlag: tar.TypeReg, LogicalBytes: 100})
+	cases = []struct {
+		path  string
+		size  int64
+		count int
+	}{
+		{"/usr/bin", 0, 1},
+		{"/usr/lib", 600, 2},
+		{"/", 620, 4},
+	}
+	check("modified")
+}
@@ -0,0 +1,73 @@
+package filetree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Attribute is a tar header attribute that can be folded into the comparison of two FileInfos.
+type Attribute uint
+
+const (
+	AttributeMode Attribute = 1 << iota
+	AttributeUid
+	AttributeGid
+	AttributeModTime
+)
+
+var attributeNames = map[string]Attribute{
+	"mode":  AttributeMode,
+	"uid":   AttributeUid,
+	"gid":   AttributeGid,
+	"mtime": AttributeModTime,
+}
+
+// compareAttributes is the set of attributes (besides the type and contents) that Compare takes into account.
+var compareAttributes Attribute
+
+// strictCompare additionally requires equal modification times for contents to be considered equal
+var strictCompare bool
+
+// SetStrictCompare enables (or disables) treating a modification time change as a content change, as a guard against
+// hash collisions beyond the size check that is always made.
+func SetStrictCompare(strict bool) {
+	strictCompare = strict
+}
+
+// SetCompareAttributes selects (by name) the tar header attributes that FileInfo.Compare takes into account in
+// addition to the file type and contents. An empty list compares contents only.
+func SetCompareAttributes(names []string) error {
+	var mask Attribute
+	for _, name := range names {
+		attribute, ok := attributeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			var supported []string
+			for key := range attributeNames {
+				supported = append(supported, key)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("unknown attribute '%s' (supported: %s)", name, strings.Join(supported, ", "))
+		}
+		mask |= attribute
+	}
+	compareAttributes = mask
+	return nil
+}
+
+// attributesEqual indicates if the given attributes are the same between two FileInfos.
+func (data *FileInfo) attributesEqual(other FileInfo, compareAttributes Attribute) bool {
+	if compareAttributes&AttributeMode != 0 && data.Mode != other.Mode {
+		return false
+	}
+	if compareAttributes&AttributeUid != 0 && data.Uid != other.Uid {
+		return false
+	}
+	if compareAttributes&AttributeGid != 0 && data.Gid != other.Gid {
+		return false
+	}
+	if compareAttributes&AttributeModTime != 0 && !data.ModTime.Equal(other.ModTime) {
+		return false
+	}
+	return true
+}
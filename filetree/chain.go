@@ -0,0 +1,66 @@
+package filetree
+
+// SetCompressChains enables (or disables) rendering chains of directories that each hold a single directory as one
+// node (e.g. "org/apache/commons"). The tree itself is left as is, only the rendering (and the rows a view shows) change.
+func (tree *FileTree) SetCompressChains(compress bool) {
+	tree.CompressChains = compress
+}
+
+// chainChild returns the child this node is rendered together with when chains are compressed, or nil when the node
+// ends its chain. A chain only continues through expanded, Unchanged directories with a single visible child that is
+// an Unchanged directory itself, so changes are never folded away.
+func (node *FileNode) chainChild() *FileNode {
+	if node.Tree == nil || !node.Tree.CompressChains || node == node.Tree.Root {
+		return nil
+	}
+	if node.Data.ViewInfo.Collapsed || node.Data.DiffType != Unchanged {
+		return nil
+	}
+	var only *FileNode
+	for _, child := range node.Children {
+		if child.Data.ViewInfo.Hidden {
+			continue
+		}
+		if only != nil {
+			return nil
+		}
+		only = child
+	}
+	if only == nil || only.Data.DiffType != Unchanged || (only.IsLeaf() && !only.Data.FileInfo.IsDir()) {
+		return nil
+	}
+	return only
+}
+
+// InChain indicates if this node is rendered as part of the row of its parent (see SetCompressChains).
+func (node *FileNode) InChain() bool {
+	return node.Parent != nil && node.Parent.chainChild() == node
+}
+
+// ChainHead returns the first node of the chain this node is rendered in, which is the node shown as the row (this
+// node itself when chains are not compressed).
+func (node *FileNode) ChainHead() *FileNode {
+	for node.InChain() {
+		node = node.Parent
+	}
+	return node
+}
+
+// ChainEnd returns the deepest directory of the chain starting at this node, which is the node that collapsing or
+// expanding the row acts on (this node itself when chains are not compressed).
+func (node *FileNode) ChainEnd() *FileNode {
+	for next := node.chainChild(); next != nil; next = node.chainChild() {
+		node = next
+	}
+	return node
+}
+
+// chainPrefix returns the leading directory names of the chain ending at this node (e.g. "org/apache/"), empty when
+// the node is not part of a chain.
+func (node *FileNode) chainPrefix() string {
+	var prefix string
+	for cur := node; cur.InChain(); cur = cur.Parent {
+		prefix = cur.Parent.Name + "/" + prefix
+	}
+	return prefix
+}
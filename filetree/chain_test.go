@@ -0,0 +1,71 @@
+package filetree
+
+import (
+	"testing"
+)
+
+func TestCompressChains(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/usr/share/java/org/apache/commons/a.jar", "/usr/share/java/org/apache/commons/b.jar", "/usr/bin/env"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	tree.SetCompressChains(true)
+
+	expected :=
+		`└── usr
+    ├── bin
+    │   └── env
+    └── share/java/org/apache/commons
+        ├── a.jar
+        └── b.jar
+`
+	if actual := tree.String(false); expected != actual {
+		t.Errorf("Expected tree string:\n--->%s<---\nGot:\n--->%s<---", expected, actual)
+	}
+
+	share, _ := tree.GetNode("/usr/share")
+	apache, _ := tree.GetNode("/usr/share/java/org/apache")
+	commons := share.ChainEnd()
+	if commons.Path() != "/usr/share/java/org/apache/commons" || apache.ChainHead() != share || !apache.InChain() || share.InChain() {
+		t.Errorf("Expected '/usr/share' to head the chain ending at '/usr/share/java/org/apache/commons', got: %s", commons.Path())
+	}
+
+	// collapsing the chain collapses its deepest directory
+	commons.Data.ViewInfo.Collapsed = true
+	expected =
+		`└── usr
+    ├── bin
+    │   └── env
+    └─⊕ share/java/org/apache/commons
+`
+	if actual := tree.String(false); expected != actual {
+		t.Errorf("Expected tree string:\n--->%s<---\nGot:\n--->%s<---", expected, actual)
+	}
+	commons.Data.ViewInfo.Collapsed = false
+
+	// changed directories break the chain
+	org, _ := tree.GetNode("/usr/share/java/org")
+	org.Data.DiffType = Changed
+	expected =
+		`└── usr
+    ├── bin
+    │   └── env
+    └── share/java
+        └── org
+            └── apache/commons
+                ├── a.jar
+                └── b.jar
+`
+	if actual := tree.String(false); expected != actual {
+		t.Errorf("Expected tree string:\n--->%s<---\nGot:\n--->%s<---", expected, actual)
+	}
+
+	// the tree itself is untouched
+	if _, err := tree.GetNode("/usr/share/java/org/apache/commons/a.jar"); err != nil {
+		t.Errorf("Expected lookups to be unaffected by compression: %v", err)
+	}
+	tree.SetCompressChains(false)
+	if share.ChainEnd() != share || apache.InChain() {
+		t.Errorf("Expected no chains without compression")
+	}
+}
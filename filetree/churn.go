@@ -0,0 +1,102 @@
+package filetree
+
+import (
+	"archive/tar"
+	"sort"
+	"strings"
+)
+
+// ChurnFile is a file added by a layer and deleted by a later one (by a whiteout, or an opaque directory hiding it),
+// so the image stores bytes the final filesystem never sees: build artifacts, package manager caches, or secrets
+// that were "removed".
+type ChurnFile struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	AddedLayer   int    `json:"addedLayer"`
+	RemovedLayer int    `json:"removedLayer"`
+}
+
+// ChurnSlice is a set of ChurnFile, ordered by size (largest first)
+type ChurnSlice []ChurnFile
+
+// churnCopy is the copy of a path visible on top of the layers read so far
+type churnCopy struct {
+	layer int
+	size  int64
+}
+
+// FindChurn walks the given trees (layers) in order, reporting every (non-empty) regular file some layer adds and a
+// later layer deletes, with the layer adding it and the layer deleting it. A file rewritten by a later layer is not
+// deleted (see WastedFiles for rewrites), only the last copy before the deletion is reported. Ties are ordered by
+// path, then adding layer.
+func FindChurn(trees []*FileTree) ChurnSlice {
+	files := make(ChurnSlice, 0)
+	visible := make(map[string]churnCopy)
+
+	remove := func(layer int, removed func(path string) bool) {
+		for path, stored := range visible {
+			if removed(path) {
+				files = append(files, ChurnFile{Path: path, Size: stored.size, AddedLayer: stored.layer, RemovedLayer: layer})
+				delete(visible, path)
+			}
+		}
+	}
+
+	for idx, tree := range trees {
+		var whiteouts []string
+		added := make(map[string]int64)
+		tree.VisitDepthChildFirst(func(node *FileNode) error {
+			info := node.Data.FileInfo
+			switch {
+			case node.IsWhiteout():
+				whiteouts = append(whiteouts, node.Path())
+			case !info.IsDir():
+				added[node.Path()] = -1
+				if info.TypeFlag == tar.TypeReg || info.TypeFlag == tar.TypeRegA {
+					added[node.Path()] = node.Size()
+				}
+			}
+			return nil
+		}, func(node *FileNode) bool { return node.IsLeaf() })
+
+		for _, dir := range tree.opaqueDirs() {
+			prefix := strings.TrimSuffix(dir.Path(), "/") + "/"
+			remove(idx, func(path string) bool {
+				_, readded := added[path]
+				return strings.HasPrefix(path, prefix) && !readded
+			})
+		}
+		for _, whiteout := range whiteouts {
+			remove(idx, func(path string) bool {
+				return path == whiteout || strings.HasPrefix(path, whiteout+"/")
+			})
+		}
+
+		for path, size := range added {
+			delete(visible, path)
+			if size > 0 {
+				visible[path] = churnCopy{idx, size}
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		if files[i].Path != files[j].Path {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].AddedLayer < files[j].AddedLayer
+	})
+	return files
+}
+
+// TotalSize returns the bytes stored for all the files
+func (files ChurnSlice) TotalSize() int64 {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	return total
+}
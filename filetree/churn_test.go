@@ -0,0 +1,47 @@
+package filetree
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+)
+
+func TestFindChurn(t *testing.T) {
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "root/.netrc", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "app/build/out.o", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/build/main.o", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/main", Typeflag: tar.TypeReg, Mode: 0755},
+			{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"root/.netrc": "secret", "app/build/out.o": "object", "app/build/main.o": "objects", "app/main": "binary", "etc/config": "v1"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "var/cache/apt/pkgcache.bin", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "root/.wh..netrc", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"var/cache/apt/pkgcache.bin": "package cache", "etc/config": "v2"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "app/.wh.build", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "var/cache/apt/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "etc/.wh.config", Typeflag: tar.TypeReg, Mode: 0644},
+		}, nil),
+	}
+
+	expected := ChurnSlice{
+		{Path: "/var/cache/apt/pkgcache.bin", Size: 13, AddedLayer: 1, RemovedLayer: 2},
+		{Path: "/app/build/main.o", Size: 7, AddedLayer: 0, RemovedLayer: 2},
+		{Path: "/app/build/out.o", Size: 6, AddedLayer: 0, RemovedLayer: 2},
+		{Path: "/root/.netrc", Size: 6, AddedLayer: 0, RemovedLayer: 1},
+		{Path: "/etc/config", Size: 2, AddedLayer: 1, RemovedLayer: 2},
+	}
+	churn := FindChurn(trees)
+	if !reflect.DeepEqual(churn, expected) {
+		t.Errorf("unexpected churn:\n%+v\nexpected:\n%+v", churn, expected)
+	}
+	if total := churn.TotalSize(); total != 34 {
+		t.Errorf("expected 34 bytes of churn, got %d", total)
+	}
+	if churn := FindChurn(trees[:1]); len(churn) != 0 {
+		t.Errorf("expected no churn for a single layer, got %+v", churn)
+	}
+}
@@ -0,0 +1,101 @@
+package filetree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultComparison = "default"
+	ContentComparison = "content"
+)
+
+// Comparator determines the DiffType between the lower (a) and upper (b) version of the same file. Tree comparisons
+// (see FileTree.Compare and CompareTrees) use the current Comparator for every node present on both sides, the
+// results for directories are merged from their children regardless of the Comparator (see DiffType.Merge).
+type Comparator interface {
+	Compare(a, b FileInfo) DiffType
+}
+
+// DefaultComparator compares FileInfos with FileInfo.Compare, taking the attributes selected with
+// SetCompareAttributes and SetStrictCompare into account.
+type DefaultComparator struct{}
+
+// Compare two FileInfos with FileInfo.Compare
+func (DefaultComparator) Compare(a, b FileInfo) DiffType {
+	return a.Compare(b)
+}
+
+// ContentComparator only reports files whose type or contents (or link target, or device numbers) differ as
+// Changed, any other difference is ignored.
+type ContentComparator struct{}
+
+// Compare the type and contents of two FileInfos
+func (ContentComparator) Compare(a, b FileInfo) DiffType {
+	if a.TypeFlag != b.TypeFlag {
+		return Changed
+	}
+	if isLink(a.TypeFlag) && a.Linkname != b.Linkname {
+		// links have no contents to hash, what they point to is what changes
+		return Changed
+	}
+	if isDevice(a.TypeFlag) && (a.Devmajor != b.Devmajor || a.Devminor != b.Devminor) {
+		// device nodes have no contents either, they are identified by their device numbers
+		return Changed
+	}
+	if !a.contentsEqual(b) {
+		return Changed
+	}
+	return Unchanged
+}
+
+// AttributeComparator refines the result of another Comparator: files the Base finds Unchanged are MetadataChanged
+// when any of the given Attributes (or, with Xattrs set, the extended attributes) differ.
+type AttributeComparator struct {
+	Base       Comparator
+	Attributes Attribute
+	Xattrs     bool
+}
+
+// Compare two FileInfos with the Base comparator, then by the selected attributes
+func (comparator AttributeComparator) Compare(a, b FileInfo) DiffType {
+	diffType := comparator.Base.Compare(a, b)
+	if diffType != Unchanged {
+		return diffType
+	}
+	if !a.attributesEqual(b, comparator.Attributes) || (comparator.Xattrs && !xattrsEqual(a.Xattrs, b.Xattrs)) {
+		return MetadataChanged
+	}
+	return Unchanged
+}
+
+var comparators = map[string]Comparator{
+	DefaultComparison: DefaultComparator{},
+	ContentComparison: ContentComparator{},
+}
+
+var currentComparator Comparator = DefaultComparator{}
+
+// SetComparison selects (by name) the Comparator used by tree comparisons.
+func SetComparison(name string) error {
+	comparator, ok := comparators[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		var names []string
+		for key := range comparators {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown comparison '%s' (supported: %s)", name, strings.Join(names, ", "))
+	}
+	currentComparator = comparator
+	return nil
+}
+
+// SetComparator sets the Comparator used by tree comparisons, nil restores the DefaultComparator.
+func SetComparator(comparator Comparator) {
+	if comparator == nil {
+		comparator = DefaultComparator{}
+	}
+	currentComparator = comparator
+}
@@ -0,0 +1,81 @@
+package filetree
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// pathComparator reports every file below the given prefix as Changed
+type pathComparator struct {
+	prefix string
+}
+
+func (comparator pathComparator) Compare(a, b FileInfo) DiffType {
+	if strings.HasPrefix(b.Path, comparator.prefix) {
+		return Changed
+	}
+	return Unchanged
+}
+
+func TestComparators(t *testing.T) {
+	lower := FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 123, LogicalBytes: 10, Mode: 0644, Xattrs: map[string]string{"user.a": "1"}}
+	chmod := lower
+	chmod.Mode = 0600
+	xattr := lower
+	xattr.Xattrs = map[string]string{"user.a": "2"}
+	edit := lower
+	edit.hash = 456
+
+	cases := []struct {
+		name       string
+		comparator Comparator
+		upper      FileInfo
+		expected   DiffType
+	}{
+		{"content ignores mode", ContentComparator{}, chmod, Unchanged},
+		{"content ignores xattrs", ContentComparator{}, xattr, Unchanged},
+		{"content sees edits", ContentComparator{}, edit, Changed},
+		{"mask compares mode", AttributeComparator{Base: ContentComparator{}, Attributes: AttributeMode}, chmod, MetadataChanged},
+		{"mask ignores unselected", AttributeComparator{Base: ContentComparator{}, Attributes: AttributeUid}, chmod, Unchanged},
+		{"mask compares xattrs", AttributeComparator{Base: ContentComparator{}, Xattrs: true}, xattr, MetadataChanged},
+		{"mask keeps base changes", AttributeComparator{Base: ContentComparator{}, Attributes: AttributeMode}, edit, Changed},
+		{"default", DefaultComparator{}, xattr, MetadataChanged},
+	}
+	for _, test := range cases {
+		if actual := test.comparator.Compare(lower, test.upper); actual != test.expected {
+			t.Errorf("[%s] expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestSetComparator(t *testing.T) {
+	defer SetComparator(nil)
+
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	for _, path := range []string{"/etc/hosts", "/usr/bin/env"} {
+		lowerTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: 123, LogicalBytes: 10})
+		upperTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: 123, LogicalBytes: 10, Mode: os.FileMode(0600)})
+	}
+
+	SetComparator(pathComparator{"/usr"})
+	if err := lowerTree.Compare(upperTree); err != nil {
+		t.Fatalf("could not compare trees: %v", err)
+	}
+	for path, expected := range map[string]DiffType{"/etc/hosts": Unchanged, "/etc": Unchanged, "/usr/bin/env": Changed, "/usr/bin": Changed, "/usr": Changed} {
+		if node, _ := lowerTree.GetNode(path); node.Data.DiffType != expected {
+			t.Errorf("expected '%s' to be %v, got %v", path, expected, node.Data.DiffType)
+		}
+	}
+	if stats := lowerTree.DiffStats(); stats.Files[Changed] != 1 || stats.Files[Unchanged] != 1 {
+		t.Errorf("expected one changed and one unchanged file, got %+v", stats.Files)
+	}
+
+	if err := SetComparison("Content"); err != nil {
+		t.Errorf("expected the content comparison to be known, got: %v", err)
+	}
+	if err := SetComparison("bogus"); err == nil {
+		t.Errorf("expected an error for an unknown comparison")
+	}
+}
@@ -2,10 +2,18 @@ package filetree
 
 import (
 	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/cespare/xxhash"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -15,13 +23,28 @@ const (
 	Changed
 	Added
 	Removed
+	MetadataChanged
+	Moved
 )
 
-// NodeData is the payload for a FileNode
+// NodeData is the payload for a FileNode. Copies of a tree (see FileTree.Copy) share the NodeData of their nodes until
+// a node is changed, so it is changed through the methods of FileNode (e.g. SetViewInfo, AssignDiffType) which give
+// the node its own NodeData first, leaving the other copies as they are. For Moved nodes (see FileTree.DetectMoves)
+// MovedFrom holds the old path of the file at its new location, and MovedTo the new path of the file at its old
+// location.
 type NodeData struct {
-	ViewInfo ViewInfo
-	FileInfo FileInfo
-	DiffType DiffType
+	ViewInfo    ViewInfo
+	FileInfo    FileInfo
+	DiffType    DiffType
+	Annotations map[string]string
+	MovedFrom   string
+	MovedTo     string
+	// AddedLayer and ModifiedLayer are the indexes of the layers that introduced the path and that last changed it
+	// (see FileTree.SetLayer), -1 when unknown
+	AddedLayer    int
+	ModifiedLayer int
+	// shares is the number of other nodes holding this NodeData (see FileNode.writeData)
+	shares int32
 }
 
 // ViewInfo contains UI specific detail for a specific FileNode
@@ -30,33 +53,97 @@ type ViewInfo struct {
 	Hidden    bool
 }
 
-// FileInfo contains tar metadata for a specific FileNode
+// FileInfo contains tar metadata for a specific FileNode. Only the parts of the tar header used after the tree has
+// been built are kept, since there is one FileInfo per file in every layer.
 type FileInfo struct {
-	Path      string
-	TypeFlag  byte
-	hash      uint64
-	TarHeader tar.Header
+	Path         string
+	TypeFlag     byte
+	Linkname     string
+	hash         uint64
+	digest       []byte
+	hashSkipped  bool
+	LogicalBytes int64
+	StoredBytes  int64
+	Mode         os.FileMode
+	Uid          int
+	Gid          int
+	ModTime      time.Time
+	Devmajor     int64
+	Devminor     int64
+	Xattrs       map[string]string
+	Unreadable   bool
 }
 
 // DiffType defines the comparison result between two FileNodes
 type DiffType int
 
+// SizeMode selects which size of a file is aggregated for display and efficiency calculations
+type SizeMode int
+
+const (
+	// LogicalSize is the size of the file as seen in the filesystem (the tar header size, or that of the target for
+	// hardlinks)
+	LogicalSize SizeMode = iota
+	// StoredSize is the number of bytes the layer holds for the file (zero for hardlinks)
+	StoredSize
+)
+
+var sizeMode = LogicalSize
+
+// SetSizeMode selects (by name, "logical" or "stored") which file size is aggregated.
+func SetSizeMode(name string) error {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "logical":
+		sizeMode = LogicalSize
+	case "stored":
+		sizeMode = StoredSize
+	default:
+		return fmt.Errorf("unknown size mode '%s' (supported: logical, stored)", name)
+	}
+	return nil
+}
+
 // NewNodeData creates an empty NodeData struct for a FileNode
 func NewNodeData() *NodeData {
 	return &NodeData{
-		ViewInfo: *NewViewInfo(),
-		FileInfo: FileInfo{},
-		DiffType: Unchanged,
+		ViewInfo:      *NewViewInfo(),
+		FileInfo:      FileInfo{},
+		DiffType:      Unchanged,
+		AddedLayer:    -1,
+		ModifiedLayer: -1,
 	}
 }
 
 // Copy duplicates a NodeData
 func (data *NodeData) Copy() *NodeData {
 	return &NodeData{
-		ViewInfo: *data.ViewInfo.Copy(),
-		FileInfo: *data.FileInfo.Copy(),
-		DiffType: data.DiffType,
+		ViewInfo:      *data.ViewInfo.Copy(),
+		FileInfo:      *data.FileInfo.Copy(),
+		DiffType:      data.DiffType,
+		Annotations:   copyAnnotations(data.Annotations),
+		MovedFrom:     data.MovedFrom,
+		MovedTo:       data.MovedTo,
+		AddedLayer:    data.AddedLayer,
+		ModifiedLayer: data.ModifiedLayer,
+	}
+}
+
+// share records one more node holding this NodeData (see FileNode.Copy)
+func (data *NodeData) share() *NodeData {
+	atomic.AddInt32(&data.shares, 1)
+	return data
+}
+
+// copyAnnotations duplicates a set of node annotations (nil stays nil)
+func copyAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	duplicate := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		duplicate[key] = value
 	}
+	return duplicate
 }
 
 // NewViewInfo creates a default ViewInfo
@@ -74,45 +161,260 @@ func (view *ViewInfo) Copy() (newView *ViewInfo) {
 	return newView
 }
 
-var chuckSize = 2 * 1024 * 1024
+var chunkSize = 2 * 1024 * 1024
+
+// maxEmptyReads is the number of consecutive empty reads tolerated before a reader is considered stuck
+const maxEmptyReads = 100
+
+// paxXattrPrefix is the PAX record prefix used to store extended attributes
+const paxXattrPrefix = "SCHILY.xattr."
+
+// bufferPool holds the scratch buffers used to read file contents, so every file read does not allocate a new chunk.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, chunkSize)
+		return &buf
+	},
+}
+
+// SetReadChunkSize sets the size (in bytes) of the buffer used when reading file contents for hashing.
+func SetReadChunkSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("read chunk size must be positive, given %d", size)
+	}
+	chunkSize = size
+	return nil
+}
+
+// maxHashSize is the file size (in bytes) above which contents are not hashed, 0 means no limit
+var maxHashSize int64
+
+// SetMaxHashSize sets the file size (in bytes) above which NewFileInfo skips hashing the contents, comparing these
+// files by their metadata instead. A size of 0 hashes every file.
+func SetMaxHashSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("max hash size cannot be negative, given %d", size)
+	}
+	maxHashSize = size
+	return nil
+}
+
+// ProgressHandler is notified of every tar entry read by NewFileInfo, with the entry path and size (in bytes).
+type ProgressHandler func(path string, size int64)
 
-func getHashFromReader(reader io.Reader) uint64 {
-	h := xxhash.New()
+var progressHandler ProgressHandler
 
-	buf := make([]byte, chuckSize)
-	for {
+// SetProgressHandler registers the callback NewFileInfo invokes for every entry it reads. A nil handler disables
+// progress reporting.
+func SetProgressHandler(handler ProgressHandler) {
+	progressHandler = handler
+}
+
+// getHashFromReader hashes all remaining bytes from the given reader with the configured Hasher, returning the hash
+// and the number of bytes read.
+func getHashFromReader(reader io.Reader) (hash.Hash, uint64, error) {
+	h := currentHasher.New()
+	var bytesRead uint64
+
+	bufPtr := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufPtr)
+	if len(*bufPtr) != chunkSize {
+		// the chunk size has changed since this buffer was pooled
+		*bufPtr = make([]byte, chunkSize)
+	}
+	buf := *bufPtr
+
+	for emptyReads := 0; ; {
 		n, err := reader.Read(buf)
-		if err != nil && err != io.EOF {
-			logrus.Panic(err)
+		// bytes returned alongside an error are still part of the contents
+		bytesRead += uint64(n)
+		h.Write(buf[:n])
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return h, bytesRead, err
 		}
+
+		// readers may return (0, nil) now and then, but not forever
 		if n == 0 {
-			break
+			emptyReads++
+			if emptyReads >= maxEmptyReads {
+				return h, bytesRead, io.ErrNoProgress
+			}
+		} else {
+			emptyReads = 0
 		}
+	}
 
-		h.Write(buf[:n])
+	return h, bytesRead, nil
+}
+
+// hasContents indicates if a tar entry of the given type carries file contents in the tar stream.
+func hasContents(typeFlag byte) bool {
+	switch typeFlag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return false
+	}
+	return true
+}
+
+// isSparse indicates if a tar entry is a GNU (typeflag 'S') or PAX (GNU.sparse.* records) sparse file.
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
 	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
 
-	return h.Sum64()
+// xattrsFromHeader collects the extended attributes of a tar entry, both from the PAX records (SCHILY.xattr.*) and the
+// deprecated Xattrs field. Entries without extended attributes return nil.
+func xattrsFromHeader(header *tar.Header) map[string]string {
+	var xattrs map[string]string
+	add := func(key, value string) {
+		if xattrs == nil {
+			xattrs = make(map[string]string)
+		}
+		xattrs[key] = value
+	}
+
+	for key, value := range header.PAXRecords {
+		if strings.HasPrefix(key, paxXattrPrefix) {
+			add(strings.TrimPrefix(key, paxXattrPrefix), value)
+		}
+	}
+	for key, value := range header.Xattrs {
+		add(key, value)
+	}
+	return xattrs
 }
 
-// NewFileInfo extracts the metadata from a tar header and file contents and generates a new FileInfo object.
-func NewFileInfo(reader *tar.Reader, header *tar.Header, path string) FileInfo {
+// xattrsEqual indicates if two sets of extended attributes hold the same values (regardless of ordering). A missing
+// set is equal to an empty one.
+func xattrsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// NewFileInfo extracts the metadata from a tar header and file contents (read from the given reader, e.g. the tar
+// reader positioned at the entry) and generates a new FileInfo object. When hashContents is false the file contents are
+// not read and comparisons fall back to the tar header metadata (as they do for files larger than the SetMaxHashSize
+// threshold, which are read but not hashed). If the file contents could not be fully read, the returned FileInfo is
+// marked as Unreadable and an error is returned.
+func NewFileInfo(reader io.Reader, header *tar.Header, path string, hashContents bool) (FileInfo, error) {
+	if progressHandler != nil {
+		progressHandler(path, header.Size)
+	}
+
+	info := FileInfo{
+		Path:         path,
+		TypeFlag:     header.Typeflag,
+		Linkname:     header.Linkname,
+		LogicalBytes: header.Size,
+		Mode:         header.FileInfo().Mode(),
+		Uid:          header.Uid,
+		Gid:          header.Gid,
+		ModTime:      header.ModTime,
+		Devmajor:     header.Devmajor,
+		Devminor:     header.Devminor,
+		Xattrs:       xattrsFromHeader(header),
+	}
+
 	if header.Typeflag == tar.TypeDir {
-		return FileInfo{
-			Path:      path,
-			TypeFlag:  header.Typeflag,
-			hash:      0,
-			TarHeader: *header,
+		return info, nil
+	}
+
+	if !hashContents {
+		info.hashSkipped = true
+		if hasContents(header.Typeflag) {
+			// not read, so trust the header
+			info.StoredBytes = header.Size
 		}
+		return info, nil
 	}
 
-	hash := getHashFromReader(reader)
+	var bytesRead uint64
+	var err error
+	if maxHashSize > 0 && header.Size > maxHashSize {
+		// not worth hashing, but drain the contents so the size can still be verified
+		var n int64
+		n, err = io.Copy(ioutil.Discard, reader)
+		bytesRead = uint64(n)
+		info.hashSkipped = true
+	} else {
+		var h hash.Hash
+		h, bytesRead, err = getHashFromReader(reader)
+		info.hash, info.digest = sumHash(h)
+	}
+	info.StoredBytes = int64(bytesRead)
+
+	if err != nil {
+		info.Unreadable = true
+		return info, fmt.Errorf("could not read '%s' (expected %d bytes, read %d): %v", path, header.Size, bytesRead, err)
+	}
+
+	if hasContents(header.Typeflag) && int64(bytesRead) != header.Size {
+		if isSparse(header) {
+			// the reader expands the sparse map it understands, any shortfall is a hole we can't see: hash what was given
+			logrus.Debugf("sparse file '%s' expected %d bytes, read %d", path, header.Size, bytesRead)
+			return info, nil
+		}
+		info.Unreadable = true
+		return info, fmt.Errorf("could not read '%s': expected %d bytes, read %d", path, header.Size, bytesRead)
+	}
+
+	return info, nil
+}
+
+// Hash returns the 64-bit fingerprint of the file contents (the leading 8 bytes for digests wider than 64 bits).
+// Directories, and entries whose contents were not hashed, have a hash of 0 which carries no meaning.
+func (data *FileInfo) Hash() uint64 {
+	return data.hash
+}
+
+// MarshalJSON encodes a FileInfo, including its content hash
+func (data FileInfo) MarshalJSON() ([]byte, error) {
+	type fileInfo FileInfo
+	return json.Marshal(struct {
+		fileInfo
+		Hash uint64
+	}{fileInfo(data), data.hash})
+}
+
+// Size returns the logical or stored size of the file (per SetSizeMode), without resolving hardlinks.
+func (data *FileInfo) Size() int64 {
+	if sizeMode == StoredSize {
+		return data.StoredBytes
+	}
+	return data.LogicalBytes
+}
+
+// IsDir indicates if the FileInfo describes a directory
+func (data *FileInfo) IsDir() bool {
+	return data.Mode.IsDir()
+}
 
+// implicitDirInfo is the FileInfo of a directory without a tar entry of its own, which only exists as the parent of
+// other paths (e.g. in layers built by tools that omit directory entries, or list children before their parents).
+// Until its entry is added (if ever), it has the defaults of a directory created by mkdir -p: mode 0755 owned by root.
+// The typeflag stays unset, telling it apart from a directory entry.
+func implicitDirInfo(path string) FileInfo {
 	return FileInfo{
-		Path:      path,
-		TypeFlag:  header.Typeflag,
-		hash:      hash,
-		TarHeader: *header,
+		Path: path,
+		Mode: os.ModeDir | 0755,
 	}
 }
 
@@ -121,22 +423,49 @@ func (data *FileInfo) Copy() *FileInfo {
 	if data == nil {
 		return nil
 	}
-	return &FileInfo{
-		Path:      data.Path,
-		TypeFlag:  data.TypeFlag,
-		hash:      data.hash,
-		TarHeader: data.TarHeader,
-	}
+	newData := *data
+	return &newData
 }
 
-// Compare determines the DiffType between two FileInfos based on the type and contents of each given FileInfo
+// Compare determines the DiffType between two FileInfos based on the type and contents of each given FileInfo. If
+// either FileInfo was not hashed then the size, modification time, and mode are compared instead of the contents.
+// Symlinks and hardlinks are also compared by their link target, and device nodes by their device numbers. When the
+// contents match but the extended attributes or any attributes selected with SetCompareAttributes differ, the result
+// is MetadataChanged.
 func (data *FileInfo) Compare(other FileInfo) DiffType {
-	if data.TypeFlag == other.TypeFlag {
-		if data.hash == other.hash {
-			return Unchanged
-		}
+	return AttributeComparator{Base: ContentComparator{}, Attributes: compareAttributes, Xattrs: true}.Compare(*data, other)
+}
+
+// contentsEqual indicates if two FileInfos hold the same contents, judged from the tar header metadata when either
+// side was not hashed. Hashes are only trusted along with equal sizes (and, in strict mode, modification times).
+func (data *FileInfo) contentsEqual(other FileInfo) bool {
+	if data.hashSkipped || other.hashSkipped {
+		return data.metadataEqual(other)
 	}
-	return Changed
+	if data.LogicalBytes != other.LogicalBytes || data.StoredBytes != other.StoredBytes {
+		return false
+	}
+	if strictCompare && !data.ModTime.Equal(other.ModTime) {
+		return false
+	}
+	return data.hash == other.hash && bytes.Equal(data.digest, other.digest)
+}
+
+// isLink indicates if a tar entry of the given type is a symlink or a hardlink.
+func isLink(typeFlag byte) bool {
+	return typeFlag == tar.TypeSymlink || typeFlag == tar.TypeLink
+}
+
+// isDevice indicates if a tar entry of the given type is a character or block device node.
+func isDevice(typeFlag byte) bool {
+	return typeFlag == tar.TypeChar || typeFlag == tar.TypeBlock
+}
+
+// metadataEqual indicates if the tar header metadata that changes along with the file contents is the same.
+func (data *FileInfo) metadataEqual(other FileInfo) bool {
+	return data.LogicalBytes == other.LogicalBytes &&
+		data.Mode == other.Mode &&
+		data.ModTime.Equal(other.ModTime)
 }
 
 // String of a DiffType
@@ -150,16 +479,59 @@ func (diff DiffType) String() string {
 		return "Added"
 	case Removed:
 		return "Removed"
+	case MetadataChanged:
+		return "MetadataChanged"
+	case Moved:
+		return "Moved"
 	default:
 		return fmt.Sprintf("%d", int(diff))
 	}
 }
 
-// merge two DiffTypes into a single result. Essentially, return the given value unless they two values differ,
-// in which case we can only determine that there is "a change".
-func (diff DiffType) merge(other DiffType) DiffType {
+// diffTypes lists every known DiffType
+var diffTypes = []DiffType{Unchanged, Changed, Added, Removed, MetadataChanged, Moved}
+
+// ParseDiffType returns the DiffType with the given name (e.g. "Changed"), ignoring case.
+func ParseDiffType(name string) (DiffType, error) {
+	for _, diff := range diffTypes {
+		if strings.EqualFold(strings.TrimSpace(name), diff.String()) {
+			return diff, nil
+		}
+	}
+	return Unchanged, fmt.Errorf("unknown diff type '%s'", name)
+}
+
+// MarshalJSON encodes a DiffType as its name
+func (diff DiffType) MarshalJSON() ([]byte, error) {
+	if _, err := ParseDiffType(diff.String()); err != nil {
+		return nil, fmt.Errorf("cannot marshal unknown diff type %d", int(diff))
+	}
+	return json.Marshal(diff.String())
+}
+
+// UnmarshalJSON decodes a DiffType from its name
+func (diff *DiffType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	parsed, err := ParseDiffType(name)
+	if err != nil {
+		return err
+	}
+	*diff = parsed
+	return nil
+}
+
+// Merge two DiffTypes into a single result. Essentially, return the given value unless they two values differ,
+// in which case we can only determine that there is "a change". The exception is a metadata change against an
+// unchanged value, which is still only a metadata change.
+func (diff DiffType) Merge(other DiffType) DiffType {
 	if diff == other {
 		return diff
 	}
+	if (diff == MetadataChanged && other == Unchanged) || (diff == Unchanged && other == MetadataChanged) {
+		return MetadataChanged
+	}
 	return Changed
 }
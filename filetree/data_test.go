@@ -1,7 +1,15 @@
 package filetree
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAssignDiffType(t *testing.T) {
@@ -19,16 +27,600 @@ func TestAssignDiffType(t *testing.T) {
 func TestMergeDiffTypes(t *testing.T) {
 	a := Unchanged
 	b := Unchanged
-	merged := a.merge(b)
+	merged := a.Merge(b)
 	if merged != Unchanged {
 		t.Errorf("Expected Unchaged (0) but got %v", merged)
 	}
 	a = Changed
 	b = Unchanged
-	merged = a.merge(b)
+	merged = a.Merge(b)
 	if merged != Changed {
 		t.Errorf("Expected Unchaged (0) but got %v", merged)
 	}
+
+	cases := []struct {
+		a, b, expected DiffType
+	}{
+		{MetadataChanged, Unchanged, MetadataChanged},
+		{Unchanged, MetadataChanged, MetadataChanged},
+		{MetadataChanged, MetadataChanged, MetadataChanged},
+		{MetadataChanged, Changed, Changed},
+		{Changed, MetadataChanged, Changed},
+		{MetadataChanged, Added, Changed},
+	}
+	for _, test := range cases {
+		if merged := test.a.Merge(test.b); merged != test.expected {
+			t.Errorf("Expected %v merged with %v to be %v but got %v", test.a, test.b, test.expected, merged)
+		}
+	}
+}
+
+func TestParseDiffType(t *testing.T) {
+	for _, expected := range []DiffType{Unchanged, Changed, Added, Removed, MetadataChanged} {
+		for _, name := range []string{expected.String(), strings.ToLower(expected.String())} {
+			actual, err := ParseDiffType(name)
+			if err != nil || actual != expected {
+				t.Errorf("Expected '%s' to parse as %v, got %v (%v)", name, expected, actual, err)
+			}
+		}
+	}
+
+	if _, err := ParseDiffType("modified"); err == nil {
+		t.Errorf("Expected an error for an unknown diff type")
+	}
+}
+
+func TestDiffTypeJSON(t *testing.T) {
+	expected := map[string]DiffType{"/etc": Changed, "/usr": Added, "/tmp": Removed, "/var": Unchanged}
+	data, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("Expected no error marshaling diff types, got: %v", err)
+	}
+	if !strings.Contains(string(data), `"/etc":"Changed"`) {
+		t.Errorf("Expected diff types to be encoded by name, got: %s", data)
+	}
+
+	var actual map[string]DiffType
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("Expected no error unmarshaling diff types, got: %v", err)
+	}
+	for path, diff := range expected {
+		if actual[path] != diff {
+			t.Errorf("Expected %s to round-trip as %v, got %v", path, diff, actual[path])
+		}
+	}
+
+	var diff DiffType
+	if err := json.Unmarshal([]byte(`"Modified"`), &diff); err == nil {
+		t.Errorf("Expected an error unmarshaling an unknown diff type")
+	}
+	if _, err := json.Marshal(DiffType(42)); err == nil {
+		t.Errorf("Expected an error marshaling an unknown diff type")
+	}
+}
+
+func TestNewFileInfoTruncatedEntry(t *testing.T) {
+	contents := []byte("some file contents")
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	writer.WriteHeader(&tar.Header{Name: "etc/truncated", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))})
+	writer.Write(contents)
+	writer.Close()
+
+	// keep the header block and only the first 5 bytes of the contents
+	reader := tar.NewReader(bytes.NewReader(buf.Bytes()[:512+5]))
+	header, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Expected no error reading the header, got: %v", err)
+	}
+
+	info, err := NewFileInfo(reader, header, header.Name, true)
+	if err == nil {
+		t.Fatalf("Expected an error when reading a truncated entry")
+	}
+	if !info.Unreadable {
+		t.Errorf("Expected the FileInfo to be marked as unreadable")
+	}
+	if info.Path != "etc/truncated" {
+		t.Errorf("Expected the FileInfo to still carry the path, got '%s'", info.Path)
+	}
+	for _, expected := range []string{"etc/truncated", "expected 18 bytes", "read 5"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain '%s', got: %v", expected, err)
+		}
+	}
+}
+
+func TestNewFileInfo(t *testing.T) {
+	contents := []byte("some file contents")
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	writer.WriteHeader(&tar.Header{Name: "etc/complete", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))})
+	writer.Write(contents)
+	writer.Close()
+
+	reader := tar.NewReader(&buf)
+	header, _ := reader.Next()
+
+	info, err := NewFileInfo(reader, header, header.Name, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if info.Unreadable {
+		t.Errorf("Expected the FileInfo to be readable")
+	}
+	if info.hash == 0 {
+		t.Errorf("Expected a content hash to be computed")
+	}
+}
+
+func TestNewFileInfoMetadataOnly(t *testing.T) {
+	modTime := time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC)
+	newEntry := func(contents string, mode int64, modTime time.Time) FileInfo {
+		var buf bytes.Buffer
+		writer := tar.NewWriter(&buf)
+		writer.WriteHeader(&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: mode, ModTime: modTime, Size: int64(len(contents))})
+		writer.Write([]byte(contents))
+		writer.Close()
+
+		reader := tar.NewReader(&buf)
+		header, _ := reader.Next()
+		info, err := NewFileInfo(reader, header, header.Name, false)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return info
+	}
+
+	original := newEntry("127.0.0.1 localhost", 0644, modTime)
+	if original.hash != 0 || !original.hashSkipped {
+		t.Errorf("Expected the file contents to not be hashed")
+	}
+
+	var table = []struct {
+		name     string
+		other    FileInfo
+		expected DiffType
+	}{
+		{"same metadata", newEntry("127.0.0.1 localhost", 0644, modTime), Unchanged},
+		{"different size", newEntry("127.0.0.1 localhost.localdomain", 0644, modTime), Changed},
+		{"different mode", newEntry("127.0.0.1 localhost", 0600, modTime), Changed},
+		{"different mtime", newEntry("127.0.0.1 localhost", 0644, modTime.Add(time.Second)), Changed},
+	}
+
+	for _, trial := range table {
+		if actual := original.Compare(trial.other); actual != trial.expected {
+			t.Errorf("Expected %v but got %v (%s)", trial.expected, actual, trial.name)
+		}
+	}
+
+	// a hashed file compared against an unhashed file cannot rely on the (zero) hash of the unhashed file
+	hashed := newTestFileInfo(t, "etc/hosts", []byte("127.0.0.1 localhost"))
+	hashed.LogicalBytes, hashed.Mode, hashed.ModTime = original.LogicalBytes, original.Mode, original.ModTime
+	if actual := hashed.Compare(original); actual != Unchanged {
+		t.Errorf("Expected %v but got %v (hashed vs unhashed)", Unchanged, actual)
+	}
+	hashed.LogicalBytes++
+	if actual := hashed.Compare(original); actual != Changed {
+		t.Errorf("Expected %v but got %v (hashed vs unhashed)", Changed, actual)
+	}
+}
+
+// gnuSparseTar builds a tar holding a single old GNU format sparse entry (typeflag 'S'), which archive/tar can read
+// but not write. The logical file is realSize bytes long with each data segment placed at its offset.
+func gnuSparseTar(name string, realSize int64, segments map[int64]string) []byte {
+	block := make([]byte, 512)
+	field := func(offset, length int, value int64) {
+		copy(block[offset:offset+length], fmt.Sprintf("%0*o", length-1, value))
+	}
+
+	var data []byte
+	var offsets []int64
+	for offset := range segments {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	copy(block[0:], name)
+	field(100, 8, 0644)
+	field(108, 8, 0)
+	field(116, 8, 0)
+	field(136, 12, 0)
+	block[156] = tar.TypeGNUSparse
+	copy(block[257:], "ustar  \x00")
+	for idx, offset := range offsets {
+		field(386+idx*24, 12, offset)
+		field(386+idx*24+12, 12, int64(len(segments[offset])))
+		data = append(data, segments[offset]...)
+	}
+	field(483, 12, realSize)
+	field(124, 12, int64(len(data)))
+
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+
+	var buf bytes.Buffer
+	buf.Write(block)
+	buf.Write(data)
+	buf.Write(make([]byte, 512-len(data)%512))
+	buf.Write(make([]byte, 1024))
+	return buf.Bytes()
+}
+
+func TestNewFileInfoSparseEntry(t *testing.T) {
+	reader := tar.NewReader(bytes.NewReader(gnuSparseTar("disk.img", 4096, map[int64]string{0: "hello", 4091: "world"})))
+	header, err := reader.Next()
+	if err != nil {
+		t.Fatalf("could not read sparse test tar: %v", err)
+	}
+	if !isSparse(header) {
+		t.Errorf("Expected the entry to be detected as sparse")
+	}
+
+	actual, err := NewFileInfo(reader, header, "/disk.img", true)
+	if err != nil {
+		t.Fatalf("Expected no error for a sparse entry, got: %v", err)
+	}
+	if actual.Unreadable {
+		t.Errorf("Expected a sparse entry to be readable")
+	}
+
+	// the hash covers the expanded contents, holes included
+	expanded := make([]byte, 4096)
+	copy(expanded, "hello")
+	copy(expanded[4091:], "world")
+	expected := newTestFileInfo(t, "/disk.img", expanded)
+	if actual.hash != expected.hash {
+		t.Errorf("Expected the sparse entry to hash as its expanded contents (%x), got %x", expected.hash, actual.hash)
+	}
+
+	if !isSparse(&tar.Header{Typeflag: tar.TypeReg, PAXRecords: map[string]string{"GNU.sparse.major": "1"}}) {
+		t.Errorf("Expected a PAX GNU.sparse entry to be detected as sparse")
+	}
+	if isSparse(&tar.Header{Typeflag: tar.TypeReg}) {
+		t.Errorf("Expected a regular entry not to be detected as sparse")
+	}
+}
+
+func TestCompareLinkTargets(t *testing.T) {
+	link := func(typeFlag byte, target string) FileInfo {
+		return FileInfo{
+			Path:     "/usr/bin/python",
+			TypeFlag: typeFlag,
+			Linkname: target,
+		}
+	}
+
+	cases := []struct {
+		name     string
+		lower    FileInfo
+		upper    FileInfo
+		expected DiffType
+	}{
+		{"same symlink target", link(tar.TypeSymlink, "/usr/bin/python2"), link(tar.TypeSymlink, "/usr/bin/python2"), Unchanged},
+		{"symlink retarget", link(tar.TypeSymlink, "/usr/bin/python2"), link(tar.TypeSymlink, "/usr/bin/python3"), Changed},
+		{"symlink to regular file", link(tar.TypeSymlink, "/usr/bin/python2"), link(tar.TypeReg, ""), Changed},
+		{"same hardlink target", link(tar.TypeLink, "usr/bin/python2"), link(tar.TypeLink, "usr/bin/python2"), Unchanged},
+		{"hardlink retarget", link(tar.TypeLink, "usr/bin/python2"), link(tar.TypeLink, "usr/bin/python3"), Changed},
+	}
+
+	for _, test := range cases {
+		if actual := test.lower.Compare(test.upper); actual != test.expected {
+			t.Errorf("[%s] Expected %v but got %v", test.name, test.expected, actual)
+		}
+	}
+
+	// links are compared by target even when contents are not hashed
+	lower, upper := link(tar.TypeSymlink, "/usr/bin/python2"), link(tar.TypeSymlink, "/usr/bin/python3")
+	lower.hashSkipped, upper.hashSkipped = true, true
+	if actual := lower.Compare(upper); actual != Changed {
+		t.Errorf("[metadata-only symlink retarget] Expected %v but got %v", Changed, actual)
+	}
+}
+
+func TestCompareAttributes(t *testing.T) {
+	defer SetCompareAttributes(nil)
+
+	base := FileInfo{Path: "/etc/hosts", TypeFlag: 1, hash: 123, Mode: 0644, Uid: 0, Gid: 0, ModTime: time.Unix(1000, 0)}
+	cases := []struct {
+		attribute string
+		modify    func(info *FileInfo)
+	}{
+		{"mode", func(info *FileInfo) { info.Mode = 0600 }},
+		{"uid", func(info *FileInfo) { info.Uid = 1000 }},
+		{"gid", func(info *FileInfo) { info.Gid = 1000 }},
+		{"mtime", func(info *FileInfo) { info.ModTime = time.Unix(2000, 0) }},
+	}
+
+	for _, test := range cases {
+		lower, upper := base, base
+		test.modify(&upper)
+
+		SetCompareAttributes(nil)
+		if actual := lower.Compare(upper); actual != Unchanged {
+			t.Errorf("[%s] Expected a content-only comparison to be %v, got %v", test.attribute, Unchanged, actual)
+		}
+
+		SetCompareAttributes([]string{test.attribute})
+		if actual := lower.Compare(upper); actual != MetadataChanged {
+			t.Errorf("[%s] Expected the attribute change to be %v, got %v", test.attribute, MetadataChanged, actual)
+		}
+
+		// other attributes in the mask do not mask the change
+		SetCompareAttributes([]string{"mode", "uid", "gid", "mtime"})
+		if actual := lower.Compare(upper); actual != MetadataChanged {
+			t.Errorf("[%s] Expected the attribute change to be %v with all attributes, got %v", test.attribute, MetadataChanged, actual)
+		}
+
+		// a content change is still a content change
+		upper.hash = 456
+		if actual := lower.Compare(upper); actual != Changed {
+			t.Errorf("[%s] Expected a content and attribute change to be %v, got %v", test.attribute, Changed, actual)
+		}
+	}
+
+	if err := SetCompareAttributes([]string{"inode"}); err == nil {
+		t.Errorf("Expected an error for an unknown attribute")
+	}
+}
+
+func TestCompareXattrs(t *testing.T) {
+	withXattrs := func(records map[string]string, xattrs map[string]string) FileInfo {
+		info, _ := NewFileInfo(tar.NewReader(&bytes.Buffer{}), &tar.Header{Typeflag: tar.TypeDir, PAXRecords: records, Xattrs: xattrs}, "/usr/bin", true)
+		return info
+	}
+	capability := map[string]string{"SCHILY.xattr.security.capability": "\x01\x00\x00\x02", "comment": "not an xattr"}
+
+	if info := withXattrs(capability, nil); len(info.Xattrs) != 1 || info.Xattrs["security.capability"] != "\x01\x00\x00\x02" {
+		t.Errorf("Expected the PAX xattr record to be captured, got %v", info.Xattrs)
+	}
+
+	cases := []struct {
+		name     string
+		lower    FileInfo
+		upper    FileInfo
+		expected DiffType
+	}{
+		{"absent vs empty", withXattrs(nil, nil), FileInfo{TypeFlag: tar.TypeDir, Xattrs: map[string]string{}}, Unchanged},
+		{"added capability", withXattrs(nil, nil), withXattrs(capability, nil), MetadataChanged},
+		{"pax vs legacy field", withXattrs(capability, nil), withXattrs(nil, map[string]string{"security.capability": "\x01\x00\x00\x02"}), Unchanged},
+		{"changed label", withXattrs(nil, map[string]string{"security.selinux": "a"}), withXattrs(nil, map[string]string{"security.selinux": "b"}), MetadataChanged},
+		{"different order", withXattrs(map[string]string{"SCHILY.xattr.user.a": "1", "SCHILY.xattr.user.b": "2"}, nil), withXattrs(map[string]string{"SCHILY.xattr.user.b": "2", "SCHILY.xattr.user.a": "1"}, nil), Unchanged},
+	}
+
+	for _, test := range cases {
+		if actual := test.lower.Compare(test.upper); actual != test.expected {
+			t.Errorf("[%s] Expected %v but got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestNewFileInfoMaxHashSize(t *testing.T) {
+	defer SetMaxHashSize(0)
+
+	contents := []byte(strings.Repeat("a large file ", 10))
+	other := []byte(strings.Repeat("a LARGE file ", 10))
+
+	SetMaxHashSize(int64(len(contents)))
+	hashed := newTestFileInfo(t, "/model.bin", contents)
+	if hashed.hashSkipped || hashed.hash == 0 {
+		t.Errorf("Expected a file at the threshold to be hashed")
+	}
+
+	SetMaxHashSize(int64(len(contents)) - 1)
+	first := newTestFileInfo(t, "/model.bin", contents)
+	second := newTestFileInfo(t, "/model.bin", other)
+	if !first.hashSkipped || first.hash != 0 {
+		t.Errorf("Expected a file over the threshold not to be hashed")
+	}
+	if first.LogicalBytes != int64(len(contents)) {
+		t.Errorf("Expected the size to be recorded, got %d", first.LogicalBytes)
+	}
+	// same size and mtime, so without a hash these compare as unchanged rather than by zero hashes
+	if first.Compare(second) != Unchanged {
+		t.Errorf("Expected files over the threshold to be compared by metadata")
+	}
+	second.LogicalBytes++
+	if first.Compare(second) != Changed {
+		t.Errorf("Expected a size change over the threshold to be Changed")
+	}
+
+	if err := SetMaxHashSize(-1); err == nil {
+		t.Errorf("Expected an error for a negative max hash size")
+	}
+}
+
+func TestCompareDeviceNodes(t *testing.T) {
+	device := func(typeFlag byte, major, minor int64) FileInfo {
+		var buf bytes.Buffer
+		writer := tar.NewWriter(&buf)
+		writer.WriteHeader(&tar.Header{Name: "dev/null", Typeflag: typeFlag, Mode: 0666, Devmajor: major, Devminor: minor})
+		writer.Close()
+
+		reader := tar.NewReader(&buf)
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatalf("could not read test tar: %v", err)
+		}
+		info, err := NewFileInfo(reader, header, "/dev/null", true)
+		if err != nil {
+			t.Fatalf("could not create FileInfo: %v", err)
+		}
+		return info
+	}
+
+	cases := []struct {
+		name     string
+		lower    FileInfo
+		upper    FileInfo
+		expected DiffType
+	}{
+		{"same char device", device(tar.TypeChar, 1, 3), device(tar.TypeChar, 1, 3), Unchanged},
+		{"char device minor", device(tar.TypeChar, 1, 3), device(tar.TypeChar, 1, 5), Changed},
+		{"char device major", device(tar.TypeChar, 1, 3), device(tar.TypeChar, 4, 3), Changed},
+		{"same block device", device(tar.TypeBlock, 8, 0), device(tar.TypeBlock, 8, 0), Unchanged},
+		{"block device swapped", device(tar.TypeBlock, 8, 0), device(tar.TypeBlock, 8, 1), Changed},
+		{"char to block device", device(tar.TypeChar, 8, 0), device(tar.TypeBlock, 8, 0), Changed},
+	}
+
+	for _, test := range cases {
+		if actual := test.lower.Compare(test.upper); actual != test.expected {
+			t.Errorf("[%s] Expected %v but got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+// stutteringReader returns (0, nil) before every chunk of the underlying reader, as some layered readers do.
+type stutteringReader struct {
+	reader  io.Reader
+	stutter bool
+}
+
+func (r *stutteringReader) Read(p []byte) (int, error) {
+	r.stutter = !r.stutter
+	if r.stutter {
+		return 0, nil
+	}
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return r.reader.Read(p)
+}
+
+// dataWithErrorReader returns all remaining bytes together with the given error.
+type dataWithErrorReader struct {
+	data []byte
+	err  error
+}
+
+func (r *dataWithErrorReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, r.err
+}
+
+func TestGetHashFromReaderEmptyReads(t *testing.T) {
+	contents := []byte("127.0.0.1 localhost")
+	expected, expectedBytes, _ := getHashFromReader(bytes.NewReader(contents))
+
+	actual, actualBytes, err := getHashFromReader(&stutteringReader{reader: bytes.NewReader(contents)})
+	if err != nil {
+		t.Fatalf("Expected no error from empty reads, got: %v", err)
+	}
+	if actualBytes != expectedBytes || !bytes.Equal(actual.Sum(nil), expected.Sum(nil)) {
+		t.Errorf("Expected all %d bytes to be hashed across empty reads, got %d", expectedBytes, actualBytes)
+	}
+
+	// bytes returned along with EOF are hashed too
+	actual, actualBytes, err = getHashFromReader(&dataWithErrorReader{data: contents, err: io.EOF})
+	if err != nil || actualBytes != expectedBytes || !bytes.Equal(actual.Sum(nil), expected.Sum(nil)) {
+		t.Errorf("Expected bytes returned with EOF to be hashed, got %d bytes (%v)", actualBytes, err)
+	}
+
+	// ...and counted before a real error is reported
+	_, actualBytes, err = getHashFromReader(&dataWithErrorReader{data: contents, err: io.ErrUnexpectedEOF})
+	if err != io.ErrUnexpectedEOF || actualBytes != expectedBytes {
+		t.Errorf("Expected %d bytes and an unexpected EOF, got %d bytes (%v)", expectedBytes, actualBytes, err)
+	}
+
+	// a reader that never makes progress is an error rather than a hang
+	_, _, err = getHashFromReader(&dataWithErrorReader{})
+	if err != io.ErrNoProgress {
+		t.Errorf("Expected a stuck reader to fail with %v, got: %v", io.ErrNoProgress, err)
+	}
+}
+
+func TestCompareSizeAndHash(t *testing.T) {
+	defer SetStrictCompare(false)
+
+	lower := FileInfo{Path: "/etc/hosts", TypeFlag: 1, hash: 123, LogicalBytes: 20, StoredBytes: 20, ModTime: time.Unix(1000, 0)}
+
+	// a colliding hash with a different size is a change
+	upper := lower
+	upper.LogicalBytes, upper.StoredBytes = 21, 21
+	if actual := lower.Compare(upper); actual != Changed {
+		t.Errorf("Expected a size change with an equal hash to be %v, got %v", Changed, actual)
+	}
+
+	upper = lower
+	upper.ModTime = time.Unix(2000, 0)
+	if actual := lower.Compare(upper); actual != Unchanged {
+		t.Errorf("Expected an mtime change with equal size and hash to be %v, got %v", Unchanged, actual)
+	}
+	SetStrictCompare(true)
+	if actual := lower.Compare(upper); actual != Changed {
+		t.Errorf("Expected an mtime change to be %v in strict mode, got %v", Changed, actual)
+	}
+	if actual := lower.Compare(lower); actual != Unchanged {
+		t.Errorf("Expected identical files to be %v in strict mode, got %v", Unchanged, actual)
+	}
+}
+
+func TestProgressHandler(t *testing.T) {
+	var paths []string
+	var sizes []int64
+	SetProgressHandler(func(path string, size int64) {
+		paths = append(paths, path)
+		sizes = append(sizes, size)
+	})
+	newTestFileInfo(t, "/etc/hosts", []byte("127.0.0.1 localhost"))
+	SetProgressHandler(nil)
+	newTestFileInfo(t, "/etc/passwd", []byte("root:x:0:0"))
+
+	if len(paths) != 1 || paths[0] != "/etc/hosts" || sizes[0] != 19 {
+		t.Errorf("Expected a single progress report for '/etc/hosts' (19 bytes), got %v %v", paths, sizes)
+	}
+}
+
+func TestSetReadChunkSize(t *testing.T) {
+	defer SetReadChunkSize(2 * 1024 * 1024)
+
+	if err := SetReadChunkSize(0); err == nil {
+		t.Errorf("Expected an error for a zero chunk size")
+	}
+
+	contents := []byte(strings.Repeat("0123456789", 10))
+	expected := newTestFileInfo(t, "/file", contents)
+
+	// reading with a chunk size smaller than the file must yield the same hash
+	SetReadChunkSize(7)
+	actual := newTestFileInfo(t, "/file", contents)
+	if expected.hash != actual.hash {
+		t.Errorf("Expected hash %x but got %x", expected.hash, actual.hash)
+	}
+}
+
+// BenchmarkNewFileInfoSmallFiles reads many small files, which should reuse pooled read buffers instead of
+// allocating a full chunk for every file (see allocs/op and B/op).
+func BenchmarkNewFileInfoSmallFiles(b *testing.B) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	contents := []byte("small file contents")
+	for idx := 0; idx < 100; idx++ {
+		writer.WriteHeader(&tar.Header{Name: fmt.Sprintf("file-%d", idx), Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))})
+		writer.Write(contents)
+	}
+	writer.Close()
+	data := buf.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		reader := tar.NewReader(bytes.NewReader(data))
+		for {
+			header, err := reader.Next()
+			if err != nil {
+				break
+			}
+			NewFileInfo(reader, header, header.Name, true)
+		}
+	}
 }
 
 func BlankFileChangeInfo(path string) (f *FileInfo) {
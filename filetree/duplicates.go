@@ -0,0 +1,131 @@
+package filetree
+
+import (
+	"sort"
+)
+
+// DuplicateOccurrence is a single copy of duplicated file contents: the path holding it in a layer
+type DuplicateOccurrence struct {
+	Layer int
+	Path  string
+}
+
+// DuplicateData represents file contents (identified by hash and size) stored by more than one layer, whether at the
+// same path or not. Every copy beyond the first is wasted space.
+type DuplicateData struct {
+	Hash        uint64
+	Size        int64
+	Occurrences []DuplicateOccurrence
+	WastedSize  int64
+}
+
+// DuplicateSlice is a set of DuplicateData, ordered by wasted size (largest first)
+type DuplicateSlice []*DuplicateData
+
+// duplicateKey identifies file contents
+type duplicateKey struct {
+	hash   uint64
+	digest string
+	size   int64
+}
+
+// Layers returns the (ascending) indexes of the layers holding a copy of the contents, each listed once.
+func (data *DuplicateData) Layers() []int {
+	var layers []int
+	seen := make(map[int]bool)
+	for _, occurrence := range data.Occurrences {
+		if !seen[occurrence.Layer] {
+			seen[occurrence.Layer] = true
+			layers = append(layers, occurrence.Layer)
+		}
+	}
+	sort.Ints(layers)
+	return layers
+}
+
+// Paths returns the (sorted) paths holding a copy of the contents, each listed once.
+func (data *DuplicateData) Paths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, occurrence := range data.Occurrences {
+		if !seen[occurrence.Path] {
+			seen[occurrence.Path] = true
+			paths = append(paths, occurrence.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// LayerSizes returns the bytes of the contents stored by each layer (by layer index).
+func (data *DuplicateData) LayerSizes() map[int]int64 {
+	sizes := make(map[int]int64)
+	for _, occurrence := range data.Occurrences {
+		sizes[occurrence.Layer] += data.Size
+	}
+	return sizes
+}
+
+// duplicateContents indicates if the node is a regular file whose contents can be matched against other files: its
+// contents were hashed and are not empty.
+func duplicateContents(node *FileNode) bool {
+	info := node.Data.FileInfo
+	if info.IsDir() || info.hashSkipped || info.Unreadable || info.LogicalBytes == 0 {
+		return false
+	}
+	if info.TypeFlag != '0' && info.TypeFlag != '\x00' {
+		return false
+	}
+	return info.hash != 0 || len(info.digest) > 0
+}
+
+// FindDuplicates groups the regular files of the given trees (layers) by content, and returns the contents stored by
+// more than one layer along with the layers and paths holding them. Copies within a single layer are only reported
+// along with copies in other layers.
+func FindDuplicates(trees []*FileTree) DuplicateSlice {
+	groups := make(map[duplicateKey]*DuplicateData)
+	var order []duplicateKey
+	for idx, tree := range trees {
+		layer := idx
+		visitor := func(node *FileNode) error {
+			if node.IsWhiteout() || !duplicateContents(node) {
+				return nil
+			}
+			info := node.Data.FileInfo
+			key := duplicateKey{info.hash, string(info.digest), info.LogicalBytes}
+			data, ok := groups[key]
+			if !ok {
+				data = &DuplicateData{Hash: info.hash, Size: node.Size()}
+				groups[key] = data
+				order = append(order, key)
+			}
+			data.Occurrences = append(data.Occurrences, DuplicateOccurrence{Layer: layer, Path: node.Path()})
+			return nil
+		}
+		tree.VisitDepthChildFirst(visitor, func(node *FileNode) bool { return node.IsLeaf() })
+	}
+
+	duplicates := make(DuplicateSlice, 0)
+	for _, key := range order {
+		data := groups[key]
+		if len(data.Layers()) < 2 {
+			continue
+		}
+		data.WastedSize = data.Size * int64(len(data.Occurrences)-1)
+		duplicates = append(duplicates, data)
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].WastedSize > duplicates[j].WastedSize
+	})
+	return duplicates
+}
+
+// pathDuplicateSize returns the bytes of the duplicates held at different paths, which are not already accounted for
+// by files rewritten at the same path (see Efficiency): every distinct path beyond the first needlessly holds a copy.
+func (duplicates DuplicateSlice) pathDuplicateSize() int64 {
+	var size int64
+	for _, data := range duplicates {
+		size += data.Size * int64(len(data.Paths())-1)
+	}
+	return size
+}
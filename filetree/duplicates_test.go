@@ -0,0 +1,83 @@
+package filetree
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "app/lib.so", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/other.so", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/empty", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"app/lib.so": "shared library", "app/other.so": "only once"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "copy/lib.so", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "copy/single", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "copy/single-again", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "copy/empty", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"copy/lib.so": "shared library", "copy/single": "same layer", "copy/single-again": "same layer"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "app/lib.so", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"app/lib.so": "shared library"}),
+	}
+
+	duplicates := FindDuplicates(trees)
+	if len(duplicates) != 1 {
+		t.Fatalf("expected 1 duplicate, got %d", len(duplicates))
+	}
+
+	data := duplicates[0]
+	size := int64(len("shared library"))
+	if data.Size != size || data.WastedSize != 2*size {
+		t.Errorf("expected size %d and wasted size %d, got %d and %d", size, 2*size, data.Size, data.WastedSize)
+	}
+	if layers := data.Layers(); !reflect.DeepEqual(layers, []int{0, 1, 2}) {
+		t.Errorf("unexpected layers: %v", layers)
+	}
+	if paths := data.Paths(); !reflect.DeepEqual(paths, []string{"/app/lib.so", "/copy/lib.so"}) {
+		t.Errorf("unexpected paths: %v", paths)
+	}
+	if sizes := data.LayerSizes(); !reflect.DeepEqual(sizes, map[int]int64{0: size, 1: size, 2: size}) {
+		t.Errorf("unexpected layer sizes: %v", sizes)
+	}
+}
+
+func TestFindDuplicatesOrder(t *testing.T) {
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "small", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "large", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"small": "a", "large": "a larger file"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "small-copy", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "large-copy", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"small-copy": "a", "large-copy": "a larger file"}),
+	}
+
+	duplicates := FindDuplicates(trees)
+	if len(duplicates) != 2 {
+		t.Fatalf("expected 2 duplicates, got %d", len(duplicates))
+	}
+	if duplicates[0].Paths()[0] != "/large" || duplicates[1].Paths()[0] != "/small" {
+		t.Errorf("expected the largest waste first, got %v then %v", duplicates[0].Paths(), duplicates[1].Paths())
+	}
+}
+
+func TestEfficiencyDuplicates(t *testing.T) {
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "orig", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"orig": "0123456789"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "copy", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"copy": "0123456789"}),
+	}
+
+	score, _ := Efficiency(trees)
+	if score != 0.5 {
+		t.Errorf("expected a score of 0.5, got %v", score)
+	}
+}
@@ -35,10 +35,13 @@ func (efs EfficiencySlice) Less(i, j int) bool {
 // Efficiency returns the score and file set of the given set of FileTrees (layers). This is loosely based on:
 // 1. Files that are duplicated across layers discounts your score, weighted by file size
 // 2. Files that are removed discounts your score, weighted by the original file size
+// 3. Contents copied to several paths by different layers discounts your score, weighted by file size (see
+// FindDuplicates)
 func Efficiency(trees []*FileTree) (float64, EfficiencySlice) {
 	efficiencyMap := make(map[string]*EfficiencyData)
 	inefficientMatches := make(EfficiencySlice, 0)
 	currentTree := 0
+	stacks := NewStackCache(trees)
 
 	visitor := func(node *FileNode) error {
 		path := node.Path()
@@ -59,19 +62,21 @@ func Efficiency(trees []*FileTree) (float64, EfficiencySlice) {
 
 		if node.IsWhiteout() {
 			sizer := func(curNode *FileNode) error {
-				sizeBytes += curNode.Data.FileInfo.TarHeader.FileInfo().Size()
+				sizeBytes += curNode.Size()
 				return nil
 			}
-			stackedTree := StackRange(trees, 0, currentTree-1)
+			stackedTree := stacks.stackRange(0, currentTree-1)
 			previousTreeNode, err := stackedTree.GetNode(node.Path())
 			if err != nil {
 				logrus.Debug(fmt.Sprintf("CurrentTree: %d : %s", currentTree, err))
-			} else if previousTreeNode.Data.FileInfo.TarHeader.FileInfo().IsDir() {
+			} else if previousTreeNode.Data.FileInfo.IsDir() && !previousTreeNode.isImplicitDir() {
+				// only directory entries are measured: the defaults of the directories without an entry of their own
+				// (see implicitDirInfo) stay out of the efficiency math
 				previousTreeNode.VisitDepthChildFirst(sizer, nil)
 			}
 
 		} else {
-			sizeBytes = node.Data.FileInfo.TarHeader.FileInfo().Size()
+			sizeBytes = node.Size()
 		}
 
 		data.CumulativeSize += sizeBytes
@@ -102,6 +107,10 @@ func Efficiency(trees []*FileTree) (float64, EfficiencySlice) {
 		minimumPathSizes += value.minDiscoveredSize
 		discoveredPathSizes += value.CumulativeSize
 	}
+	minimumPathSizes -= FindDuplicates(trees).pathDuplicateSize()
+	if minimumPathSizes < 0 {
+		minimumPathSizes = 0
+	}
 	score := float64(minimumPathSizes) / float64(discoveredPathSizes)
 
 	sort.Sort(inefficientMatches)
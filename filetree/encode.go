@@ -0,0 +1,192 @@
+package filetree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// treeEncodingVersion is the version of the binary tree encoding, a stream written by another version is rejected
+const treeEncodingVersion byte = 1
+
+// treeEncodingMagic starts every binary tree encoding
+var treeEncodingMagic = []byte("DIVETREE")
+
+// encodedTree is the binary (gob) representation of a FileTree
+type encodedTree struct {
+	Name          string
+	HashAlgorithm string
+	FileSize      uint64
+	BlobSize      uint64
+	ContentSize   uint64
+	Duplicates    int
+	RootOpaque    bool
+	Nodes         []encodedNode
+}
+
+// encodedNode is the binary representation of a single FileNode. Nodes are listed parents first, referring to their
+// parent by its index in the list (-1 for children of the root).
+type encodedNode struct {
+	Parent    int
+	Name      string
+	DiffType  DiffType
+	MovedFrom string
+	MovedTo   string
+	Opaque    bool
+	Info      encodedInfo
+}
+
+// encodedInfo is the binary representation of a FileInfo
+type encodedInfo struct {
+	Path         string
+	TypeFlag     byte
+	Linkname     string
+	Hash         uint64
+	Digest       []byte
+	HashSkipped  bool
+	LogicalBytes int64
+	StoredBytes  int64
+	Mode         os.FileMode
+	Uid          int
+	Gid          int
+	ModTime      time.Time
+	Devmajor     int64
+	Devminor     int64
+	Xattrs       map[string]string
+	Unreadable   bool
+}
+
+// Encode writes a compact binary representation of the tree (its files and their metadata, but not the view state)
+// that can be read back with DecodeFileTree, e.g. to cache the (expensive) result of reading a layer.
+func (tree *FileTree) Encode(writer io.Writer) error {
+	encoded := encodedTree{
+		Name:          tree.Name,
+		HashAlgorithm: tree.HashAlgorithm,
+		FileSize:      tree.FileSize,
+		BlobSize:      tree.BlobSize,
+		ContentSize:   tree.ContentSize,
+		Duplicates:    tree.DuplicateEntries,
+		RootOpaque:    tree.Root.opaque,
+		Nodes:         make([]encodedNode, 0, tree.Size),
+	}
+
+	indexes := make(map[*FileNode]int)
+	err := tree.VisitDepthParentFirst(func(node *FileNode) error {
+		parent, ok := indexes[node.Parent]
+		if !ok {
+			parent = -1
+		}
+		indexes[node] = len(encoded.Nodes)
+		info := node.Data.FileInfo
+		encoded.Nodes = append(encoded.Nodes, encodedNode{
+			Parent:    parent,
+			Name:      node.Name,
+			DiffType:  node.Data.DiffType,
+			MovedFrom: node.Data.MovedFrom,
+			MovedTo:   node.Data.MovedTo,
+			Opaque:    node.opaque,
+			Info: encodedInfo{
+				Path:         info.Path,
+				TypeFlag:     info.TypeFlag,
+				Linkname:     info.Linkname,
+				Hash:         info.hash,
+				Digest:       info.digest,
+				HashSkipped:  info.hashSkipped,
+				LogicalBytes: info.LogicalBytes,
+				StoredBytes:  info.StoredBytes,
+				Mode:         info.Mode,
+				Uid:          info.Uid,
+				Gid:          info.Gid,
+				ModTime:      info.ModTime,
+				Devmajor:     info.Devmajor,
+				Devminor:     info.Devminor,
+				Xattrs:       info.Xattrs,
+				Unreadable:   info.Unreadable,
+			},
+		})
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	header := append(append([]byte{}, treeEncodingMagic...), treeEncodingVersion)
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	return gob.NewEncoder(writer).Encode(encoded)
+}
+
+// DecodeFileTree reads a tree written by FileTree.Encode. Input written by another version of the encoding is
+// rejected with an error.
+func DecodeFileTree(reader io.Reader) (*FileTree, error) {
+	header := make([]byte, len(treeEncodingMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("cannot read tree encoding header: %v", err)
+	}
+	if !bytes.Equal(header[:len(treeEncodingMagic)], treeEncodingMagic) {
+		return nil, fmt.Errorf("not an encoded file tree")
+	}
+	if version := header[len(treeEncodingMagic)]; version != treeEncodingVersion {
+		return nil, fmt.Errorf("unsupported tree encoding version %d (expected %d)", version, treeEncodingVersion)
+	}
+
+	var encoded encodedTree
+	if err := gob.NewDecoder(reader).Decode(&encoded); err != nil {
+		return nil, fmt.Errorf("cannot decode tree: %v", err)
+	}
+
+	tree := NewFileTree()
+	tree.Name = encoded.Name
+	tree.HashAlgorithm = encoded.HashAlgorithm
+	tree.FileSize = encoded.FileSize
+	tree.BlobSize = encoded.BlobSize
+	tree.ContentSize = encoded.ContentSize
+	tree.DuplicateEntries = encoded.Duplicates
+	tree.Root.opaque = encoded.RootOpaque
+
+	nodes := make([]*FileNode, len(encoded.Nodes))
+	for idx, node := range encoded.Nodes {
+		parent := tree.Root
+		if node.Parent >= 0 {
+			if node.Parent >= idx {
+				return nil, fmt.Errorf("invalid parent of tree node '%s'", node.Name)
+			}
+			parent = nodes[node.Parent]
+		}
+		if parent.Children[node.Name] != nil {
+			return nil, fmt.Errorf("duplicate tree node '%s'", node.Name)
+		}
+		info := node.Info
+		child := parent.AddChild(node.Name, FileInfo{
+			Path:         info.Path,
+			TypeFlag:     info.TypeFlag,
+			Linkname:     info.Linkname,
+			hash:         info.Hash,
+			digest:       info.Digest,
+			hashSkipped:  info.HashSkipped,
+			LogicalBytes: info.LogicalBytes,
+			StoredBytes:  info.StoredBytes,
+			Mode:         info.Mode,
+			Uid:          info.Uid,
+			Gid:          info.Gid,
+			ModTime:      info.ModTime,
+			Devmajor:     info.Devmajor,
+			Devminor:     info.Devminor,
+			Xattrs:       info.Xattrs,
+			Unreadable:   info.Unreadable,
+		})
+		if child == nil {
+			return nil, fmt.Errorf("could not add tree node '%s'", node.Name)
+		}
+		data := child.writeData()
+		data.DiffType = node.DiffType
+		data.MovedFrom, data.MovedTo = node.MovedFrom, node.MovedTo
+		child.opaque = node.Opaque
+		nodes[idx] = child
+	}
+	return tree, nil
+}
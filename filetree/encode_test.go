@@ -0,0 +1,74 @@
+package filetree
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tree := NewFileTree()
+	tree.Name = "layer-0"
+	tree.FileSize = 1234
+	tree.BlobSize, tree.ContentSize = 567, 890
+	tree.AddPath("/etc/hosts", FileInfo{
+		Path: "etc/hosts", TypeFlag: '0', hash: 123, digest: []byte{1, 2, 3}, LogicalBytes: 10, StoredBytes: 10,
+		Mode: 0644, Uid: 1000, Gid: 100, ModTime: time.Unix(1000, 0).UTC(), Xattrs: map[string]string{"user.key": "value"},
+	})
+	tree.AddPath("/usr/bin/sh", FileInfo{Path: "usr/bin/sh", TypeFlag: '2', Linkname: "busybox"})
+	tree.AddPath("/dev/null", FileInfo{Path: "dev/null", TypeFlag: '3', Devmajor: 1, Devminor: 3})
+	tree.AddPath("/var/big", FileInfo{Path: "var/big", TypeFlag: '0', hashSkipped: true, LogicalBytes: 1 << 40})
+	tree.AddPath("/tmp/.wh.cache", FileInfo{})
+	tree.AddPath("/opt/.wh..wh..opq", FileInfo{})
+	hosts, _ := tree.GetNode("/etc/hosts")
+	hosts.Data.DiffType = Changed
+	etc, _ := tree.GetNode("/etc")
+	etc.Data.ViewInfo.Collapsed = true
+
+	var buf bytes.Buffer
+	if err := tree.Encode(&buf); err != nil {
+		t.Fatalf("Expected no error encoding the tree, got: %v", err)
+	}
+	decoded, err := DecodeFileTree(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error decoding the tree, got: %v", err)
+	}
+
+	if decoded.Name != tree.Name || decoded.FileSize != tree.FileSize || decoded.BlobSize != tree.BlobSize || decoded.ContentSize != tree.ContentSize || decoded.HashAlgorithm != tree.HashAlgorithm || decoded.Size != tree.Size {
+		t.Errorf("Expected the tree attributes to be preserved, got: %+v", decoded)
+	}
+	assertPaths(t, "decoded", visitPaths(tree), visitPaths(decoded))
+	var expectedNodes, actualNodes []*FileNode
+	tree.VisitDepthParentFirst(func(node *FileNode) error { expectedNodes = append(expectedNodes, node); return nil }, nil)
+	decoded.VisitDepthParentFirst(func(node *FileNode) error { actualNodes = append(actualNodes, node); return nil }, nil)
+	for idx, expected := range expectedNodes {
+		actual, path := actualNodes[idx], expected.Path()
+		if expected.Name != actual.Name {
+			t.Errorf("Expected '%s' to be decoded as '%s', got '%s'", path, expected.Name, actual.Name)
+		}
+		if !reflect.DeepEqual(expected.Data.FileInfo, actual.Data.FileInfo) || expected.Data.DiffType != actual.Data.DiffType {
+			t.Errorf("Expected '%s' to decode as %+v (%v), got %+v (%v)", path, expected.Data.FileInfo, expected.Data.DiffType, actual.Data.FileInfo, actual.Data.DiffType)
+		}
+		if expected.IsWhiteout() != actual.IsWhiteout() || expected.IsOpaque() != actual.IsOpaque() {
+			t.Errorf("Expected '%s' to keep its whiteout markers", path)
+		}
+	}
+	if decodedEtc, _ := decoded.GetNode("/etc"); decodedEtc.Data.ViewInfo.Collapsed {
+		t.Errorf("Expected the view state not to be persisted")
+	}
+
+	// other versions and other data are rejected
+	stale := append([]byte{}, buf.Bytes()...)
+	stale[len(treeEncodingMagic)]++
+	if _, err := DecodeFileTree(bytes.NewReader(stale)); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected a version error, got: %v", err)
+	}
+	if _, err := DecodeFileTree(strings.NewReader("not a tree at all")); err == nil {
+		t.Errorf("Expected an error decoding garbage")
+	}
+	if _, err := DecodeFileTree(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Errorf("Expected an error decoding a truncated tree")
+	}
+}
@@ -0,0 +1,181 @@
+package filetree
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// treeExport is the JSON representation of a FileTree
+type treeExport struct {
+	Name          string       `json:"name"`
+	HashAlgorithm string       `json:"hashAlgorithm"`
+	Children      []nodeExport `json:"children"`
+}
+
+// nodeExport is the JSON representation of a single FileNode and its children. The typeflag is the tar typeflag
+// character (empty for directories that only exist as parents of other paths), the size is that of the node alone
+// (per SetSizeMode), the hash is the hex encoded content fingerprint (see FileInfo.Hash), the moved paths are the other
+// side of a Moved node (see FileTree.DetectMoves), the layers are those adding and last modifying the path (left out
+// when unknown, see FileTree.SetLayer) and the annotations are those attached with FileNode.SetAnnotation.
+type nodeExport struct {
+	Name          string            `json:"name"`
+	Path          string            `json:"path"`
+	TypeFlag      string            `json:"typeFlag"`
+	Size          int64             `json:"size"`
+	Hash          string            `json:"hash"`
+	DiffType      DiffType          `json:"diffType"`
+	MovedFrom     string            `json:"movedFrom,omitempty"`
+	MovedTo       string            `json:"movedTo,omitempty"`
+	AddedLayer    *int              `json:"addedLayer,omitempty"`
+	ModifiedLayer *int              `json:"modifiedLayer,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Children      []nodeExport      `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the tree hierarchy, with children sorted by name so the output is stable between runs.
+func (tree *FileTree) MarshalJSON() ([]byte, error) {
+	return json.Marshal(treeExport{
+		Name:          tree.Name,
+		HashAlgorithm: tree.HashAlgorithm,
+		Children:      exportChildren(tree.Root),
+	})
+}
+
+// ExportJSON writes the (indented) JSON representation of the tree to the given writer.
+func (tree *FileTree) ExportJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(tree)
+}
+
+// exportChildren returns the JSON representation of the children of the given node, sorted by name.
+func exportChildren(node *FileNode) []nodeExport {
+	names := node.sortedChildNames()
+	children := make([]nodeExport, 0, len(names))
+	for _, name := range names {
+		child := node.Children[name]
+		var typeFlag string
+		if child.Data.FileInfo.TypeFlag != 0 {
+			typeFlag = string(child.Data.FileInfo.TypeFlag)
+		}
+		children = append(children, nodeExport{
+			Name:          child.Name,
+			Path:          child.Path(),
+			TypeFlag:      typeFlag,
+			Size:          child.Size(),
+			Hash:          fmt.Sprintf("%016x", child.Data.FileInfo.Hash()),
+			DiffType:      child.Data.DiffType,
+			MovedFrom:     child.Data.MovedFrom,
+			MovedTo:       child.Data.MovedTo,
+			AddedLayer:    layerIndex(child.Data.AddedLayer),
+			ModifiedLayer: layerIndex(child.Data.ModifiedLayer),
+			Annotations:   child.Data.Annotations,
+			Children:      exportChildren(child),
+		})
+	}
+	return children
+}
+
+// layerIndex returns the given layer index for exporting, nil when unknown (negative)
+func layerIndex(index int) *int {
+	if index < 0 {
+		return nil
+	}
+	return &index
+}
+
+// CSVOptions selects the nodes written by the CSV exports
+type CSVOptions struct {
+	// IncludeUnchanged writes rows for Unchanged nodes as well
+	IncludeUnchanged bool
+	// ExcludeDirs skips rows for directories
+	ExcludeDirs bool
+	// DetectMoves pairs the Removed and Added files of every layer into moves (see FileTree.DetectMoves)
+	DetectMoves bool
+	// Platform is the platform of the image (e.g. "linux/amd64"), written in a trailing column of every row when set
+	Platform string
+}
+
+// csvHeader names the columns written by the CSV exports
+var csvHeader = []string{"layer", "path", "diff type", "size before", "size after"}
+
+// ExportCSV writes a row (layer, path, diff type, size before, size after) for every changed node of a tree that has
+// already been compared against the given upper tree (see Compare). Sizes are empty when the node does not exist on
+// that side of the comparison (before for Added nodes, after for Removed nodes). A Moved file is written once, at its
+// new location, with the path given as "old → new".
+func (tree *FileTree) ExportCSV(writer *csv.Writer, upper *FileTree, layer string, options CSVOptions) error {
+	visitor := func(node *FileNode) error {
+		if node.Data.DiffType == Unchanged && !options.IncludeUnchanged {
+			return nil
+		}
+		if options.ExcludeDirs && (node.Data.FileInfo.IsDir() || !node.IsLeaf()) {
+			return nil
+		}
+		if node.Data.MovedTo != "" {
+			// the row of the new location covers the move
+			return nil
+		}
+		path := node.Path()
+		if node.Data.MovedFrom != "" {
+			path = node.Data.MovedFrom + " → " + path
+		}
+
+		var sizeBefore, sizeAfter string
+		if node.Data.DiffType != Added {
+			sizeBefore = strconv.FormatInt(node.Size(), 10)
+		}
+		if node.Data.DiffType != Removed {
+			// nodes the upper tree doesn't touch keep their size
+			sizeAfter = sizeBefore
+			if upperNode, err := upper.GetNode(node.Path()); err == nil {
+				sizeAfter = strconv.FormatInt(upperNode.Size(), 10)
+			}
+		}
+
+		row := []string{layer, path, node.Data.DiffType.String(), sizeBefore, sizeAfter}
+		if options.Platform != "" {
+			row = append(row, options.Platform)
+		}
+		return writer.Write(row)
+	}
+	return tree.VisitDepthParentFirst(visitor, nil)
+}
+
+// ExportLayersCSV writes a CSV table (with a header row) of the changes every layer makes on top of the layers below
+// it. The first layer is compared against an empty tree, so all of its files are Added.
+func ExportLayersCSV(writer io.Writer, trees []*FileTree, options CSVOptions) error {
+	csvWriter := csv.NewWriter(writer)
+	header := csvHeader
+	if options.Platform != "" {
+		header = append(append([]string{}, csvHeader...), "platform")
+	}
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	stacks := NewStackCache(trees)
+	for idx, upper := range trees {
+		var lower *FileTree
+		if idx == 0 {
+			lower = NewFileTree()
+			lower.HashAlgorithm = upper.HashAlgorithm
+		} else {
+			lower = stacks.StackRange(0, idx-1)
+		}
+		if err := lower.Compare(upper); err != nil {
+			return err
+		}
+		if options.DetectMoves {
+			lower.DetectMoves()
+		}
+		if err := lower.ExportCSV(csvWriter, upper, upper.Name, options); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,124 @@
+package filetree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	for _, path := range []string{"/usr/bin/env", "/etc/hosts", "/etc/group"} {
+		lowerTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: 123, LogicalBytes: 10})
+	}
+	upperTree.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 456, LogicalBytes: 10})
+	lowerTree.Compare(upperTree)
+
+	var first, second bytes.Buffer
+	if err := lowerTree.ExportJSON(&first); err != nil {
+		t.Fatalf("Expected no error exporting the tree, got: %v", err)
+	}
+	lowerTree.Copy().ExportJSON(&second)
+	if first.String() != second.String() {
+		t.Errorf("Expected a deterministic export, got:\n%s\nand:\n%s", first.String(), second.String())
+	}
+
+	var exported treeExport
+	if err := json.Unmarshal(first.Bytes(), &exported); err != nil {
+		t.Fatalf("Expected valid JSON, got: %v", err)
+	}
+	if len(exported.Children) != 2 || exported.Children[0].Name != "etc" || exported.Children[1].Name != "usr" {
+		t.Fatalf("Expected children sorted by name, got: %+v", exported.Children)
+	}
+
+	etc := exported.Children[0]
+	if etc.TypeFlag != "" || len(etc.Children) != 2 || etc.Children[0].Name != "group" {
+		t.Fatalf("Expected '/etc' to hold the sorted files, got: %+v", etc)
+	}
+	hosts := etc.Children[1]
+	expected := nodeExport{Name: "hosts", Path: "/etc/hosts", TypeFlag: "0", Size: 10, Hash: "000000000000007b", DiffType: Changed}
+	if hosts.Name != expected.Name || hosts.Path != expected.Path || hosts.TypeFlag != expected.TypeFlag ||
+		hosts.Size != expected.Size || hosts.Hash != expected.Hash || hosts.DiffType != expected.DiffType {
+		t.Errorf("Expected %+v but got %+v", expected, hosts)
+	}
+	if !bytes.Contains(first.Bytes(), []byte(`"diffType": "Changed"`)) {
+		t.Errorf("Expected diff types to be exported by name, got:\n%s", first.String())
+	}
+}
+
+func TestExportLayersCSV(t *testing.T) {
+	trees := make([]*FileTree, 2)
+	for idx := range trees {
+		trees[idx] = NewFileTree()
+		trees[idx].Name = fmt.Sprintf("layer-%d", idx)
+	}
+	trees[0].AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 123, LogicalBytes: 10})
+	trees[0].AddPath("/etc/group", FileInfo{Path: "/etc/group", TypeFlag: '0', hash: 123, LogicalBytes: 20})
+	trees[0].AddPath("/tmp/cache", FileInfo{Path: "/tmp/cache", TypeFlag: '0', hash: 123, LogicalBytes: 30})
+	trees[1].AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 456, LogicalBytes: 15})
+	trees[1].AddPath("/tmp/.wh.cache", FileInfo{})
+
+	cases := []struct {
+		name     string
+		options  CSVOptions
+		expected string
+	}{
+		{"changes only", CSVOptions{ExcludeDirs: true}, `layer,path,diff type,size before,size after
+layer-0,/etc/group,Added,,20
+layer-0,/etc/hosts,Added,,10
+layer-0,/tmp/cache,Added,,30
+layer-1,/etc/hosts,Changed,10,15
+layer-1,/tmp/cache,Removed,30,
+`},
+		{"unchanged", CSVOptions{ExcludeDirs: true, IncludeUnchanged: true}, `layer,path,diff type,size before,size after
+layer-0,/etc/group,Added,,20
+layer-0,/etc/hosts,Added,,10
+layer-0,/tmp/cache,Added,,30
+layer-1,/etc/group,Unchanged,20,20
+layer-1,/etc/hosts,Changed,10,15
+layer-1,/tmp/cache,Removed,30,
+`},
+		{"platform", CSVOptions{ExcludeDirs: true, Platform: "linux/arm64"}, `layer,path,diff type,size before,size after,platform
+layer-0,/etc/group,Added,,20,linux/arm64
+layer-0,/etc/hosts,Added,,10,linux/arm64
+layer-0,/tmp/cache,Added,,30,linux/arm64
+layer-1,/etc/hosts,Changed,10,15,linux/arm64
+layer-1,/tmp/cache,Removed,30,,linux/arm64
+`},
+	}
+
+	for _, test := range cases {
+		var buf bytes.Buffer
+		if err := ExportLayersCSV(&buf, trees, test.options); err != nil {
+			t.Fatalf("[%s] Expected no error exporting, got: %v", test.name, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("[%s] Expected:\n%s\nGot:\n%s", test.name, test.expected, buf.String())
+		}
+	}
+
+	// directories are included unless excluded
+	var buf bytes.Buffer
+	ExportLayersCSV(&buf, trees, CSVOptions{})
+	if !strings.Contains(buf.String(), "layer-1,/etc,Changed,") {
+		t.Errorf("Expected directory rows, got:\n%s", buf.String())
+	}
+
+	// a move is a single row with both paths
+	trees[1].AddPath("/var/cache", FileInfo{Path: "/var/cache", TypeFlag: '0', hash: 123, LogicalBytes: 30})
+	buf.Reset()
+	ExportLayersCSV(&buf, trees, CSVOptions{ExcludeDirs: true, DetectMoves: true})
+	expected := `layer,path,diff type,size before,size after
+layer-0,/etc/group,Added,,20
+layer-0,/etc/hosts,Added,,10
+layer-0,/tmp/cache,Added,,30
+layer-1,/etc/hosts,Changed,10,15
+layer-1,/tmp/cache → /var/cache,Moved,30,30
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,75 @@
+package filetree
+
+import (
+	"sort"
+)
+
+// ForbiddenPath is a path matching a forbidden pattern (see FindForbiddenPaths), with the layer that introduced it
+// (-1 when unknown). A path removed by a later layer is still stored in the layer that introduced it.
+type ForbiddenPath struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Layer   int    `json:"layer"`
+	Removed bool   `json:"removed"`
+}
+
+// ForbiddenPathSlice is a set of ForbiddenPath, ordered by path
+type ForbiddenPathSlice []ForbiddenPath
+
+// FindForbiddenPaths reports the paths of the final filesystem of the given trees (layers) matching any of the given
+// patterns (see MatchGlob), e.g. secrets or VCS directories that must not ship in an image. With allLayers set, the
+// paths of every layer are checked, including those removed by a later layer (while still stored in the layer adding
+// them). A path beneath a matching path is reported with it, and is not reported on its own.
+func FindForbiddenPaths(trees []*FileTree, patterns []string, allLayers bool) (ForbiddenPathSlice, error) {
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+	paths := make(ForbiddenPathSlice, 0)
+	if len(trees) == 0 || len(patterns) == 0 {
+		return paths, nil
+	}
+
+	final := StackRange(trees, 0, len(trees)-1)
+	checked := []*FileTree{final}
+	if allLayers {
+		checked = trees
+	}
+
+	reported := make(map[string]bool)
+	for _, tree := range checked {
+		err := tree.VisitDepthParentFirst(func(node *FileNode) error {
+			if node.IsWhiteout() {
+				return nil
+			}
+			for _, pattern := range patterns {
+				matched, _ := MatchGlob(pattern, node.Path())
+				if !matched {
+					continue
+				}
+				path := node.Path()
+				if !reported[path] {
+					reported[path] = true
+					forbidden := ForbiddenPath{Path: path, Pattern: pattern, Layer: node.Data.AddedLayer}
+					if tree != final {
+						if _, err := final.GetNode(path); err != nil {
+							forbidden.Removed = true
+						}
+					}
+					paths = append(paths, forbidden)
+				}
+				return SkipSubtree
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return paths[i].Path < paths[j].Path
+	})
+	return paths, nil
+}
@@ -0,0 +1,51 @@
+package filetree
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+)
+
+func TestFindForbiddenPaths(t *testing.T) {
+	lower := NewFileTree()
+	lower.AddPath("/src/.git/config", FileInfo{Path: "src/.git/config", TypeFlag: tar.TypeReg})
+	lower.AddPath("/src/.git/HEAD", FileInfo{Path: "src/.git/HEAD", TypeFlag: tar.TypeReg})
+	lower.AddPath("/etc/tls/server.pem", FileInfo{Path: "etc/tls/server.pem", TypeFlag: tar.TypeReg})
+	upper := NewFileTree()
+	upper.AddPath("/etc/tls/.wh.server.pem", FileInfo{Path: "etc/tls/.wh.server.pem", TypeFlag: tar.TypeReg})
+	upper.AddPath("/etc/tls/client.pem", FileInfo{Path: "etc/tls/client.pem", TypeFlag: tar.TypeReg})
+	trees := []*FileTree{lower, upper}
+	for idx, tree := range trees {
+		tree.SetLayer(idx)
+	}
+	patterns := []string{"**/.git", "**/*.pem"}
+
+	paths, err := FindForbiddenPaths(trees, patterns, false)
+	if err != nil {
+		t.Fatalf("could not find forbidden paths: %v", err)
+	}
+	expected := ForbiddenPathSlice{
+		{Path: "/etc/tls/client.pem", Pattern: "**/*.pem", Layer: 1},
+		{Path: "/src/.git", Pattern: "**/.git", Layer: 0},
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %+v, got %+v", expected, paths)
+	}
+
+	paths, err = FindForbiddenPaths(trees, patterns, true)
+	if err != nil {
+		t.Fatalf("could not find forbidden paths: %v", err)
+	}
+	expected = ForbiddenPathSlice{
+		{Path: "/etc/tls/client.pem", Pattern: "**/*.pem", Layer: 1},
+		{Path: "/etc/tls/server.pem", Pattern: "**/*.pem", Layer: 0, Removed: true},
+		{Path: "/src/.git", Pattern: "**/.git", Layer: 0},
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %+v, got %+v", expected, paths)
+	}
+
+	if _, err := FindForbiddenPaths(trees, []string{"[a-"}, false); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
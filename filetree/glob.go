@@ -0,0 +1,148 @@
+package filetree
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MatchGlob indicates if the given (slash delimited) path matches the given glob pattern. Besides the path.Match
+// syntax within a path segment, a "**" segment matches zero or more path segments (e.g. "/proc/**" or
+// "**/__pycache__/**"). Leading slashes are ignored on both the pattern and the path.
+func MatchGlob(pattern, filePath string) (bool, error) {
+	return matchSegments(splitPath(pattern), splitPath(filePath))
+}
+
+// ValidateGlob returns an error when the given glob pattern (see MatchGlob) is malformed, whatever the paths it would
+// be matched against.
+func ValidateGlob(pattern string) error {
+	for _, segment := range splitPath(pattern) {
+		if segment == "**" {
+			continue
+		}
+		// the whole pattern is checked even when the name does not match it
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitPath splits a slash delimited path into its segments
+func splitPath(value string) []string {
+	value = strings.Trim(value, "/")
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, "/")
+}
+
+// matchSegments matches path segments against glob pattern segments, where "**" matches any number of segments.
+func matchSegments(pattern, segments []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true, nil
+			}
+			for idx := 0; idx <= len(segments); idx++ {
+				matched, err := matchSegments(pattern[1:], segments[idx:])
+				if matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(segments) == 0 {
+			return false, nil
+		}
+		matched, err := path.Match(pattern[0], segments[0])
+		if !matched || err != nil {
+			return false, err
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0, nil
+}
+
+// ApplyHideGlobs marks the nodes whose path matches any of the given glob patterns (see MatchGlob) as Hidden, along
+// with all of their children when subtrees is set.
+func (tree *FileTree) ApplyHideGlobs(patterns []string, subtrees bool) error {
+	return tree.setHiddenByGlobs(patterns, true, subtrees)
+}
+
+// ClearHideGlobs reverses ApplyHideGlobs, marking the nodes that match any of the given glob patterns (and all of their
+// children when subtrees is set) as no longer Hidden.
+func (tree *FileTree) ClearHideGlobs(patterns []string, subtrees bool) error {
+	return tree.setHiddenByGlobs(patterns, false, subtrees)
+}
+
+// setHiddenByGlobs sets the Hidden flag of every node matching any of the given glob patterns.
+func (tree *FileTree) setHiddenByGlobs(patterns []string, hidden bool, subtrees bool) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	// validate up front so a bad pattern is reported even when no path reaches it
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			return err
+		}
+	}
+
+	return tree.VisitDepthParentFirst(func(node *FileNode) error {
+		for _, pattern := range patterns {
+			matched, err := MatchGlob(pattern, node.Path())
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+			if subtrees {
+				return node.VisitDepthParentFirst(func(child *FileNode) error {
+					child.SetHidden(hidden)
+					return nil
+				}, nil)
+			}
+			node.SetHidden(hidden)
+			return nil
+		}
+		return nil
+	}, nil)
+}
+
+// ignoreGlobs are the patterns of the paths left out of trees as they are read (see SetIgnoreGlobs)
+var ignoreGlobs []string
+
+// SetIgnoreGlobs sets the glob patterns (see MatchGlob) of the paths that are never added to a tree as layers are
+// read, along with everything beneath them. Unlike hidden paths (see ApplyHideGlobs), ignored paths are not part of
+// the analysis at all: not compared, not counted as wasted, and not exported.
+func SetIgnoreGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			return fmt.Errorf("invalid ignore pattern '%s': %v", pattern, err)
+		}
+	}
+	ignoreGlobs = patterns
+	return nil
+}
+
+// IgnoreGlobs returns the glob patterns of the ignored paths (see SetIgnoreGlobs)
+func IgnoreGlobs() []string {
+	return ignoreGlobs
+}
+
+// IsIgnored indicates if the given path (or any of its parent directories) matches one of the ignored patterns.
+func IsIgnored(filePath string) bool {
+	if len(ignoreGlobs) == 0 {
+		return false
+	}
+	segments := splitPath(path.Clean("/" + filePath))
+	for depth := 1; depth <= len(segments); depth++ {
+		for _, pattern := range ignoreGlobs {
+			if matched, _ := matchSegments(splitPath(pattern), segments[:depth]); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
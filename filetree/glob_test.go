@@ -0,0 +1,96 @@
+package filetree
+
+import (
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"/proc/**", "/proc", true},
+		{"/proc/**", "/proc/1/status", true},
+		{"/proc/**", "/usr/proc/1", false},
+		{"proc/*", "/proc/1", true},
+		{"/proc/*", "/proc/1/status", false},
+		{"**/__pycache__/**", "/usr/lib/python3/__pycache__/os.pyc", true},
+		{"**/__pycache__/**", "/__pycache__", true},
+		{"**/__pycache__/**", "/usr/lib/pycache", false},
+		{"/usr/**/*.pyc", "/usr/lib/python3/os.pyc", true},
+		{"/usr/**/*.pyc", "/usr/os.pyc", true},
+		{"/usr/**/*.pyc", "/usr/lib/os.py", false},
+		{"/etc/host?", "/etc/hosts", true},
+	}
+
+	for _, test := range cases {
+		actual, err := MatchGlob(test.pattern, test.path)
+		if err != nil {
+			t.Errorf("Expected no error matching '%s', got: %v", test.pattern, err)
+		}
+		if actual != test.expected {
+			t.Errorf("Expected '%s' matching '%s' to be %v", test.pattern, test.path, test.expected)
+		}
+	}
+}
+
+func TestValidateGlob(t *testing.T) {
+	for _, pattern := range []string{"/proc/**", "**/*.pem", "/etc/host?", "[ab]/c"} {
+		if err := ValidateGlob(pattern); err != nil {
+			t.Errorf("Expected '%s' to be valid, got: %v", pattern, err)
+		}
+	}
+	// a malformed segment following one the pattern itself does not match is still reported
+	for _, pattern := range []string{"[a-", "/usr/**/[", "[ab]/["} {
+		if err := ValidateGlob(pattern); err == nil {
+			t.Errorf("Expected '%s' to be invalid", pattern)
+		}
+	}
+}
+
+func TestApplyHideGlobs(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/proc/1/status", "/usr/lib/python3/__pycache__/os.pyc", "/usr/lib/python3/os.py", "/etc/hosts"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	patterns := []string{"/proc", "**/__pycache__"}
+
+	tree.ApplyHideGlobs(patterns, false)
+	assertPaths(t, "nodes", []string{"/proc", "/usr/lib/python3/__pycache__"}, hiddenPaths(tree))
+
+	tree.ApplyHideGlobs(patterns, true)
+	assertPaths(t, "subtrees", []string{"/proc", "/proc/1", "/proc/1/status", "/usr/lib/python3/__pycache__", "/usr/lib/python3/__pycache__/os.pyc"}, hiddenPaths(tree))
+
+	tree.ClearHideGlobs([]string{"/proc"}, true)
+	assertPaths(t, "cleared", []string{"/usr/lib/python3/__pycache__", "/usr/lib/python3/__pycache__/os.pyc"}, hiddenPaths(tree))
+
+	if err := tree.ApplyHideGlobs([]string{"/usr/[lib"}, true); err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	if err := SetIgnoreGlobs([]string{"/proc/*", "**/.git"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer SetIgnoreGlobs(nil)
+
+	cases := map[string]bool{
+		"proc":                 false,
+		"proc/1":               true,
+		"./proc/1/status":      true,
+		"/opt/app/.git/config": true,
+		"opt/app/.gitignore":   false,
+		"etc/hosts":            false,
+	}
+	for path, expected := range cases {
+		if actual := IsIgnored(path); actual != expected {
+			t.Errorf("Expected '%s' ignored to be %v", path, expected)
+		}
+	}
+
+	if err := SetIgnoreGlobs([]string{"/usr/[lib"}); err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
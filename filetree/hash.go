@@ -0,0 +1,91 @@
+package filetree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash"
+)
+
+const (
+	XXHash = "xxhash"
+	SHA256 = "sha256"
+	CRC32  = "crc32"
+)
+
+// Hasher creates the hash functions used to digest file contents.
+type Hasher interface {
+	// Name is the identifier of the algorithm (e.g. "sha256").
+	Name() string
+	// New creates a fresh hash function.
+	New() hash.Hash
+}
+
+type hasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+func (h hasher) Name() string {
+	return h.name
+}
+
+func (h hasher) New() hash.Hash {
+	return h.newHash()
+}
+
+var hashers = map[string]Hasher{
+	XXHash: hasher{XXHash, func() hash.Hash { return xxhash.New() }},
+	SHA256: hasher{SHA256, sha256.New},
+	CRC32:  hasher{CRC32, func() hash.Hash { return crc32.NewIEEE() }},
+}
+
+var currentHasher = hashers[XXHash]
+
+// SetHashAlgorithm selects (by name) the algorithm NewFileInfo uses to hash file contents.
+func SetHashAlgorithm(name string) error {
+	h, ok := hashers[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		var names []string
+		for key := range hashers {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown hash algorithm '%s' (supported: %s)", name, strings.Join(names, ", "))
+	}
+	currentHasher = h
+	return nil
+}
+
+// SetHasher sets the Hasher NewFileInfo uses to hash file contents.
+func SetHasher(h Hasher) {
+	currentHasher = h
+}
+
+// HashAlgorithm returns the name of the algorithm currently used to hash file contents.
+func HashAlgorithm() string {
+	return currentHasher.Name()
+}
+
+// sumHash returns a 64-bit fingerprint of the given hash and, for digests wider than 64 bits, the full digest.
+func sumHash(h hash.Hash) (uint64, []byte) {
+	switch sum := h.(type) {
+	case hash.Hash64:
+		return sum.Sum64(), nil
+	case hash.Hash32:
+		return uint64(sum.Sum32()), nil
+	}
+
+	digest := h.Sum(nil)
+	if len(digest) < 8 {
+		padded := make([]byte, 8)
+		copy(padded[8-len(digest):], digest)
+		return binary.BigEndian.Uint64(padded), nil
+	}
+	return binary.BigEndian.Uint64(digest[:8]), digest
+}
@@ -0,0 +1,116 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func newTestFileInfo(t *testing.T, path string, contents []byte) FileInfo {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	writer.WriteHeader(&tar.Header{Name: path, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))})
+	writer.Write(contents)
+	writer.Close()
+
+	reader := tar.NewReader(&buf)
+	header, err := reader.Next()
+	if err != nil {
+		t.Fatalf("could not read test tar: %v", err)
+	}
+	info, err := NewFileInfo(reader, header, path, true)
+	if err != nil {
+		t.Fatalf("could not create FileInfo: %v", err)
+	}
+	return info
+}
+
+func TestSetHashAlgorithm(t *testing.T) {
+	defer SetHashAlgorithm(XXHash)
+
+	for _, name := range []string{XXHash, SHA256, CRC32, " SHA256 "} {
+		if err := SetHashAlgorithm(name); err != nil {
+			t.Errorf("Expected algorithm '%s' to be supported, got: %v", name, err)
+		}
+	}
+
+	if err := SetHashAlgorithm("md4"); err == nil {
+		t.Errorf("Expected an error for an unknown algorithm")
+	}
+	if HashAlgorithm() != SHA256 {
+		t.Errorf("Expected an unknown algorithm to leave the current algorithm in place, got '%s'", HashAlgorithm())
+	}
+}
+
+func TestHashAlgorithmDigests(t *testing.T) {
+	defer SetHashAlgorithm(XXHash)
+
+	contents := []byte("the same leading bytes... but a different ending A")
+	other := []byte("the same leading bytes... but a different ending B")
+
+	for _, name := range []string{XXHash, SHA256, CRC32} {
+		SetHashAlgorithm(name)
+
+		first := newTestFileInfo(t, "/file", contents)
+		second := newTestFileInfo(t, "/file", contents)
+		third := newTestFileInfo(t, "/file", other)
+
+		if first.Compare(second) != Unchanged {
+			t.Errorf("[%s] Expected identical contents to be Unchanged", name)
+		}
+		if first.Compare(third) != Changed {
+			t.Errorf("[%s] Expected different contents to be Changed", name)
+		}
+	}
+
+	SetHashAlgorithm(SHA256)
+	info := newTestFileInfo(t, "/file", contents)
+	expected := sha256.Sum256(contents)
+	if !bytes.Equal(info.digest, expected[:]) {
+		t.Errorf("Expected the full sha256 digest to be recorded, got %x", info.digest)
+	}
+}
+
+func TestMixedHashAlgorithms(t *testing.T) {
+	defer SetHashAlgorithm(XXHash)
+
+	SetHashAlgorithm(XXHash)
+	lowerTree := NewFileTree()
+	lowerTree.AddPath("/etc/hosts", FileInfo{})
+
+	SetHashAlgorithm(SHA256)
+	upperTree := NewFileTree()
+	upperTree.AddPath("/etc/hosts", FileInfo{})
+
+	if err := lowerTree.Stack(upperTree); err == nil {
+		t.Errorf("Expected an error when stacking trees with different hash algorithms")
+	}
+	if err := lowerTree.Compare(upperTree); err == nil {
+		t.Errorf("Expected an error when comparing trees with different hash algorithms")
+	}
+	if err := lowerTree.Copy().Compare(lowerTree); err != nil {
+		t.Errorf("Expected a copied tree to keep its hash algorithm, got: %v", err)
+	}
+}
+
+func TestFileInfoHash(t *testing.T) {
+	info := newTestFileInfo(t, "/etc/hosts", []byte("127.0.0.1 localhost"))
+	if info.Hash() == 0 || info.Hash() != info.Copy().Hash() {
+		t.Errorf("Expected a non-zero hash preserved by Copy, got %x and %x", info.Hash(), info.Copy().Hash())
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Expected no error marshaling a FileInfo, got: %v", err)
+	}
+	var decoded struct {
+		Path string
+		Hash uint64
+	}
+	json.Unmarshal(data, &decoded)
+	if decoded.Path != "/etc/hosts" || decoded.Hash != info.Hash() {
+		t.Errorf("Expected the path and hash in the JSON representation, got: %s", data)
+	}
+}
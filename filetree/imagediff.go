@@ -0,0 +1,225 @@
+package filetree
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ChangeSummary counts the file changes beneath a top-level path between the final filesystems of two images (see
+// SummarizeChanges). Changed counts files changed in any way (contents, metadata, or moved).
+type ChangeSummary struct {
+	Path       string `json:"path"`
+	Added      int    `json:"added"`
+	Removed    int    `json:"removed"`
+	Changed    int    `json:"changed"`
+	SizeBefore int64  `json:"sizeBefore"`
+	SizeAfter  int64  `json:"sizeAfter"`
+}
+
+// FileChange is a path that differs between the final filesystems of two images (see ListChanges)
+type FileChange struct {
+	Path       string   `json:"path"`
+	Change     DiffType `json:"change"`
+	SizeBefore int64    `json:"sizeBefore"`
+	SizeAfter  int64    `json:"sizeAfter"`
+	// Directory is set for a directory found in one of the images only, which stands for the Files beneath it
+	Directory bool `json:"directory,omitempty"`
+	Files     int  `json:"files,omitempty"`
+	// LinkBefore and LinkAfter are the targets of a link in either image (they differ for a retargeted symlink)
+	LinkBefore string `json:"linkBefore,omitempty"`
+	LinkAfter  string `json:"linkAfter,omitempty"`
+	// MovedFrom is the path of a Moved file in the base image
+	MovedFrom string `json:"movedFrom,omitempty"`
+	// Attributes lists the metadata that differs between both images (e.g. "mode"), which is all that differs for a
+	// MetadataChanged file
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+// changeAttributes are the attributes reported as differing by ListChanges, in order (extended attributes follow)
+var changeAttributes = []string{"mode", "uid", "gid", "mtime"}
+
+// ImageComparison is the comparison of the final filesystems of two images (see NewImageComparison), with the sizes
+// of both filesystems, the number of changes beneath every top-level path, the changed paths, and the compared tree
+type ImageComparison struct {
+	Base        string          `json:"base"`
+	Image       string          `json:"image"`
+	SizeBefore  int64           `json:"sizeBefore"`
+	SizeAfter   int64           `json:"sizeAfter"`
+	Directories []ChangeSummary `json:"directories"`
+	Changes     []FileChange    `json:"changes"`
+	Tree        *FileTree       `json:"tree"`
+}
+
+// NewImageComparison compares the final filesystems of the given images (see CompareImages), named as given, also
+// detecting the files moved between them when asked to.
+func NewImageComparison(baseName, imageName string, base, target *FileTree, detectMoves bool) (ImageComparison, error) {
+	compared, err := CompareImages(base, target)
+	if err != nil {
+		return ImageComparison{}, err
+	}
+	if detectMoves {
+		compared.DetectMoves()
+	}
+	return ImageComparison{
+		Base:        baseName,
+		Image:       imageName,
+		SizeBefore:  base.EntryStats().Bytes,
+		SizeAfter:   target.EntryStats().Bytes,
+		Directories: SummarizeChanges(compared, base, target),
+		Changes:     ListChanges(compared, base, target),
+		Tree:        compared,
+	}, nil
+}
+
+// CompareImages compares the final (stacked) filesystems of two images, regardless of how their layers are arranged.
+// The returned tree holds the files of both: files only in the target are Added, files only in the base are Removed,
+// and files in both are compared as with Compare. Neither given tree is modified.
+func CompareImages(base, target *FileTree) (*FileTree, error) {
+	compared := base.Copy()
+	if err := compared.Compare(target); err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	compared.VisitDepthChildFirst(func(node *FileNode) error {
+		if _, err := target.GetNode(node.Path()); err != nil {
+			removed = append(removed, node.Path())
+		}
+		return nil
+	}, func(node *FileNode) bool { return node.IsLeaf() })
+	for _, path := range removed {
+		if err := compared.markRemoved(path); err != nil {
+			return nil, err
+		}
+	}
+	compared.PropagateDiff()
+	return compared, nil
+}
+
+// SummarizeChanges counts the changed files beneath each top-level path of a tree returned by CompareImages for the
+// given trees, along with the bytes held beneath that path by either image. Paths without any change are left out.
+func SummarizeChanges(compared, base, target *FileTree) []ChangeSummary {
+	summaries := make(map[string]*ChangeSummary)
+	summary := func(path string) *ChangeSummary {
+		top := topLevelPath(path)
+		if _, ok := summaries[top]; !ok {
+			summaries[top] = &ChangeSummary{Path: top}
+		}
+		return summaries[top]
+	}
+	leaves := func(node *FileNode) bool { return node.IsLeaf() }
+
+	compared.VisitDepthChildFirst(func(node *FileNode) error {
+		switch node.Data.DiffType {
+		case Added:
+			summary(node.Path()).Added++
+		case Removed:
+			summary(node.Path()).Removed++
+		case Changed, MetadataChanged, Moved:
+			summary(node.Path()).Changed++
+		}
+		return nil
+	}, leaves)
+
+	base.VisitDepthChildFirst(func(node *FileNode) error {
+		if data, ok := summaries[topLevelPath(node.Path())]; ok {
+			data.SizeBefore += node.Size()
+		}
+		return nil
+	}, leaves)
+	target.VisitDepthChildFirst(func(node *FileNode) error {
+		if data, ok := summaries[topLevelPath(node.Path())]; ok {
+			data.SizeAfter += node.Size()
+		}
+		return nil
+	}, leaves)
+
+	result := make([]ChangeSummary, 0, len(summaries))
+	for _, data := range summaries {
+		result = append(result, *data)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// ListChanges lists the paths that differ in a tree returned by CompareImages for the given trees, in path order. A
+// directory found in one image only is listed once (with the files and bytes beneath it) instead of every file beneath
+// it. Directories found in both images are left out, their files are listed. Moved files are listed at their new path.
+func ListChanges(compared, base, target *FileTree) []FileChange {
+	changes := make([]FileChange, 0)
+	compared.VisitDepthParentFirst(func(node *FileNode) error {
+		path := node.Path()
+		diffType := node.Data.DiffType
+		if diffType == Unchanged || node.Data.MovedTo != "" {
+			return nil
+		}
+		baseNode, _ := base.GetNode(path)
+		targetNode, _ := target.GetNode(path)
+		if node.Data.FileInfo.IsDir() && !node.IsLeaf() {
+			if (diffType == Added && baseNode == nil) || (diffType == Removed && targetNode == nil) {
+				change := FileChange{Path: path, Change: diffType, Directory: true}
+				node.VisitDepthChildFirst(func(child *FileNode) error {
+					if !child.Data.FileInfo.IsDir() {
+						change.Files++
+						if diffType == Added {
+							change.SizeAfter += child.Size()
+						} else {
+							change.SizeBefore += child.Size()
+						}
+					}
+					return nil
+				}, nil)
+				changes = append(changes, change)
+				return SkipSubtree
+			}
+			return nil
+		}
+
+		change := FileChange{Path: path, Change: diffType, MovedFrom: node.Data.MovedFrom}
+		if node.Data.MovedFrom != "" {
+			baseNode, _ = base.GetNode(node.Data.MovedFrom)
+		}
+		if baseNode != nil {
+			change.SizeBefore = baseNode.Size()
+			if isLink(baseNode.Data.FileInfo.TypeFlag) {
+				change.LinkBefore = baseNode.Data.FileInfo.Linkname
+			}
+		}
+		if targetNode != nil {
+			change.SizeAfter = targetNode.Size()
+			if isLink(targetNode.Data.FileInfo.TypeFlag) {
+				change.LinkAfter = targetNode.Data.FileInfo.Linkname
+			}
+		}
+		if baseNode != nil && targetNode != nil {
+			before, after := baseNode.Data.FileInfo, targetNode.Data.FileInfo
+			for _, name := range changeAttributes {
+				if !before.attributesEqual(after, attributeNames[name]) {
+					change.Attributes = append(change.Attributes, name)
+				}
+			}
+			if !xattrsEqual(before.Xattrs, after.Xattrs) {
+				change.Attributes = append(change.Attributes, "xattrs")
+			}
+		}
+		changes = append(changes, change)
+		return nil
+	}, nil)
+	return changes
+}
+
+// topLevelPath returns the first component of the given path (e.g. "/usr" for "/usr/lib/libc.so").
+func topLevelPath(path string) string {
+	return "/" + strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// ExportComparisonJSON writes the (indented) JSON representation of an image-to-image comparison to the given writer.
+func ExportComparisonJSON(writer io.Writer, comparison ImageComparison) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(comparison)
+}
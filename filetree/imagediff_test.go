@@ -0,0 +1,124 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareImages(t *testing.T) {
+	base := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/same", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opt/legacy/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/legacy/tool", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"etc/config": "version=1", "etc/same": "same", "opt/legacy/tool": "old tool"})
+	target := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/same", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"etc/config": "version=22", "etc/same": "same", "usr/bin/tool": "new tool"})
+
+	compared, err := CompareImages(base, target)
+	if err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+
+	expected := map[string]DiffType{
+		"/etc":             Changed,
+		"/etc/config":      Changed,
+		"/etc/same":        Unchanged,
+		"/opt":             Removed,
+		"/opt/legacy/tool": Removed,
+		"/usr/bin/tool":    Added,
+	}
+	for path, diffType := range expected {
+		node, err := compared.GetNode(path)
+		if err != nil {
+			t.Errorf("expected path '%s': %v", path, err)
+			continue
+		}
+		if node.Data.DiffType != diffType {
+			t.Errorf("%s: expected %v, got %v", path, diffType, node.Data.DiffType)
+		}
+	}
+	if node, _ := base.GetNode("/etc/config"); node.Data.DiffType != Unchanged {
+		t.Errorf("expected the base tree to be left alone, got %v", node.Data.DiffType)
+	}
+
+	summaries := SummarizeChanges(compared, base, target)
+	expectedSummaries := []ChangeSummary{
+		{Path: "/etc", Changed: 1, SizeBefore: 13, SizeAfter: 14},
+		{Path: "/opt", Removed: 1, SizeBefore: 8},
+		{Path: "/usr", Added: 1, SizeAfter: 8},
+	}
+	if !reflect.DeepEqual(summaries, expectedSummaries) {
+		t.Errorf("unexpected summaries:\n%+v\nexpected:\n%+v", summaries, expectedSummaries)
+	}
+
+	comparison, err := NewImageComparison("app:v1", "app:v2", base, target, false)
+	if err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+	if !reflect.DeepEqual(comparison.Directories, expectedSummaries) {
+		t.Errorf("unexpected comparison summaries: %+v", comparison.Directories)
+	}
+	if comparison.SizeBefore != 21 || comparison.SizeAfter != 22 {
+		t.Errorf("expected sizes 21 and 22, got %d and %d", comparison.SizeBefore, comparison.SizeAfter)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportComparisonJSON(&buf, comparison); err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	for _, expected := range []string{`"base": "app:v1"`, `"image": "app:v2"`, `"sizeAfter": 22`, `"path": "/opt"`, `"path": "/usr/bin/tool"`, `"change": "Removed"`} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %s in the export: %s", expected, buf.String())
+		}
+	}
+}
+
+func TestListChanges(t *testing.T) {
+	if err := SetCompareAttributes([]string{"mode", "uid", "gid"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCompareAttributes(nil)
+
+	base := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/secret", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/alternatives/python", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/python2"},
+		{Name: "opt/legacy/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/legacy/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "opt/legacy/lib/libtool.so", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/config": "version=1", "etc/secret": "hunter2", "opt/legacy/tool": "old tool", "opt/legacy/lib/libtool.so": "lib"})
+	target := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/secret", Typeflag: tar.TypeReg, Mode: 0600, Uid: 1000},
+		{Name: "etc/alternatives/python", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/python3"},
+		{Name: "srv/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "srv/app/index.html", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/config": "version=22", "etc/secret": "hunter2", "srv/app/index.html": "<html>"})
+
+	compared, err := CompareImages(base, target)
+	if err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+	changes := ListChanges(compared, base, target)
+	expected := []FileChange{
+		{Path: "/etc/alternatives/python", Change: Changed, LinkBefore: "/usr/bin/python2", LinkAfter: "/usr/bin/python3"},
+		{Path: "/etc/config", Change: Changed, SizeBefore: 9, SizeAfter: 10},
+		{Path: "/etc/secret", Change: MetadataChanged, SizeBefore: 7, SizeAfter: 7, Attributes: []string{"mode", "uid"}},
+		{Path: "/opt", Change: Removed, SizeBefore: 11, Directory: true, Files: 2},
+		{Path: "/srv", Change: Added, SizeAfter: 6, Directory: true, Files: 1},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes:\n%+v\nexpected:\n%+v", changes, expected)
+	}
+}
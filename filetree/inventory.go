@@ -0,0 +1,186 @@
+package filetree
+
+import (
+	"archive/tar"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// InventoryJSONLines writes one JSON object per file
+	InventoryJSONLines = "jsonl"
+	// InventoryCSV writes a CSV table (with a header row) of the files
+	InventoryCSV = "csv"
+)
+
+// inventoryHeader names the columns of the CSV inventory
+var inventoryHeader = []string{"path", "type", "size", "mode", "uid", "gid", "mtime", "link", "hash", "added layer", "modified layer"}
+
+// inventoryEntry is a single file of an inventory (see ExportInventory). The hash is prefixed with the algorithm used
+// (e.g. "sha256:..."), and empty for entries without hashed contents. The layers are those adding and last modifying
+// the path, when known (see FileTree.SetLayer).
+type inventoryEntry struct {
+	Path          string `json:"path"`
+	Type          string `json:"type"`
+	Size          int64  `json:"size"`
+	Mode          string `json:"mode"`
+	Uid           int    `json:"uid"`
+	Gid           int    `json:"gid"`
+	MTime         string `json:"mtime"`
+	Link          string `json:"link,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	AddedLayer    *int   `json:"addedLayer,omitempty"`
+	ModifiedLayer *int   `json:"modifiedLayer,omitempty"`
+}
+
+// ParseInventoryFormat validates the name of an inventory format (one of: jsonl, csv).
+func ParseInventoryFormat(format string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(format)); format {
+	case InventoryJSONLines, InventoryCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown inventory format '%s' (supported: jsonl, csv)", format)
+}
+
+// ExportInventory writes a flat listing of every path of the tree (typically the final, stacked tree of an image) with
+// its metadata and content hash, in the given format. Paths are written in lexicographic order (parents first) so the
+// output is stable between runs, whiteouts are left out.
+func (tree *FileTree) ExportInventory(writer io.Writer, format string) error {
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	switch format {
+	case InventoryCSV:
+		csvWriter = csv.NewWriter(writer)
+		if err := csvWriter.Write(inventoryHeader); err != nil {
+			return err
+		}
+	case InventoryJSONLines:
+		encoder = json.NewEncoder(writer)
+	default:
+		return fmt.Errorf("unknown inventory format '%s' (supported: jsonl, csv)", format)
+	}
+
+	var visit func(node *FileNode) error
+	visit = func(node *FileNode) error {
+		for _, name := range node.sortedChildNames() {
+			child := node.Children[name]
+			if child.IsWhiteout() {
+				continue
+			}
+			entry := tree.inventoryEntry(child)
+			var err error
+			if csvWriter != nil {
+				err = csvWriter.Write([]string{entry.Path, entry.Type, strconv.FormatInt(entry.Size, 10), entry.Mode, strconv.Itoa(entry.Uid), strconv.Itoa(entry.Gid), entry.MTime, entry.Link, entry.Hash, csvLayer(entry.AddedLayer), csvLayer(entry.ModifiedLayer)})
+			} else {
+				err = encoder.Encode(entry)
+			}
+			if err != nil {
+				return err
+			}
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(tree.Root); err != nil {
+		return err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return nil
+}
+
+// inventoryEntry returns the inventory representation of a node of the tree.
+func (tree *FileTree) inventoryEntry(node *FileNode) inventoryEntry {
+	info := node.Data.FileInfo
+	entry := inventoryEntry{
+		Path:          node.Path(),
+		Type:          inventoryType(&info),
+		Size:          node.Size(),
+		Mode:          fmt.Sprintf("%04o", unixMode(info.Mode)),
+		Uid:           info.Uid,
+		Gid:           info.Gid,
+		Link:          info.Linkname,
+		AddedLayer:    layerIndex(node.Data.AddedLayer),
+		ModifiedLayer: layerIndex(node.Data.ModifiedLayer),
+	}
+	if !info.ModTime.IsZero() {
+		entry.MTime = info.ModTime.UTC().Format(time.RFC3339)
+	}
+	if entry.Type == "file" && !info.hashSkipped && !info.Unreadable {
+		algorithm := tree.HashAlgorithm
+		if algorithm == "" {
+			algorithm = currentHasher.Name()
+		}
+		entry.Hash = algorithm + ":" + contentHash(&info, algorithm)
+	}
+	return entry
+}
+
+// csvLayer returns the CSV column of a layer index, empty when unknown
+func csvLayer(index *int) string {
+	if index == nil {
+		return ""
+	}
+	return strconv.Itoa(*index)
+}
+
+// unixMode returns the permission bits of a mode as written by chmod (including the setuid, setgid, and sticky bits)
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// inventoryType names the type of a file in an inventory
+func inventoryType(info *FileInfo) string {
+	if info.IsDir() {
+		return "dir"
+	}
+	switch info.TypeFlag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return string(info.TypeFlag)
+}
+
+// contentHash returns the hex encoded content hash of a file, the full digest when the algorithm has one wider than
+// 64 bits.
+func contentHash(info *FileInfo, algorithm string) string {
+	if len(info.digest) > 0 {
+		return hex.EncodeToString(info.digest)
+	}
+	if algorithm == CRC32 {
+		return fmt.Sprintf("%08x", info.hash)
+	}
+	return fmt.Sprintf("%016x", info.hash)
+}
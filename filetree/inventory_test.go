@@ -0,0 +1,72 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportInventory(t *testing.T) {
+	mtime := time.Date(2019, 3, 12, 10, 4, 5, 0, time.UTC)
+	lower := treeFromTar(t, []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "usr/bin/su", Typeflag: tar.TypeReg, Mode: 04755, Uid: 0, Gid: 0, ModTime: mtime},
+		{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 01777, ModTime: mtime},
+		{Name: "tmp/secret", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime},
+	}, map[string]string{"usr/bin/su": "binary", "tmp/secret": "deleted"})
+	upper := treeFromTar(t, []*tar.Header{
+		{Name: "tmp/.wh.secret", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime},
+		{Name: "usr/bin/sudo", Typeflag: tar.TypeSymlink, Linkname: "su", Mode: 0777, Uid: 1, Gid: 2, ModTime: mtime},
+	}, nil)
+	lower.SetLayer(0)
+	upper.SetLayer(1)
+	final := StackRange([]*FileTree{lower, upper}, 0, 1)
+
+	var buf bytes.Buffer
+	if err := final.ExportInventory(&buf, InventoryCSV); err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	hash := lower.HashAlgorithm
+	if hash == "" {
+		hash = currentHasher.Name()
+	}
+	node, _ := final.GetNode("/usr/bin/su")
+	expected := strings.Join([]string{
+		"path,type,size,mode,uid,gid,mtime,link,hash,added layer,modified layer",
+		"/tmp,dir,0,1777,0,0,2019-03-12T10:04:05Z,,,0,0",
+		"/usr,dir,0,0755,0,0,2019-03-12T10:04:05Z,,,0,0",
+		"/usr/bin,dir,0,0755,0,0,2019-03-12T10:04:05Z,,,0,0",
+		"/usr/bin/su,file,6,4755,0,0,2019-03-12T10:04:05Z,," + hash + ":" + contentHash(&node.Data.FileInfo, hash) + ",0,0",
+		"/usr/bin/sudo,symlink,0,0777,1,2,2019-03-12T10:04:05Z,su,,1,1",
+	}, "\n") + "\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected inventory:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := final.ExportInventory(&buf, InventoryJSONLines); err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[4], `"path":"/usr/bin/sudo"`) || !strings.Contains(lines[4], `"link":"su"`) || !strings.Contains(lines[4], `"addedLayer":1`) {
+		t.Errorf("unexpected inventory:\n%s", buf.String())
+	}
+
+	if err := final.ExportInventory(&buf, "xml"); err == nil {
+		t.Error("expected an unknown format error")
+	}
+}
+
+func TestParseInventoryFormat(t *testing.T) {
+	for value, expected := range map[string]string{"jsonl": InventoryJSONLines, " CSV ": InventoryCSV} {
+		if format, err := ParseInventoryFormat(value); err != nil || format != expected {
+			t.Errorf("%q: expected %s, got %s (%v)", value, expected, format, err)
+		}
+	}
+	if _, err := ParseInventoryFormat("xml"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,76 @@
+package filetree
+
+import (
+	"sort"
+)
+
+// LargeFile is a single copy of a file over a size threshold stored by a layer (see LargeFiles). Removed copies are
+// no longer part of the final filesystem (deleted or rewritten by a later layer) but their bytes remain in the image.
+type LargeFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Layer   int    `json:"layer"`
+	Removed bool   `json:"removed"`
+}
+
+// LargeFileSlice is a set of LargeFile, ordered by size (largest first)
+type LargeFileSlice []LargeFile
+
+// LargeFiles returns every regular file of at least the given size stored by the given trees (layers), along with the
+// layer storing it. Every stored copy is reported, as the bytes of a file deleted or rewritten by a later layer remain
+// in the image. Ties are ordered by path, then layer.
+func LargeFiles(trees []*FileTree, threshold int64) LargeFileSlice {
+	files := make(LargeFileSlice, 0)
+	if len(trees) == 0 {
+		return files
+	}
+	final := StackRange(trees, 0, len(trees)-1)
+
+	for idx, tree := range trees {
+		layer := idx
+		visitor := func(node *FileNode) error {
+			info := node.Data.FileInfo
+			if node.IsWhiteout() || info.IsDir() || (info.TypeFlag != '0' && info.TypeFlag != '\x00') {
+				return nil
+			}
+			size := node.Size()
+			if size < threshold {
+				return nil
+			}
+			path := node.Path()
+			files = append(files, LargeFile{
+				Path:    path,
+				Size:    size,
+				Layer:   layer,
+				Removed: !storedInFinalTree(trees, final, layer, path),
+			})
+			return nil
+		}
+		tree.VisitDepthChildFirst(visitor, func(node *FileNode) bool { return node.IsLeaf() })
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		if files[i].Path != files[j].Path {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].Layer < files[j].Layer
+	})
+	return files
+}
+
+// storedInFinalTree indicates if the copy of the path stored by the given layer is the one in the final (stacked)
+// tree: the path still exists and no later layer rewrites it.
+func storedInFinalTree(trees []*FileTree, final *FileTree, layer int, path string) bool {
+	if _, err := final.GetNode(path); err != nil {
+		return false
+	}
+	for _, tree := range trees[layer+1:] {
+		if _, err := tree.GetNode(path); err == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package filetree
+
+import (
+	"archive/tar"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLargeFiles(t *testing.T) {
+	large := strings.Repeat("x", 100)
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "opt/model.bin", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "tmp/core", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "etc/small", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "usr/bin/debug", Typeflag: tar.TypeReg, Mode: 0755},
+		}, map[string]string{"opt/model.bin": large + "x", "tmp/core": large, "etc/small": "small", "usr/bin/debug": large}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "tmp/.wh.core", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "usr/bin/debug", Typeflag: tar.TypeReg, Mode: 0755},
+		}, map[string]string{"usr/bin/debug": large + "xx"}),
+	}
+
+	expected := LargeFileSlice{
+		{Path: "/usr/bin/debug", Size: 102, Layer: 1},
+		{Path: "/opt/model.bin", Size: 101, Layer: 0},
+		{Path: "/tmp/core", Size: 100, Layer: 0, Removed: true},
+		{Path: "/usr/bin/debug", Size: 100, Layer: 0, Removed: true},
+	}
+	if files := LargeFiles(trees, 100); !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected large files:\n%+v\nexpected:\n%+v", files, expected)
+	}
+	if files := LargeFiles(trees, 1000); len(files) != 0 {
+		t.Errorf("expected no large files, got %+v", files)
+	}
+}
@@ -0,0 +1,89 @@
+package filetree
+
+import (
+	"archive/tar"
+)
+
+// moveKey identifies the contents of a file for pairing the two sides of a move
+type moveKey struct {
+	hash   uint64
+	digest string
+	size   int64
+}
+
+// movable indicates if the node is a (non empty) regular file whose contents were hashed, so it can be recognized
+// at another path.
+func (node *FileNode) movable() bool {
+	info := node.Data.FileInfo
+	if !node.IsLeaf() || info.IsDir() || info.hashSkipped || info.LogicalBytes == 0 {
+		return false
+	}
+	return info.TypeFlag == tar.TypeReg || info.TypeFlag == tar.TypeRegA
+}
+
+// DetectMoves pairs the Removed and Added files of a compared tree (see Compare) that hold the same contents (equal
+// hash and size) and marks both sides as Moved, recording the path of the other side in NodeData.MovedTo (on the old
+// location) and NodeData.MovedFrom (on the new location). When several files share the same contents, they are only
+// paired when their names match one to one, the rest stay Added and Removed. Returns the number of moves found.
+func (tree *FileTree) DetectMoves() int {
+	removed := make(map[moveKey][]*FileNode)
+	added := make(map[moveKey][]*FileNode)
+	var keys []moveKey
+	tree.VisitDepthChildFirst(func(node *FileNode) error {
+		if node.Data.DiffType != Removed && node.Data.DiffType != Added || !node.movable() {
+			return nil
+		}
+		info := node.Data.FileInfo
+		key := moveKey{info.hash, string(info.digest), info.LogicalBytes}
+		if node.Data.DiffType == Removed {
+			removed[key] = append(removed[key], node)
+		} else {
+			if len(added[key]) == 0 {
+				keys = append(keys, key)
+			}
+			added[key] = append(added[key], node)
+		}
+		return nil
+	}, nil)
+
+	moves := 0
+	for _, key := range keys {
+		oldNodes, newNodes := removed[key], added[key]
+		if len(oldNodes) == 1 && len(newNodes) == 1 {
+			markMoved(oldNodes[0], newNodes[0])
+			moves++
+			continue
+		}
+		// ambiguous contents, only pair the files whose name is unique on both sides
+		for _, newNode := range newNodes {
+			oldNode := uniqueByName(oldNodes, newNode.Name)
+			if oldNode != nil && uniqueByName(newNodes, newNode.Name) != nil {
+				markMoved(oldNode, newNode)
+				moves++
+			}
+		}
+	}
+	return moves
+}
+
+// uniqueByName returns the only node with the given name, or nil when there are none or several.
+func uniqueByName(nodes []*FileNode, name string) *FileNode {
+	var found *FileNode
+	for _, node := range nodes {
+		if node.Name == name {
+			if found != nil {
+				return nil
+			}
+			found = node
+		}
+	}
+	return found
+}
+
+// markMoved marks the old and new location of a file as Moved, linking each to the other.
+func markMoved(oldNode, newNode *FileNode) {
+	oldNode.AssignDiffType(Moved)
+	oldNode.writeData().MovedTo = newNode.Path()
+	newNode.AssignDiffType(Moved)
+	newNode.writeData().MovedFrom = oldNode.Path()
+}
@@ -0,0 +1,80 @@
+package filetree
+
+import (
+	"testing"
+)
+
+func TestDetectMoves(t *testing.T) {
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	files := []struct {
+		path string
+		hash uint64
+	}{
+		{"/opt/app/app.jar", 1},
+		{"/opt/app/lib/a/util.jar", 2},
+		{"/opt/app/lib/b/util.jar", 2},
+		{"/opt/app/lib/b/other.jar", 2},
+		{"/opt/app/empty", 0},
+	}
+	for _, file := range files {
+		lowerTree.AddPath(file.path, FileInfo{Path: file.path, TypeFlag: '0', hash: file.hash, LogicalBytes: int64(file.hash) * 100})
+	}
+	upperTree.AddPath("/.wh.opt", FileInfo{})
+	for _, path := range []string{"/usr/local/lib/app.jar", "/usr/share/util.jar", "/usr/share/other.jar"} {
+		hash := uint64(2)
+		if path == "/usr/local/lib/app.jar" {
+			hash = 1
+		}
+		upperTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: hash, LogicalBytes: int64(hash) * 100})
+	}
+	upperTree.AddPath("/usr/empty", FileInfo{Path: "/usr/empty", TypeFlag: '0'})
+
+	if err := lowerTree.Compare(upperTree); err != nil {
+		t.Fatalf("could not compare trees: %v", err)
+	}
+	if moves := lowerTree.DetectMoves(); moves != 2 {
+		t.Errorf("expected 2 moves, got %d", moves)
+	}
+
+	expected := map[string]struct {
+		diffType  DiffType
+		movedFrom string
+		movedTo   string
+	}{
+		"/opt/app/app.jar":         {Moved, "", "/usr/local/lib/app.jar"},
+		"/usr/local/lib/app.jar":   {Moved, "/opt/app/app.jar", ""},
+		"/opt/app/lib/b/other.jar": {Moved, "", "/usr/share/other.jar"},
+		"/usr/share/other.jar":     {Moved, "/opt/app/lib/b/other.jar", ""},
+		// several candidates with the same name, no way to tell which one moved
+		"/opt/app/lib/a/util.jar": {Removed, "", ""},
+		"/opt/app/lib/b/util.jar": {Removed, "", ""},
+		"/usr/share/util.jar":     {Added, "", ""},
+		// empty files are never paired
+		"/opt/app/empty": {Removed, "", ""},
+		"/usr/empty":     {Added, "", ""},
+	}
+	for path, expect := range expected {
+		node, err := lowerTree.GetNode(path)
+		if err != nil {
+			t.Fatalf("could not find '%s': %v", path, err)
+		}
+		if node.Data.DiffType != expect.diffType || node.Data.MovedFrom != expect.movedFrom || node.Data.MovedTo != expect.movedTo {
+			t.Errorf("expected '%s' to be %v (from '%s', to '%s'), got %v (from '%s', to '%s')", path,
+				expect.diffType, expect.movedFrom, expect.movedTo, node.Data.DiffType, node.Data.MovedFrom, node.Data.MovedTo)
+		}
+	}
+
+	// a move is counted once, at its new location
+	stats := lowerTree.DiffStats()
+	if stats.Files[Moved] != 2 || stats.Bytes[Moved] != 300 {
+		t.Errorf("expected 2 moved files of 300 bytes, got %d files of %d bytes", stats.Files[Moved], stats.Bytes[Moved])
+	}
+
+	// reassigning the diff type drops the move
+	node, _ := lowerTree.GetNode("/usr/local/lib/app.jar")
+	node.AssignDiffType(Added)
+	if node.Data.MovedFrom != "" {
+		t.Errorf("expected the move to be cleared, got '%s'", node.Data.MovedFrom)
+	}
+}
@@ -3,8 +3,8 @@ package filetree
 import (
 	"archive/tar"
 	"fmt"
-	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
@@ -13,13 +13,17 @@ import (
 
 const (
 	AttributeFormat = "%s%s %10s %10s "
+	// unreadableMarker follows the name of the files whose contents could not be (fully) read
+	unreadableMarker = "⚠ unreadable"
 )
 
 var diffTypeColor = map[DiffType]*color.Color{
-	Added:     color.New(color.FgGreen),
-	Removed:   color.New(color.FgRed),
-	Changed:   color.New(color.FgYellow),
-	Unchanged: color.New(color.Reset),
+	Added:           color.New(color.FgGreen),
+	Removed:         color.New(color.FgRed),
+	Changed:         color.New(color.FgYellow),
+	MetadataChanged: color.New(color.FgCyan),
+	Moved:           color.New(color.FgMagenta),
+	Unchanged:       color.New(color.Reset),
 }
 
 // FileNode represents a single file, its relation to files beneath it, the tree it exists in, and the metadata of the given file.
@@ -27,17 +31,25 @@ type FileNode struct {
 	Tree     *FileTree
 	Parent   *FileNode
 	Name     string
-	Data     NodeData
+	Data     *NodeData
 	Children map[string]*FileNode
 	path     string
+	opaque   bool
+	folded   map[string][]*FileNode
+	sorted   []string
 }
 
 // NewNode creates a new FileNode relative to the given parent node with a payload.
 func NewNode(parent *FileNode, name string, data FileInfo) (node *FileNode) {
 	node = new(FileNode)
 	node.Name = name
-	node.Data = *NewNodeData()
-	node.Data.FileInfo = *data.Copy()
+	node.Data = &NodeData{
+		ViewInfo:      *NewViewInfo(),
+		FileInfo:      *data.Copy(),
+		DiffType:      Unchanged,
+		AddedLayer:    -1,
+		ModifiedLayer: -1,
+	}
 
 	node.Children = make(map[string]*FileNode)
 	node.Parent = parent
@@ -69,21 +81,71 @@ func (node *FileNode) renderTreeLine(spaces []bool, last bool, collapsed bool) s
 		collapsedIndicator = collapsedItem
 	}
 
-	return otherBranches + thisBranch + collapsedIndicator + node.String() + newLine
+	return otherBranches + thisBranch + collapsedIndicator + node.chainPrefix() + node.String() + newLine
 }
 
-// Copy duplicates the existing node relative to a new parent node.
+// Copy duplicates the existing node (and everything beneath it) relative to a new parent node, leaving the original
+// nodes attached to their own tree. The copies share their NodeData with the original nodes until either side changes
+// it (see writeData), so copying a tree does not duplicate the metadata of every file.
 func (node *FileNode) Copy(parent *FileNode) *FileNode {
-	newNode := NewNode(parent, node.Name, node.Data.FileInfo)
-	newNode.Data.ViewInfo = node.Data.ViewInfo
-	newNode.Data.DiffType = node.Data.DiffType
+	newNode := &FileNode{
+		Name:     node.Name,
+		Data:     node.Data.share(),
+		Parent:   parent,
+		Children: make(map[string]*FileNode, len(node.Children)),
+		opaque:   node.opaque,
+		sorted:   node.sorted,
+	}
+	if parent != nil {
+		newNode.Tree = parent.Tree
+	}
 	for name, child := range node.Children {
 		newNode.Children[name] = child.Copy(newNode)
-		child.Parent = newNode
 	}
 	return newNode
 }
 
+// writeData returns the NodeData of this node to be changed. Data still shared with other copies of the tree (see
+// Copy) is duplicated first, so the change stays with this node.
+func (node *FileNode) writeData() *NodeData {
+	for {
+		shares := atomic.LoadInt32(&node.Data.shares)
+		if shares == 0 {
+			return node.Data
+		}
+		if atomic.CompareAndSwapInt32(&node.Data.shares, shares, shares-1) {
+			node.Data = node.Data.Copy()
+			return node.Data
+		}
+	}
+}
+
+// SetViewInfo replaces the view state of this node, leaving the other copies of the tree as they are.
+func (node *FileNode) SetViewInfo(info ViewInfo) {
+	if node.Data.ViewInfo != info {
+		node.writeData().ViewInfo = info
+	}
+}
+
+// SetCollapsed collapses (or expands) this node, leaving the other copies of the tree as they are.
+func (node *FileNode) SetCollapsed(collapsed bool) {
+	info := node.Data.ViewInfo
+	info.Collapsed = collapsed
+	node.SetViewInfo(info)
+}
+
+// SetHidden hides (or shows) this node, leaving the other copies of the tree as they are.
+func (node *FileNode) SetHidden(hidden bool) {
+	info := node.Data.ViewInfo
+	info.Hidden = hidden
+	node.SetViewInfo(info)
+}
+
+// MarkUnreadable records that the contents of the file (or directory) could not be (fully) read.
+func (node *FileNode) MarkUnreadable() {
+	node.writeData().FileInfo.Unreadable = true
+}
+
 // AddChild creates a new node relative to the current FileNode.
 func (node *FileNode) AddChild(name string, data FileInfo) (child *FileNode) {
 	// never allow processing of purely whiteout flag files (for now)
@@ -94,11 +156,13 @@ func (node *FileNode) AddChild(name string, data FileInfo) (child *FileNode) {
 	child = NewNode(node, name, data)
 	if node.Children[name] != nil {
 		// tree node already exists, replace the payload, keep the children
-		node.Children[name].Data.FileInfo = *data.Copy()
+		node.Children[name].writeData().FileInfo = *data.Copy()
 	} else {
 		node.Children[name] = child
+		node.folded, node.sorted = nil, nil
 		node.Tree.Size++
 	}
+	node.Tree.invalidateAggregates()
 
 	return child
 }
@@ -112,7 +176,27 @@ func (node *FileNode) Remove() error {
 		child.Remove()
 	}
 	delete(node.Parent.Children, node.Name)
+	node.Parent.folded, node.Parent.sorted = nil, nil
 	node.Tree.Size--
+	node.Tree.invalidateAggregates()
+	return nil
+}
+
+// RemoveAndPrune deletes the current FileNode like Remove, then removes every ancestor directory left empty by the
+// removal. Pruning stops at the root and at directories shipped as entries of their own (e.g. a directory created by
+// a "RUN mkdir"), which are kept even when they end up empty.
+func (node *FileNode) RemoveAndPrune() error {
+	parent := node.Parent
+	if err := node.Remove(); err != nil {
+		return err
+	}
+	for parent != nil && parent != parent.Tree.Root && len(parent.Children) == 0 && parent.Data.FileInfo.TypeFlag == 0 {
+		next := parent.Parent
+		if err := parent.Remove(); err != nil {
+			return err
+		}
+		parent = next
+	}
 	return nil
 }
 
@@ -124,8 +208,16 @@ func (node *FileNode) String() string {
 	}
 
 	display = node.Name
-	if node.Data.FileInfo.TarHeader.Typeflag == tar.TypeSymlink || node.Data.FileInfo.TarHeader.Typeflag == tar.TypeLink {
-		display += " → " + node.Data.FileInfo.TarHeader.Linkname
+	if node.Data.FileInfo.TypeFlag == tar.TypeSymlink || node.Data.FileInfo.TypeFlag == tar.TypeLink {
+		display += " → " + node.Data.FileInfo.Linkname
+	}
+	if node.Data.MovedTo != "" {
+		display += " (moved to " + node.Data.MovedTo + ")"
+	} else if node.Data.MovedFrom != "" {
+		display += " (moved from " + node.Data.MovedFrom + ")"
+	}
+	if node.Data.FileInfo.Unreadable {
+		display += " " + unreadableMarker
 	}
 	return diffTypeColor[node.Data.DiffType].Sprint(display)
 }
@@ -136,25 +228,25 @@ func (node *FileNode) MetadataString() string {
 		return ""
 	}
 
-	fileMode := permbits.FileMode(node.Data.FileInfo.TarHeader.FileInfo().Mode()).String()
+	fileMode := permbits.FileMode(node.Data.FileInfo.Mode).String()
 	dir := "-"
-	if node.Data.FileInfo.TarHeader.FileInfo().IsDir() {
+	if node.Data.FileInfo.IsDir() {
 		dir = "d"
 	}
-	user := node.Data.FileInfo.TarHeader.Uid
-	group := node.Data.FileInfo.TarHeader.Gid
+	user := node.Data.FileInfo.Uid
+	group := node.Data.FileInfo.Gid
 	userGroup := fmt.Sprintf("%d:%d", user, group)
 
 	var sizeBytes int64
 
 	if node.IsLeaf() {
-		sizeBytes = node.Data.FileInfo.TarHeader.FileInfo().Size()
+		sizeBytes = node.Size()
 	} else {
 		sizer := func(curNode *FileNode) error {
 			// don't include file sizes of children that have been removed (unless the node in question is a removed dir,
 			// then show the accumulated size of removed files)
 			if curNode.Data.DiffType != Removed || node.Data.DiffType == Removed {
-				sizeBytes += curNode.Data.FileInfo.TarHeader.FileInfo().Size()
+				sizeBytes += curNode.Size()
 			}
 			return nil
 		}
@@ -163,20 +255,40 @@ func (node *FileNode) MetadataString() string {
 	}
 
 	size := humanize.Bytes(uint64(sizeBytes))
+	if node.IsLeaf() && node.Data.FileInfo.Unreadable {
+		// only part of the contents (if any) could be read
+		size = "?"
+	}
 
 	return diffTypeColor[node.Data.DiffType].Sprint(fmt.Sprintf(AttributeFormat, dir, fileMode, userGroup, size))
 }
 
-// VisitDepthChildFirst iterates a tree depth-first (starting at this FileNode), evaluating the deepest depths first (visit on bubble up)
+// Size returns the size of this FileNode alone (per SetSizeMode). A hardlink stores no bytes of its own, but its
+// logical size is that of its target.
+func (node *FileNode) Size() int64 {
+	if sizeMode == LogicalSize {
+		if target := node.linkTarget(); target != nil {
+			return target.Data.FileInfo.Size()
+		}
+	}
+	return node.Data.FileInfo.Size()
+}
+
+// VisitDepthChildFirst iterates a tree depth-first (starting at this FileNode), evaluating the deepest depths first (visit on bubble up).
+// A visitor error ends the traversal and is returned, except for StopWalk which ends it without an error. Since the
+// children of a node have already been visited by the time the node is, SkipSubtree is ignored.
 func (node *FileNode) VisitDepthChildFirst(visitor Visitor, evaluator VisitEvaluator) error {
-	var keys []string
-	for key := range node.Children {
-		keys = append(keys, key)
+	if err := node.visitDepthChildFirst(visitor, evaluator); err != StopWalk {
+		return err
 	}
-	sort.Strings(keys)
-	for _, name := range keys {
+	return nil
+}
+
+// visitDepthChildFirst is VisitDepthChildFirst, passing StopWalk up to the caller
+func (node *FileNode) visitDepthChildFirst(visitor Visitor, evaluator VisitEvaluator) error {
+	for _, name := range node.childNames() {
 		child := node.Children[name]
-		err := child.VisitDepthChildFirst(visitor, evaluator)
+		err := child.visitDepthChildFirst(visitor, evaluator)
 		if err != nil {
 			return err
 		}
@@ -185,16 +297,26 @@ func (node *FileNode) VisitDepthChildFirst(visitor Visitor, evaluator VisitEvalu
 	if node == node.Tree.Root {
 		return nil
 	} else if evaluator != nil && evaluator(node) || evaluator == nil {
-		return visitor(node)
+		if err := visitor(node); err != SkipSubtree {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// VisitDepthParentFirst iterates a tree depth-first (starting at this FileNode), evaluating the shallowest depths first (visit while sinking down)
+// VisitDepthParentFirst iterates a tree depth-first (starting at this FileNode), evaluating the shallowest depths first (visit while sinking down).
+// A visitor error ends the traversal and is returned, except for SkipSubtree, which skips the children of the visited
+// node, and StopWalk, which ends the traversal without an error.
 func (node *FileNode) VisitDepthParentFirst(visitor Visitor, evaluator VisitEvaluator) error {
-	var err error
+	if err := node.visitDepthParentFirst(visitor, evaluator); err != StopWalk {
+		return err
+	}
+	return nil
+}
 
+// visitDepthParentFirst is VisitDepthParentFirst, passing StopWalk up to the caller
+func (node *FileNode) visitDepthParentFirst(visitor Visitor, evaluator VisitEvaluator) error {
 	doVisit := evaluator != nil && evaluator(node) || evaluator == nil
 
 	if !doVisit {
@@ -203,25 +325,22 @@ func (node *FileNode) VisitDepthParentFirst(visitor Visitor, evaluator VisitEval
 
 	// never visit the root node
 	if node != node.Tree.Root {
-		err = visitor(node)
-		if err != nil {
+		err := visitor(node)
+		if err == SkipSubtree {
+			return nil
+		} else if err != nil {
 			return err
 		}
 	}
 
-	var keys []string
-	for key := range node.Children {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-	for _, name := range keys {
+	for _, name := range node.childNames() {
 		child := node.Children[name]
-		err = child.VisitDepthParentFirst(visitor, evaluator)
+		err := child.visitDepthParentFirst(visitor, evaluator)
 		if err != nil {
 			return err
 		}
 	}
-	return err
+	return nil
 }
 
 // IsWhiteout returns an indication if this file may be a overlay-whiteout file.
@@ -229,6 +348,53 @@ func (node *FileNode) IsWhiteout() bool {
 	return strings.HasPrefix(node.Name, whiteoutPrefix)
 }
 
+// isImplicitDir indicates if this node is a directory that only exists as the parent of other paths, without a tar
+// entry of its own (see implicitDirInfo).
+func (node *FileNode) isImplicitDir() bool {
+	return node.Data.FileInfo.TypeFlag == 0 && node.Data.FileInfo.IsDir()
+}
+
+// replacesDir indicates if this (upper) node is a file of its own, which hides any directory of the same path below.
+func (node *FileNode) replacesDir() bool {
+	info := node.Data.FileInfo
+	return node.IsLeaf() && info.TypeFlag != 0 && info.TypeFlag != tar.TypeDir && !info.IsDir()
+}
+
+// childrenFold returns the children whose name matches the given one regardless of case. The children are indexed by
+// their lower case name on first use, until the children change.
+func (node *FileNode) childrenFold(name string) []*FileNode {
+	if node.folded == nil {
+		node.folded = make(map[string][]*FileNode, len(node.Children))
+		for _, childName := range node.sortedChildNames() {
+			key := strings.ToLower(childName)
+			node.folded[key] = append(node.folded[key], node.Children[childName])
+		}
+	}
+	return node.folded[strings.ToLower(name)]
+}
+
+// SetAnnotation attaches a value to this node under the given key, for tools that want to keep their own data along
+// with the tree. Annotations are kept by copies of the tree, but play no part in comparisons.
+func (node *FileNode) SetAnnotation(key, value string) {
+	data := node.writeData()
+	if data.Annotations == nil {
+		data.Annotations = make(map[string]string)
+	}
+	data.Annotations[key] = value
+}
+
+// GetAnnotation returns the value attached to this node under the given key, and whether there is one.
+func (node *FileNode) GetAnnotation(key string) (string, bool) {
+	value, ok := node.Data.Annotations[key]
+	return value, ok
+}
+
+// IsOpaque indicates if this directory is marked as opaque (by a '.wh..wh..opq' entry), hiding everything that lower
+// layers hold in the same directory.
+func (node *FileNode) IsOpaque() bool {
+	return node.opaque
+}
+
 // IsLeaf returns true is the current node has no child nodes.
 func (node *FileNode) IsLeaf() bool {
 	return len(node.Children) == 0
@@ -267,7 +433,7 @@ func (node *FileNode) deriveDiffType(diffType DiffType) error {
 	myDiffType := diffType
 
 	for _, v := range node.Children {
-		myDiffType = myDiffType.merge(v.Data.DiffType)
+		myDiffType = myDiffType.Merge(v.Data.DiffType)
 
 	}
 
@@ -283,7 +449,14 @@ func (node *FileNode) AssignDiffType(diffType DiffType) error {
 		return nil
 	}
 
-	node.Data.DiffType = diffType
+	data := node.writeData()
+	data.DiffType = diffType
+	if diffType != Moved {
+		data.MovedFrom, data.MovedTo = "", ""
+	}
+	if node.Tree != nil {
+		node.Tree.diffStats = nil
+	}
 
 	// if we've removed this node, then all children have been removed as well
 	if diffType == Removed {
@@ -297,6 +470,21 @@ func (node *FileNode) AssignDiffType(diffType DiffType) error {
 	return nil
 }
 
+// markRemovedUnless marks every descendant of this node as Removed, unless the given (upper) node holds the same path.
+func (node *FileNode) markRemovedUnless(upper *FileNode) error {
+	for name, child := range node.Children {
+		upperChild, ok := upper.Children[name]
+		if !ok {
+			if err := child.AssignDiffType(Removed); err != nil {
+				return err
+			}
+		} else if err := child.markRemovedUnless(upperChild); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // compare the current node against the given node, returning a definitive DiffType.
 func (node *FileNode) compare(other *FileNode) DiffType {
 	if node == nil && other == nil {
@@ -317,7 +505,32 @@ func (node *FileNode) compare(other *FileNode) DiffType {
 	if node.Name != other.Name {
 		panic("comparing mismatched nodes")
 	}
+	if (node.isImplicitDir() || other.isImplicitDir()) && node.Data.FileInfo.IsDir() && other.Data.FileInfo.IsDir() {
+		// an implicit directory has no metadata to compare, only its children tell what changed
+		return Unchanged
+	}
 	// TODO: fails on nil
 
-	return node.Data.FileInfo.Compare(other.Data.FileInfo)
+	diffType := currentComparator.Compare(node.Data.FileInfo, other.Data.FileInfo)
+	if diffType == Unchanged && node.Data.FileInfo.TypeFlag == tar.TypeLink {
+		// hardlinks to the same path still change when the contents of that path change
+		lowerTarget, upperTarget := node.linkTarget(), other.linkTarget()
+		if lowerTarget != nil && upperTarget != nil {
+			return currentComparator.Compare(lowerTarget.Data.FileInfo, upperTarget.Data.FileInfo)
+		}
+	}
+	return diffType
+}
+
+// linkTarget resolves the node a hardlink refers to within the same tree, returning nil when this node is not a
+// hardlink or the target cannot be found.
+func (node *FileNode) linkTarget() *FileNode {
+	if node.Data.FileInfo.TypeFlag != tar.TypeLink || node.Tree == nil {
+		return nil
+	}
+	target, err := node.Tree.GetNode(node.Data.FileInfo.Linkname)
+	if err != nil || target == node || target.Data.FileInfo.TypeFlag == tar.TypeLink {
+		return nil
+	}
+	return target
 }
@@ -2,6 +2,8 @@ package filetree
 
 import (
 	"archive/tar"
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -81,6 +83,46 @@ func TestRemoveChild(t *testing.T) {
 
 }
 
+func TestRemoveAndPrune(t *testing.T) {
+	tree := NewFileTree()
+	tree.AddPath("/usr/share/doc/pkg/README", FileInfo{Path: "/usr/share/doc/pkg/README", TypeFlag: '0'})
+	tree.AddPath("/usr/share/man/man1/ls.1", FileInfo{Path: "/usr/share/man/man1/ls.1", TypeFlag: '0'})
+	tree.AddPath("/var/log", FileInfo{Path: "/var/log", TypeFlag: '5'})
+	tree.AddPath("/var/log/app/out.log", FileInfo{Path: "/var/log/app/out.log", TypeFlag: '0'})
+
+	if err := tree.RemovePathAndPrune("/usr/share/doc/pkg/README"); err != nil {
+		t.Fatalf("Expected no error removing the file, got: %v", err)
+	}
+	// the emptied directories are gone, up to the directory that still holds other files
+	if _, err := tree.GetNode("/usr/share/doc"); err == nil {
+		t.Errorf("Expected '/usr/share/doc' to be pruned")
+	}
+	if _, err := tree.GetNode("/usr/share/man/man1/ls.1"); err != nil {
+		t.Errorf("Expected '/usr/share/man/man1/ls.1' to be kept")
+	}
+
+	// directories shipped as entries of their own are kept, even when empty
+	tree.RemovePathAndPrune("/var/log/app/out.log")
+	if _, err := tree.GetNode("/var/log/app"); err == nil {
+		t.Errorf("Expected '/var/log/app' to be pruned")
+	}
+	if _, err := tree.GetNode("/var/log"); err != nil {
+		t.Errorf("Expected the explicit '/var/log' directory to be kept")
+	}
+
+	// an already empty directory is not pruned along with a sibling
+	tree.AddPath("/opt/empty", FileInfo{Path: "/opt/empty", TypeFlag: '5'})
+	tree.AddPath("/opt/file", FileInfo{Path: "/opt/file", TypeFlag: '0'})
+	tree.RemovePathAndPrune("/opt/file")
+	if _, err := tree.GetNode("/opt/empty"); err != nil {
+		t.Errorf("Expected '/opt/empty' to be kept")
+	}
+
+	if expected, actual := 9, tree.Size; expected != actual {
+		t.Errorf("Expected a tree size of %d got %d.", expected, actual)
+	}
+}
+
 func TestPath(t *testing.T) {
 	expected := "/etc/nginx/nginx.conf"
 	tree := NewFileTree()
@@ -106,8 +148,8 @@ func TestIsWhiteout(t *testing.T) {
 		t.Errorf("Expected path '%s' to be a whiteout file", p2.Name)
 	}
 
-	if p3 != nil {
-		t.Errorf("Expected to not be able to add path '%s'", p2.Name)
+	if p3 == nil || p3.Path() != "/etc/nginx/public3" || !p3.IsOpaque() {
+		t.Errorf("Expected the opaque whiteout to mark '/etc/nginx/public3' as opaque, got: %v", p3)
 	}
 }
 
@@ -152,13 +194,63 @@ func TestDiffTypeFromRemovedChildren(t *testing.T) {
 
 func TestDirSize(t *testing.T) {
 	tree1 := NewFileTree()
-	tree1.AddPath("/etc/nginx/public1", FileInfo{TarHeader: tar.Header{Size: 100}})
-	tree1.AddPath("/etc/nginx/thing1", FileInfo{TarHeader: tar.Header{Size: 200}})
-	tree1.AddPath("/etc/nginx/public3/thing2", FileInfo{TarHeader: tar.Header{Size: 300}})
+	tree1.AddPath("/etc/nginx/public1", FileInfo{LogicalBytes: 100})
+	tree1.AddPath("/etc/nginx/thing1", FileInfo{LogicalBytes: 200})
+	tree1.AddPath("/etc/nginx/public3/thing2", FileInfo{LogicalBytes: 300})
 
 	node, _ := tree1.GetNode("/etc/nginx")
-	expected, actual := "----------        0:0      600 B ", node.MetadataString()
+	// the directory has no entry of its own, it shows the defaults of an implicit directory
+	expected, actual := "drwxr-xr-x        0:0      600 B ", node.MetadataString()
+	if expected != actual {
+		t.Errorf("Expected metadata '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestUnreadableNode(t *testing.T) {
+	tree := NewFileTree()
+	tree.AddPath("/var/lib/data.db", FileInfo{Path: "var/lib/data.db", TypeFlag: tar.TypeReg, Mode: 0644, LogicalBytes: 4096, StoredBytes: 512, Unreadable: true})
+
+	node, _ := tree.GetNode("/var/lib/data.db")
+	if name := node.String(); name != "data.db "+unreadableMarker {
+		t.Errorf("expected the name to carry a warning marker, got '%s'", name)
+	}
+	// only part of the contents was read, the size is unknown
+	expected, actual := "-rw-r--r--        0:0          ? ", node.MetadataString()
 	if expected != actual {
 		t.Errorf("Expected metadata '%s' got '%s'", expected, actual)
 	}
 }
+
+func TestAnnotations(t *testing.T) {
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	node, _ := lowerTree.AddPath("/usr/lib/libssl.so", FileInfo{Path: "/usr/lib/libssl.so", TypeFlag: '0', hash: 123})
+	upperTree.AddPath("/usr/lib/libssl.so", FileInfo{Path: "/usr/lib/libssl.so", TypeFlag: '0', hash: 123})
+
+	if _, ok := node.GetAnnotation("package"); ok || node.Data.Annotations != nil {
+		t.Errorf("Expected no annotations to be allocated up front")
+	}
+	node.SetAnnotation("package", "openssl")
+
+	// copies keep (but don't share) the annotations
+	copied, _ := lowerTree.Copy().GetNode("/usr/lib/libssl.so")
+	if value, ok := copied.GetAnnotation("package"); !ok || value != "openssl" {
+		t.Errorf("Expected the copy to keep the annotation, got '%s'", value)
+	}
+	copied.SetAnnotation("package", "libssl")
+	if value, _ := node.GetAnnotation("package"); value != "openssl" {
+		t.Errorf("Expected the original annotation to be unaffected, got '%s'", value)
+	}
+
+	// annotations don't make a difference when comparing
+	lowerTree.Compare(upperTree)
+	if err := AssertDiffType(node, Unchanged); err != nil {
+		t.Error(err)
+	}
+
+	var buf bytes.Buffer
+	lowerTree.ExportJSON(&buf)
+	if !strings.Contains(buf.String(), `"annotations": {`) || !strings.Contains(buf.String(), `"package": "openssl"`) {
+		t.Errorf("Expected the annotations in the JSON export, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,153 @@
+package filetree
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+)
+
+// PermissionChange is a path whose permission bits (see unixMode) or owner a layer changes, regardless of whether
+// its contents change as well. Paths a layer adds that are setuid, setgid or world-writable from the start are
+// reported too (as New), since they matter just as much to a security review. The Became* flags are set when the
+// layer makes the path setuid, setgid or world-writable (sticky directories like /tmp are not world-writable in this
+// sense, and neither are symlinks, whose mode is meaningless).
+type PermissionChange struct {
+	Path                string `json:"path"`
+	Layer               int    `json:"layer"`
+	New                 bool   `json:"new"`
+	ModeBefore          string `json:"modeBefore,omitempty"`
+	ModeAfter           string `json:"modeAfter"`
+	UidBefore           int    `json:"uidBefore"`
+	GidBefore           int    `json:"gidBefore"`
+	UidAfter            int    `json:"uidAfter"`
+	GidAfter            int    `json:"gidAfter"`
+	BecameSetuid        bool   `json:"becameSetuid"`
+	BecameSetgid        bool   `json:"becameSetgid"`
+	BecameWorldWritable bool   `json:"becameWorldWritable"`
+}
+
+// PermissionChangeSlice is a set of PermissionChange, ordered by layer then path
+type PermissionChangeSlice []PermissionChange
+
+// FindPermissionChanges walks the given trees (layers) in order, comparing the mode, uid and gid of every entry of a
+// layer with those of the same path in the layers below it. Entries replacing a path with one of another type (e.g.
+// a file with a symlink) are not compared. The first layer is the base: its entries are never reported.
+func FindPermissionChanges(trees []*FileTree) PermissionChangeSlice {
+	changes := make(PermissionChangeSlice, 0)
+	if len(trees) == 0 {
+		return changes
+	}
+
+	stacked := NewFileTree()
+	stacked.HashAlgorithm = trees[0].HashAlgorithm
+	for idx, tree := range trees {
+		if idx > 0 {
+			tree.VisitDepthParentFirst(func(node *FileNode) error {
+				if node.IsWhiteout() || node.isImplicitDir() {
+					return nil
+				}
+				lower, _ := stacked.GetNode(node.Path())
+				if change, ok := permissionChange(lower, node, idx); ok {
+					changes = append(changes, change)
+				}
+				return nil
+			}, nil)
+		}
+		if err := stacked.Stack(tree); err != nil {
+			break
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Layer != changes[j].Layer {
+			return changes[i].Layer < changes[j].Layer
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// permissionChange compares an entry of the given layer with the same path below it (nil when the path is new)
+func permissionChange(lower, upper *FileNode, layer int) (PermissionChange, bool) {
+	after := upper.Data.FileInfo
+	change := PermissionChange{
+		Path:      upper.Path(),
+		Layer:     layer,
+		ModeAfter: fmt.Sprintf("%04o", unixMode(after.Mode)),
+		UidAfter:  after.Uid,
+		GidAfter:  after.Gid,
+	}
+
+	var beforeBits uint32
+	if lower == nil || lower.isImplicitDir() {
+		change.New = true
+	} else {
+		before := lower.Data.FileInfo
+		if inventoryType(&before) != inventoryType(&after) {
+			return change, false
+		}
+		beforeBits = unixMode(before.Mode)
+		change.ModeBefore = fmt.Sprintf("%04o", beforeBits)
+		change.UidBefore, change.GidBefore = before.Uid, before.Gid
+	}
+
+	afterBits := unixMode(after.Mode)
+	if after.TypeFlag != tar.TypeSymlink {
+		change.BecameSetuid = afterBits&04000 != 0 && beforeBits&04000 == 0
+		change.BecameSetgid = afterBits&02000 != 0 && beforeBits&02000 == 0
+		change.BecameWorldWritable = worldWritable(&after, afterBits) && (change.New || !worldWritable(&lower.Data.FileInfo, beforeBits))
+	}
+
+	if change.New {
+		return change, change.Risky()
+	}
+	changed := change.ModeBefore != change.ModeAfter || change.UidBefore != change.UidAfter || change.GidBefore != change.GidAfter
+	return change, changed
+}
+
+// worldWritable reports whether anyone may write to the path (ignoring sticky directories, where they may only
+// remove their own files)
+func worldWritable(info *FileInfo, bits uint32) bool {
+	return bits&0002 != 0 && !(info.IsDir() && bits&01000 != 0)
+}
+
+// Risky reports whether the change makes the path setuid, setgid or world-writable
+func (change PermissionChange) Risky() bool {
+	return change.BecameSetuid || change.BecameSetgid || change.BecameWorldWritable
+}
+
+// ModeTransition describes the change of permission bits (e.g. "0644→4755"), empty when they do not change
+func (change PermissionChange) ModeTransition() string {
+	if change.New {
+		return "new " + change.ModeAfter
+	}
+	if change.ModeBefore == change.ModeAfter {
+		return ""
+	}
+	return change.ModeBefore + "→" + change.ModeAfter
+}
+
+// OwnerTransition describes the change of owner as uid:gid (e.g. "0:0→1000:1000"), empty when it does not change
+func (change PermissionChange) OwnerTransition() string {
+	after := fmt.Sprintf("%d:%d", change.UidAfter, change.GidAfter)
+	if change.New {
+		return after
+	}
+	before := fmt.Sprintf("%d:%d", change.UidBefore, change.GidBefore)
+	if before == after {
+		return ""
+	}
+	return before + "→" + after
+}
+
+// Risky returns the changes making a path setuid, setgid or world-writable in a layer after the given one (e.g. 0 for
+// everything after the base layer)
+func (changes PermissionChangeSlice) Risky(afterLayer int) PermissionChangeSlice {
+	risky := make(PermissionChangeSlice, 0)
+	for _, change := range changes {
+		if change.Layer > afterLayer && change.Risky() {
+			risky = append(risky, change)
+		}
+	}
+	return risky
+}
@@ -0,0 +1,67 @@
+package filetree
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestFindPermissionChanges(t *testing.T) {
+	base := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 01777},
+		{Name: "usr/bin/ping", Typeflag: tar.TypeReg, Mode: 04755},
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "usr/bin/link", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/app.conf": "conf", "usr/bin/tool": "tool", "usr/bin/link": "file", "usr/bin/ping": "ping"})
+	upper := treeFromTar(t, []*tar.Header{
+		// world-writable config
+		{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0666},
+		// new owner only
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, Uid: 1000, Gid: 1000},
+		// became setuid, with new contents
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 04755},
+		// replaced by a symlink: not compared
+		{Name: "usr/bin/link", Typeflag: tar.TypeSymlink, Linkname: "tool", Mode: 0777},
+		// unchanged
+		{Name: "usr/bin/ping", Typeflag: tar.TypeReg, Mode: 04755},
+		// new setgid file, and a new ordinary one
+		{Name: "opt/run", Typeflag: tar.TypeReg, Mode: 02755},
+		{Name: "opt/plain", Typeflag: tar.TypeReg, Mode: 0644},
+		// sticky directories are not world-writable
+		{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 01777},
+	}, map[string]string{"usr/bin/tool": "new tool", "usr/bin/ping": "ping"})
+
+	changes := FindPermissionChanges([]*FileTree{base, upper})
+	expected := []struct {
+		path, mode, owner string
+		risky             bool
+	}{
+		{"/etc", "", "0:0→1000:1000", false},
+		{"/etc/app.conf", "0644→0666", "", true},
+		{"/opt/run", "new 2755", "0:0", true},
+		{"/usr/bin/tool", "0755→4755", "", true},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for idx, change := range changes {
+		if change.Path != expected[idx].path || change.ModeTransition() != expected[idx].mode ||
+			change.OwnerTransition() != expected[idx].owner || change.Risky() != expected[idx].risky || change.Layer != 1 {
+			t.Errorf("unexpected change %d: %+v", idx, change)
+		}
+	}
+	if !changes[3].BecameSetuid || !changes[2].BecameSetgid || !changes[1].BecameWorldWritable {
+		t.Errorf("unexpected risk flags: %+v", changes)
+	}
+
+	if risky := changes.Risky(0); len(risky) != 3 {
+		t.Errorf("expected 3 risky changes after the base layer, got %+v", risky)
+	}
+	if risky := changes.Risky(1); len(risky) != 0 {
+		t.Errorf("expected no risky changes after layer 1, got %+v", risky)
+	}
+	if changes := FindPermissionChanges(nil); len(changes) != 0 {
+		t.Errorf("expected no changes without layers, got %+v", changes)
+	}
+}
@@ -0,0 +1,29 @@
+package filetree
+
+// SetLayer records the given layer index as the layer that added and last modified every path of the tree. It is
+// meant for the tree of a single layer, stacking such trees (see Stack) keeps track of the layers of every path.
+func (tree *FileTree) SetLayer(index int) {
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		data := node.writeData()
+		data.AddedLayer, data.ModifiedLayer = index, index
+		return nil
+	}, nil)
+}
+
+// inheritLayers records the layer of the upper node (see SetLayer) as the last one modifying this node, and as the
+// one adding it if the node is new. Parents created along the way (which do not know their layer yet) are added by
+// the same layer.
+func (node *FileNode) inheritLayers(upper *FileNode, isNew bool) {
+	if upper.Data.ModifiedLayer < 0 {
+		return
+	}
+	data := node.writeData()
+	data.ModifiedLayer = upper.Data.ModifiedLayer
+	if isNew || data.AddedLayer < 0 {
+		data.AddedLayer = upper.Data.AddedLayer
+	}
+	for parent := node.Parent; parent != nil && parent != node.Tree.Root && parent.Data.AddedLayer < 0; parent = parent.Parent {
+		data := parent.writeData()
+		data.AddedLayer, data.ModifiedLayer = upper.Data.AddedLayer, upper.Data.AddedLayer
+	}
+}
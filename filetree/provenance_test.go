@@ -0,0 +1,113 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func provenanceLayers(t *testing.T) []*FileTree {
+	layers := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		}, map[string]string{"etc/app.conf": "v1", "usr/bin/tool": "tool"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "opt/app/run", Typeflag: tar.TypeReg, Mode: 0755},
+			{Name: "usr/bin/.wh.tool", Typeflag: tar.TypeReg},
+		}, map[string]string{"etc/app.conf": "v2", "opt/app/run": "run"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		}, map[string]string{"usr/bin/tool": "new tool"}),
+	}
+	for idx, layer := range layers {
+		layer.SetLayer(idx)
+	}
+	return layers
+}
+
+func assertLayers(t *testing.T, tree *FileTree, expected map[string][2]int) {
+	t.Helper()
+	for path, layers := range expected {
+		node, err := tree.GetNode(path)
+		if err != nil {
+			t.Fatalf("missing %s: %v", path, err)
+		}
+		if node.Data.AddedLayer != layers[0] || node.Data.ModifiedLayer != layers[1] {
+			t.Errorf("%s: expected added/modified layers %v, got %d/%d", path, layers, node.Data.AddedLayer, node.Data.ModifiedLayer)
+		}
+	}
+}
+
+func TestStackRecordsLayers(t *testing.T) {
+	layers := provenanceLayers(t)
+	expected := map[string][2]int{
+		"/etc":          {0, 0},
+		"/etc/app.conf": {0, 1},
+		"/opt":          {1, 1},
+		"/opt/app":      {1, 1},
+		"/opt/app/run":  {1, 1},
+		"/usr/bin":      {0, 0},
+		"/usr/bin/tool": {2, 2},
+	}
+
+	stacked := StackRange(layers, 0, 2)
+	assertLayers(t, stacked, expected)
+	assertLayers(t, stacked.Copy(), expected)
+	assertLayers(t, NewStackCache(layers).StackRange(0, 2), expected)
+
+	squashed, err := Squash(layers)
+	if err != nil {
+		t.Fatalf("could not squash: %v", err)
+	}
+	assertLayers(t, squashed, expected)
+
+	assertLayers(t, StackRange(layers, 0, 1), map[string][2]int{
+		"/etc/app.conf": {0, 1},
+		"/usr/bin":      {0, 0},
+	})
+}
+
+func TestCompareRecordsLayers(t *testing.T) {
+	layers := provenanceLayers(t)
+	lower := StackRange(layers, 0, 0)
+	if err := lower.Compare(layers[1]); err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+	assertLayers(t, lower, map[string][2]int{
+		"/etc/app.conf": {0, 1},
+		"/opt/app/run":  {1, 1},
+	})
+
+	// a layer repeating a path as is does not modify it
+	repeated := treeFromTar(t, []*tar.Header{
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"usr/bin/tool": "tool"})
+	repeated.SetLayer(3)
+	lower = StackRange(layers, 0, 0)
+	if err := lower.Compare(repeated); err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+	assertLayers(t, lower, map[string][2]int{
+		"/usr/bin/tool": {0, 0},
+	})
+}
+
+func TestExportLayers(t *testing.T) {
+	stacked := StackRange(provenanceLayers(t), 0, 2)
+	encoded, err := stacked.MarshalJSON()
+	if err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"addedLayer":0,"modifiedLayer":1`) {
+		t.Errorf("expected the layers of /etc/app.conf in:\n%s", encoded)
+	}
+
+	var buf bytes.Buffer
+	if err := NewFileTree().ExportJSON(&buf); err != nil || strings.Contains(buf.String(), "Layer") {
+		t.Errorf("expected no layers for a tree without them: %v\n%s", err, buf.String())
+	}
+}
@@ -0,0 +1,97 @@
+package filetree
+
+import (
+	"sort"
+)
+
+// MinRemovableSize is the size (in bytes) under which matches of the removable patterns are not reported, as they
+// are not worth the noise
+const MinRemovableSize = 1024 * 1024
+
+// DefaultRemovablePatterns are the (MatchGlob) patterns of the package manager caches and temporary directories that
+// images commonly ship by accident. Users add their own with the efficiency.removable-paths setting.
+var DefaultRemovablePatterns = []string{
+	"/tmp/*",
+	"/var/tmp/*",
+	"/var/cache/apt",
+	"/var/lib/apt/lists",
+	"/var/cache/yum",
+	"/var/cache/dnf",
+	"/var/cache/apk",
+	"/var/cache/zypp",
+	"**/.cache/pip",
+	"**/.cache/go-build",
+	"**/.cache/yarn",
+	"**/.npm",
+	"**/.m2/repository",
+	"**/.gradle/caches",
+	"**/.cargo/registry",
+	"**/__pycache__",
+}
+
+// RemovablePatterns returns the default removable patterns along with the given ones.
+func RemovablePatterns(patterns []string) []string {
+	return append(append([]string{}, DefaultRemovablePatterns...), patterns...)
+}
+
+// RemovablePath is a path of a tree matching a removable pattern (see FindRemovable), with the size and number of
+// the files at and beneath it.
+type RemovablePath struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Size    int64  `json:"size"`
+	Files   int    `json:"files"`
+}
+
+// RemovableSlice is a set of RemovablePath, ordered by size (largest first)
+type RemovableSlice []RemovablePath
+
+// FindRemovable reports the paths of the given tree (typically the final, stacked tree of an image) matching any of
+// the given patterns (see MatchGlob), which are likely removable: caches and temporary files. A path beneath a
+// matching path is already counted by it, and is not reported on its own. Matches smaller than the given size are
+// left out. Ties are ordered by path.
+func FindRemovable(tree *FileTree, patterns []string, minSize int64) (RemovableSlice, error) {
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make(RemovableSlice, 0)
+	err := tree.VisitDepthParentFirst(func(node *FileNode) error {
+		if node.IsWhiteout() {
+			return nil
+		}
+		for _, pattern := range patterns {
+			matched, _ := MatchGlob(pattern, node.Path())
+			if !matched {
+				continue
+			}
+			if size := node.AggregateSize(); size >= minSize {
+				paths = append(paths, RemovablePath{Path: node.Path(), Pattern: pattern, Size: size, Files: node.FileCount()})
+			}
+			return SkipSubtree
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Size != paths[j].Size {
+			return paths[i].Size > paths[j].Size
+		}
+		return paths[i].Path < paths[j].Path
+	})
+	return paths, nil
+}
+
+// TotalSize returns the bytes of all the paths
+func (paths RemovableSlice) TotalSize() int64 {
+	var total int64
+	for _, removable := range paths {
+		total += removable.Size
+	}
+	return total
+}
@@ -0,0 +1,52 @@
+package filetree
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindRemovable(t *testing.T) {
+	tree := NewFileTree()
+	files := map[string]int64{
+		"/var/lib/apt/lists/deb.debian.org_dists_main": 3 * MinRemovableSize,
+		"/var/lib/apt/lists/lock":                      0,
+		"/var/cache/apt/archives/curl.deb":             MinRemovableSize,
+		"/var/cache/apt/pkgcache.bin":                  MinRemovableSize,
+		"/root/.cache/pip/http/wheel":                  4 * MinRemovableSize,
+		"/tmp/build.log":                               10,
+		"/tmp/artifact.tar":                            2 * MinRemovableSize,
+		"/usr/lib/python3/__pycache__/os.pyc":          100,
+		"/usr/bin/curl":                                5 * MinRemovableSize,
+	}
+	for path, size := range files {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', LogicalBytes: size})
+	}
+
+	removable, err := FindRemovable(tree, DefaultRemovablePatterns, MinRemovableSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := RemovableSlice{
+		{Path: "/root/.cache/pip", Pattern: "**/.cache/pip", Size: 4 * MinRemovableSize, Files: 1},
+		{Path: "/var/lib/apt/lists", Pattern: "/var/lib/apt/lists", Size: 3 * MinRemovableSize, Files: 2},
+		{Path: "/tmp/artifact.tar", Pattern: "/tmp/*", Size: 2 * MinRemovableSize, Files: 1},
+		{Path: "/var/cache/apt", Pattern: "/var/cache/apt", Size: 2 * MinRemovableSize, Files: 2},
+	}
+	if !reflect.DeepEqual(removable, expected) {
+		t.Errorf("unexpected removable paths:\n%+v\nexpected:\n%+v", removable, expected)
+	}
+	if total := removable.TotalSize(); total != 11*MinRemovableSize {
+		t.Errorf("expected a total of %d bytes, got %d", 11*MinRemovableSize, total)
+	}
+
+	// user patterns are matched along with the defaults
+	removable, _ = FindRemovable(tree, RemovablePatterns([]string{"/usr/bin/curl"}), MinRemovableSize)
+	if len(removable) != 5 || removable[0].Path != "/usr/bin/curl" {
+		t.Errorf("expected the user pattern to match, got %+v", removable)
+	}
+
+	if _, err := FindRemovable(tree, []string{"/var/[cache"}, MinRemovableSize); err == nil || !strings.Contains(err.Error(), "syntax") {
+		t.Errorf("expected an invalid pattern error, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+)
+
+const (
+	// MinMetadataRewriteSize is the number of duplicated bytes under which a layer is never a metadata-only rewrite
+	MinMetadataRewriteSize = 1024 * 1024
+	// MetadataRewriteFraction is the share of the file bytes of a layer that must be duplicated for the layer to be a
+	// metadata-only rewrite
+	MetadataRewriteFraction = 0.5
+)
+
+// MetadataRewrite is a layer storing mostly files whose contents are identical to the same path below, typically
+// a "RUN chown -R" or "RUN chmod -R" step only changing the metadata of the files (and so storing a copy of every one
+// of them). Using "COPY --chown" or changing the files in the step creating them avoids the copies.
+type MetadataRewrite struct {
+	Layer      int   `json:"layer"`
+	Files      int   `json:"files"`
+	Bytes      int64 `json:"bytes"`
+	LayerBytes int64 `json:"layerBytes"`
+}
+
+// MetadataRewriteSlice is a set of MetadataRewrite, ordered by layer
+type MetadataRewriteSlice []MetadataRewrite
+
+// FindMetadataRewrites walks the given trees (layers) in order, counting the regular files of every layer whose
+// content hash is identical to that of the same path in the layers below, and returns the layers where these
+// duplicated bytes are at least MinMetadataRewriteSize and MetadataRewriteFraction of all the file bytes of the
+// layer. Files whose contents were not hashed are never counted.
+func FindMetadataRewrites(trees []*FileTree) MetadataRewriteSlice {
+	rewrites := make(MetadataRewriteSlice, 0)
+	if len(trees) == 0 {
+		return rewrites
+	}
+
+	stacked := NewFileTree()
+	stacked.HashAlgorithm = trees[0].HashAlgorithm
+	for idx, tree := range trees {
+		rewrite := MetadataRewrite{Layer: idx, LayerBytes: tree.EntryStats().Bytes}
+		tree.VisitDepthParentFirst(func(node *FileNode) error {
+			if !isRegular(&node.Data.FileInfo) {
+				return nil
+			}
+			lower, _ := stacked.GetNode(node.Path())
+			if lower != nil && isRegular(&lower.Data.FileInfo) && sameHashedContents(&lower.Data.FileInfo, &node.Data.FileInfo) {
+				rewrite.Files++
+				rewrite.Bytes += node.Size()
+			}
+			return nil
+		}, nil)
+		if rewrite.Bytes >= MinMetadataRewriteSize && rewrite.Fraction() >= MetadataRewriteFraction {
+			rewrites = append(rewrites, rewrite)
+		}
+		if err := stacked.Stack(tree); err != nil {
+			break
+		}
+	}
+	return rewrites
+}
+
+// isRegular indicates if the entry is a regular file (the typeflag of implicit directories is that of old regular files)
+func isRegular(info *FileInfo) bool {
+	return !info.IsDir() && (info.TypeFlag == tar.TypeReg || info.TypeFlag == tar.TypeRegA)
+}
+
+// sameHashedContents indicates if both files were hashed and hold the same contents (whatever their metadata)
+func sameHashedContents(a, b *FileInfo) bool {
+	if a.hashSkipped || b.hashSkipped || a.Unreadable || b.Unreadable {
+		return false
+	}
+	return a.LogicalBytes == b.LogicalBytes && a.hash == b.hash && bytes.Equal(a.digest, b.digest)
+}
+
+// Fraction returns the share of the file bytes of the layer that are duplicated
+func (rewrite MetadataRewrite) Fraction() float64 {
+	if rewrite.LayerBytes == 0 {
+		return 0
+	}
+	return float64(rewrite.Bytes) / float64(rewrite.LayerBytes)
+}
+
+// ForLayer returns the rewrite of the given layer, if it is one
+func (rewrites MetadataRewriteSlice) ForLayer(layer int) (MetadataRewrite, bool) {
+	for _, rewrite := range rewrites {
+		if rewrite.Layer == layer {
+			return rewrite, true
+		}
+	}
+	return MetadataRewrite{}, false
+}
+
+// TotalSize returns the bytes duplicated by all the rewrites
+func (rewrites MetadataRewriteSlice) TotalSize() int64 {
+	var total int64
+	for _, rewrite := range rewrites {
+		total += rewrite.Bytes
+	}
+	return total
+}
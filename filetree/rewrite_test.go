@@ -0,0 +1,42 @@
+package filetree
+
+import (
+	"archive/tar"
+	"strings"
+	"testing"
+)
+
+func TestFindMetadataRewrites(t *testing.T) {
+	large := strings.Repeat("x", 2*MinMetadataRewriteSize)
+	base := treeFromTar(t, []*tar.Header{
+		{Name: "app/data", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "app/bin", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"app/data": large, "app/bin": "binary"})
+	chown := treeFromTar(t, []*tar.Header{
+		{Name: "app/data", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Gid: 1000},
+		{Name: "app/bin", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000, Gid: 1000},
+	}, map[string]string{"app/data": large, "app/bin": "binary"})
+	rewrite := treeFromTar(t, []*tar.Header{
+		{Name: "app/data", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"app/data": large + "y"})
+	// the same contents again, but mostly new files
+	mixed := treeFromTar(t, []*tar.Header{
+		{Name: "app/bin", Typeflag: tar.TypeReg, Mode: 0700},
+		{Name: "app/other", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"app/bin": "binary", "app/other": large})
+
+	rewrites := FindMetadataRewrites([]*FileTree{base, chown, rewrite, mixed})
+	if len(rewrites) != 1 {
+		t.Fatalf("expected a single rewrite, got %+v", rewrites)
+	}
+	found, ok := rewrites.ForLayer(1)
+	if !ok || found.Files != 2 || found.Bytes != int64(len(large)+len("binary")) || found.Fraction() != 1 {
+		t.Errorf("unexpected rewrite: %+v", found)
+	}
+	if _, ok := rewrites.ForLayer(3); ok {
+		t.Error("expected no rewrite for a layer of mostly new files")
+	}
+	if rewrites.TotalSize() != found.Bytes {
+		t.Errorf("unexpected total size %d", rewrites.TotalSize())
+	}
+}
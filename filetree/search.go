@@ -0,0 +1,62 @@
+package filetree
+
+import (
+	"regexp"
+)
+
+// SearchOptions narrows the nodes returned by Search
+type SearchOptions struct {
+	// DiffTypes restricts matches to nodes with one of the given DiffTypes (any DiffType when empty)
+	DiffTypes []DiffType
+	// Limit stops the search after this many matches (no limit when 0)
+	Limit int
+	// IgnoreCase matches the expression regardless of case
+	IgnoreCase bool
+}
+
+// Search returns the nodes whose full path matches the given expression (and the given options) in tree order:
+// parents before their children, and siblings sorted by name.
+func (tree *FileTree) Search(re *regexp.Regexp, options SearchOptions) []*FileNode {
+	var matches []*FileNode
+	if options.IgnoreCase {
+		re = regexp.MustCompile("(?i)" + re.String())
+	}
+
+	visitor := func(node *FileNode) error {
+		if !re.MatchString(node.Path()) {
+			return nil
+		}
+		if len(options.DiffTypes) > 0 && !hasDiffType(options.DiffTypes, node.Data.DiffType) {
+			return nil
+		}
+		matches = append(matches, node)
+		if options.Limit > 0 && len(matches) >= options.Limit {
+			return StopWalk
+		}
+		return nil
+	}
+	tree.VisitDepthParentFirst(visitor, nil)
+
+	return matches
+}
+
+// FindAll returns all nodes whose full path matches the given expression, in tree order.
+func (tree *FileTree) FindAll(re *regexp.Regexp) []*FileNode {
+	return tree.Search(re, SearchOptions{})
+}
+
+// FindAllWithDiffType returns all nodes with the given DiffType whose full path matches the given expression, in tree
+// order.
+func (tree *FileTree) FindAllWithDiffType(re *regexp.Regexp, diffType DiffType) []*FileNode {
+	return tree.Search(re, SearchOptions{DiffTypes: []DiffType{diffType}})
+}
+
+// hasDiffType indicates if the given DiffType is in the list
+func hasDiffType(diffTypes []DiffType, diffType DiffType) bool {
+	for _, candidate := range diffTypes {
+		if candidate == diffType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package filetree
+
+import (
+	"regexp"
+	"testing"
+)
+
+func searchPaths(nodes []*FileNode) []string {
+	paths := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		paths = append(paths, node.Path())
+	}
+	return paths
+}
+
+func assertPaths(t *testing.T, name string, expected, actual []string) {
+	if len(expected) != len(actual) {
+		t.Errorf("[%s] Expected %v but got %v", name, expected, actual)
+		return
+	}
+	for idx := range expected {
+		if expected[idx] != actual[idx] {
+			t.Errorf("[%s] Expected %v but got %v", name, expected, actual)
+			return
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/usr/lib/python3/os.pyc", "/etc/hosts", "/usr/bin/python3", "/tmp/cache.pyc", "/etc/hostname"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	tmp, _ := tree.GetNode("/tmp/cache.pyc")
+	tmp.AssignDiffType(Added)
+
+	assertPaths(t, "all", []string{"/tmp/cache.pyc", "/usr/lib/python3/os.pyc"}, searchPaths(tree.FindAll(regexp.MustCompile(`\.pyc$`))))
+	assertPaths(t, "full path", []string{"/etc", "/etc/hostname", "/etc/hosts"}, searchPaths(tree.FindAll(regexp.MustCompile(`^/etc`))))
+	assertPaths(t, "diff type", []string{"/tmp/cache.pyc"}, searchPaths(tree.FindAllWithDiffType(regexp.MustCompile(`\.pyc$`), Added)))
+	assertPaths(t, "no match", []string{}, searchPaths(tree.FindAll(regexp.MustCompile(`\.so$`))))
+
+	limited := tree.Search(regexp.MustCompile(`python`), SearchOptions{Limit: 2})
+	assertPaths(t, "limit", []string{"/usr/bin/python3", "/usr/lib/python3"}, searchPaths(limited))
+
+	multiple := tree.Search(regexp.MustCompile(`^/etc/host`), SearchOptions{DiffTypes: []DiffType{Added, Unchanged}})
+	assertPaths(t, "diff types", []string{"/etc/hostname", "/etc/hosts"}, searchPaths(multiple))
+}
+
+func TestSearchIgnoreCase(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/App/Config.JSON", "/app/other.json", "/etc/hosts"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	re := regexp.MustCompile(`\.json$`)
+
+	assertPaths(t, "case sensitive", []string{"/app/other.json"}, searchPaths(tree.Search(re, SearchOptions{})))
+	assertPaths(t, "ignore case", []string{"/App/Config.JSON", "/app/other.json"}, searchPaths(tree.Search(re, SearchOptions{IgnoreCase: true})))
+}
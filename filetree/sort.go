@@ -0,0 +1,100 @@
+package filetree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortOrder is the order in which the children of a node are visited and rendered.
+type SortOrder int
+
+const (
+	// SortByName orders children by name (ascending)
+	SortByName SortOrder = iota
+	// SortBySize orders children by their aggregate size (descending, see FileNode.AggregateSize), then by name
+	SortBySize
+	// SortByDiffType orders changed children before unchanged ones (see diffTypeRank), then by name
+	SortByDiffType
+)
+
+var sortOrders = []SortOrder{SortByName, SortBySize, SortByDiffType}
+
+// diffTypeRank is the position of each DiffType when sorting by diff status, changes come first
+var diffTypeRank = map[DiffType]int{
+	Added:           0,
+	Changed:         1,
+	Moved:           2,
+	MetadataChanged: 3,
+	Removed:         4,
+	Unchanged:       5,
+}
+
+// String of a SortOrder
+func (order SortOrder) String() string {
+	switch order {
+	case SortByName:
+		return "name"
+	case SortBySize:
+		return "size"
+	case SortByDiffType:
+		return "diff"
+	default:
+		return fmt.Sprintf("%d", int(order))
+	}
+}
+
+// Next returns the sort order following this one (wrapping around), for cycling through the orders at runtime.
+func (order SortOrder) Next() SortOrder {
+	return sortOrders[(int(order)+1)%len(sortOrders)]
+}
+
+// ParseSortOrder returns the SortOrder with the given name (one of: name, size, diff).
+func ParseSortOrder(name string) (SortOrder, error) {
+	for _, order := range sortOrders {
+		if strings.EqualFold(order.String(), strings.TrimSpace(name)) {
+			return order, nil
+		}
+	}
+	return SortByName, fmt.Errorf("unknown sort order '%s' (supported: name, size, diff)", name)
+}
+
+// SetSortOrder changes the order in which the children of every node are visited and rendered.
+func (tree *FileTree) SetSortOrder(order SortOrder) {
+	tree.SortOrder = order
+}
+
+// sortedChildNames returns the names of the children of this node in lexicographic order. The names are sorted on
+// first use and cached until the children change, the returned slice must not be modified.
+func (node *FileNode) sortedChildNames() []string {
+	if node.sorted == nil {
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		node.sorted = names
+	}
+	return node.sorted
+}
+
+// childNames returns the names of the children of this node in the sort order of its tree. Ties are always broken by
+// name, so the order is stable between runs. The returned slice must not be modified.
+func (node *FileNode) childNames() []string {
+	if node.Tree == nil || node.Tree.SortOrder == SortByName {
+		return node.sortedChildNames()
+	}
+
+	names := append([]string(nil), node.sortedChildNames()...)
+	switch node.Tree.SortOrder {
+	case SortBySize:
+		sort.SliceStable(names, func(i, j int) bool {
+			return node.Children[names[i]].AggregateSize() > node.Children[names[j]].AggregateSize()
+		})
+	case SortByDiffType:
+		sort.SliceStable(names, func(i, j int) bool {
+			return diffTypeRank[node.Children[names[i]].Data.DiffType] < diffTypeRank[node.Children[names[j]].Data.DiffType]
+		})
+	}
+	return names
+}
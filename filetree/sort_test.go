@@ -0,0 +1,123 @@
+package filetree
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// visitPaths lists the paths of a tree in visiting order
+func visitPaths(tree *FileTree) []string {
+	paths := []string{}
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		paths = append(paths, node.Path())
+		return nil
+	}, nil)
+	return paths
+}
+
+func TestSortOrder(t *testing.T) {
+	tree := NewFileTree()
+	sizes := map[string]int64{
+		"/a/small":  10,
+		"/a/big":    500,
+		"/b/medium": 300,
+		"/b/other":  300,
+		"/c":        100,
+	}
+	for path, size := range sizes {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', LogicalBytes: size})
+	}
+
+	assertPaths(t, "name", []string{"/a", "/a/big", "/a/small", "/b", "/b/medium", "/b/other", "/c"}, visitPaths(tree))
+
+	// directories sort by the size of their whole subtree, ties by name
+	tree.SetSortOrder(SortBySize)
+	assertPaths(t, "size", []string{"/b", "/b/medium", "/b/other", "/a", "/a/big", "/a/small", "/c"}, visitPaths(tree))
+
+	// the cached sizes follow modifications of the tree
+	tree.AddPath("/c", FileInfo{Path: "/c", TypeFlag: '0', LogicalBytes: 1000})
+	tree.RemovePath("/b/other")
+	assertPaths(t, "size after change", []string{"/c", "/a", "/a/big", "/a/small", "/b", "/b/medium"}, visitPaths(tree))
+
+	// the order survives copies
+	copied := tree.Copy()
+	assertPaths(t, "size copy", visitPaths(tree), visitPaths(copied))
+
+	tree.SetSortOrder(SortByDiffType)
+	node, _ := tree.GetNode("/a/small")
+	node.Data.DiffType = Added
+	node, _ = tree.GetNode("/b")
+	node.Data.DiffType = Changed
+	assertPaths(t, "diff", []string{"/b", "/b/medium", "/a", "/a/small", "/a/big", "/c"}, visitPaths(tree))
+
+	// rendering uses the same order
+	expected := `├── b
+│   └── medium
+├── a
+│   ├── small
+│   └── big
+└── c
+`
+	if actual := tree.String(false); actual != expected {
+		t.Errorf("Expected tree string:\n--->%s<---\nGot:\n--->%s<---", expected, actual)
+	}
+}
+
+func TestParseSortOrder(t *testing.T) {
+	for _, order := range []SortOrder{SortByName, SortBySize, SortByDiffType} {
+		parsed, err := ParseSortOrder(order.String())
+		if err != nil || parsed != order {
+			t.Errorf("Expected to parse '%s' as %v, got %v (%v)", order.String(), order, parsed, err)
+		}
+	}
+	if _, err := ParseSortOrder("mtime"); err == nil {
+		t.Errorf("Expected an error for an unknown sort order")
+	}
+	if SortByDiffType.Next() != SortByName {
+		t.Errorf("Expected the sort orders to wrap around")
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	paths := []string{"/etc/hosts", "/etc/passwd", "/usr/bin/env", "/usr/bin/sh", "/usr/lib/libc.so", "/var", "/a", "/Z", "/.hidden"}
+
+	build := func(seed int64) (string, string) {
+		shuffled := append([]string(nil), paths...)
+		rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		tree := NewFileTree()
+		for _, path := range shuffled {
+			tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', LogicalBytes: int64(len(path))})
+		}
+
+		childFirst := []string{}
+		tree.VisitDepthChildFirst(func(node *FileNode) error {
+			childFirst = append(childFirst, node.Path())
+			return nil
+		}, nil)
+
+		var exported bytes.Buffer
+		if err := tree.ExportJSON(&exported); err != nil {
+			t.Fatalf("could not export tree: %v", err)
+		}
+		traversal := strings.Join(visitPaths(tree), ",") + "\n" + strings.Join(childFirst, ",")
+		return traversal, tree.String(false) + exported.String()
+	}
+
+	expectedTraversal, expectedOutput := build(1)
+	for seed := int64(2); seed < 20; seed++ {
+		traversal, output := build(seed)
+		if traversal != expectedTraversal {
+			t.Fatalf("traversal differs for seed %d:\n%s\nexpected:\n%s", seed, traversal, expectedTraversal)
+		}
+		if output != expectedOutput {
+			t.Fatalf("output differs for seed %d:\n%s\nexpected:\n%s", seed, output, expectedOutput)
+		}
+	}
+	if !strings.HasPrefix(expectedTraversal, "/.hidden,/Z,/a,/etc,/etc/hosts,") {
+		t.Errorf("children are not visited in lexicographic order: %s", expectedTraversal)
+	}
+}
@@ -0,0 +1,62 @@
+package filetree
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// StackCache builds and remembers the results of stacking the same set of trees (see StackRange), one per stop index,
+// so moving back and forth between layers does not repeat the work. A stack is built from the closest cached one below
+// it, stacking only the remaining trees on top of it. Only the stacks starting at the last start index asked for are
+// kept. The given trees must not be modified while the cache is in use.
+type StackCache struct {
+	trees   []*FileTree
+	start   int
+	stacked []*FileTree
+}
+
+// NewStackCache creates an empty StackCache for the given trees.
+func NewStackCache(trees []*FileTree) *StackCache {
+	return &StackCache{
+		trees: trees,
+	}
+}
+
+// StackRange returns the same tree as the StackRange function for the trees of the cache. The returned tree belongs
+// to the caller and may be modified freely: it shares the data of its nodes with the cached stack until they are
+// changed (see FileTree.Copy), so only the nodes the caller changes are duplicated.
+func (cache *StackCache) StackRange(start, stop int) *FileTree {
+	return cache.stackRange(start, stop).Copy()
+}
+
+// stackRange returns the cached stack of the given range, building it (from the cached stack with the same start and
+// the closest lower stop) if needed. The returned tree is shared and must not be modified.
+func (cache *StackCache) stackRange(start, stop int) *FileTree {
+	if stop < start {
+		return cache.trees[0]
+	}
+	if cache.stacked == nil || start != cache.start {
+		cache.start, cache.stacked = start, make([]*FileTree, len(cache.trees))
+	}
+	if tree := cache.stacked[stop]; tree != nil {
+		return tree
+	}
+
+	tree, from := cache.trees[0], start
+	for idx := stop - 1; idx >= start; idx-- {
+		if cached := cache.stacked[idx]; cached != nil {
+			tree, from = cached, idx+1
+			break
+		}
+	}
+
+	tree = tree.Copy()
+	for idx := from; idx <= stop; idx++ {
+		err := tree.Stack(cache.trees[idx])
+		if err != nil {
+			logrus.Debug("could not stack tree range:", err)
+		}
+	}
+
+	cache.stacked[stop] = tree
+	return tree
+}
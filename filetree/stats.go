@@ -0,0 +1,80 @@
+package filetree
+
+import (
+	"archive/tar"
+)
+
+// DiffStats is the number of files and the bytes they hold for each DiffType in a tree. Directories are not counted
+// (their children are), and a Moved file is counted at its new location alone, so no byte is counted twice.
+type DiffStats struct {
+	Files map[DiffType]int
+	Bytes map[DiffType]int64
+}
+
+// DiffStats returns the number of files (and their size, per SetSizeMode) of every DiffType in the tree, as marked
+// by Compare. The counts are computed once and cached until the tree or its DiffTypes are changed.
+func (tree *FileTree) DiffStats() DiffStats {
+	if tree.diffStats == nil {
+		stats := DiffStats{
+			Files: make(map[DiffType]int),
+			Bytes: make(map[DiffType]int64),
+		}
+		tree.VisitDepthChildFirst(func(node *FileNode) error {
+			if !node.IsLeaf() || node.Data.FileInfo.IsDir() || node.Data.MovedTo != "" {
+				return nil
+			}
+			stats.Files[node.Data.DiffType]++
+			stats.Bytes[node.Data.DiffType] += node.Size()
+			return nil
+		}, nil)
+		tree.diffStats = &stats
+	}
+	return *tree.diffStats
+}
+
+// EntryStats counts the entries of a tree (e.g. of a single layer) by type, along with the bytes of the files. Large
+// numbers of (even tiny) entries slow pulls and put pressure on the inodes of overlay file systems, regardless of their
+// size. Directories only implied by the paths beneath them are not entries of their own, and are not counted.
+type EntryStats struct {
+	Entries     int   `json:"entries"`
+	Files       int   `json:"files"`
+	Directories int   `json:"directories"`
+	Symlinks    int   `json:"symlinks"`
+	Hardlinks   int   `json:"hardlinks"`
+	Whiteouts   int   `json:"whiteouts"`
+	Other       int   `json:"other"`
+	Bytes       int64 `json:"bytes"`
+}
+
+// EntryStats returns the number of entries of every type in the tree, and the bytes of its files (per SetSizeMode).
+// The counts are computed once and cached until the tree is changed.
+func (tree *FileTree) EntryStats() EntryStats {
+	if tree.entryStats == nil {
+		var stats EntryStats
+		tree.VisitDepthChildFirst(func(node *FileNode) error {
+			if node == tree.Root || node.isImplicitDir() {
+				return nil
+			}
+			info := node.Data.FileInfo
+			switch {
+			case node.IsWhiteout():
+				stats.Whiteouts++
+			case info.IsDir():
+				stats.Directories++
+			case info.TypeFlag == tar.TypeSymlink:
+				stats.Symlinks++
+			case info.TypeFlag == tar.TypeLink:
+				stats.Hardlinks++
+			case info.TypeFlag == tar.TypeReg || info.TypeFlag == tar.TypeRegA:
+				stats.Files++
+				stats.Bytes += node.Size()
+			default:
+				stats.Other++
+			}
+			stats.Entries++
+			return nil
+		}, nil)
+		tree.entryStats = &stats
+	}
+	return *tree.entryStats
+}
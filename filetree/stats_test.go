@@ -0,0 +1,62 @@
+package filetree
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestDiffStats(t *testing.T) {
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	for _, path := range []string{"/etc/hosts", "/etc/group", "/usr/bin/env", "/usr/bin/sh"} {
+		lowerTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: 123, LogicalBytes: 10})
+	}
+	upperTree.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 456, LogicalBytes: 15})
+	upperTree.AddPath("/etc/ssl/cert.pem", FileInfo{Path: "/etc/ssl/cert.pem", TypeFlag: '0', hash: 123, LogicalBytes: 100})
+	upperTree.AddPath("/etc/ssl/key.pem", FileInfo{Path: "/etc/ssl/key.pem", TypeFlag: '0', hash: 123, LogicalBytes: 200})
+	upperTree.AddPath("/usr/.wh.bin", FileInfo{})
+	if err := lowerTree.Compare(upperTree); err != nil {
+		t.Fatalf("Could not compare trees: %v", err)
+	}
+
+	stats := lowerTree.DiffStats()
+	expectedFiles := map[DiffType]int{Added: 2, Removed: 2, Changed: 1, Unchanged: 1}
+	expectedBytes := map[DiffType]int64{Added: 300, Removed: 20, Changed: 10, Unchanged: 10}
+	for _, diffType := range diffTypes {
+		if stats.Files[diffType] != expectedFiles[diffType] || stats.Bytes[diffType] != expectedBytes[diffType] {
+			t.Errorf("Expected %d %v files of %d bytes, got %d files of %d bytes", expectedFiles[diffType], diffType, expectedBytes[diffType], stats.Files[diffType], stats.Bytes[diffType])
+		}
+	}
+
+	// the stats follow changes of the diff types
+	hosts, _ := lowerTree.GetNode("/etc/hosts")
+	hosts.AssignDiffType(Unchanged)
+	if stats := lowerTree.DiffStats(); stats.Files[Changed] != 0 || stats.Files[Unchanged] != 2 {
+		t.Errorf("Expected the stats to be recomputed, got: %+v", stats)
+	}
+}
+
+func TestEntryStats(t *testing.T) {
+	tree := treeFromTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox", Mode: 0777},
+		{Name: "usr/bin/ls", Typeflag: tar.TypeLink, Linkname: "usr/bin/busybox", Mode: 0755},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666},
+		{Name: "tmp/.wh.cache", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/hosts": "localhost", "etc/passwd": "root"})
+
+	// the implied usr, usr/bin, dev, and tmp directories are not entries
+	expected := EntryStats{Entries: 7, Files: 2, Directories: 1, Symlinks: 1, Hardlinks: 1, Whiteouts: 1, Other: 1, Bytes: 13}
+	if stats := tree.EntryStats(); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+
+	// the stats follow changes to the tree
+	node, _ := tree.GetNode("/etc/hosts")
+	node.Remove()
+	if stats := tree.EntryStats(); stats.Files != 1 || stats.Entries != 6 || stats.Bytes != 4 {
+		t.Errorf("Expected the stats to be recomputed, got: %+v", stats)
+	}
+}
@@ -1,6 +1,8 @@
 package filetree
 
 import (
+	"archive/tar"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -16,17 +18,47 @@ const (
 	lastItem             = "└─"
 	whiteoutPrefix       = ".wh."
 	doubleWhiteoutPrefix = ".wh..wh.."
+	opaqueWhiteout       = ".wh..wh..opq"
 	uncollapsedItem      = "─ "
 	collapsedItem        = "⊕ "
 )
 
-// FileTree represents a set of files, directories, and their relations.
+// maxLinkHops is the number of symlinks followed when resolving a path before giving up on a link loop
+const maxLinkHops = 40
+
+var (
+	// ErrPathNotFound is returned (wrapped) by GetNodeResolved when a path does not exist
+	ErrPathNotFound = errors.New("path does not exist")
+	// ErrBrokenLink is returned (wrapped) by GetNodeResolved when a symlink along a path points to nothing
+	ErrBrokenLink = errors.New("broken symlink")
+	// ErrTooManyLinks is returned (wrapped) by GetNodeResolved when resolving a path follows too many symlinks
+	ErrTooManyLinks = errors.New("too many levels of symlinks")
+	// ErrAmbiguousPath is returned (wrapped) by GetNodeFold when a path matches more than one node (differing in case)
+	ErrAmbiguousPath = errors.New("ambiguous path")
+)
+
+// FileTree represents a set of files, directories, and their relations. DuplicateEntries counts the entries added with
+// AddEntry for a path that an earlier entry already held, UnreadableEntries the layer entries that could not be read
+// (kept in the tree, marked as unreadable, when errors are ignored). Trees read from a layer tar also record the bytes of the
+// (possibly compressed) blob consumed to read it (BlobSize) and the sum of the sizes of its entries (ContentSize), both
+// 0 when unknown.
 type FileTree struct {
-	Root     *FileNode
-	Size     int
-	FileSize uint64
-	Name     string
-	Id       uuid.UUID
+	Root              *FileNode
+	Size              int
+	FileSize          uint64
+	BlobSize          uint64
+	ContentSize       uint64
+	Name              string
+	Id                uuid.UUID
+	HashAlgorithm     string
+	SortOrder         SortOrder
+	CompressChains    bool
+	DuplicateEntries  int
+	UnreadableEntries int
+	aggregates        map[*FileNode]aggregate
+	diffStats         *DiffStats
+	entryStats        *EntryStats
+	dotfilesHidden    map[*FileNode]bool
 }
 
 // NewFileTree creates an empty FileTree
@@ -35,8 +67,10 @@ func NewFileTree() (tree *FileTree) {
 	tree.Size = 0
 	tree.Root = new(FileNode)
 	tree.Root.Tree = tree
+	tree.Root.Data = new(NodeData)
 	tree.Root.Children = make(map[string]*FileNode)
 	tree.Id = uuid.New()
+	tree.HashAlgorithm = HashAlgorithm()
 	return tree
 }
 
@@ -64,13 +98,8 @@ func (tree *FileTree) renderStringTreeBetween(startRow, stopRow int, showAttribu
 		var currentParams renderParams
 		currentParams, paramsToVisit = paramsToVisit[0], paramsToVisit[1:]
 
-		// take note of the next nodes to visit later
-		var keys []string
-		for key := range currentParams.node.Children {
-			keys = append(keys, key)
-		}
-		// we should always visit nodes in order
-		sort.Strings(keys)
+		// take note of the next nodes to visit later (we should always visit nodes in order)
+		keys := currentParams.node.childNames()
 
 		var childParams = make([]renderParams, 0)
 		for idx, name := range keys {
@@ -79,6 +108,8 @@ func (tree *FileTree) renderStringTreeBetween(startRow, stopRow int, showAttribu
 			if child.Data.ViewInfo.Hidden || currentParams.node.Data.ViewInfo.Collapsed {
 				continue
 			}
+			// a compressed chain of directories is rendered as its deepest directory
+			child = child.ChainEnd()
 
 			// visit this node...
 			isLast := idx == (len(currentParams.node.Children) - 1)
@@ -138,11 +169,20 @@ func (tree *FileTree) StringBetween(start, stop uint, showAttributes bool) strin
 	return tree.renderStringTreeBetween(int(start), int(stop), showAttributes)
 }
 
-// Copy returns a copy of the given FileTree
+// Copy returns a copy of the given FileTree. Every node is duplicated, but shares its NodeData with the original until
+// either side changes it (see FileNode.Copy), so the copies can be changed (e.g. collapsed in the UI, or compared)
+// independently while the untouched nodes cost no more metadata.
 func (tree *FileTree) Copy() *FileTree {
 	newTree := NewFileTree()
 	newTree.Size = tree.Size
 	newTree.FileSize = tree.FileSize
+	newTree.BlobSize = tree.BlobSize
+	newTree.ContentSize = tree.ContentSize
+	newTree.DuplicateEntries = tree.DuplicateEntries
+	newTree.UnreadableEntries = tree.UnreadableEntries
+	newTree.HashAlgorithm = tree.HashAlgorithm
+	newTree.SortOrder = tree.SortOrder
+	newTree.CompressChains = tree.CompressChains
 	newTree.Root = tree.Root.Copy(newTree.Root)
 
 	// update the tree pointers
@@ -154,9 +194,17 @@ func (tree *FileTree) Copy() *FileTree {
 	return newTree
 }
 
-// Visitor is a function that processes, observes, or otherwise transforms the given node
+// Visitor is a function that processes, observes, or otherwise transforms the given node. Returning SkipSubtree or
+// StopWalk controls the rest of the traversal, any other error ends it and is returned by the visit function.
 type Visitor func(*FileNode) error
 
+var (
+	// SkipSubtree is returned by a Visitor to skip the children of the visited node (when visiting parents first)
+	SkipSubtree = errors.New("skip this subtree")
+	// StopWalk is returned by a Visitor to end the traversal without an error
+	StopWalk = errors.New("stop the walk")
+)
+
 // VisitEvaluator is a function that indicates whether the given node should be visited by a Visitor.
 type VisitEvaluator func(*FileNode) bool
 
@@ -170,19 +218,94 @@ func (tree *FileTree) VisitDepthParentFirst(visitor Visitor, evaluator VisitEval
 	return tree.Root.VisitDepthParentFirst(visitor, evaluator)
 }
 
+// VisitDepth iterates the tree depth-first (shallowest depths first, like VisitDepthParentFirst) without descending
+// past the given depth: a depth of 1 visits the children of the root alone. The nodes at the maximum depth summarize
+// what is beneath them with their (cached) AggregateSize and FileCount. SkipSubtree and StopWalk work as they do for
+// VisitDepthParentFirst.
+func (tree *FileTree) VisitDepth(maxDepth int, visitor Visitor) error {
+	if err := tree.Root.visitDepth(1, maxDepth, visitor); err != StopWalk {
+		return err
+	}
+	return nil
+}
+
+// visitDepth visits the children of this node (at the given depth) and their children, down to the maximum depth.
+func (node *FileNode) visitDepth(depth, maxDepth int, visitor Visitor) error {
+	if depth > maxDepth {
+		return nil
+	}
+	for _, name := range node.childNames() {
+		child := node.Children[name]
+		err := visitor(child)
+		if err == SkipSubtree {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := child.visitDepth(depth+1, maxDepth, visitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHashAlgorithm ensures the file contents of both trees were hashed with the same algorithm (otherwise content
+// hashes cannot be meaningfully compared).
+func (tree *FileTree) checkHashAlgorithm(other *FileTree) error {
+	if tree.HashAlgorithm != other.HashAlgorithm {
+		return fmt.Errorf("cannot combine trees hashed with different algorithms ('%s' and '%s')", tree.HashAlgorithm, other.HashAlgorithm)
+	}
+	return nil
+}
+
 // Stack takes two trees and combines them together. This is done by "stacking" the given tree on top of the owning tree.
+// Whiteouts remove the paths they hide (those that do not exist are ignored) and a file replaces any directory of the
+// same path along with its contents.
 func (tree *FileTree) Stack(upper *FileTree) error {
+	if err := tree.checkHashAlgorithm(upper); err != nil {
+		return err
+	}
+	// the contents of opaque directories are replaced by the upper tree, not merged with it
+	for _, upperNode := range upper.opaqueDirs() {
+		if lowerNode, _ := tree.GetNode(upperNode.Path()); lowerNode != nil {
+			for _, child := range lowerNode.Children {
+				child.Remove()
+			}
+		}
+	}
+
 	graft := func(node *FileNode) error {
 		if node.IsWhiteout() {
-			err := tree.RemovePath(node.Path())
+			lowerNode, _ := tree.GetNode(node.Path())
+			if lowerNode == nil {
+				// nothing to remove, the path never existed below
+				return nil
+			}
+			err := lowerNode.Remove()
 			if err != nil {
 				return fmt.Errorf("cannot remove node %s: %v", node.Path(), err.Error())
 			}
 		} else {
+			if node.isImplicitDir() {
+				if lowerNode, _ := tree.GetNode(node.Path()); lowerNode != nil {
+					// the upper tree holds no entry of its own for the directory, keep the metadata below
+					return nil
+				}
+			}
+			if node.replacesDir() {
+				// a file replacing a directory hides everything the directory held
+				if lowerNode, _ := tree.GetNode(node.Path()); lowerNode != nil {
+					for _, child := range lowerNode.Children {
+						child.Remove()
+					}
+				}
+			}
+			lowerNode, _ := tree.GetNode(node.Path())
 			newNode, err := tree.AddPath(node.Path(), node.Data.FileInfo)
 			if err != nil {
 				return fmt.Errorf("cannot add node %s: %v", newNode.Path(), err.Error())
 			}
+			newNode.inheritLayers(node, lowerNode == nil)
 		}
 		return nil
 	}
@@ -194,7 +317,7 @@ func (tree *FileTree) GetNode(path string) (*FileNode, error) {
 	nodeNames := strings.Split(strings.Trim(path, "/"), "/")
 	node := tree.Root
 	for _, name := range nodeNames {
-		if name == "" {
+		if name == "" || name == "." {
 			continue
 		}
 		if node.Children[name] == nil {
@@ -205,21 +328,112 @@ func (tree *FileTree) GetNode(path string) (*FileNode, error) {
 	return node, nil
 }
 
+// GetNodeFold fetches a single node like GetNode, but ignores the case of the path. When more than one node matches
+// (their names only differ in case) an error wrapping ErrAmbiguousPath is returned rather than picking one, otherwise
+// errors wrap ErrPathNotFound.
+func (tree *FileTree) GetNodeFold(path string) (*FileNode, error) {
+	node := tree.Root
+	for _, name := range splitPath(path) {
+		matches := node.childrenFold(name)
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		case 1:
+			node = matches[0]
+		default:
+			var names []string
+			for _, match := range matches {
+				names = append(names, match.Path())
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%w: %s (matches %s)", ErrAmbiguousPath, path, strings.Join(names, ", "))
+		}
+	}
+	return node, nil
+}
+
+// GetNodeResolved fetches a single node like GetNode, but follows any symlinks (absolute or relative) found along the
+// path, including the last node. Errors wrap ErrPathNotFound, ErrBrokenLink, or ErrTooManyLinks.
+func (tree *FileTree) GetNodeResolved(path string) (*FileNode, error) {
+	hops := 0
+	return tree.resolve(tree.Root, splitPath(path), &hops)
+}
+
+// resolve walks the given path components starting at the given node, following symlinks along the way. The number
+// of symlinks followed is shared across nested resolutions to guard against loops.
+func (tree *FileTree) resolve(start *FileNode, names []string, hops *int) (*FileNode, error) {
+	node := start
+	for idx, name := range names {
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if node.Parent != nil {
+				node = node.Parent
+			}
+			continue
+		}
+
+		child := node.Children[name]
+		if child == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, strings.Join(names[:idx+1], "/"))
+		}
+
+		if child.Data.FileInfo.TypeFlag == tar.TypeSymlink {
+			*hops++
+			if *hops > maxLinkHops {
+				return nil, fmt.Errorf("%w: %s", ErrTooManyLinks, child.Path())
+			}
+			// relative targets are relative to the directory holding the link
+			base := node
+			if strings.HasPrefix(child.Data.FileInfo.Linkname, "/") {
+				base = tree.Root
+			}
+			target, err := tree.resolve(base, splitPath(child.Data.FileInfo.Linkname), hops)
+			if errors.Is(err, ErrPathNotFound) {
+				return nil, fmt.Errorf("%w: %s -> %s", ErrBrokenLink, child.Path(), child.Data.FileInfo.Linkname)
+			} else if err != nil {
+				return nil, err
+			}
+			child = target
+		}
+		node = child
+	}
+	return node, nil
+}
+
 // AddPath adds a new node to the tree with the given payload
 func (tree *FileTree) AddPath(path string, data FileInfo) (*FileNode, error) {
 	nodeNames := strings.Split(strings.Trim(path, "/"), "/")
 	node := tree.Root
 	for idx, name := range nodeNames {
-		if name == "" {
+		if name == "" || name == "." {
 			continue
 		}
+		isLast := idx == len(nodeNames)-1
+		if isLast && name == opaqueWhiteout {
+			// the marker is not a file of its own, it marks the directory holding it as opaque
+			node.opaque = true
+			return node, nil
+		}
 		// find or create node
 		if node.Children[name] != nil {
 			node = node.Children[name]
+
+			// attach payload to the last specified node
+			if isLast {
+				node.writeData().FileInfo = data
+				tree.invalidateAggregates()
+			}
 		} else {
-			// don't attach the payload. The payload is destined for the
-			// Path's end node, not any intermediary node.
-			node = node.AddChild(name, FileInfo{})
+			// only attach the payload to the last specified node. The payload is destined for the
+			// Path's end node, not any intermediary node (these are implicit directories until their own entry, if any,
+			// is added).
+			payload := data
+			if !isLast {
+				payload = implicitDirInfo(strings.Join(nodeNames[:idx+1], "/"))
+			}
+			node = node.AddChild(name, payload)
 
 			if node == nil {
 				// the child could not be added
@@ -227,15 +441,27 @@ func (tree *FileTree) AddPath(path string, data FileInfo) (*FileNode, error) {
 			}
 		}
 
-		// attach payload to the last specified node
-		if idx == len(nodeNames)-1 {
-			node.Data.FileInfo = data
-		}
-
 	}
 	return node, nil
 }
 
+// AddEntry adds a tar entry of a layer to the tree (see AddPath), counting its size in FileSize and ContentSize. When an
+// earlier entry of the layer already held the same path, the last entry wins (as it does when extracting the tar): its
+// FileInfo (and size) replaces the earlier one, and the duplicate is logged and counted in DuplicateEntries.
+// Directories only created as the parents of earlier entries are not duplicates, the entry of the directory provides
+// their metadata.
+func (tree *FileTree) AddEntry(path string, data FileInfo) (*FileNode, error) {
+	if node, _ := tree.GetNode(path); node != nil && node != tree.Root && !node.isImplicitDir() {
+		logrus.Warnf("duplicate tar entry for '%s' in layer %s, using the last one", path, tree.Name)
+		tree.DuplicateEntries++
+		tree.FileSize -= uint64(node.Data.FileInfo.Size())
+		tree.ContentSize -= uint64(node.Data.FileInfo.LogicalBytes)
+	}
+	tree.FileSize += uint64(data.Size())
+	tree.ContentSize += uint64(data.LogicalBytes)
+	return tree.AddPath(path, data)
+}
+
 // RemovePath removes a node from the tree given its path.
 func (tree *FileTree) RemovePath(path string) error {
 	node, err := tree.GetNode(path)
@@ -245,11 +471,34 @@ func (tree *FileTree) RemovePath(path string) error {
 	return node.Remove()
 }
 
+// RemovePathAndPrune removes a node from the tree given its path, along with the ancestor directories left empty by
+// the removal (see FileNode.RemoveAndPrune).
+func (tree *FileTree) RemovePathAndPrune(path string) error {
+	node, err := tree.GetNode(path)
+	if err != nil {
+		return err
+	}
+	return node.RemoveAndPrune()
+}
+
 // Compare marks the FileNodes in the owning (lower) tree with DiffType annotations when compared to the given (upper) tree.
 func (tree *FileTree) Compare(upper *FileTree) error {
+	if err := tree.checkHashAlgorithm(upper); err != nil {
+		return err
+	}
+
 	// always compare relative to the original, unaltered tree.
 	originalTree := tree.Copy()
 
+	// everything the lower tree held in an opaque directory is removed, unless the upper tree adds it again
+	for _, upperNode := range upper.opaqueDirs() {
+		if lowerNode, _ := tree.GetNode(upperNode.Path()); lowerNode != nil {
+			if err := lowerNode.markRemovedUnless(upperNode); err != nil {
+				return err
+			}
+		}
+	}
+
 	graft := func(upperNode *FileNode) error {
 		if upperNode.IsWhiteout() {
 			err := tree.markRemoved(upperNode.Path())
@@ -266,18 +515,95 @@ func (tree *FileTree) Compare(upper *FileTree) error {
 					return fmt.Errorf("cannot add new upperNode %s: %v", upperNode.Path(), err.Error())
 				}
 				newNode.AssignDiffType(Added)
+				newNode.inheritLayers(upperNode, true)
 			} else {
 				// check the tree for comparison markings
 				lowerNode, _ := tree.GetNode(upperNode.Path())
-
 				diffType := lowerNode.compare(upperNode)
-				return lowerNode.deriveDiffType(diffType)
+				if err := lowerNode.deriveDiffType(diffType); err != nil {
+					return err
+				}
+				// an upper node repeating the lower node as is does not modify it
+				if lowerNode.Data.DiffType != Unchanged {
+					lowerNode.inheritLayers(upperNode, false)
+				}
 			}
 		}
 		return nil
 	}
 	// we must visit from the leaves upwards to ensure that diff types can be derived from and assigned to children
-	return upper.VisitDepthChildFirst(graft, nil)
+	err := upper.VisitDepthChildFirst(graft, nil)
+	tree.diffStats = nil
+	return err
+}
+
+// PropagateDiff recomputes the DiffType of every directory from the DiffTypes of its children (see DiffType.Merge),
+// from the deepest directories up. This brings the directories back in line after the DiffTypes of files have been
+// changed (e.g. to ignore some changes). Empty directories keep their own DiffType.
+func (tree *FileTree) PropagateDiff() {
+	tree.VisitDepthChildFirst(func(node *FileNode) error {
+		if node.IsLeaf() {
+			return nil
+		}
+		var diffType DiffType
+		first := true
+		for _, child := range node.Children {
+			if first {
+				diffType, first = child.Data.DiffType, false
+			} else {
+				diffType = diffType.Merge(child.Data.DiffType)
+			}
+		}
+		node.writeData().DiffType = diffType
+		return nil
+	}, nil)
+	tree.diffStats = nil
+}
+
+// CompareTrees marks the FileNodes of the upper tree with DiffType annotations relative to the base tree. Unlike
+// Compare (which applies a single layer on top of a lower tree), both trees are taken to be complete file systems
+// (e.g. two stacked images): paths only found in the base tree are added to the upper tree and marked as Removed.
+// Otherwise the markup is the same as that of Compare.
+func CompareTrees(base, upper *FileTree) error {
+	if err := base.checkHashAlgorithm(upper); err != nil {
+		return err
+	}
+
+	// bring over everything the upper tree no longer has
+	removed := make(map[*FileNode]bool)
+	err := base.VisitDepthParentFirst(func(baseNode *FileNode) error {
+		if upperNode, _ := upper.GetNode(baseNode.Path()); upperNode != nil {
+			return nil
+		}
+		err := baseNode.VisitDepthParentFirst(func(node *FileNode) error {
+			newNode, err := upper.AddPath(node.Path(), node.Data.FileInfo)
+			if err != nil {
+				return fmt.Errorf("cannot add removed node %s: %v", node.Path(), err.Error())
+			}
+			removed[newNode] = true
+			return newNode.AssignDiffType(Removed)
+		}, nil)
+		if err != nil {
+			return err
+		}
+		// the children of a removed directory have been brought over along with it
+		return SkipSubtree
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// we must visit from the leaves upwards to ensure that diff types can be derived from and assigned to children
+	return upper.VisitDepthChildFirst(func(upperNode *FileNode) error {
+		if removed[upperNode] {
+			return nil
+		}
+		baseNode, _ := base.GetNode(upperNode.Path())
+		if baseNode == nil {
+			return upperNode.AssignDiffType(Added)
+		}
+		return upperNode.deriveDiffType(baseNode.compare(upperNode))
+	}, nil)
 }
 
 // markRemoved annotates the FileNode at the given path as Removed.
@@ -289,6 +615,45 @@ func (tree *FileTree) markRemoved(path string) error {
 	return node.AssignDiffType(Removed)
 }
 
+// opaqueDirs returns the directories of the tree marked as opaque (by a '.wh..wh..opq' entry), which hide all of the
+// lower contents of the same directory.
+func (tree *FileTree) opaqueDirs() []*FileNode {
+	var dirs []*FileNode
+	if tree.Root.opaque {
+		dirs = append(dirs, tree.Root)
+	}
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		if node.opaque {
+			dirs = append(dirs, node)
+		}
+		return nil
+	}, nil)
+	return dirs
+}
+
+// Squash stacks all the given trees (the layers of an image, lowest first) into the file system they present at
+// runtime: whiteouts and opaque directories are applied by deleting what they hide, and the result holds no diff
+// markup (every node is Unchanged). Whiteouts of paths that never existed are ignored.
+func Squash(trees []*FileTree) (*FileTree, error) {
+	squashed := NewFileTree()
+	if len(trees) > 0 {
+		squashed.HashAlgorithm = trees[0].HashAlgorithm
+	}
+	for _, tree := range trees {
+		if err := squashed.Stack(tree); err != nil {
+			return nil, err
+		}
+	}
+
+	// the nodes are all new (so Unchanged), only the opaque markers of the layers remain to be dropped
+	squashed.Root.opaque = false
+	err := squashed.VisitDepthParentFirst(func(node *FileNode) error {
+		node.opaque = false
+		return nil
+	}, nil)
+	return squashed, err
+}
+
 // StackRange combines an array of trees into a single tree
 func StackRange(trees []*FileTree, start, stop int) *FileTree {
 	tree := trees[0].Copy()
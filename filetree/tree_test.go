@@ -1,8 +1,13 @@
 package filetree
 
 import (
+	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func stringInSlice(a string, list []string) bool {
@@ -187,8 +192,6 @@ func TestStack(t *testing.T) {
 	// whiteout the following files
 	tree2.AddPath("/var/run/.wh.bashful", FileInfo{})
 	tree2.AddPath("/.wh.tmp", FileInfo{})
-	// ignore opaque whiteout files entirely
-	tree2.AddPath("/.wh..wh..opq", FileInfo{})
 
 	err := tree1.Stack(tree2)
 
@@ -526,3 +529,647 @@ func TestRemoveOnIterate(t *testing.T) {
 	}
 
 }
+
+// treeFromTar builds a FileTree from a layer tar holding the given headers (in order), with contents for regular files.
+func treeFromTar(t *testing.T, headers []*tar.Header, contents map[string]string) *FileTree {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, header := range headers {
+		header.Size = int64(len(contents[header.Name]))
+		writer.WriteHeader(header)
+		writer.Write([]byte(contents[header.Name]))
+	}
+	writer.Close()
+
+	tree := NewFileTree()
+	reader := tar.NewReader(&buf)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		info, err := NewFileInfo(reader, header, header.Name, true)
+		if err != nil {
+			t.Fatalf("could not read test tar: %v", err)
+		}
+		tree.AddEntry(header.Name, info)
+	}
+	return tree
+}
+
+func TestCompareHardlinkTargets(t *testing.T) {
+	layer := func(targetContents string) *FileTree {
+		// the hardlink is listed before its target in the tar
+		return treeFromTar(t, []*tar.Header{
+			{Name: "usr/bin/python", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3.6", Mode: 0755},
+			{Name: "usr/bin/python3.6", Typeflag: tar.TypeReg, Mode: 0755},
+		}, map[string]string{"usr/bin/python3.6": targetContents})
+	}
+
+	cases := []struct {
+		name     string
+		lower    string
+		upper    string
+		expected DiffType
+	}{
+		{"unchanged target", "python 3.6.1", "python 3.6.1", Unchanged},
+		{"changed target", "python 3.6.1", "python 3.6.8", Changed},
+	}
+
+	for _, test := range cases {
+		lowerTree, upperTree := layer(test.lower), layer(test.upper)
+		if err := lowerTree.Compare(upperTree); err != nil {
+			t.Fatalf("[%s] Expected no error from comparing trees, got: %v", test.name, err)
+		}
+		node, _ := lowerTree.GetNode("/usr/bin/python")
+		if err := AssertDiffType(node, test.expected); err != nil {
+			t.Errorf("[%s] %v", test.name, err)
+		}
+	}
+
+	node, _ := layer("python 3.6.1").GetNode("/usr/bin/python")
+	if target := node.linkTarget(); target == nil || target.Path() != "/usr/bin/python3.6" {
+		t.Errorf("Expected the hardlink to resolve to '/usr/bin/python3.6', got %v", target)
+	}
+}
+
+func TestHardlinkSizes(t *testing.T) {
+	defer SetSizeMode("logical")
+
+	tree := treeFromTar(t, []*tar.Header{
+		{Name: "usr/bin/python", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3.6", Mode: 0755},
+		{Name: "usr/bin/python3.6", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"usr/bin/python3.6": "python 3.6.1"})
+
+	link, _ := tree.GetNode("/usr/bin/python")
+	target, _ := tree.GetNode("/usr/bin/python3.6")
+	dir, _ := tree.GetNode("/usr/bin")
+
+	if link.Data.FileInfo.StoredBytes != 0 || target.Data.FileInfo.StoredBytes != 12 {
+		t.Errorf("Expected 0 stored bytes for the hardlink and 12 for the target, got %d and %d", link.Data.FileInfo.StoredBytes, target.Data.FileInfo.StoredBytes)
+	}
+
+	cases := []struct {
+		mode     string
+		link     int64
+		expected string
+	}{
+		{"logical", 12, "24 B"},
+		{"stored", 0, "12 B"},
+	}
+	for _, test := range cases {
+		if err := SetSizeMode(test.mode); err != nil {
+			t.Fatalf("Expected size mode '%s' to be supported, got: %v", test.mode, err)
+		}
+		if actual := link.Size(); actual != test.link {
+			t.Errorf("[%s] Expected the hardlink size to be %d, got %d", test.mode, test.link, actual)
+		}
+		if actual := dir.MetadataString(); !strings.Contains(actual, test.expected) {
+			t.Errorf("[%s] Expected the directory size to be %s, got '%s'", test.mode, test.expected, actual)
+		}
+	}
+
+	if err := SetSizeMode("apparent"); err == nil {
+		t.Errorf("Expected an error for an unknown size mode")
+	}
+}
+
+// BenchmarkLargeTree builds (and copies, as stacking does) a synthetic tree of 500k files, to measure the memory held
+// for every FileInfo (see B/op).
+func BenchmarkLargeTree(b *testing.B) {
+	headers := make([]*tar.Header, 500000)
+	for idx := range headers {
+		headers[idx] = &tar.Header{
+			Name:       fmt.Sprintf("usr/share/dir-%d/file-%d", idx/100, idx),
+			Typeflag:   tar.TypeReg,
+			Mode:       0644,
+			Size:       1024,
+			Uname:      "root",
+			Gname:      "root",
+			ModTime:    time.Unix(1000, 0),
+			PAXRecords: map[string]string{"mtime": "1000.5"},
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tree := NewFileTree()
+		for _, header := range headers {
+			info, _ := NewFileInfo(nil, header, header.Name, false)
+			tree.AddPath(header.Name, info)
+		}
+		tree.Copy()
+	}
+}
+
+func TestGetNodeResolved(t *testing.T) {
+	tree := NewFileTree()
+	tree.AddPath("/usr/share/ca-certificates/ca.crt", FileInfo{Path: "/usr/share/ca-certificates/ca.crt", TypeFlag: tar.TypeReg})
+	links := map[string]string{
+		"/etc/ssl/certs":    "/usr/share/ca-certificates",
+		"/etc/ssl/relative": "../../usr/share/ca-certificates",
+		"/etc/ssl/chain":    "certs",
+		"/etc/ssl/ca.crt":   "chain/ca.crt",
+		"/etc/ssl/broken":   "/nowhere",
+		"/etc/ssl/loop":     "loop",
+	}
+	for path, target := range links {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeSymlink, Linkname: target})
+	}
+
+	for _, path := range []string{"/etc/ssl/certs/ca.crt", "/etc/ssl/relative/ca.crt", "/etc/ssl/chain/ca.crt", "/etc/ssl/ca.crt", "/usr/share/ca-certificates/ca.crt"} {
+		node, err := tree.GetNodeResolved(path)
+		if err != nil {
+			t.Errorf("Expected '%s' to resolve, got: %v", path, err)
+		} else if node.Path() != "/usr/share/ca-certificates/ca.crt" {
+			t.Errorf("Expected '%s' to resolve to the certificate, got: %s", path, node.Path())
+		}
+	}
+
+	// the literal lookup doesn't follow links
+	if _, err := tree.GetNode("/etc/ssl/certs/ca.crt"); err == nil {
+		t.Errorf("Expected GetNode not to follow symlinks")
+	}
+
+	cases := map[string]error{
+		"/etc/ssl/certs/missing.crt": ErrPathNotFound,
+		"/etc/missing":               ErrPathNotFound,
+		"/etc/ssl/broken":            ErrBrokenLink,
+		"/etc/ssl/broken/file":       ErrBrokenLink,
+		"/etc/ssl/loop":              ErrTooManyLinks,
+	}
+	for path, expected := range cases {
+		if _, err := tree.GetNodeResolved(path); !errors.Is(err, expected) {
+			t.Errorf("Expected '%s' to fail with '%v', got: %v", path, expected, err)
+		}
+	}
+}
+
+func TestStackCache(t *testing.T) {
+	trees := make([]*FileTree, 4)
+	for idx := range trees {
+		trees[idx] = NewFileTree()
+	}
+	trees[0].AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, LogicalBytes: 10})
+	trees[0].AddPath("/etc/group", FileInfo{Path: "/etc/group", TypeFlag: tar.TypeReg, LogicalBytes: 10})
+	trees[1].AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, LogicalBytes: 20})
+	trees[1].AddPath("/tmp/cache/data", FileInfo{Path: "/tmp/cache/data", TypeFlag: tar.TypeReg, LogicalBytes: 30})
+	trees[2].AddPath("/tmp/.wh.cache", FileInfo{})
+	trees[2].AddPath("/etc/.wh.group", FileInfo{})
+	trees[3].AddPath("/tmp/cache/data", FileInfo{Path: "/tmp/cache/data", TypeFlag: tar.TypeReg, LogicalBytes: 40})
+
+	cache := NewStackCache(trees)
+	// visit out of order to build ranges both from scratch and from cached ranges
+	for _, stop := range []int{2, 0, 3, 1, 3, -1} {
+		for _, start := range []int{0, 1} {
+			expected := StackRange(trees, start, stop)
+			actual := cache.StackRange(start, stop)
+			if expected.String(true) != actual.String(true) {
+				t.Errorf("Expected stack %d-%d:\n%s\nGot:\n%s", start, stop, expected.String(true), actual.String(true))
+			}
+			if expected.Size != actual.Size {
+				t.Errorf("Expected stack %d-%d to have %d nodes, got %d", start, stop, expected.Size, actual.Size)
+			}
+		}
+	}
+
+	// modifying a returned tree doesn't change the cached one
+	cache.StackRange(0, 3).RemovePath("/etc")
+	if _, err := cache.StackRange(0, 3).GetNode("/etc/hosts"); err != nil {
+		t.Errorf("Expected the cached stack to be unaffected by modifications: %v", err)
+	}
+
+	// a stack is built on top of the cached one below it, sharing the data of the nodes the upper tree leaves alone
+	cache = NewStackCache(trees)
+	below, _ := cache.stackRange(0, 2).GetNode("/etc/hosts")
+	above, _ := cache.stackRange(0, 3).GetNode("/etc/hosts")
+	if below.Data != above.Data {
+		t.Errorf("Expected the stacks to share the data of the untouched nodes")
+	}
+}
+
+func TestCopySharesNodeData(t *testing.T) {
+	trees := make([]*FileTree, 2)
+	for idx := range trees {
+		trees[idx] = NewFileTree()
+	}
+	trees[0].AddPath("/etc/ssl/cert.pem", FileInfo{Path: "/etc/ssl/cert.pem", TypeFlag: tar.TypeReg, LogicalBytes: 10})
+	trees[1].AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, LogicalBytes: 20})
+
+	cache := NewStackCache(trees)
+	firstLayer := cache.StackRange(0, 0)
+	secondLayer := cache.StackRange(0, 1)
+
+	secondNode, _ := secondLayer.GetNode("/etc/ssl/cert.pem")
+
+	// copying a tree leaves the nodes of the original attached to it
+	if node, _ := trees[0].GetNode("/etc/ssl/cert.pem"); node.Parent.Parent.Parent != trees[0].Root {
+		t.Errorf("Expected the original tree to keep its nodes")
+	}
+
+	// collapsing a directory in one layer view must not collapse it in the other
+	firstDir, _ := firstLayer.GetNode("/etc/ssl")
+	firstDir.SetCollapsed(true)
+	firstDir.SetHidden(true)
+	if secondDir, _ := secondLayer.GetNode("/etc/ssl"); secondDir.Data.ViewInfo.Collapsed || secondDir.Data.ViewInfo.Hidden {
+		t.Errorf("Expected the view info of the other layer view to be unaffected")
+	}
+	if again, _ := cache.StackRange(0, 0).GetNode("/etc/ssl"); again.Data.ViewInfo.Collapsed {
+		t.Errorf("Expected the view info of the cached layer view to be unaffected")
+	}
+
+	// replacing a payload doesn't change the other copies
+	firstLayer.AddPath("/etc/ssl/cert.pem", FileInfo{Path: "/etc/ssl/cert.pem", TypeFlag: tar.TypeReg, LogicalBytes: 30})
+	if secondNode.Data.FileInfo.LogicalBytes != 10 {
+		t.Errorf("Expected the other layer view to keep its payload, got size %d", secondNode.Data.FileInfo.LogicalBytes)
+	}
+
+	firstLayer.Compare(trees[1])
+	if secondDir, _ := secondLayer.GetNode("/etc"); secondDir.Data.DiffType != Unchanged {
+		t.Errorf("Expected the diff types of the other layer view to be unaffected, got %v", secondDir.Data.DiffType)
+	}
+
+	// the nodes left untouched keep sharing their data with the original tree, the changed ones have their own
+	copied := trees[0].Copy()
+	copied.AddPath("/etc/ssl/cert.pem", FileInfo{Path: "/etc/ssl/cert.pem", TypeFlag: tar.TypeReg, LogicalBytes: 40})
+	for path, shared := range map[string]bool{"/etc": true, "/etc/ssl": true, "/etc/ssl/cert.pem": false} {
+		original, _ := trees[0].GetNode(path)
+		node, _ := copied.GetNode(path)
+		if (node.Data == original.Data) != shared {
+			t.Errorf("Expected the data of %s to be shared (%v), got %v", path, shared, !shared)
+		}
+	}
+	etc, _ := copied.GetNode("/etc")
+	etc.SetCollapsed(true)
+	if original, _ := trees[0].GetNode("/etc"); original.Data == etc.Data || original.Data.ViewInfo.Collapsed {
+		t.Errorf("Expected collapsing a copy to give it its own data")
+	}
+}
+
+func TestStackOpaqueWhiteout(t *testing.T) {
+	lower := NewFileTree()
+	for _, path := range []string{"/var/lib/apt/lists/old-a", "/var/lib/apt/lists/partial/old-b", "/var/lib/apt/lists/kept", "/var/lib/dpkg/status"} {
+		lower.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+	}
+	upper := NewFileTree()
+	upper.AddPath("/var/lib/apt/lists/.wh..wh..opq", FileInfo{})
+	upper.AddPath("/var/lib/apt/lists/kept", FileInfo{Path: "/var/lib/apt/lists/kept", TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+	upper.AddPath("/var/lib/apt/lists/partial/new", FileInfo{Path: "/var/lib/apt/lists/partial/new", TypeFlag: tar.TypeReg, hash: 456, LogicalBytes: 10})
+
+	stacked := lower.Copy()
+	if err := stacked.Stack(upper); err != nil {
+		t.Fatalf("Could not stack trees: %v", err)
+	}
+	expected := []string{"/var", "/var/lib", "/var/lib/apt", "/var/lib/apt/lists", "/var/lib/apt/lists/kept", "/var/lib/apt/lists/partial", "/var/lib/apt/lists/partial/new", "/var/lib/dpkg", "/var/lib/dpkg/status"}
+	assertPaths(t, "stack", expected, visitPaths(stacked))
+
+	compared := lower.Copy()
+	if err := compared.Compare(upper); err != nil {
+		t.Fatalf("Could not compare trees: %v", err)
+	}
+	diffTypes := map[string]DiffType{
+		"/var/lib/apt/lists":               Changed,
+		"/var/lib/apt/lists/old-a":         Removed,
+		"/var/lib/apt/lists/kept":          Unchanged,
+		"/var/lib/apt/lists/partial":       Changed,
+		"/var/lib/apt/lists/partial/old-b": Removed,
+		"/var/lib/apt/lists/partial/new":   Added,
+		"/var/lib/dpkg/status":             Unchanged,
+	}
+	for path, diffType := range diffTypes {
+		node, err := compared.GetNode(path)
+		if err != nil {
+			t.Errorf("Expected '%s' to exist in the compared tree", path)
+			continue
+		}
+		if err := AssertDiffType(node, diffType); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPropagateDiff(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/etc/hosts", "/etc/group", "/usr/bin/env", "/usr/lib/libc.so"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg})
+	}
+	tree.AddPath("/tmp", FileInfo{Path: "/tmp", TypeFlag: tar.TypeDir})
+
+	diffTypes := map[string]DiffType{
+		"/etc/hosts":       Changed,
+		"/etc/group":       Unchanged,
+		"/usr/bin/env":     Added,
+		"/usr/lib/libc.so": MetadataChanged,
+		"/tmp":             Removed,
+	}
+	for path, diffType := range diffTypes {
+		node, _ := tree.GetNode(path)
+		node.Data.DiffType = diffType
+	}
+
+	expected := map[string]DiffType{
+		"/etc":     Changed,
+		"/usr/bin": Added,
+		"/usr/lib": MetadataChanged,
+		"/usr":     Changed,
+		"/tmp":     Removed,
+	}
+	for idx := 0; idx < 2; idx++ {
+		// propagating twice gives the same result
+		tree.PropagateDiff()
+		for path, diffType := range expected {
+			node, _ := tree.GetNode(path)
+			if err := AssertDiffType(node, diffType); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// ignoring a change brings the directories back in line
+	hosts, _ := tree.GetNode("/etc/hosts")
+	hosts.Data.DiffType = Unchanged
+	tree.PropagateDiff()
+	etc, _ := tree.GetNode("/etc")
+	if err := AssertDiffType(etc, Unchanged); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVisitSkipAndStop(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/a/1", "/a/2", "/b/1", "/c/1"} {
+		tree.AddPath(path, FileInfo{})
+	}
+
+	var visited []string
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		visited = append(visited, node.Path())
+		switch node.Path() {
+		case "/a":
+			return SkipSubtree
+		case "/b/1":
+			return StopWalk
+		}
+		return nil
+	}, nil)
+	assertPaths(t, "parent first", []string{"/a", "/b", "/b/1"}, visited)
+
+	visited = nil
+	err := tree.VisitDepthChildFirst(func(node *FileNode) error {
+		visited = append(visited, node.Path())
+		if node.Path() == "/a/2" {
+			return SkipSubtree
+		}
+		if node.Path() == "/b" {
+			return StopWalk
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Errorf("Expected StopWalk to end the walk without an error, got: %v", err)
+	}
+	assertPaths(t, "child first", []string{"/a/1", "/a/2", "/a", "/b/1", "/b"}, visited)
+
+	expected := errors.New("failed")
+	if err := tree.VisitDepthParentFirst(func(node *FileNode) error { return expected }, nil); err != expected {
+		t.Errorf("Expected the visitor error to be returned, got: %v", err)
+	}
+}
+
+func TestCompareTrees(t *testing.T) {
+	base := NewFileTree()
+	for _, path := range []string{"/etc/hosts", "/etc/group", "/usr/bin/env"} {
+		base.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+	}
+	upper := NewFileTree()
+	upper.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, hash: 456, LogicalBytes: 10})
+	upper.AddPath("/etc/group", FileInfo{Path: "/etc/group", TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+	upper.AddPath("/opt/new", FileInfo{Path: "/opt/new", TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+
+	if err := CompareTrees(base, upper); err != nil {
+		t.Fatalf("Could not compare trees: %v", err)
+	}
+
+	expected := map[string]DiffType{
+		"/etc":         Changed,
+		"/etc/hosts":   Changed,
+		"/etc/group":   Unchanged,
+		"/usr":         Removed,
+		"/usr/bin":     Removed,
+		"/usr/bin/env": Removed,
+		"/opt":         Added,
+		"/opt/new":     Added,
+	}
+	assertDiffTypes := func(name string, tree *FileTree) {
+		for path, diffType := range expected {
+			node, err := tree.GetNode(path)
+			if err != nil {
+				t.Errorf("[%s] Expected '%s' to exist", name, path)
+				continue
+			}
+			if err := AssertDiffType(node, diffType); err != nil {
+				t.Errorf("[%s] %v", name, err)
+			}
+		}
+	}
+	assertDiffTypes("compare trees", upper)
+
+	// the same changes applied as a layer give the same markup
+	layer := NewFileTree()
+	layer.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: tar.TypeReg, hash: 456, LogicalBytes: 10})
+	layer.AddPath("/.wh.usr", FileInfo{})
+	layer.AddPath("/opt/new", FileInfo{Path: "/opt/new", TypeFlag: tar.TypeReg, hash: 123, LogicalBytes: 10})
+	lower := base.Copy()
+	lower.Compare(layer)
+	assertDiffTypes("layer compare", lower)
+}
+
+func TestGetNodeFold(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/App/Config.JSON", "/App/data/README", "/App/data/readme"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg})
+	}
+
+	node, err := tree.GetNodeFold("/app/config.json")
+	if err != nil || node.Path() != "/App/Config.JSON" {
+		t.Errorf("Expected to find '/App/Config.JSON' regardless of case, got: %v (%v)", node, err)
+	}
+	if _, err := tree.GetNodeFold("/app/data/Readme"); !errors.Is(err, ErrAmbiguousPath) {
+		t.Errorf("Expected an ambiguous path error, got: %v", err)
+	}
+	if _, err := tree.GetNodeFold("/app/missing"); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("Expected a path not found error, got: %v", err)
+	}
+
+	// the index follows changes to the children
+	tree.RemovePath("/App/data/readme")
+	if node, err := tree.GetNodeFold("/APP/DATA/readme"); err != nil || node.Path() != "/App/data/README" {
+		t.Errorf("Expected to find '/App/data/README' after the removal, got: %v (%v)", node, err)
+	}
+	tree.AddPath("/App/CONFIG.json", FileInfo{Path: "/App/CONFIG.json", TypeFlag: tar.TypeReg})
+	if _, err := tree.GetNodeFold("/app/config.json"); !errors.Is(err, ErrAmbiguousPath) {
+		t.Errorf("Expected an ambiguous path error after the addition, got: %v", err)
+	}
+}
+
+func TestVisitDepth(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/usr/lib/x86_64/libc.so", "/usr/bin/env", "/etc/hosts"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg, LogicalBytes: 10})
+	}
+
+	visit := func(maxDepth int) []string {
+		paths := []string{}
+		tree.VisitDepth(maxDepth, func(node *FileNode) error {
+			paths = append(paths, fmt.Sprintf("%s:%d:%d", node.Path(), node.AggregateSize(), node.FileCount()))
+			return nil
+		})
+		return paths
+	}
+	assertPaths(t, "depth 0", []string{}, visit(0))
+	assertPaths(t, "depth 1", []string{"/etc:10:1", "/usr:20:2"}, visit(1))
+	assertPaths(t, "depth 2", []string{"/etc:10:1", "/etc/hosts:10:1", "/usr:20:2", "/usr/bin:10:1", "/usr/lib:10:1"}, visit(2))
+	assertPaths(t, "unlimited", []string{"/etc", "/etc/hosts", "/usr", "/usr/bin", "/usr/bin/env", "/usr/lib", "/usr/lib/x86_64", "/usr/lib/x86_64/libc.so"}, func() []string {
+		paths := []string{}
+		tree.VisitDepth(100, func(node *FileNode) error {
+			paths = append(paths, node.Path())
+			return nil
+		})
+		return paths
+	}())
+}
+
+func TestSquash(t *testing.T) {
+	layers := make([]*FileTree, 3)
+	for idx := range layers {
+		layers[idx] = NewFileTree()
+	}
+	for _, path := range []string{"/etc/hosts", "/etc/group", "/opt/app/lib/a.jar", "/opt/app/lib/b.jar", "/var/cache/x"} {
+		layers[0].AddPath(path, FileInfo{Path: path, TypeFlag: tar.TypeReg})
+	}
+	// a whiteout for a path that never existed is ignored
+	layers[0].AddPath("/.wh.nothing", FileInfo{})
+	layers[1].AddPath("/etc/.wh.group", FileInfo{})
+	layers[1].AddPath("/etc/.wh.missing", FileInfo{})
+	layers[1].AddPath("/var/cache/.wh..wh..opq", FileInfo{})
+	layers[1].AddPath("/var/cache/y", FileInfo{Path: "/var/cache/y", TypeFlag: tar.TypeReg})
+	// the lib directory is replaced by a file
+	layers[2].AddPath("/opt/app/lib", FileInfo{Path: "/opt/app/lib", TypeFlag: tar.TypeSymlink, Linkname: "/usr/lib"})
+
+	squashed, err := Squash(layers)
+	if err != nil {
+		t.Fatalf("could not squash: %v", err)
+	}
+	expected := []string{"/etc", "/etc/hosts", "/opt", "/opt/app", "/opt/app/lib", "/var", "/var/cache", "/var/cache/y"}
+	assertPaths(t, "squashed", expected, visitPaths(squashed))
+
+	lib, _ := squashed.GetNode("/opt/app/lib")
+	if lib.Data.FileInfo.TypeFlag != tar.TypeSymlink {
+		t.Errorf("expected the directory to be replaced by the symlink, got type %q", lib.Data.FileInfo.TypeFlag)
+	}
+	squashed.VisitDepthParentFirst(func(node *FileNode) error {
+		if node.Data.DiffType != Unchanged || node.IsOpaque() || node.IsWhiteout() {
+			t.Errorf("expected a clean node at '%s', got %v (opaque: %v)", node.Path(), node.Data.DiffType, node.IsOpaque())
+		}
+		return nil
+	}, nil)
+
+	// the layers themselves are left alone
+	if _, err := layers[0].GetNode("/etc/group"); err != nil {
+		t.Errorf("expected the layers to be unchanged, got: %v", err)
+	}
+}
+
+func TestOutOfOrderEntries(t *testing.T) {
+	dirHeader := &tar.Header{Name: "etc/nginx/", Typeflag: tar.TypeDir, Mode: 0700, Uid: 101, Gid: 101}
+	childHeader := func() *tar.Header {
+		return &tar.Header{Name: "etc/nginx/nginx.conf", Typeflag: tar.TypeReg, Mode: 0644}
+	}
+	contents := map[string]string{"etc/nginx/nginx.conf": "worker_processes 1;"}
+
+	// children listed before their parent, as written by Bazel
+	childFirst := treeFromTar(t, []*tar.Header{childHeader(), dirHeader}, contents)
+	// no directory entries at all, as written by ko
+	absent := treeFromTar(t, []*tar.Header{childHeader()}, contents)
+	// both, with a "./" prefix on the directory
+	dotted := treeFromTar(t, []*tar.Header{childHeader(), {Name: "./etc/nginx/", Typeflag: tar.TypeDir, Mode: 0700, Uid: 101, Gid: 101}}, contents)
+
+	for name, tree := range map[string]*FileTree{"child first": childFirst, "absent": absent, "dotted": dotted} {
+		assertPaths(t, name, []string{"/etc", "/etc/nginx", "/etc/nginx/nginx.conf"}, visitPaths(tree))
+		if tree.Size != 3 {
+			t.Errorf("[%s] expected 3 nodes, got %d", name, tree.Size)
+		}
+	}
+
+	node, _ := childFirst.GetNode("/etc/nginx")
+	if info := node.Data.FileInfo; info.TypeFlag != tar.TypeDir || info.Uid != 101 || info.Mode.Perm() != 0700 {
+		t.Errorf("expected the directory entry to be merged into the placeholder, got %+v", info)
+	}
+	node, _ = absent.GetNode("/etc/nginx")
+	if expected, actual := "drwxr-xr-x        0:0       19 B ", node.MetadataString(); expected != actual {
+		t.Errorf("expected implicit directory metadata '%s' got '%s'", expected, actual)
+	}
+
+	// stacking a layer without directory entries keeps the metadata of the directory below
+	stacked := childFirst.Copy()
+	if err := stacked.Stack(absent); err != nil {
+		t.Fatalf("could not stack: %v", err)
+	}
+	if node, _ := stacked.GetNode("/etc/nginx"); node.Data.FileInfo.Uid != 101 {
+		t.Errorf("expected the directory metadata to be kept, got %+v", node.Data.FileInfo)
+	}
+
+	// and comparing against it only shows what changed in the files
+	if err := childFirst.Compare(absent); err != nil {
+		t.Fatalf("could not compare: %v", err)
+	}
+	for _, path := range []string{"/etc", "/etc/nginx", "/etc/nginx/nginx.conf"} {
+		if node, _ := childFirst.GetNode(path); node.Data.DiffType != Unchanged {
+			t.Errorf("expected '%s' to be Unchanged, got %v", path, node.Data.DiffType)
+		}
+	}
+}
+
+func TestDuplicateEntries(t *testing.T) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range []struct{ name, contents string }{{"etc/", ""}, {"etc/app.conf", "first"}, {"etc/app.conf", "second"}} {
+		header := &tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.contents))}
+		if strings.HasSuffix(entry.name, "/") {
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		writer.WriteHeader(header)
+		writer.Write([]byte(entry.contents))
+	}
+	writer.Close()
+
+	tree := NewFileTree()
+	var last FileInfo
+	reader := tar.NewReader(&buf)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		info, err := NewFileInfo(reader, header, header.Name, true)
+		if err != nil {
+			t.Fatalf("could not read test tar: %v", err)
+		}
+		tree.AddEntry(header.Name, info)
+		last = info
+	}
+
+	assertPaths(t, "duplicate", []string{"/etc", "/etc/app.conf"}, visitPaths(tree))
+	if tree.Size != 2 || tree.DuplicateEntries != 1 {
+		t.Errorf("expected 2 nodes and 1 duplicate, got %d nodes and %d duplicates", tree.Size, tree.DuplicateEntries)
+	}
+	node, _ := tree.GetNode("/etc/app.conf")
+	if node.Data.FileInfo.Hash() != last.Hash() || node.Data.FileInfo.LogicalBytes != 6 {
+		t.Errorf("expected the last entry to win, got %+v", node.Data.FileInfo)
+	}
+	// the replaced entry is not counted in the size of the layer
+	if tree.FileSize != 6 || tree.ContentSize != 6 {
+		t.Errorf("expected 6 bytes, got %d (%d of contents)", tree.FileSize, tree.ContentSize)
+	}
+}
@@ -0,0 +1,121 @@
+package filetree
+
+import (
+	"strings"
+)
+
+// ShowAll clears the Hidden flag of every node in the tree.
+func (tree *FileTree) ShowAll() {
+	tree.VisitDepthChildFirst(func(node *FileNode) error {
+		node.SetHidden(false)
+		return nil
+	}, nil)
+	tree.dotfilesHidden = nil
+}
+
+// HideFilesSmallerThan marks every file smaller than the given size (per SetSizeMode) as Hidden, along with every
+// directory left without a visible descendant. Nodes are only ever hidden (so this composes with other visibility
+// filters), reset the visibility first (see ShowAll) to re-filter with a lower size. A size of 0 hides nothing.
+func (tree *FileTree) HideFilesSmallerThan(size int64) {
+	if size <= 0 {
+		return
+	}
+	tree.VisitDepthChildFirst(func(node *FileNode) error {
+		if node.IsLeaf() {
+			// an empty directory has nothing large enough to show
+			if node.Data.FileInfo.IsDir() || node.Size() < size {
+				node.SetHidden(true)
+			}
+			return nil
+		}
+		node.hideIfNoVisibleChildren()
+		return nil
+	}, nil)
+}
+
+// hideIfNoVisibleChildren marks a directory as Hidden when all of its children are (it has nothing to show).
+func (node *FileNode) hideIfNoVisibleChildren() {
+	for _, child := range node.Children {
+		if !child.Data.ViewInfo.Hidden {
+			return
+		}
+	}
+	node.SetHidden(true)
+}
+
+// SetDiffTypeVisibility sets the Hidden flag of every node from its DiffType: files (and empty directories) are shown
+// only when their DiffType maps to true, DiffTypes missing from the map are hidden. Directories are shown whenever
+// they contain a visible descendant, regardless of their own (merged) DiffType, since a directory holding an Added
+// file is usually Changed itself.
+func (tree *FileTree) SetDiffTypeVisibility(visible map[DiffType]bool) {
+	tree.VisitDepthChildFirst(func(node *FileNode) error {
+		if node.IsLeaf() {
+			node.SetHidden(!visible[node.Data.DiffType])
+			return nil
+		}
+		node.SetHidden(false)
+		node.hideIfNoVisibleChildren()
+		return nil
+	}, nil)
+	tree.dotfilesHidden = nil
+}
+
+// CollapseAll collapses every directory in the tree.
+func (tree *FileTree) CollapseAll() {
+	tree.CollapseToDepth(0)
+}
+
+// ExpandAll expands every directory in the tree.
+func (tree *FileTree) ExpandAll() {
+	tree.setCollapsed(func(depth int) bool { return false })
+}
+
+// CollapseToDepth expands the directories shallower than the given depth and collapses the rest, so only the first
+// depth levels of the tree are shown (directories directly beneath the root are at a depth of 1). Files are never
+// collapsed, and the Hidden flag is left untouched.
+func (tree *FileTree) CollapseToDepth(depth int) {
+	tree.setCollapsed(func(nodeDepth int) bool { return nodeDepth >= depth })
+}
+
+// setCollapsed sets the Collapsed flag of every directory from its depth in the tree.
+func (tree *FileTree) setCollapsed(collapsed func(depth int) bool) {
+	var walk func(node *FileNode, depth int)
+	walk = func(node *FileNode, depth int) {
+		for _, child := range node.Children {
+			if child.Data.FileInfo.IsDir() || !child.IsLeaf() {
+				child.SetCollapsed(collapsed(depth + 1))
+			}
+			walk(child, depth+1)
+		}
+	}
+	walk(tree.Root, 0)
+}
+
+// HideDotfiles marks every node whose name starts with a "." (except whiteout markers) as Hidden, along with
+// everything beneath it. The previous visibility of these nodes is kept, so ShowDotfiles can restore it exactly.
+// Resetting the visibility of the whole tree (see ShowAll, SetDiffTypeVisibility) forgets it.
+func (tree *FileTree) HideDotfiles() {
+	if tree.dotfilesHidden != nil {
+		return
+	}
+	tree.dotfilesHidden = make(map[*FileNode]bool)
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		if !strings.HasPrefix(node.Name, ".") || node.IsWhiteout() {
+			return nil
+		}
+		node.VisitDepthParentFirst(func(curNode *FileNode) error {
+			tree.dotfilesHidden[curNode] = curNode.Data.ViewInfo.Hidden
+			curNode.SetHidden(true)
+			return nil
+		}, nil)
+		return SkipSubtree
+	}, nil)
+}
+
+// ShowDotfiles restores the visibility of the nodes hidden by HideDotfiles to what it was before.
+func (tree *FileTree) ShowDotfiles() {
+	for node, hidden := range tree.dotfilesHidden {
+		node.SetHidden(hidden)
+	}
+	tree.dotfilesHidden = nil
+}
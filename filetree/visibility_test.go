@@ -0,0 +1,127 @@
+package filetree
+
+import (
+	"os"
+	"testing"
+)
+
+// hiddenPaths lists the Hidden nodes of a tree, in tree order
+func hiddenPaths(tree *FileTree) []string {
+	paths := []string{}
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		if node.Data.ViewInfo.Hidden {
+			paths = append(paths, node.Path())
+		}
+		return nil
+	}, nil)
+	return paths
+}
+
+func TestHideFilesSmallerThan(t *testing.T) {
+	tree := NewFileTree()
+	sizes := map[string]int64{
+		"/usr/lib/libbig.so":   5000000,
+		"/usr/lib/libsmall.so": 1000,
+		"/etc/hosts":           100,
+		"/etc/ssl/cert.pem":    2000,
+	}
+	for path, size := range sizes {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', LogicalBytes: size})
+	}
+	tree.AddPath("/tmp", FileInfo{Path: "/tmp", TypeFlag: '5', Mode: 0755 | os.ModeDir})
+
+	tree.HideFilesSmallerThan(0)
+	assertPaths(t, "no threshold", []string{}, hiddenPaths(tree))
+
+	tree.HideFilesSmallerThan(1500)
+	assertPaths(t, "1500", []string{"/etc/hosts", "/tmp", "/usr/lib/libsmall.so"}, hiddenPaths(tree))
+
+	tree.HideFilesSmallerThan(1000000)
+	assertPaths(t, "1MB", []string{"/etc", "/etc/hosts", "/etc/ssl", "/etc/ssl/cert.pem", "/tmp", "/usr/lib/libsmall.so"}, hiddenPaths(tree))
+
+	// re-filtering with a lower threshold starts from a visible tree
+	tree.ShowAll()
+	tree.HideFilesSmallerThan(150)
+	assertPaths(t, "150", []string{"/etc/hosts", "/tmp"}, hiddenPaths(tree))
+}
+
+func TestSetDiffTypeVisibility(t *testing.T) {
+	lowerTree := NewFileTree()
+	upperTree := NewFileTree()
+	for _, path := range []string{"/etc/hosts", "/etc/group", "/usr/bin/env"} {
+		lowerTree.AddPath(path, FileInfo{Path: path, TypeFlag: '0', hash: 123, LogicalBytes: 10})
+	}
+	upperTree.AddPath("/etc/hosts", FileInfo{Path: "/etc/hosts", TypeFlag: '0', hash: 456, LogicalBytes: 10})
+	upperTree.AddPath("/etc/ssl/cert.pem", FileInfo{Path: "/etc/ssl/cert.pem", TypeFlag: '0', hash: 123, LogicalBytes: 10})
+	upperTree.AddPath("/usr/bin/.wh.env", FileInfo{})
+	lowerTree.Compare(upperTree)
+	tree := lowerTree
+
+	tree.SetDiffTypeVisibility(map[DiffType]bool{Added: true, Removed: true, Changed: true, Unchanged: true})
+	assertPaths(t, "all", []string{}, hiddenPaths(tree))
+
+	// '/etc' is Changed, but still holds the visible Unchanged file
+	tree.SetDiffTypeVisibility(map[DiffType]bool{Unchanged: true})
+	assertPaths(t, "unchanged", []string{"/etc/hosts", "/etc/ssl", "/etc/ssl/cert.pem", "/usr", "/usr/bin", "/usr/bin/env"}, hiddenPaths(tree))
+
+	tree.SetDiffTypeVisibility(map[DiffType]bool{Added: true})
+	assertPaths(t, "added", []string{"/etc/group", "/etc/hosts", "/usr", "/usr/bin", "/usr/bin/env"}, hiddenPaths(tree))
+
+	tree.SetDiffTypeVisibility(map[DiffType]bool{Removed: true})
+	assertPaths(t, "removed", []string{"/etc", "/etc/group", "/etc/hosts", "/etc/ssl", "/etc/ssl/cert.pem"}, hiddenPaths(tree))
+
+	tree.SetDiffTypeVisibility(map[DiffType]bool{})
+	assertPaths(t, "none", []string{"/etc", "/etc/group", "/etc/hosts", "/etc/ssl", "/etc/ssl/cert.pem", "/usr", "/usr/bin", "/usr/bin/env"}, hiddenPaths(tree))
+}
+
+// collapsedPaths lists the Collapsed nodes of a tree, in tree order
+func collapsedPaths(tree *FileTree) []string {
+	paths := []string{}
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		if node.Data.ViewInfo.Collapsed {
+			paths = append(paths, node.Path())
+		}
+		return nil
+	}, nil)
+	return paths
+}
+
+func TestCollapse(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/usr/lib/x86_64/libc.so", "/usr/bin/env", "/etc/hosts"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	tree.AddPath("/tmp", FileInfo{Path: "/tmp", TypeFlag: '5', Mode: 0755 | os.ModeDir})
+	hosts, _ := tree.GetNode("/etc/hosts")
+	hosts.Data.ViewInfo.Hidden = true
+
+	tree.CollapseAll()
+	assertPaths(t, "collapse all", []string{"/etc", "/tmp", "/usr", "/usr/bin", "/usr/lib", "/usr/lib/x86_64"}, collapsedPaths(tree))
+
+	tree.CollapseToDepth(2)
+	assertPaths(t, "depth 2", []string{"/usr/bin", "/usr/lib", "/usr/lib/x86_64"}, collapsedPaths(tree))
+
+	tree.ExpandAll()
+	assertPaths(t, "expand all", []string{}, collapsedPaths(tree))
+
+	if !hosts.Data.ViewInfo.Hidden {
+		t.Errorf("Expected collapsing to leave the Hidden flag untouched")
+	}
+}
+
+func TestHideDotfiles(t *testing.T) {
+	tree := NewFileTree()
+	for _, path := range []string{"/root/.cache/pip/wheel", "/root/.cache/pip/http", "/root/.bashrc", "/.dockerenv", "/app/main", "/tmp/.wh.old"} {
+		tree.AddPath(path, FileInfo{Path: path, TypeFlag: '0'})
+	}
+	// a node hidden by another filter before the dotfiles are
+	http, _ := tree.GetNode("/root/.cache/pip/http")
+	http.Data.ViewInfo.Hidden = true
+
+	tree.HideDotfiles()
+	expected := []string{"/.dockerenv", "/root/.bashrc", "/root/.cache", "/root/.cache/pip", "/root/.cache/pip/http", "/root/.cache/pip/wheel"}
+	assertPaths(t, "hidden", expected, hiddenPaths(tree))
+
+	tree.ShowDotfiles()
+	assertPaths(t, "shown", []string{"/root/.cache/pip/http"}, hiddenPaths(tree))
+}
@@ -0,0 +1,208 @@
+package filetree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WastedFile is a path that costs more bytes than the final image needs: a file rewritten by later layers, a file
+// deleted while still stored in a lower layer, or content duplicated at other paths (see FindDuplicates).
+type WastedFile struct {
+	Path        string `json:"path"`
+	TotalBytes  int64  `json:"totalBytes"`
+	Occurrences int    `json:"occurrences"`
+	Layers      []int  `json:"layers"`
+}
+
+// WastedFileSlice is a set of WastedFile, ordered by total bytes (largest first, see WastedFiles)
+type WastedFileSlice []WastedFile
+
+// WastedDirectory is a directory holding wasted files (see WastedFileSlice.Directories): the bytes wasted by all the
+// paths beneath it and how many of those paths there are.
+type WastedDirectory struct {
+	Path       string `json:"path"`
+	TotalBytes int64  `json:"totalBytes"`
+	Files      int    `json:"files"`
+}
+
+// WastedDirectorySlice is a set of WastedDirectory, ordered by total bytes (largest first)
+type WastedDirectorySlice []WastedDirectory
+
+// WastedReport gathers the analyses of the space wasted by an image, as exported by ExportWastedJSON: the paths and
+// directories wasting the most space, the files added then removed (see FindChurn), the likely removable paths (see
+// FindRemovable), and the layers only rewriting metadata (see FindMetadataRewrites).
+type WastedReport struct {
+	Files            WastedFileSlice      `json:"files"`
+	Directories      WastedDirectorySlice `json:"directories"`
+	Churn            ChurnSlice           `json:"churn"`
+	Removable        RemovableSlice       `json:"removable"`
+	MetadataRewrites MetadataRewriteSlice `json:"metadataRewrites"`
+}
+
+// WastedFiles ranks the paths of the given trees (layers) by the bytes they cost across the layers, from the results
+// of Efficiency and FindDuplicates for the same trees. A path rewritten or deleted by a layer counts every copy
+// stored for it (a deleted file counting its full size in the lower layer), a path holding content duplicated at
+// another path by a different layer counts the copies stored at that path. Ties are ordered by path.
+func WastedFiles(trees []*FileTree, inefficiencies EfficiencySlice, duplicates DuplicateSlice) WastedFileSlice {
+	layerIndexes := make(map[*FileTree]int)
+	for idx, tree := range trees {
+		layerIndexes[tree] = idx
+	}
+
+	wasted := make(map[string]*WastedFile)
+	layers := make(map[string]map[int]bool)
+	entry := func(path string) *WastedFile {
+		if _, ok := wasted[path]; !ok {
+			wasted[path] = &WastedFile{Path: path}
+			layers[path] = make(map[int]bool)
+		}
+		return wasted[path]
+	}
+
+	for _, data := range inefficiencies {
+		file := entry(data.Path)
+		file.TotalBytes = data.CumulativeSize
+		file.Occurrences = len(data.Nodes)
+		for _, node := range data.Nodes {
+			if idx, ok := layerIndexes[node.Tree]; ok {
+				layers[data.Path][idx] = true
+			}
+		}
+	}
+
+	for _, data := range duplicates {
+		if len(data.Paths()) < 2 {
+			// rewrites of the same path are already covered by the efficiency results
+			continue
+		}
+		for _, occurrence := range data.Occurrences {
+			if layers[occurrence.Path] != nil && layers[occurrence.Path][occurrence.Layer] {
+				continue
+			}
+			file := entry(occurrence.Path)
+			file.TotalBytes += data.Size
+			file.Occurrences++
+			layers[occurrence.Path][occurrence.Layer] = true
+		}
+	}
+
+	files := make(WastedFileSlice, 0, len(wasted))
+	for path, file := range wasted {
+		for idx := range layers[path] {
+			file.Layers = append(file.Layers, idx)
+		}
+		sort.Ints(file.Layers)
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].TotalBytes != files[j].TotalBytes {
+			return files[i].TotalBytes > files[j].TotalBytes
+		}
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// Top returns the first (largest) n files, or all files when n is negative.
+func (files WastedFileSlice) Top(n int) WastedFileSlice {
+	if n < 0 || n >= len(files) {
+		return files
+	}
+	return files[:n]
+}
+
+// Directories rolls the wasted bytes of the files up the directory hierarchy. A path beneath another path of the set
+// (e.g. the files of a deleted directory) is already counted by that path and is skipped. A directory whose wasted
+// bytes all come from a single subdirectory is left out, so the deepest directory holding the waste is reported
+// instead of each of its parents, the root directory (the total of the files) is left out as well. Ties are ordered by path.
+func (files WastedFileSlice) Directories() WastedDirectorySlice {
+	listed := make(map[string]bool)
+	for _, file := range files {
+		listed[file.Path] = true
+	}
+
+	directories := make(map[string]*WastedDirectory)
+	children := make(map[string]map[string]bool)
+	for _, file := range files {
+		covered := false
+		for dir := path.Dir(file.Path); dir != "/"; dir = path.Dir(dir) {
+			if listed[dir] {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+
+		child := file.Path
+		for dir := path.Dir(file.Path); ; dir = path.Dir(dir) {
+			if _, ok := directories[dir]; !ok {
+				directories[dir] = &WastedDirectory{Path: dir}
+				children[dir] = make(map[string]bool)
+			}
+			directories[dir].TotalBytes += file.TotalBytes
+			directories[dir].Files++
+			children[dir][child] = true
+			if dir == "/" {
+				break
+			}
+			child = dir
+		}
+	}
+
+	result := make(WastedDirectorySlice, 0, len(directories))
+	for dir, data := range directories {
+		if len(children[dir]) == 1 {
+			for child := range children[dir] {
+				if sub, ok := directories[child]; ok && sub.TotalBytes == data.TotalBytes {
+					data = nil
+				}
+			}
+		}
+		if data != nil && dir != "/" {
+			result = append(result, *data)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalBytes != result[j].TotalBytes {
+			return result[i].TotalBytes > result[j].TotalBytes
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// Top returns the first (largest) n directories, or all directories when n is negative.
+func (directories WastedDirectorySlice) Top(n int) WastedDirectorySlice {
+	if n < 0 || n >= len(directories) {
+		return directories
+	}
+	return directories[:n]
+}
+
+// ExportWastedJSON writes the (indented) JSON representation of the given report to the given writer.
+func ExportWastedJSON(writer io.Writer, report WastedReport) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// ParseWastedLimit returns the number of wasted files to report from a count or "all" (any number of files, returned
+// as -1).
+func ParseWastedLimit(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "all") {
+		return -1, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid wasted file limit '%s' (expected a count or 'all')", value)
+	}
+	return limit, nil
+}
@@ -0,0 +1,95 @@
+package filetree
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWastedFiles(t *testing.T) {
+	trees := []*FileTree{
+		treeFromTar(t, []*tar.Header{
+			{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "tmp/archive.tgz", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/lib.so", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "app/unique", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"etc/config": "version=1", "tmp/archive.tgz": "a large download", "app/lib.so": "lib", "app/unique": "unique"}),
+		treeFromTar(t, []*tar.Header{
+			{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "tmp/.wh.archive.tgz", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "copy/lib.so", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"etc/config": "version=22", "copy/lib.so": "lib"}),
+	}
+
+	_, inefficiencies := Efficiency(trees)
+	wasted := WastedFiles(trees, inefficiencies, FindDuplicates(trees))
+
+	expected := WastedFileSlice{
+		{Path: "/etc/config", TotalBytes: 19, Occurrences: 2, Layers: []int{0, 1}},
+		{Path: "/tmp/archive.tgz", TotalBytes: 16, Occurrences: 2, Layers: []int{0, 1}},
+		{Path: "/app/lib.so", TotalBytes: 3, Occurrences: 1, Layers: []int{0}},
+		{Path: "/copy/lib.so", TotalBytes: 3, Occurrences: 1, Layers: []int{1}},
+	}
+	if !reflect.DeepEqual(wasted, expected) {
+		t.Errorf("unexpected wasted files:\n%+v\nexpected:\n%+v", wasted, expected)
+	}
+
+	if top := wasted.Top(2); len(top) != 2 || top[0].Path != "/etc/config" {
+		t.Errorf("unexpected top files: %+v", top)
+	}
+	if all := wasted.Top(-1); len(all) != len(wasted) {
+		t.Errorf("expected all %d files, got %d", len(wasted), len(all))
+	}
+
+	var buf bytes.Buffer
+	churn := ChurnSlice{{Path: "/tmp/build.log", Size: 10, AddedLayer: 0, RemovedLayer: 1}}
+	if err := ExportWastedJSON(&buf, WastedReport{Files: wasted.Top(1), Churn: churn}); err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"path": "/etc/config"`) || !strings.Contains(buf.String(), `"totalBytes": 19`) {
+		t.Errorf("unexpected export: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"path": "/tmp/build.log"`) || !strings.Contains(buf.String(), `"removedLayer": 1`) {
+		t.Errorf("unexpected export: %s", buf.String())
+	}
+}
+
+func TestParseWastedLimit(t *testing.T) {
+	cases := map[string]int{"10": 10, "0": 0, "all": -1, " ALL ": -1}
+	for value, expected := range cases {
+		limit, err := ParseWastedLimit(value)
+		if err != nil || limit != expected {
+			t.Errorf("%q: expected %d, got %d (%v)", value, expected, limit, err)
+		}
+	}
+	for _, value := range []string{"", "-1", "some"} {
+		if _, err := ParseWastedLimit(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestWastedDirectories(t *testing.T) {
+	files := WastedFileSlice{
+		{Path: "/var/lib/apt/lists/main", TotalBytes: 400},
+		{Path: "/var/lib/apt/lists/universe", TotalBytes: 300},
+		{Path: "/root/.cache", TotalBytes: 500},
+		{Path: "/root/.cache/pip/wheel", TotalBytes: 200},
+		{Path: "/root/.bashrc", TotalBytes: 10},
+		{Path: "/etc/config", TotalBytes: 20},
+	}
+
+	expected := WastedDirectorySlice{
+		{Path: "/var/lib/apt/lists", TotalBytes: 700, Files: 2},
+		{Path: "/root", TotalBytes: 510, Files: 2},
+		{Path: "/etc", TotalBytes: 20, Files: 1},
+	}
+	if dirs := files.Directories(); !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("unexpected directories:\n%+v\nexpected:\n%+v", dirs, expected)
+	}
+	if top := files.Directories().Top(1); len(top) != 1 || top[0].Path != "/var/lib/apt/lists" {
+		t.Errorf("unexpected top directories: %+v", top)
+	}
+}
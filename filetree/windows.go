@@ -0,0 +1,77 @@
+package filetree
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WindowsHivesDir is the top-level directory holding the registry hive deltas of a Windows layer (see
+	// WindowsLayerTree), apart from the container filesystem
+	WindowsHivesDir = "[Hives]"
+	// WindowsUtilityVMDir is the top-level directory holding the utility VM of a Windows base layer (see
+	// WindowsLayerTree), apart from the container filesystem
+	WindowsUtilityVMDir = "[UtilityVM]"
+)
+
+// windowsLayerPath maps the path of an entry of a Windows layer to its path in the tree of the layer (see
+// WindowsLayerTree), empty for the Files directory itself (the root).
+func windowsLayerPath(path string) string {
+	names := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	var rest string
+	if len(names) > 1 {
+		rest = names[1]
+	}
+	switch strings.ToLower(names[0]) {
+	case "files":
+		return rest
+	case "hives":
+		return strings.TrimSuffix(WindowsHivesDir+"/"+rest, "/")
+	case "utilityvm":
+		return strings.TrimSuffix(WindowsUtilityVMDir+"/"+rest, "/")
+	}
+	return strings.Trim(path, "/")
+}
+
+// WindowsLayerTree returns the tree of a Windows layer (read from its tar, like any other layer) as the container
+// sees it: Windows layers hold the container filesystem under Files/, the registry hive deltas under Hives/, and the
+// utility VM of base layers under UtilityVM/. The contents of Files/ become the root of the returned tree, the hives
+// and the utility VM are grouped under WindowsHivesDir and WindowsUtilityVMDir. Every entry is kept, so the sizes of
+// the layer do not change.
+func WindowsLayerTree(tree *FileTree) *FileTree {
+	relocated := NewFileTree()
+	relocated.Name = tree.Name
+	relocated.HashAlgorithm = tree.HashAlgorithm
+	relocated.FileSize = tree.FileSize
+	relocated.BlobSize = tree.BlobSize
+	relocated.ContentSize = tree.ContentSize
+	relocated.DuplicateEntries = tree.DuplicateEntries
+	relocated.UnreadableEntries = tree.UnreadableEntries
+	relocated.SortOrder = tree.SortOrder
+	relocated.CompressChains = tree.CompressChains
+	relocated.Root.opaque = tree.Root.opaque
+
+	tree.VisitDepthParentFirst(func(node *FileNode) error {
+		// the path of whiteouts drops their prefix, which must be kept
+		path := windowsLayerPath(strings.TrimSuffix(node.Parent.Path(), "/") + "/" + node.Name)
+		if path == "" {
+			relocated.Root.opaque = relocated.Root.opaque || node.opaque
+			return nil
+		}
+		info := node.Data.FileInfo
+		if !node.isImplicitDir() {
+			info.Path = path
+		} else {
+			info = implicitDirInfo(path)
+		}
+		newNode, err := relocated.AddPath(path, info)
+		if err != nil {
+			logrus.Debugf("could not relocate windows layer entry '%s': %v", node.Path(), err)
+			return nil
+		}
+		newNode.opaque = node.opaque
+		return nil
+	}, nil)
+	return relocated
+}
@@ -0,0 +1,55 @@
+package filetree
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestWindowsLayerTree(t *testing.T) {
+	layer := treeFromTar(t, []*tar.Header{
+		{Name: "Files/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "Files/Windows/System32/cmd.exe", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "Files/Program Files/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "Files/Program Files/app/app.exe", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "Files/Users/.wh.old", Typeflag: tar.TypeReg},
+		{Name: "Hives/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "Hives/SOFTWARE_Delta", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "UtilityVM/Files/EFI/boot.efi", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{
+		"Files/Windows/System32/cmd.exe":  "cmd",
+		"Files/Program Files/app/app.exe": "application",
+		"Hives/SOFTWARE_Delta":            "hive",
+		"UtilityVM/Files/EFI/boot.efi":    "efi",
+	})
+	layer.Name = "layer"
+
+	relocated := WindowsLayerTree(layer)
+	for _, path := range []string{
+		"/Windows/System32/cmd.exe",
+		"/Program Files/app/app.exe",
+		"/Users/.wh.old",
+		"/" + WindowsHivesDir + "/SOFTWARE_Delta",
+		"/" + WindowsUtilityVMDir + "/Files/EFI/boot.efi",
+	} {
+		if node, err := relocated.GetNode(path); err != nil || node == nil {
+			t.Errorf("expected %s in the relocated tree: %v", path, err)
+		}
+	}
+	for _, path := range []string{"/Files", "/Hives", "/UtilityVM"} {
+		if node, _ := relocated.GetNode(path); node != nil {
+			t.Errorf("expected no %s in the relocated tree", path)
+		}
+	}
+	if relocated.Name != "layer" || relocated.EntryStats().Bytes != layer.EntryStats().Bytes {
+		t.Errorf("expected the name and sizes of the layer to be kept, got %q and %d bytes (expected %d)", relocated.Name, relocated.EntryStats().Bytes, layer.EntryStats().Bytes)
+	}
+
+	// stacking a layer deleting a file of the one below
+	upper := WindowsLayerTree(treeFromTar(t, []*tar.Header{
+		{Name: "Files/Program Files/app/.wh.app.exe", Typeflag: tar.TypeReg},
+	}, nil))
+	stacked := StackRange([]*FileTree{relocated, upper}, 0, 1)
+	if node, _ := stacked.GetNode("/Program Files/app/app.exe"); node != nil {
+		t.Error("expected the whiteout to remove the file")
+	}
+}
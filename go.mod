@@ -13,6 +13,7 @@ require (
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/jroimartin/gocui v0.4.0
 	github.com/k0kubun/go-ansi v0.0.0-20180517002512-3bf9e2903213
+	github.com/klauspost/compress v1.17.9
 	github.com/lunixbochs/vtclean v0.0.0-20180621232353-2d01aacdc34a
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
@@ -28,4 +29,5 @@ require (
 	github.com/spf13/viper v1.2.1
 	github.com/wagoodman/jotframe v0.0.0-20181125020952-719d4625b8fa
 	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,519 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+)
+
+// maxArchiveLinks is the number of links followed to resolve a layer entry of an archive, before giving up on a
+// link cycle
+const maxArchiveLinks = 16
+
+// stdinRef is the image argument reading an image archive from stdin (e.g. "docker save app | dive -")
+const stdinRef = "-"
+
+// streamMemoryLimit is the size up to which the entries of an archive stream kept for later (e.g. manifests and
+// configs) are held in memory, larger ones are spilled to a temporary file (see readDockerArchiveStream)
+const streamMemoryLimit = 4 << 20
+
+// archiveSniffSize is the number of bytes of an archive entry looked at to recognize a layer tar, which holds a tar
+// header
+const archiveSniffSize = 512
+
+// archiveEntry is an entry of an image archive, as found by scanning it
+type archiveEntry struct {
+	header *tar.Header
+	// index is the position of the entry within the archive (of its last occurrence, when repeated)
+	index int
+}
+
+// readDockerArchive reads an image saved with "docker save" (both the legacy "<id>/layer.tar" layout and the
+// "blobs/sha256/<digest>" layout of recent Docker versions), and passes each of its layers to the given reader.
+// Layers stored as links to other entries are resolved, and a layer referenced several times is read once. The
+// archive is read twice: once to find its entries and manifest, then to read the config and layers. Returns the
+// manifest of the first image (listing the layers by the entry holding them) and its config. Archives holding the
+// index of a multi-platform image (or only an index, like OCI layout archives) are read through the index, selecting
+// the given platform.
+func readDockerArchive(archive io.ReadSeeker, platform string, readLayer ociLayerReader) (ImageManifest, []byte, error) {
+	var manifest ImageManifest
+	entries := make(map[string]archiveEntry)
+	var manifestBytes, indexBytes []byte
+
+	reader := tar.NewReader(archive)
+	for index := 0; ; index++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read the archive: %v", err)
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		entries[name] = archiveEntry{header, index}
+		switch name {
+		case "manifest.json":
+			if manifestBytes, err = ioutil.ReadAll(reader); err != nil {
+				return manifest, nil, fmt.Errorf("could not read manifest.json: %v", err)
+			}
+		case "index.json":
+			if indexBytes, err = ioutil.ReadAll(reader); err != nil {
+				return manifest, nil, fmt.Errorf("could not read index.json: %v", err)
+			}
+		}
+	}
+
+	manifest, configEntry, err := resolveArchiveManifest(entries, manifestBytes, indexBytes, platform, func(entry archiveEntry) ([]byte, error) {
+		return readArchiveEntry(archive, entry)
+	})
+	if err != nil {
+		return manifest, nil, err
+	}
+	// the layer entries to read, by their position in the archive
+	layerEntries := make(map[int]string)
+	for _, layerPath := range manifest.LayerTarPaths {
+		layerEntries[entries[layerPath].index] = layerPath
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return manifest, nil, err
+	}
+	var configBytes []byte
+	reader = tar.NewReader(archive)
+	for index := 0; ; index++ {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read the archive: %v", err)
+		}
+		if index == configEntry.index {
+			if configBytes, err = ioutil.ReadAll(reader); err != nil {
+				return manifest, nil, fmt.Errorf("could not read the image config: %v", err)
+			}
+		}
+		if name, ok := layerEntries[index]; ok {
+			if err := readArchiveLayer(name, reader, readLayer); err != nil {
+				return manifest, nil, fmt.Errorf("could not read layer %s: %v", name, err)
+			}
+		}
+	}
+	return manifest, configBytes, nil
+}
+
+// readDockerArchiveStream reads an image archive (see readDockerArchive) from a stream, in a single pass. Layers are
+// read as their entries stream by: until the manifest is known (it comes last in "docker save" archives), every
+// entry holding a tar (compressed or not) is read as a layer, then only the layers of the image. Other entries are
+// kept for when the manifest is known, in memory up to streamMemoryLimit bytes, spilled to a temporary file (removed
+// before returning) otherwise.
+func readDockerArchiveStream(stream io.Reader, platform string, readLayer ociLayerReader) (ImageManifest, []byte, error) {
+	var manifest ImageManifest
+	entries := make(map[string]archiveEntry)
+	var manifestBytes, indexBytes []byte
+	kept := newArchiveSpill()
+	defer kept.remove()
+	readEntry := func(entry archiveEntry) ([]byte, error) {
+		return kept.read(path.Clean(strings.TrimPrefix(entry.header.Name, "/")))
+	}
+	// the layers read so far, and the entries holding those of the image once its manifest is known
+	read := make(map[string]bool)
+	var selected *ImageManifest
+	var planned map[string]bool
+
+	reader := tar.NewReader(stream)
+	for index := 0; ; index++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read the archive: %v", err)
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		entries[name] = archiveEntry{header, index}
+		if selected != nil {
+			// links seen so far may point to layers yet to come
+			planned = plannedArchiveLayers(entries, selected.LayerTarPaths)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		contents := bufio.NewReaderSize(reader, archiveSniffSize)
+		layer := planned[name]
+		if planned == nil {
+			layer = sniffLayerTar(contents)
+		} else if !layer && sniffLayerTar(contents) {
+			// a layer of another image (or platform)
+			continue
+		}
+		if layer {
+			if err := readArchiveLayer(name, contents, readLayer); err != nil {
+				return manifest, nil, fmt.Errorf("could not read layer %s: %v", name, err)
+			}
+			read[name] = true
+			continue
+		}
+		if err := kept.keep(name, header.Size, contents); err != nil {
+			return manifest, nil, fmt.Errorf("could not keep %s of the archive: %v", name, err)
+		}
+
+		switch name {
+		case "manifest.json":
+			manifestBytes, err = kept.read(name)
+		case "index.json":
+			indexBytes, err = kept.read(name)
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read %s: %v", name, err)
+		}
+		if selected == nil && (manifestBytes != nil || indexBytes != nil) {
+			// the manifests of an index may be yet to come
+			if imageManifest, err := selectArchiveManifest(entries, manifestBytes, indexBytes, platform, readEntry); err == nil {
+				selected = &imageManifest
+				planned = plannedArchiveLayers(entries, selected.LayerTarPaths)
+			}
+		}
+	}
+
+	manifest, configEntry, err := resolveArchiveManifest(entries, manifestBytes, indexBytes, platform, readEntry)
+	if err != nil {
+		return manifest, nil, err
+	}
+	configBytes, err := readEntry(configEntry)
+	if err != nil {
+		return manifest, nil, fmt.Errorf("could not read the image config: %v", err)
+	}
+	// layers that were not recognized as they streamed by (e.g. empty ones)
+	for _, name := range manifest.LayerTarPaths {
+		if read[name] {
+			continue
+		}
+		contents, err := kept.open(name)
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read layer %s: %v", name, err)
+		}
+		err = readArchiveLayer(name, contents, readLayer)
+		contents.Close()
+		if err != nil {
+			return manifest, nil, fmt.Errorf("could not read layer %s: %v", name, err)
+		}
+		read[name] = true
+	}
+	return manifest, configBytes, nil
+}
+
+// sniffLayerTar indicates if the given entry contents hold a layer tar (compressed or not), without consuming them.
+func sniffLayerTar(contents *bufio.Reader) bool {
+	magic, _ := contents.Peek(archiveSniffSize)
+	return bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic) ||
+		(len(magic) >= 262 && string(magic[257:262]) == "ustar")
+}
+
+// archiveSpill keeps the entries of an archive stream that may be needed once it is read, in memory when they are
+// small and in temporary files otherwise
+type archiveSpill struct {
+	memory map[string][]byte
+	files  map[string]string
+	dir    string
+}
+
+func newArchiveSpill() *archiveSpill {
+	return &archiveSpill{
+		memory: make(map[string][]byte),
+		files:  make(map[string]string),
+	}
+}
+
+// keep stores the contents of the entry with the given name and size.
+func (spill *archiveSpill) keep(name string, size int64, contents io.Reader) error {
+	if size <= streamMemoryLimit {
+		data, err := ioutil.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+		spill.memory[name] = data
+		return nil
+	}
+
+	if spill.dir == "" {
+		dir, err := ioutil.TempDir("", "dive-stream-")
+		if err != nil {
+			return err
+		}
+		spill.dir = dir
+	}
+	file, err := ioutil.TempFile(spill.dir, "entry-")
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("spilling %s of the archive (%d bytes) to %s", name, size, file.Name())
+	_, err = io.Copy(file, contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	delete(spill.memory, name)
+	spill.files[name] = file.Name()
+	return nil
+}
+
+// open returns the contents of the kept entry with the given name.
+func (spill *archiveSpill) open(name string) (io.ReadCloser, error) {
+	if data, ok := spill.memory[name]; ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	if file, ok := spill.files[name]; ok {
+		return os.Open(file)
+	}
+	return nil, fmt.Errorf("the archive has no entry '%s' left to read", name)
+}
+
+// read returns the contents of the kept entry with the given name.
+func (spill *archiveSpill) read(name string) ([]byte, error) {
+	contents, err := spill.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+	return ioutil.ReadAll(contents)
+}
+
+// remove deletes the temporary files of the spilled entries.
+func (spill *archiveSpill) remove() {
+	if spill.dir != "" {
+		os.RemoveAll(spill.dir)
+		spill.dir = ""
+	}
+}
+
+// resolveArchiveManifest returns the manifest of the image of an archive (see readDockerArchive) from its entries and
+// the contents of its manifest.json and index.json (nil when missing), reading other entries (e.g. the manifests of
+// an index) with the given function. The layers of the manifest are listed by the (clean) name of the entry holding
+// them, links resolved, and the entry holding the config is returned along with it.
+func resolveArchiveManifest(entries map[string]archiveEntry, manifestBytes, indexBytes []byte, platform string, readEntry func(archiveEntry) ([]byte, error)) (ImageManifest, archiveEntry, error) {
+	manifest, err := selectArchiveManifest(entries, manifestBytes, indexBytes, platform, readEntry)
+	if err != nil {
+		return manifest, archiveEntry{}, err
+	}
+	configEntry, err := resolveArchiveEntry(entries, manifest.ConfigPath)
+	if err != nil {
+		return manifest, archiveEntry{}, err
+	}
+	layerPaths := make([]string, len(manifest.LayerTarPaths))
+	for idx, layerPath := range manifest.LayerTarPaths {
+		entry, err := resolveArchiveEntry(entries, layerPath)
+		if err != nil {
+			return manifest, archiveEntry{}, err
+		}
+		layerPaths[idx] = path.Clean(strings.TrimPrefix(entry.header.Name, "/"))
+	}
+	manifest.LayerTarPaths = layerPaths
+	return manifest, configEntry, nil
+}
+
+// selectArchiveManifest returns the manifest of the image of an archive (see resolveArchiveManifest), listing its
+// config and layers by the paths it names (which may be links).
+func selectArchiveManifest(entries map[string]archiveEntry, manifestBytes, indexBytes []byte, platform string, readEntry func(archiveEntry) ([]byte, error)) (ImageManifest, error) {
+	var manifest ImageManifest
+	var err error
+	var fromIndex bool
+	if indexBytes != nil {
+		// the platform of a multi-platform image must be found, falling back to manifest.json is only for other indexes
+		manifest, fromIndex, err = readArchiveIndex(entries, indexBytes, platform, readEntry)
+		if err != nil && (fromIndex || manifestBytes == nil) {
+			return manifest, err
+		}
+	}
+	if fromIndex {
+		return manifest, nil
+	}
+	if manifestBytes == nil {
+		return manifest, fmt.Errorf("not an image archive (no manifest.json)")
+	}
+	var manifests []ImageManifest
+	if err := json.Unmarshal(manifestBytes, &manifests); err != nil {
+		return manifest, fmt.Errorf("could not parse manifest.json: %v", err)
+	}
+	if len(manifests) == 0 {
+		return manifest, fmt.Errorf("the archive holds no image")
+	}
+	return manifests[0], nil
+}
+
+// readArchiveIndex selects the image of the given platform through the index of an archive, returning its manifest
+// (listing the config and layers by their blob entries). The index is only used when it is that of a multi-platform
+// image or the archive has no manifest.json, which is indicated as well (also along with errors).
+func readArchiveIndex(entries map[string]archiveEntry, indexBytes []byte, platform string, readEntry func(archiveEntry) ([]byte, error)) (ImageManifest, bool, error) {
+	var manifest ImageManifest
+	var index ociIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return manifest, false, fmt.Errorf("could not parse index.json: %v", err)
+	}
+
+	imageManifest, multiPlatform, err := resolveOCIImage(index, "", platform, func(digest string) ([]byte, error) {
+		name, err := archiveBlobPath(digest)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := resolveArchiveEntry(entries, name)
+		if err != nil {
+			return nil, fmt.Errorf("the archive does not hold blob %s (was the image saved for platform '%s'?)", digest, platform)
+		}
+		return readEntry(entry)
+	})
+	if err != nil {
+		return manifest, multiPlatform, err
+	}
+	if !multiPlatform && entries["manifest.json"].header != nil {
+		return manifest, false, nil
+	}
+
+	if manifest.ConfigPath, err = archiveBlobPath(imageManifest.Config.Digest); err != nil {
+		return manifest, false, err
+	}
+	for _, layer := range imageManifest.Layers {
+		layerPath, err := archiveBlobPath(layer.Digest)
+		if err != nil {
+			return manifest, false, err
+		}
+		manifest.LayerTarPaths = append(manifest.LayerTarPaths, layerPath)
+		manifest.LayerSizes = append(manifest.LayerSizes, layer.Size)
+	}
+	return manifest, true, nil
+}
+
+// archiveBlobPath returns the path of the blob with the given digest within an archive.
+func archiveBlobPath(digest string) (string, error) {
+	if !ociDigestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+	return "blobs/" + strings.Replace(digest, ":", "/", 1), nil
+}
+
+// readArchiveEntry reads the contents of the given entry of an archive.
+func readArchiveEntry(archive io.ReadSeeker, entry archiveEntry) ([]byte, error) {
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := tar.NewReader(archive)
+	for index := 0; index <= entry.index; index++ {
+		if _, err := reader.Next(); err != nil {
+			return nil, fmt.Errorf("could not read the archive: %v", err)
+		}
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// resolveArchiveEntry returns the entry holding the contents of the given path of an archive, following symlinks
+// (relative to the directory of the link) and hardlinks.
+func resolveArchiveEntry(entries map[string]archiveEntry, name string) (archiveEntry, error) {
+	current := path.Clean(strings.TrimPrefix(name, "/"))
+	for links := 0; links <= maxArchiveLinks; links++ {
+		entry, ok := entries[current]
+		if !ok {
+			return entry, fmt.Errorf("the archive has no entry '%s'", current)
+		}
+		switch entry.header.Typeflag {
+		case tar.TypeSymlink, tar.TypeLink:
+			current = archiveLinkTarget(current, entry.header)
+		case tar.TypeReg, tar.TypeRegA:
+			return entry, nil
+		default:
+			return entry, fmt.Errorf("the archive entry '%s' is not a file", current)
+		}
+	}
+	return archiveEntry{}, fmt.Errorf("too many links resolving '%s'", name)
+}
+
+// archiveLinkTarget returns the path of the entry the given link entry (with the given clean name) points to: symlinks
+// are relative to the directory of the link, hardlinks to the root of the archive.
+func archiveLinkTarget(name string, header *tar.Header) string {
+	if header.Typeflag == tar.TypeSymlink && !path.IsAbs(header.Linkname) {
+		return path.Join(path.Dir(name), header.Linkname)
+	}
+	return path.Clean(strings.TrimPrefix(header.Linkname, "/"))
+}
+
+// plannedArchiveLayers returns the names of the entries that may hold the given layers of an archive stream, following
+// the links among the entries seen so far (the layer itself may still be to come).
+func plannedArchiveLayers(entries map[string]archiveEntry, layerPaths []string) map[string]bool {
+	planned := make(map[string]bool)
+	for _, layerPath := range layerPaths {
+		current := path.Clean(strings.TrimPrefix(layerPath, "/"))
+		for links := 0; links <= maxArchiveLinks; links++ {
+			planned[current] = true
+			entry, ok := entries[current]
+			if !ok || (entry.header.Typeflag != tar.TypeSymlink && entry.header.Typeflag != tar.TypeLink) {
+				break
+			}
+			current = archiveLinkTarget(current, entry.header)
+		}
+	}
+	return planned
+}
+
+// readArchiveLayer passes the layer tar read from the given reader to the layer reader, decompressing it first
+// should it be compressed.
+func readArchiveLayer(name string, reader io.Reader, readLayer ociLayerReader) error {
+	return readLayer(name, func() (io.ReadCloser, error) {
+		blob := &blobCounter{Reader: reader}
+		stream, err := sniffLayer(blob)
+		if err != nil {
+			return nil, err
+		}
+		return layerStream{stream, nil, blob}, nil
+	})
+}
+
+// fetchArchiveImage reads an image saved with "docker save" from the given file, returning the image manifest and
+// config along with the tree of every layer (by the archive entry holding it).
+func fetchArchiveImage(archivePath, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		logrus.Error("Could not open the image archive: " + err.Error())
+		utils.Exit(1)
+	}
+	defer file.Close()
+
+	// layers are read from the archive as it is scanned, one at a time
+	manifest, configBytes, layerMap, err := readImageLayers(1, func(readLayer ociLayerReader) (ImageManifest, []byte, error) {
+		return readDockerArchive(file, platform, readLayer)
+	})
+	if err != nil {
+		logrus.Error("Could not read the image archive '" + archivePath + "': " + err.Error())
+		utils.Exit(1)
+	}
+	return manifest, NewImageConfig(configBytes), layerMap
+}
+
+// fetchArchiveStream reads an image archive (of "docker save", or of an OCI layout) from the given stream, returning
+// the image manifest and config along with the tree of every layer (by the archive entry holding it).
+func fetchArchiveStream(stream *os.File, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	if info, err := stream.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		logrus.Error("Could not read the image archive from stdin: it is a terminal (pipe an archive, e.g. docker save <image> | dive -)")
+		utils.Exit(1)
+	}
+	manifest, configBytes, layerMap, err := readImageLayers(1, func(readLayer ociLayerReader) (ImageManifest, []byte, error) {
+		return readDockerArchiveStream(stream, platform, readLayer)
+	})
+	if err != nil {
+		logrus.Error("Could not read the image archive from stdin: " + err.Error())
+		utils.Exit(1)
+	}
+	return manifest, NewImageConfig(configBytes), layerMap
+}
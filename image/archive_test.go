@@ -0,0 +1,286 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveFile is an entry of a test archive, a link when linkname is set
+type archiveFile struct {
+	name, linkname string
+	typeflag       byte
+	contents       []byte
+}
+
+func layerTar(files ...string) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range files {
+		writer.WriteHeader(&tar.Header{Name: file, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file))})
+		writer.Write([]byte(file))
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func zstdLayerTar(files ...string) []byte {
+	var compressed bytes.Buffer
+	encoder, _ := zstd.NewWriter(&compressed)
+	encoder.Write(layerTar(files...))
+	encoder.Close()
+	return compressed.Bytes()
+}
+
+func testArchive(t *testing.T, files ...archiveFile) *bytes.Reader {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Typeflag: file.typeflag, Linkname: file.linkname, Mode: 0644, Size: int64(len(file.contents))}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("could not write the archive: %v", err)
+		}
+		writer.Write(file.contents)
+	}
+	writer.Close()
+	return bytes.NewReader(buf.Bytes())
+}
+
+func archiveManifest(config string, layers ...string) []byte {
+	data, _ := json.Marshal([]ImageManifest{{ConfigPath: config, RepoTags: []string{"app:latest"}, LayerTarPaths: layers}})
+	return data
+}
+
+func readTestArchive(archive *bytes.Reader) ([][]string, ImageManifest, []byte, error) {
+	return readTestArchivePlatform(archive, "linux/amd64")
+}
+
+func readTestArchivePlatform(archive *bytes.Reader, platform string) ([][]string, ImageManifest, []byte, error) {
+	var layers [][]string
+	manifest, config, err := readDockerArchive(archive, platform, func(name string, open layerOpener) error {
+		files, err := openFileList(open)
+		paths := []string{name}
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths[1:])
+		layers = append(layers, paths)
+		return err
+	})
+	return layers, manifest, config, err
+}
+
+func TestReadDockerArchive(t *testing.T) {
+	config := []byte(`{"rootfs": {"diff_ids": ["a", "b", "c"]}}`)
+
+	// legacy layout, where a layer identical to an earlier one is a relative symlink to it
+	legacy := testArchive(t,
+		archiveFile{name: "aaaa/layer.tar", typeflag: tar.TypeReg, contents: layerTar("etc/os-release")},
+		archiveFile{name: "bbbb/layer.tar", typeflag: tar.TypeReg, contents: layerTar("app/v1")},
+		archiveFile{name: "cccc/layer.tar", typeflag: tar.TypeSymlink, linkname: "../aaaa/layer.tar"},
+		archiveFile{name: "cccc.json", typeflag: tar.TypeReg, contents: config},
+		archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("cccc.json", "aaaa/layer.tar", "bbbb/layer.tar", "cccc/layer.tar")},
+	)
+	layers, manifest, configBytes, err := readTestArchive(legacy)
+	if err != nil {
+		t.Fatalf("could not read the legacy archive: %v", err)
+	}
+	expected := [][]string{{"aaaa/layer.tar", "etc/os-release"}, {"bbbb/layer.tar", "app/v1"}}
+	if !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected layers %v, got %v", expected, layers)
+	}
+	if paths := []string{"aaaa/layer.tar", "bbbb/layer.tar", "aaaa/layer.tar"}; !reflect.DeepEqual(manifest.LayerTarPaths, paths) {
+		t.Errorf("expected layer paths %v, got %v", paths, manifest.LayerTarPaths)
+	}
+	if !bytes.Equal(configBytes, config) {
+		t.Errorf("expected the config, got %q", configBytes)
+	}
+
+	// blobs layout, with the manifest first, a (zstd compressed) layer referenced twice, and a repeated entry (the
+	// last one wins)
+	blobs := testArchive(t,
+		archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("blobs/sha256/cfg", "blobs/sha256/base", "blobs/sha256/app", "blobs/sha256/base")},
+		archiveFile{name: "blobs/sha256/app", typeflag: tar.TypeReg, contents: layerTar("app/old")},
+		archiveFile{name: "blobs/sha256/base", typeflag: tar.TypeReg, contents: zstdLayerTar("bin/sh")},
+		archiveFile{name: "blobs/sha256/cfg", typeflag: tar.TypeReg, contents: config},
+		archiveFile{name: "blobs/sha256/app", typeflag: tar.TypeReg, contents: layerTar("app/new")},
+	)
+	layers, manifest, _, err = readTestArchive(blobs)
+	if err != nil {
+		t.Fatalf("could not read the blobs archive: %v", err)
+	}
+	expected = [][]string{{"blobs/sha256/base", "bin/sh"}, {"blobs/sha256/app", "app/new"}}
+	if !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected layers %v, got %v", expected, layers)
+	}
+	if len(manifest.LayerTarPaths) != 3 || manifest.LayerTarPaths[2] != "blobs/sha256/base" {
+		t.Errorf("expected the repeated layer to be kept, got %v", manifest.LayerTarPaths)
+	}
+
+	// broken archives are reported
+	cases := []struct {
+		name    string
+		archive *bytes.Reader
+		err     string
+	}{
+		{"no manifest", testArchive(t, archiveFile{name: "cfg.json", typeflag: tar.TypeReg, contents: config}), "no manifest.json"},
+		{"missing layer", testArchive(t,
+			archiveFile{name: "cfg.json", typeflag: tar.TypeReg, contents: config},
+			archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("cfg.json", "gone/layer.tar")},
+		), "no entry 'gone/layer.tar'"},
+		{"link cycle", testArchive(t,
+			archiveFile{name: "cfg.json", typeflag: tar.TypeReg, contents: config},
+			archiveFile{name: "a/layer.tar", typeflag: tar.TypeSymlink, linkname: "../b/layer.tar"},
+			archiveFile{name: "b/layer.tar", typeflag: tar.TypeSymlink, linkname: "../a/layer.tar"},
+			archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("cfg.json", "a/layer.tar")},
+		), "too many links"},
+	}
+	for _, test := range cases {
+		if _, _, _, err := readTestArchive(test.archive); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("[%s] expected an error containing '%s', got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+// archiveBlobs collects the blobs of a test archive, by digest
+type archiveBlobs []archiveFile
+
+func (blobs *archiveBlobs) add(mediaType string, data []byte) ociDescriptor {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	*blobs = append(*blobs, archiveFile{name: "blobs/sha256/" + digest[7:], typeflag: tar.TypeReg, contents: data})
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+func (blobs *archiveBlobs) image(app string) ociDescriptor {
+	data, _ := json.Marshal(ociManifest{
+		MediaType: ociManifestMediaType,
+		Config:    blobs.add("application/vnd.oci.image.config.v1+json", []byte(`{"architecture": "`+app+`", "rootfs": {"diff_ids": ["a"]}}`)),
+		Layers:    []ociDescriptor{blobs.add("application/vnd.oci.image.layer.v1.tar", layerTar("app/"+app))},
+	})
+	return blobs.add(ociManifestMediaType, data)
+}
+
+func TestReadDockerArchivePlatforms(t *testing.T) {
+	var blobs archiveBlobs
+	amd64 := platformOf(blobs.image("amd64"), "linux", "amd64", "")
+	arm64 := platformOf(blobs.image("arm64"), "linux", "arm64", "")
+	indexData, _ := json.Marshal(ociIndex{Manifests: []ociDescriptor{amd64, arm64}})
+	index, _ := json.Marshal(ociIndex{Manifests: []ociDescriptor{blobs.add(ociIndexMediaType, indexData)}})
+
+	// the manifest.json of a multi-platform archive only lists the platform that was saved
+	var imageManifest ociManifest
+	json.Unmarshal(blobs[2].contents, &imageManifest)
+	legacy := archiveManifest("blobs/"+strings.Replace(imageManifest.Config.Digest, ":", "/", 1), "blobs/"+strings.Replace(imageManifest.Layers[0].Digest, ":", "/", 1))
+
+	for name, files := range map[string][]archiveFile{
+		"with manifest.json": append(append([]archiveFile{}, blobs...),
+			archiveFile{name: "index.json", typeflag: tar.TypeReg, contents: index},
+			archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: legacy}),
+		"index only": append(append([]archiveFile{}, blobs...),
+			archiveFile{name: "index.json", typeflag: tar.TypeReg, contents: index}),
+	} {
+		for platform, app := range map[string]string{"linux/amd64": "app/amd64", "linux/arm64": "app/arm64"} {
+			layers, _, config, err := readTestArchivePlatform(testArchive(t, files...), platform)
+			if err != nil {
+				t.Fatalf("[%s %s] could not read the archive: %v", name, platform, err)
+			}
+			if len(layers) != 1 || layers[0][1] != app {
+				t.Errorf("[%s %s] expected the %s layer, got %v", name, platform, app, layers)
+			}
+			if !strings.Contains(string(config), app[4:]) {
+				t.Errorf("[%s %s] expected the config of the platform, got %s", name, platform, config)
+			}
+		}
+		if _, _, _, err := readTestArchivePlatform(testArchive(t, files...), "linux/s390x"); err == nil || !strings.Contains(err.Error(), "available: linux/amd64, linux/arm64") {
+			t.Errorf("[%s] expected an error listing the platforms, got: %v", name, err)
+		}
+	}
+}
+
+func readTestArchiveStream(archive *bytes.Reader) ([][]string, ImageManifest, []byte, error) {
+	var layers [][]string
+	manifest, config, err := readDockerArchiveStream(archive, "linux/amd64", func(name string, open layerOpener) error {
+		files, err := openFileList(open)
+		paths := []string{name}
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths[1:])
+		layers = append(layers, paths)
+		return err
+	})
+	return layers, manifest, config, err
+}
+
+func TestReadDockerArchiveStream(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "dive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	// legacy layout, where the manifest comes last: layers are read as they stream by, the empty one (no tar header)
+	// and the config (too large to be held in memory) once the manifest is known
+	config := []byte(`{"rootfs": {"diff_ids": ["a", "b", "c"]}, "padding": "` + strings.Repeat("x", streamMemoryLimit) + `"}`)
+	legacy := testArchive(t,
+		archiveFile{name: "aaaa/layer.tar", typeflag: tar.TypeReg, contents: layerTar("etc/os-release")},
+		archiveFile{name: "bbbb/layer.tar", typeflag: tar.TypeReg, contents: layerTar()},
+		archiveFile{name: "cccc/layer.tar", typeflag: tar.TypeSymlink, linkname: "../aaaa/layer.tar"},
+		archiveFile{name: "cccc.json", typeflag: tar.TypeReg, contents: config},
+		archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("cccc.json", "aaaa/layer.tar", "bbbb/layer.tar", "cccc/layer.tar")},
+	)
+	layers, manifest, configBytes, err := readTestArchiveStream(legacy)
+	if err != nil {
+		t.Fatalf("could not read the legacy archive: %v", err)
+	}
+	expected := [][]string{{"aaaa/layer.tar", "etc/os-release"}, {"bbbb/layer.tar"}}
+	if !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected layers %v, got %v", expected, layers)
+	}
+	if paths := []string{"aaaa/layer.tar", "bbbb/layer.tar", "aaaa/layer.tar"}; !reflect.DeepEqual(manifest.LayerTarPaths, paths) {
+		t.Errorf("expected layer paths %v, got %v", paths, manifest.LayerTarPaths)
+	}
+	if !bytes.Equal(configBytes, config) {
+		t.Errorf("expected the config (%d bytes), got %d bytes", len(config), len(configBytes))
+	}
+	if spilled, _ := ioutil.ReadDir(tmp); len(spilled) != 0 {
+		t.Errorf("expected the spilled entries to be removed, found %d", len(spilled))
+	}
+
+	// blobs layout with the manifest first: layers of other images are skipped
+	blobs := testArchive(t,
+		archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("blobs/sha256/cfg", "blobs/sha256/base")},
+		archiveFile{name: "blobs/sha256/other", typeflag: tar.TypeReg, contents: layerTar("other/app")},
+		archiveFile{name: "blobs/sha256/base", typeflag: tar.TypeReg, contents: zstdLayerTar("bin/sh")},
+		archiveFile{name: "blobs/sha256/cfg", typeflag: tar.TypeReg, contents: []byte(`{"rootfs": {"diff_ids": ["a"]}}`)},
+	)
+	layers, _, _, err = readTestArchiveStream(blobs)
+	if err != nil {
+		t.Fatalf("could not read the blobs archive: %v", err)
+	}
+	expected = [][]string{{"blobs/sha256/base", "bin/sh"}}
+	if !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected layers %v, got %v", expected, layers)
+	}
+
+	// entries that are not layers are not read as such
+	missing := testArchive(t,
+		archiveFile{name: "cfg.json", typeflag: tar.TypeReg, contents: config[:64]},
+		archiveFile{name: "manifest.json", typeflag: tar.TypeReg, contents: archiveManifest("cfg.json", "gone/layer.tar")},
+	)
+	if _, _, _, err := readTestArchiveStream(missing); err == nil || !strings.Contains(err.Error(), "no entry 'gone/layer.tar'") {
+		t.Errorf("expected an error for the missing layer, got: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+	"github.com/wagoodman/jotframe"
+)
+
+// layerCacheVersion is the version of the layer cache files, files of another version are ignored (and replaced)
+const layerCacheVersion = 2
+
+// legacyLayerPattern matches the layer tars of legacy "docker save" archives, named by the (content derived) layer ID
+var legacyLayerPattern = regexp.MustCompile(`^([a-f0-9]{64})/layer\.tar$`)
+
+// layerTreeCache holds the trees of the layers read before, nil when caching is disabled
+var layerTreeCache *layerCache
+
+// layerCache stores the tree of every layer read (encoded with FileTree.Encode) in a directory, by layer digest, so
+// reading an image again only reads its new layers. Once the cache grows over its maximum size, the least recently
+// used trees are evicted.
+type layerCache struct {
+	dir     string
+	maxSize int64
+	// settings describes the settings the trees are built with, trees built with other settings are not used
+	settings string
+	// lock serializes evictions, as layers may be stored concurrently
+	lock sync.Mutex
+}
+
+// configureLayerCache enables the layer cache per the config, exiting on invalid values.
+func configureLayerCache() {
+	layerTreeCache = nil
+	if viper.GetBool("cache.disabled") {
+		return
+	}
+
+	maxSize, err := humanize.ParseBytes(viper.GetString("cache.max-size"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'cache.max-size': " + err.Error())
+		utils.Exit(1)
+	}
+
+	dir := viper.GetString("cache.dir")
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			logrus.Debug("no cache directory, layers are not cached: ", err)
+			return
+		}
+		dir = filepath.Join(base, "dive")
+	}
+
+	layerTreeCache = &layerCache{
+		dir:     dir,
+		maxSize: int64(maxSize),
+		settings: fmt.Sprintf("hash-algorithm=%s max-hash-size=%d metadata-only=%t size-mode=%s ignore=%s",
+			filetree.HashAlgorithm(), viper.GetInt64("filetree.max-hash-size"), viper.GetBool("filetree.metadata-only"),
+			viper.GetString("filetree.size-mode"), strings.Join(filetree.IgnoreGlobs(), ",")),
+	}
+}
+
+// loadLayerTree adds the tree of the given layer to the layer map, from the cache when it holds the layer, otherwise
+// by opening and reading the layer (and caching the result).
+func loadLayerTree(line *jotframe.Line, layerMap map[string]*filetree.FileTree, name string, open layerOpener) error {
+	if tree := layerTreeCache.load(name); tree != nil {
+		tree.Name = name
+		layerMap[name] = tree
+		io.WriteString(line, fmt.Sprintf("    ├─ %s : cached", shortLayerName(name)))
+		line.Close()
+		return nil
+	}
+
+	stream, err := open()
+	if err != nil {
+		line.Close()
+		return err
+	}
+	defer stream.Close()
+	if err := processLayerTar(line, layerMap, name, tar.NewReader(stream)); err != nil {
+		return err
+	}
+	if counted, ok := stream.(layerStream); ok && counted.blob != nil {
+		// the tar ends before the stream does should anything follow its end marker, which still takes space
+		io.Copy(ioutil.Discard, stream)
+		layerMap[name].BlobSize = uint64(counted.blob.bytes)
+	}
+	if layerMap[name].UnreadableEntries == 0 {
+		// a partial tree must not stand in for the layer once errors are no longer ignored
+		layerTreeCache.store(name, layerMap[name])
+	}
+	return nil
+}
+
+// cacheKey returns the path (relative to the cache directory) of the tree of the layer with the given name, which
+// is a digest (e.g. "sha256:..."), the path of a blob ("blobs/sha256/..."), or the path of a legacy layer tar
+// ("<id>/layer.tar"). Layers with other names are not content addressed, so not cached (an empty key is returned).
+func cacheKey(name string) string {
+	if match := legacyLayerPattern.FindStringSubmatch(name); match != nil {
+		return filepath.Join("legacy", match[1])
+	}
+	if strings.HasPrefix(name, "blobs/") {
+		name = strings.Replace(strings.TrimPrefix(name, "blobs/"), "/", ":", 1)
+	}
+	if !ociDigestPattern.MatchString(name) {
+		return ""
+	}
+	parts := strings.SplitN(name, ":", 2)
+	return filepath.Join(parts[0], parts[1])
+}
+
+// header is the first line of the cache files, identifying the version and settings the trees were built with
+func (cache *layerCache) header() string {
+	return fmt.Sprintf("dive layer cache %d %s\n", layerCacheVersion, cache.settings)
+}
+
+// load returns the cached tree of the given layer, nil when it is not in the cache (or cannot be read).
+func (cache *layerCache) load(name string) *filetree.FileTree {
+	key := cacheKey(name)
+	if cache == nil || key == "" {
+		return nil
+	}
+	path := filepath.Join(cache.dir, key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header, err := reader.ReadString('\n')
+	if err != nil || header != cache.header() {
+		logrus.Debugf("ignoring the cached tree of layer %s (built with other settings)", name)
+		return nil
+	}
+	tree, err := filetree.DecodeFileTree(reader)
+	if err != nil {
+		logrus.Debugf("ignoring the cached tree of layer %s: %v", name, err)
+		return nil
+	}
+
+	// the cache is evicted by last use
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return tree
+}
+
+// store caches the tree of the given layer, then evicts the least recently used trees should the cache be too large.
+// Failing to cache a tree only costs reading the layer again next time, so errors are only logged.
+func (cache *layerCache) store(name string, tree *filetree.FileTree) {
+	key := cacheKey(name)
+	if cache == nil || key == "" || tree == nil {
+		return
+	}
+	path := filepath.Join(cache.dir, key)
+	if err := cache.write(path, tree); err != nil {
+		logrus.Debugf("could not cache the tree of layer %s: %v", name, err)
+		return
+	}
+	cache.evict()
+}
+
+// write encodes the tree into the given path, through a temporary file so readers never see partial trees.
+func (cache *layerCache) write(path string, tree *filetree.FileTree) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	_, err = writer.WriteString(cache.header())
+	if err == nil {
+		err = tree.Encode(writer)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// evict removes the least recently used trees until the cache is no larger than its maximum size (if any).
+func (cache *layerCache) evict() {
+	if cache.maxSize <= 0 {
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	var files []os.FileInfo
+	var paths []string
+	var total int64
+	filepath.Walk(cache.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+			paths = append(paths, path)
+			total += info.Size()
+		}
+		return nil
+	})
+	if total <= cache.maxSize {
+		return
+	}
+
+	order := make([]int, len(files))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return files[order[i]].ModTime().Before(files[order[j]].ModTime())
+	})
+	for _, idx := range order {
+		if total <= cache.maxSize {
+			break
+		}
+		if err := os.Remove(paths[idx]); err != nil {
+			logrus.Debugf("could not evict %s from the layer cache: %v", paths[idx], err)
+			continue
+		}
+		total -= files[idx].Size()
+	}
+}
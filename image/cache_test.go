@@ -0,0 +1,151 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+)
+
+func newTestCache(t *testing.T, maxSize int64) *layerCache {
+	dir, err := ioutil.TempDir("", "dive-cache")
+	if err != nil {
+		t.Fatalf("could not create the cache: %v", err)
+	}
+	return &layerCache{dir: dir, maxSize: maxSize, settings: "hash-algorithm=xxhash"}
+}
+
+func testLayerTree(paths ...string) *filetree.FileTree {
+	tree := filetree.NewFileTree()
+	for _, path := range paths {
+		tree.AddPath(path, filetree.FileInfo{Path: path, TypeFlag: '0', LogicalBytes: 10})
+		tree.FileSize += 10
+	}
+	return tree
+}
+
+func TestCacheKey(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	cases := map[string]string{
+		"sha256:" + id:           filepath.Join("sha256", id),
+		"blobs/sha256/" + id:     filepath.Join("sha256", id),
+		id + "/layer.tar":        filepath.Join("legacy", id),
+		"layer.tar":              "",
+		"blobs/sha256/../../etc": "",
+		"sha256:../../etc":       "",
+	}
+	for name, expected := range cases {
+		if key := cacheKey(name); key != expected {
+			t.Errorf("[%s] expected key '%s', got '%s'", name, expected, key)
+		}
+	}
+}
+
+func TestLayerCache(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.dir)
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	if tree := cache.load(digest); tree != nil {
+		t.Fatalf("expected an empty cache")
+	}
+	cache.store(digest, testLayerTree("/etc/hosts", "/bin/sh"))
+	tree := cache.load(digest)
+	if tree == nil {
+		t.Fatalf("expected the cached tree")
+	}
+	if tree.FileSize != 20 || tree.Size != 4 {
+		t.Errorf("expected the tree back, got %d bytes and %d nodes", tree.FileSize, tree.Size)
+	}
+
+	// trees built with other settings are not used
+	other := &layerCache{dir: cache.dir, maxSize: cache.maxSize, settings: "hash-algorithm=sha256"}
+	if tree := other.load(digest); tree != nil {
+		t.Errorf("expected no tree for other settings")
+	}
+
+	// neither are unreadable ones, which are replaced on the next store
+	path := filepath.Join(cache.dir, cacheKey(digest))
+	ioutil.WriteFile(path, []byte(cache.header()+"garbage"), 0644)
+	if tree := cache.load(digest); tree != nil {
+		t.Errorf("expected no tree for a corrupted cache file")
+	}
+	cache.store(digest, testLayerTree("/etc/hosts"))
+	if tree := cache.load(digest); tree == nil || tree.FileSize != 10 {
+		t.Errorf("expected the replaced tree, got %v", tree)
+	}
+
+	// names that are not digests are never cached
+	cache.store("layer.tar", testLayerTree("/etc/hosts"))
+	if tree := cache.load("layer.tar"); tree != nil {
+		t.Errorf("expected no tree for an unaddressed layer")
+	}
+
+	// a disabled cache holds nothing
+	var disabled *layerCache
+	disabled.store(digest, testLayerTree("/etc/hosts"))
+	if tree := disabled.load(digest); tree != nil {
+		t.Errorf("expected no tree from a disabled cache")
+	}
+}
+
+func TestLayerCacheSizeMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dive-cache")
+	if err != nil {
+		t.Fatalf("could not create the cache: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("cache.dir", dir)
+	viper.Set("cache.max-size", "1GB")
+	defer viper.Set("cache.dir", "")
+	defer viper.Set("filetree.size-mode", "logical")
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	// the layer sizes depend on the size mode, trees built under another mode are not used
+	viper.Set("filetree.size-mode", "logical")
+	configureLayerCache()
+	layerTreeCache.store(digest, testLayerTree("/etc/hosts"))
+	if tree := layerTreeCache.load(digest); tree == nil {
+		t.Fatalf("expected the cached tree")
+	}
+	viper.Set("filetree.size-mode", "stored")
+	configureLayerCache()
+	if tree := layerTreeCache.load(digest); tree != nil {
+		t.Errorf("expected no tree for another size mode")
+	}
+	layerTreeCache = nil
+}
+
+func TestLayerCacheEviction(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.dir)
+
+	digests := make([]string, 3)
+	for idx := range digests {
+		digests[idx] = "sha256:" + strings.Repeat(string(rune('a'+idx)), 64)
+		cache.store(digests[idx], testLayerTree("/etc/hosts", "/bin/sh"))
+		// make the order of use unambiguous
+		past := time.Now().Add(time.Duration(idx-10) * time.Minute)
+		os.Chtimes(filepath.Join(cache.dir, cacheKey(digests[idx])), past, past)
+	}
+	info, err := os.Stat(filepath.Join(cache.dir, cacheKey(digests[0])))
+	if err != nil {
+		t.Fatalf("expected a cache file: %v", err)
+	}
+
+	// using the oldest tree makes the second one the least recently used, which is evicted when the cache is full
+	cache.load(digests[0])
+	cache.maxSize = 3 * info.Size()
+	cache.store("sha256:"+strings.Repeat("d", 64), testLayerTree("/etc/hosts", "/bin/sh"))
+
+	for idx, expected := range []bool{true, false, true} {
+		if tree := cache.load(digests[idx]); (tree != nil) != expected {
+			t.Errorf("[%d] expected cached=%t", idx, expected)
+		}
+	}
+}
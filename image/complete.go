@@ -0,0 +1,78 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ListImageNames returns the names ("repository:tag") of the images held by the container engine of the given source
+// (see engineEnv.resolveEngine), in order. Only the engines list their images: other sources have none to offer.
+func ListImageNames(ctx context.Context, source string) ([]string, error) {
+	switch source {
+	case "", SourceDocker, SourcePodman:
+	default:
+		return nil, nil
+	}
+	containerEngine, err := systemEngineEnv.resolveEngine(source)
+	if err != nil {
+		return nil, err
+	}
+	dockerClient, err := containerEngine.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerClient.Close()
+
+	summaries, err := dockerClient.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, summary := range summaries {
+		for _, tag := range summary.RepoTags {
+			if tag == "<none>:<none>" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			names = append(names, tag)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CompleteImage returns the completions of a partial image argument: the source prefixes it may start (e.g.
+// "registry://" for "reg"), and the names of the images of its source (the configured one, without a prefix) that
+// it is the start of. Sources that cannot be reached in time (see ListImageNames) offer no image.
+func CompleteImage(ctx context.Context, word string) []string {
+	return completeImageWord(word, func(source string) []string {
+		names, _ := ListImageNames(ctx, source)
+		return names
+	})
+}
+
+// completeImageWord completes a partial image argument (see CompleteImage) with the images listed by the given
+// function for a source.
+func completeImageWord(word string, list func(source string) []string) []string {
+	source, ref := parseImageSource(word)
+	prefix := strings.TrimSuffix(word, ref)
+
+	var candidates []string
+	if prefix == "" {
+		for _, known := range sources {
+			if strings.HasPrefix(known+"://", word) {
+				candidates = append(candidates, known+"://")
+			}
+		}
+	}
+	for _, name := range list(source) {
+		if strings.HasPrefix(name, ref) {
+			candidates = append(candidates, prefix+name)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,37 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteImageWord(t *testing.T) {
+	list := func(source string) []string {
+		switch source {
+		case "", SourceDocker:
+			return []string{"alpine:3.8", "debian:stretch", "docker-registry:2"}
+		case SourcePodman:
+			return []string{"alpine:edge"}
+		}
+		return nil
+	}
+
+	cases := []struct {
+		word     string
+		expected []string
+	}{
+		{"", []string{"docker://", "podman://", "containerd://", "oci://", "docker-archive://", "registry://", "dir://", "alpine:3.8", "debian:stretch", "docker-registry:2"}},
+		{"do", []string{"docker://", "docker-archive://", "docker-registry:2"}},
+		{"alpine:", []string{"alpine:3.8"}},
+		{"podman://al", []string{"podman://alpine:edge"}},
+		{"docker://", []string{"docker://alpine:3.8", "docker://debian:stretch", "docker://docker-registry:2"}},
+		{"registry://alp", nil},
+		{"dir:/srv", nil},
+	}
+	for _, test := range cases {
+		actual := completeImageWord(test.word, list)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("completing %q: expected %v, got %v", test.word, test.expected, actual)
+		}
+	}
+}
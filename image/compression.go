@@ -0,0 +1,100 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// layerStream is a decompressed layer read from a source that must be closed along with it (if any), counting the bytes
+// read from the blob of the layer (if known)
+type layerStream struct {
+	io.ReadCloser
+	source io.Closer
+	blob   *blobCounter
+}
+
+// Close the decompressor and the source of the layer
+func (stream layerStream) Close() error {
+	err := stream.ReadCloser.Close()
+	if stream.source == nil {
+		return err
+	}
+	if sourceErr := stream.source.Close(); err == nil {
+		err = sourceErr
+	}
+	return err
+}
+
+// blobCounter counts the bytes read from the (possibly compressed) blob of a layer, before decompression
+type blobCounter struct {
+	io.Reader
+	bytes int64
+}
+
+// Read from the blob, counting the bytes read
+func (counter *blobCounter) Read(p []byte) (int, error) {
+	n, err := counter.Reader.Read(p)
+	counter.bytes += int64(n)
+	return n, err
+}
+
+// decompressLayer returns the (uncompressed) tar of a layer blob with the given media type.
+func decompressLayer(mediaType string, blob io.Reader) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip") || strings.HasSuffix(mediaType, ".tar.gzip"):
+		reader, err = gzip.NewReader(blob)
+	case strings.HasSuffix(mediaType, "+zstd") || strings.HasSuffix(mediaType, ".tar.zstd"):
+		reader, err = newZstdReader(blob)
+	case strings.HasSuffix(mediaType, ".tar"):
+		return ioutil.NopCloser(blob), nil
+	default:
+		return nil, fmt.Errorf("unsupported layer media type '%s'", mediaType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress layer of media type '%s': %v", mediaType, err)
+	}
+	return reader, nil
+}
+
+// sniffLayer returns the (uncompressed) tar of a layer whose media type is unknown (e.g. a layer of an archive),
+// detecting gzip and zstd compression by their magic bytes. Anything else is taken to be an uncompressed tar.
+func sniffLayer(blob io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(blob)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newZstdReader(buffered)
+	default:
+		return ioutil.NopCloser(buffered), nil
+	}
+}
+
+// newZstdReader decompresses a zstd stream. The decoder only reads the stream once read from, so its magic number is
+// checked upfront, like gzip.NewReader checks its header.
+func newZstdReader(blob io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(blob)
+	if magic, _ := buffered.Peek(len(zstdMagic)); !bytes.Equal(magic, zstdMagic) {
+		return nil, fmt.Errorf("not a zstd stream (magic number mismatch)")
+	}
+	decoder, err := zstd.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,208 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+)
+
+// containerdNamespaces are the namespaces searched for an image when none is configured: the one of the Kubernetes
+// CRI plugin, then the default of the containerd clients
+var containerdNamespaces = []string{"k8s.io", "default"}
+
+// ctrRunner runs the containerd client CLI (ctr) with the given arguments, returning its standard output
+type ctrRunner func(args ...string) ([]byte, error)
+
+// ctrStreamer runs the containerd client CLI (ctr) with the given arguments, streaming its standard output
+type ctrStreamer func(args ...string) (io.ReadCloser, error)
+
+// containerd reads images from a containerd daemon through its client CLI, streaming every blob out of its content
+// store
+type containerd struct {
+	address string
+	run     ctrRunner
+	stream  ctrStreamer
+}
+
+// runCtr runs the ctr binary, with its error output as the error when failing.
+func runCtr(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ctr", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, ctrError(err, &stderr)
+	}
+	return output, nil
+}
+
+// streamCtr starts the ctr binary, returning its standard output. Closing it waits for ctr to exit, failing with its
+// error output when it did not succeed.
+func streamCtr(args ...string) (io.ReadCloser, error) {
+	stream := &ctrStream{cmd: exec.Command("ctr", args...)}
+	stream.cmd.Stderr = &stream.stderr
+	stdout, err := stream.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.cmd.Start(); err != nil {
+		return nil, err
+	}
+	stream.ReadCloser = stdout
+	return stream, nil
+}
+
+// ctrStream is the standard output of a running ctr command (see streamCtr)
+type ctrStream struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+// Close the output and wait for ctr to exit
+func (stream *ctrStream) Close() error {
+	stream.ReadCloser.Close()
+	if err := stream.cmd.Wait(); err != nil {
+		return ctrError(err, &stream.stderr)
+	}
+	return nil
+}
+
+// ctrError is the error of a failed ctr command, along with its error output (if any).
+func ctrError(err error, stderr *bytes.Buffer) error {
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("%v: %s", err, message)
+	}
+	return err
+}
+
+// args returns the arguments of a ctr command within the given namespace.
+func (c containerd) args(namespace string, args ...string) []string {
+	global := []string{"--namespace", namespace}
+	if c.address != "" {
+		global = append(global, "--address", c.address)
+	}
+	return append(global, args...)
+}
+
+// ctr runs a ctr command within the given namespace.
+func (c containerd) ctr(namespace string, args ...string) ([]byte, error) {
+	return c.run(c.args(namespace, args...)...)
+}
+
+// findImage returns the namespace holding the image with the given reference (the given namespace, or when empty the
+// first of containerdNamespaces holding it) and the descriptor of the image: its manifest, or the index of a
+// multi-platform image.
+func (c containerd) findImage(namespace, ref string) (string, ociDescriptor, error) {
+	candidates := containerdNamespaces
+	if namespace != "" {
+		candidates = []string{namespace}
+	}
+	for _, candidate := range candidates {
+		output, err := c.ctr(candidate, "images", "list")
+		if err != nil {
+			return "", ociDescriptor{}, fmt.Errorf("could not list the images of namespace '%s': %v", candidate, err)
+		}
+		// the columns are the reference, media type, digest, size, platforms, and labels of every image
+		for _, line := range strings.Split(string(output), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == ref {
+				return candidate, ociDescriptor{MediaType: fields[1], Digest: fields[2]}, nil
+			}
+		}
+	}
+	if namespace != "" {
+		return "", ociDescriptor{}, fmt.Errorf("image '%s' not found in namespace '%s' (is it pulled?)", ref, namespace)
+	}
+	return "", ociDescriptor{}, fmt.Errorf("image '%s' not found in namespaces %s (is it pulled? select the namespace with containerd.namespace)", ref, strings.Join(containerdNamespaces, ", "))
+}
+
+// readBlob reads the whole blob with the given digest from the content store of the given namespace.
+func (c containerd) readBlob(namespace, digest string) ([]byte, error) {
+	if !ociDigestPattern.MatchString(digest) {
+		return nil, fmt.Errorf("invalid digest '%s'", digest)
+	}
+	data, err := c.ctr(namespace, "content", "get", digest)
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob %s: %v", digest, err)
+	}
+	return data, nil
+}
+
+// readImage resolves the image of the given descriptor (selecting the given platform of a multi-platform image), and
+// passes each of its layers to the given reader, lowest first. Returns the manifest of the image (listing the layers
+// by digest) and its config.
+func (c containerd) readImage(namespace string, target ociDescriptor, platform string, readLayer ociLayerReader) (ImageManifest, []byte, error) {
+	var manifest ImageManifest
+	readBlob := func(digest string) ([]byte, error) {
+		return c.readBlob(namespace, digest)
+	}
+	imageManifest, _, err := resolveOCIImage(ociIndex{Manifests: []ociDescriptor{target}}, "", platform, readBlob)
+	if err != nil {
+		return manifest, nil, err
+	}
+	configBytes, err := readBlob(imageManifest.Config.Digest)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	manifest.ConfigPath = imageManifest.Config.Digest
+	for _, layer := range imageManifest.Layers {
+		if err := c.readLayer(namespace, layer, readLayer); err != nil {
+			return manifest, nil, fmt.Errorf("could not read layer %s: %v", layer.Digest, err)
+		}
+		manifest.LayerTarPaths = append(manifest.LayerTarPaths, layer.Digest)
+		manifest.LayerSizes = append(manifest.LayerSizes, layer.Size)
+	}
+	return manifest, configBytes, nil
+}
+
+// readLayer passes the layer blob referenced by the given descriptor to the given reader, to be streamed out of the
+// content store (and decompressed per its media type) when opened.
+func (c containerd) readLayer(namespace string, descriptor ociDescriptor, readLayer ociLayerReader) error {
+	if !ociDigestPattern.MatchString(descriptor.Digest) {
+		return fmt.Errorf("invalid digest '%s'", descriptor.Digest)
+	}
+	return readLayer(descriptor.Digest, func() (io.ReadCloser, error) {
+		content, err := c.stream(c.args(namespace, "content", "get", descriptor.Digest)...)
+		if err != nil {
+			return nil, err
+		}
+		blob := &blobCounter{Reader: content}
+		reader, err := decompressLayer(descriptor.MediaType, blob)
+		if err != nil {
+			content.Close()
+			return nil, err
+		}
+		return layerStream{reader, content, blob}, nil
+	})
+}
+
+// fetchContainerdImage reads the given image from containerd, returning the image manifest and config along with the
+// tree of every layer (by layer digest). Every blob is streamed out of the content store of containerd, nothing is
+// written to disk.
+func fetchContainerdImage(ref, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	client := containerd{address: viper.GetString("containerd.address"), run: runCtr, stream: streamCtr}
+
+	namespace, target, err := client.findImage(viper.GetString("containerd.namespace"), ref)
+	if err != nil {
+		logrus.Error("Could not find the image in containerd: " + err.Error())
+		utils.Exit(1)
+	}
+
+	logrus.Info("  Reading image from containerd namespace '" + namespace + "'...")
+	manifest, configBytes, layerMap, err := readImageLayers(layerWorkers(), func(readLayer ociLayerReader) (ImageManifest, []byte, error) {
+		return client.readImage(namespace, target, platform, readLayer)
+	})
+	if err != nil {
+		logrus.Error("Could not read the image from containerd: " + err.Error())
+		utils.Exit(1)
+	}
+	return manifest, NewImageConfig(configBytes), layerMap
+}
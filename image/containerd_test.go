@@ -0,0 +1,107 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestContainerdFindImage(t *testing.T) {
+	images := map[string]string{
+		"k8s.io": "REF TYPE DIGEST SIZE PLATFORMS LABELS\n" +
+			"registry.k8s.io/pause:3.9 application/vnd.oci.image.index.v1+json sha256:aaa 311.1 KiB linux/amd64,linux/arm64 io.cri-containerd.image=managed\n",
+		"default": "REF TYPE DIGEST SIZE PLATFORMS LABELS\n" +
+			"docker.io/library/alpine:3.8 application/vnd.docker.distribution.manifest.v2+json sha256:bbb 2.1 MiB linux/amd64 -\n",
+	}
+	var calls []string
+	client := containerd{address: "/run/k3s/containerd.sock", run: func(args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return []byte(images[args[1]]), nil
+	}}
+
+	cases := []struct {
+		namespace, ref, expected, digest string
+	}{
+		{"", "registry.k8s.io/pause:3.9", "k8s.io", "sha256:aaa"},
+		{"", "docker.io/library/alpine:3.8", "default", "sha256:bbb"},
+		{"default", "docker.io/library/alpine:3.8", "default", "sha256:bbb"},
+	}
+	for _, test := range cases {
+		namespace, target, err := client.findImage(test.namespace, test.ref)
+		if err != nil || namespace != test.expected || target.Digest != test.digest {
+			t.Errorf("[%s] expected namespace '%s' and digest %s, got '%s' and %s (%v)", test.ref, test.expected, test.digest, namespace, target.Digest, err)
+		}
+	}
+	if _, _, err := client.findImage("", "docker.io/library/missing:1"); err == nil {
+		t.Errorf("expected an error for a missing image")
+	}
+	if _, _, err := client.findImage("builds", "docker.io/library/alpine:3.8"); err == nil || !strings.Contains(err.Error(), "namespace 'builds'") {
+		t.Errorf("expected an error for an image missing from the namespace, got: %v", err)
+	}
+	if expected := "--namespace k8s.io --address /run/k3s/containerd.sock images list"; calls[0] != expected {
+		t.Errorf("expected the call '%s', got '%s'", expected, calls[0])
+	}
+
+	failing := containerd{run: func(args ...string) ([]byte, error) { return nil, fmt.Errorf("connection refused") }}
+	if _, _, err := failing.findImage("", "alpine"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the ctr error to be reported, got: %v", err)
+	}
+}
+
+func TestContainerdReadImage(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	base := layout.layer(true, "etc/os-release")
+	amd64 := layout.image(base, layout.layer(true, "app/amd64"))
+	arm64 := layout.image(base, layout.layer(false, "app/arm64"))
+	index := layout.jsonBlob(ociIndexMediaType, ociIndex{Manifests: []ociDescriptor{
+		platformOf(arm64, "linux", "arm64", "v8"),
+		platformOf(amd64, "linux", "amd64", ""),
+	}})
+	index.MediaType = ociIndexMediaType
+
+	// the content store of the namespace is the test layout, small blobs are read whole and layers streamed
+	var streamed []string
+	client := containerd{
+		run: func(args ...string) ([]byte, error) {
+			if args[2] != "content" || args[3] != "get" {
+				return nil, fmt.Errorf("unexpected command %v", args)
+			}
+			return readOCIBlob(layout.dir, args[4])
+		},
+		stream: func(args ...string) (io.ReadCloser, error) {
+			streamed = append(streamed, args[4])
+			path, err := ociBlobPath(layout.dir, args[4])
+			if err != nil {
+				return nil, err
+			}
+			return os.Open(path)
+		},
+	}
+
+	var layers [][]string
+	manifest, config, err := client.readImage("k8s.io", index, "linux/arm64", func(digest string, open layerOpener) error {
+		files, err := openFileList(open)
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths)
+		layers = append(layers, paths)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("could not read the image: %v", err)
+	}
+	if expected := [][]string{{"etc/os-release"}, {"app/arm64"}}; !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected layers %v, got %v", expected, layers)
+	}
+	if len(manifest.LayerTarPaths) != 2 || len(streamed) != 2 || streamed[0] != base.Digest || len(NewImageConfig(config).History) != 2 {
+		t.Errorf("unexpected image: %+v (streamed %v)", manifest, streamed)
+	}
+}
@@ -0,0 +1,144 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+)
+
+// fetchDirImage reads a local directory (e.g. an unpacked root filesystem) as an image with a single layer, exiting
+// when the directory cannot be read.
+func fetchDirImage(dir string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	logrus.Info("  Reading directory...")
+	tree, err := readDirTree(dir, !viper.GetBool("filetree.metadata-only"))
+	if err != nil {
+		logrus.Error("Could not read the directory: " + err.Error())
+		utils.Exit(1)
+	}
+
+	manifest := ImageManifest{
+		ConfigPath:    "",
+		RepoTags:      []string{dir},
+		LayerTarPaths: []string{tree.Name},
+	}
+	config := ImageConfig{
+		History: []ImageHistoryEntry{{ID: tree.Name, CreatedBy: "directory " + dir}},
+		RootFs:  RootFs{Type: "layers", DiffIds: []string{tree.Name}},
+	}
+	return manifest, config, map[string]*filetree.FileTree{tree.Name: tree}
+}
+
+// readDirTree walks the given directory and builds the tree of a synthetic layer holding its files, with the metadata
+// a tar entry of each file would carry (mode, owner, modification time, link target). Files and directories that
+// cannot be read are kept in the tree (marked as unreadable) instead of stopping the walk. The tree is named after the
+// digest of the absolute path of the directory.
+func readDirTree(dir string, hashContents bool) (*filetree.FileTree, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !rootInfo.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", dir)
+	}
+
+	tree := filetree.NewFileTree()
+	tree.Name = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(root)))
+	var ignored int
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if path == root {
+			return walkErr
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if walkErr != nil {
+			// the directory itself was already added, only its contents are out of reach
+			logrus.Warnf("unable to read directory: %v", walkErr)
+			if node, err := tree.GetNode(name); err == nil {
+				node.MarkUnreadable()
+			}
+			return nil
+		}
+
+		if filetree.IsIgnored(name) {
+			logrus.Debugf("ignoring %s", name)
+			ignored++
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := readDirEntry(path, name, entry, hashContents)
+		if err != nil {
+			logrus.Warnf("unable to read file: %v", err)
+		}
+		tree.AddEntry(name, info)
+		return nil
+	})
+	if ignored > 0 {
+		logrus.Infof("ignored %d paths of %s matching filetree.ignore", ignored, root)
+	}
+	return tree, err
+}
+
+// readDirEntry returns the FileInfo of a single file of a walked directory (named by its path relative to the
+// directory). The file is marked as unreadable (and an error returned) when it cannot be inspected or opened.
+func readDirEntry(path, name string, entry fs.DirEntry, hashContents bool) (filetree.FileInfo, error) {
+	unreadable := func(err error) (filetree.FileInfo, error) {
+		info, _ := filetree.NewFileInfo(nil, &tar.Header{Name: name, Typeflag: tar.TypeReg}, name, false)
+		info.Unreadable = true
+		return info, err
+	}
+
+	stat, err := entry.Info()
+	if err != nil {
+		return unreadable(err)
+	}
+
+	var link string
+	if stat.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return unreadable(err)
+		}
+	}
+	header, err := tar.FileInfoHeader(stat, link)
+	if err != nil {
+		return unreadable(err)
+	}
+	header.Name = name
+
+	if header.Typeflag != tar.TypeReg {
+		// nothing to read, but hashed the same way as entries of a layer tar
+		return filetree.NewFileInfo(strings.NewReader(""), header, name, hashContents)
+	}
+	if !hashContents {
+		return filetree.NewFileInfo(nil, header, name, false)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		info, _ := filetree.NewFileInfo(nil, header, name, false)
+		info.Unreadable = true
+		return info, err
+	}
+	defer file.Close()
+	return filetree.NewFileInfo(file, header, name, true)
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDirTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dive-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "etc", "app"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "etc", "app", "config"), []byte("setting=1"), 0640)
+	ioutil.WriteFile(filepath.Join(dir, "etc", "app", "copy"), []byte("setting=1"), 0644)
+	os.Symlink("app/config", filepath.Join(dir, "etc", "config"))
+
+	tree, err := readDirTree(dir, true)
+	if err != nil {
+		t.Fatalf("could not read directory: %v", err)
+	}
+
+	for _, path := range []string{"/etc", "/etc/app", "/etc/app/config", "/etc/app/copy", "/etc/config"} {
+		if _, err := tree.GetNode(path); err != nil {
+			t.Errorf("expected path '%s': %v", path, err)
+		}
+	}
+
+	config, _ := tree.GetNode("/etc/app/config")
+	copied, _ := tree.GetNode("/etc/app/copy")
+	info := config.Data.FileInfo
+	if info.Size() != 9 || info.Mode.Perm() != 0640 || info.Hash() == 0 || info.Hash() != copied.Data.FileInfo.Hash() {
+		t.Errorf("unexpected file info: %+v", info)
+	}
+	if link, _ := tree.GetNode("/etc/config"); link.Data.FileInfo.Linkname != "app/config" {
+		t.Errorf("expected the symlink target, got '%s'", link.Data.FileInfo.Linkname)
+	}
+	if tree.FileSize != 18 {
+		t.Errorf("expected 18 bytes, got %d", tree.FileSize)
+	}
+
+	if _, err := readDirTree(filepath.Join(dir, "etc", "app", "config"), true); err == nil {
+		t.Error("expected an error reading a file as a directory")
+	}
+}
+
+func TestReadDirTreeUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir, err := ioutil.TempDir("", "dive-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("hidden"), 0000)
+	ioutil.WriteFile(filepath.Join(dir, "public"), []byte("shown"), 0644)
+
+	tree, err := readDirTree(dir, true)
+	if err != nil {
+		t.Fatalf("could not read directory: %v", err)
+	}
+	secret, err := tree.GetNode("/secret")
+	if err != nil || !secret.Data.FileInfo.Unreadable {
+		t.Errorf("expected an unreadable node: %v", err)
+	}
+	if public, err := tree.GetNode("/public"); err != nil || public.Data.FileInfo.Unreadable {
+		t.Errorf("expected a readable node: %v", err)
+	}
+}
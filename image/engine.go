@@ -0,0 +1,173 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/spf13/viper"
+)
+
+const (
+	dockerSocket         = "/var/run/docker.sock"
+	rootfulPodmanSocket  = "/run/podman/podman.sock"
+	podmanSocketSubpath  = "podman/podman.sock"
+	podmanSocketHelpText = "start the podman API socket (e.g. with 'systemctl --user start podman.socket')"
+)
+
+// engine is a container engine serving the Docker API, which images are read from
+type engine struct {
+	// name of the engine, which is also its CLI binary (used for pulling images)
+	name string
+	// host is the API endpoint (e.g. "unix:///run/podman/podman.sock"), empty for the Docker defaults (DOCKER_HOST)
+	host string
+}
+
+var dockerEngine = engine{name: SourceDocker}
+
+// newClient connects to the API of the engine.
+func (e engine) newClient() (*client.Client, error) {
+	opts := []client.Opt{client.WithVersion(dockerVersion), client.FromEnv}
+	if e.host != "" {
+		opts = append(opts, client.WithHost(e.host))
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// engineEnv is the environment used to find the engine sockets (os.Getenv and a stat in practice, swapped in tests)
+type engineEnv struct {
+	getenv func(string) string
+	exists func(string) bool
+	uid    int
+}
+
+var systemEngineEnv = engineEnv{
+	getenv: os.Getenv,
+	exists: func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	},
+	uid: os.Getuid(),
+}
+
+// podmanSockets lists the podman API sockets to try, in order: the CONTAINER_HOST podman itself honors, the rootless
+// socket of the user (under XDG_RUNTIME_DIR, or /run/user/<uid> when unset), then the rootful socket.
+func (env engineEnv) podmanSockets() []string {
+	var sockets []string
+	if host := env.getenv("CONTAINER_HOST"); strings.HasPrefix(host, "unix://") {
+		sockets = append(sockets, strings.TrimPrefix(host, "unix://"))
+	}
+	runtimeDir := env.getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", env.uid)
+	}
+	sockets = append(sockets, filepath.Join(runtimeDir, podmanSocketSubpath), rootfulPodmanSocket)
+	return sockets
+}
+
+// podman returns the podman engine listening on the first existing podman socket.
+func (env engineEnv) podman() (engine, error) {
+	sockets := env.podmanSockets()
+	for _, socket := range sockets {
+		if env.exists(socket) {
+			return engine{name: SourcePodman, host: "unix://" + socket}, nil
+		}
+	}
+	return engine{}, fmt.Errorf("no podman API socket found (tried %s), %s", strings.Join(sockets, ", "), podmanSocketHelpText)
+}
+
+// dockerReachable indicates if a Docker daemon may be reached: either DOCKER_HOST points somewhere, or the default
+// socket exists.
+func (env engineEnv) dockerReachable() bool {
+	return env.getenv("DOCKER_HOST") != "" || env.exists(dockerSocket)
+}
+
+// resolveEngine returns the engine of the given source (SourceDocker or SourcePodman). Without a source, Docker is
+// used when it may be reached, podman otherwise (failing with the sourceAttempts of both).
+func (env engineEnv) resolveEngine(source string) (engine, error) {
+	engines, _, err := env.resolveEngines(source)
+	if err != nil {
+		return engine{}, err
+	}
+	return engines[0], nil
+}
+
+// resolveEngines returns the engines to look for an image of the given source in, in order, along with the reasons the
+// other engines were left out. Without a source, both Docker and podman are returned when they may be reached (in
+// that order), so an image missing from Docker is looked for in podman next (see findEngineImage).
+func (env engineEnv) resolveEngines(source string) ([]engine, []sourceAttempt, error) {
+	switch source {
+	case SourceDocker:
+		return []engine{dockerEngine}, nil, nil
+	case SourcePodman:
+		podman, err := env.podman()
+		if err != nil {
+			return nil, nil, err
+		}
+		return []engine{podman}, nil, nil
+	}
+
+	var engines []engine
+	var unreachable []sourceAttempt
+	if env.dockerReachable() {
+		engines = append(engines, dockerEngine)
+	} else {
+		unreachable = append(unreachable, sourceAttempt{SourceDocker, fmt.Errorf("no Docker socket at %s (and DOCKER_HOST is not set)", dockerSocket)})
+	}
+	if podman, err := env.podman(); err == nil {
+		engines = append(engines, podman)
+	} else {
+		unreachable = append(unreachable, sourceAttempt{SourcePodman, err})
+	}
+	if len(engines) == 0 {
+		return nil, nil, sourceAttempts{summary: "neither Docker nor podman is reachable", attempts: unreachable}
+	}
+	return engines, unreachable, nil
+}
+
+// findEngineImage returns the first of the given engines the prepare function succeeds with (see prepareEngineImage).
+// When it fails with every engine, the error lists each attempt along with the given engines left out, in the order
+// the sources are looked at.
+func findEngineImage(engines []engine, unreachable []sourceAttempt, prepare func(engine) error) (engine, error) {
+	attempts := append([]sourceAttempt{}, unreachable...)
+	for _, containerEngine := range engines {
+		err := prepare(containerEngine)
+		if err == nil {
+			return containerEngine, nil
+		}
+		attempts = append(attempts, sourceAttempt{containerEngine.name, err})
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return sourceRank(attempts[i].source) < sourceRank(attempts[j].source)
+	})
+	return engine{}, sourceAttempts{summary: "no container engine holds the image or could pull it", attempts: attempts}
+}
+
+// sourceRank is the position of a source in the sources list
+func sourceRank(source string) int {
+	for idx, known := range sources {
+		if known == source {
+			return idx
+		}
+	}
+	return len(sources)
+}
+
+// BuildEngine returns the container engine CLI (docker or podman) building images for the configured source, which
+// the built image is then read from (see engineEnv.resolveEngine).
+func BuildEngine() (string, error) {
+	source := strings.ToLower(strings.TrimSpace(viper.GetString("source")))
+	switch source {
+	case "", SourceDocker, SourcePodman:
+	default:
+		return "", fmt.Errorf("images are built with docker or podman, not with source '%s'", source)
+	}
+	containerEngine, err := systemEngineEnv.resolveEngine(source)
+	if err != nil {
+		return "", err
+	}
+	return containerEngine.name, nil
+}
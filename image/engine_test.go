@@ -0,0 +1,94 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeEngineEnv builds an engine environment with the given variables and existing files
+func fakeEngineEnv(vars map[string]string, files ...string) engineEnv {
+	return engineEnv{
+		getenv: func(key string) string { return vars[key] },
+		exists: func(path string) bool {
+			for _, file := range files {
+				if file == path {
+					return true
+				}
+			}
+			return false
+		},
+		uid: 1000,
+	}
+}
+
+func TestResolveEngine(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      engineEnv
+		source   string
+		expected engine
+		err      string
+	}{
+		{"docker socket", fakeEngineEnv(nil, dockerSocket, "/run/user/1000/podman/podman.sock"), "", dockerEngine, ""},
+		{"docker host", fakeEngineEnv(map[string]string{"DOCKER_HOST": "tcp://remote:2375"}), "", dockerEngine, ""},
+		{"explicit docker", fakeEngineEnv(nil), SourceDocker, dockerEngine, ""},
+		{"rootless fallback", fakeEngineEnv(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1234"}, "/run/user/1234/podman/podman.sock"), "",
+			engine{SourcePodman, "unix:///run/user/1234/podman/podman.sock"}, ""},
+		{"rootless without XDG_RUNTIME_DIR", fakeEngineEnv(nil, "/run/user/1000/podman/podman.sock", rootfulPodmanSocket), "",
+			engine{SourcePodman, "unix:///run/user/1000/podman/podman.sock"}, ""},
+		{"rootful", fakeEngineEnv(nil, rootfulPodmanSocket), "", engine{SourcePodman, "unix://" + rootfulPodmanSocket}, ""},
+		{"container host", fakeEngineEnv(map[string]string{"CONTAINER_HOST": "unix:///tmp/podman.sock"}, "/tmp/podman.sock", rootfulPodmanSocket), SourcePodman,
+			engine{SourcePodman, "unix:///tmp/podman.sock"}, ""},
+		{"explicit podman", fakeEngineEnv(nil, dockerSocket, rootfulPodmanSocket), SourcePodman, engine{SourcePodman, "unix://" + rootfulPodmanSocket}, ""},
+		{"no podman", fakeEngineEnv(nil, dockerSocket), SourcePodman, engine{}, "no podman API socket found (tried /run/user/1000/podman/podman.sock, /run/podman/podman.sock)"},
+		{"nothing", fakeEngineEnv(nil), "", engine{}, "neither Docker nor podman is reachable"},
+	}
+
+	for _, test := range cases {
+		actual, err := test.env.resolveEngine(test.source)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("[%s] expected an error containing '%s', got: %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] expected no error, got: %v", test.name, err)
+		} else if actual != test.expected {
+			t.Errorf("[%s] expected %+v, got %+v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestFindEngineImage(t *testing.T) {
+	podman := engine{SourcePodman, "unix://" + rootfulPodmanSocket}
+	engines, unreachable, err := fakeEngineEnv(nil, dockerSocket, rootfulPodmanSocket).resolveEngines("")
+	if err != nil || len(engines) != 2 || engines[0] != dockerEngine || engines[1] != podman || len(unreachable) != 0 {
+		t.Fatalf("expected docker then podman, got %+v %+v (%v)", engines, unreachable, err)
+	}
+
+	// an image missing from docker is looked for in podman
+	var tried []string
+	found, err := findEngineImage(engines, unreachable, func(containerEngine engine) error {
+		tried = append(tried, containerEngine.name)
+		if containerEngine.name == SourceDocker {
+			return fmt.Errorf("not available locally")
+		}
+		return nil
+	})
+	if err != nil || found != podman || strings.Join(tried, ",") != "docker,podman" {
+		t.Errorf("expected the image to be found in podman, got %+v after %v (%v)", found, tried, err)
+	}
+
+	// every attempt is reported, along with the engines that could not be reached, in order
+	engines, unreachable, _ = fakeEngineEnv(nil, rootfulPodmanSocket).resolveEngines("")
+	_, err = findEngineImage(engines, unreachable, func(engine) error { return fmt.Errorf("pulling it failed") })
+	attempts, ok := err.(sourceAttempts)
+	if !ok || len(attempts.attempts) != 2 || attempts.attempts[0].source != SourceDocker || attempts.attempts[1].source != SourcePodman {
+		t.Fatalf("expected the attempts of docker and podman, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "  - podman: pulling it failed") {
+		t.Errorf("expected the podman failure in: %v", err)
+	}
+}
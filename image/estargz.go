@@ -0,0 +1,106 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wagoodman/dive/filetree"
+)
+
+const (
+	// estargzTOCName is the table of contents appended (as the last entry) to eStargz layers
+	estargzTOCName = "stargz.index.json"
+	// estargzLandmarkContents is the single byte held by the landmark entries of eStargz layers
+	estargzLandmarkContents = 0xf
+	// estargzMaxTOCSize is the size (in bytes) above which an entry is not taken for a table of contents, so a
+	// (malicious or unlucky) large file of that name is not held in memory
+	estargzMaxTOCSize = 64 * 1024 * 1024
+)
+
+// estargzLandmarkNames are the entries marking the end of the prioritized files of eStargz layers
+var estargzLandmarkNames = []string{".prefetch.landmark", ".no.prefetch.landmark"}
+
+// estargzTOC holds the fields identifying an eStargz table of contents
+type estargzTOC struct {
+	Version int               `json:"version"`
+	Entries []json.RawMessage `json:"entries"`
+}
+
+// estargzEntries tracks the entries of a layer that are eStargz metadata, should the layer turn out to be an
+// eStargz layer. Such layers are otherwise ordinary tars, so they are only recognized once the whole layer is read:
+// the table of contents must be the last entry (and a valid one), and landmarks must hold the landmark byte. Layers
+// produced as zstd:chunked keep their table of contents outside of the tar stream, so need no special handling.
+type estargzEntries struct {
+	// landmarks are the indexes of the landmark entries (in the list of files of the layer)
+	landmarks []int
+	// toc is the index of the table of contents entry, valid is set when the entry parses as one
+	toc   int
+	valid bool
+}
+
+// isEstargzCandidate indicates if the given entry could be eStargz metadata, and should be captured to be checked.
+func isEstargzCandidate(header *tar.Header, name string) bool {
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		return false
+	}
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if name == estargzTOCName {
+		return header.Size <= estargzMaxTOCSize
+	}
+	for _, landmark := range estargzLandmarkNames {
+		if name == landmark {
+			return header.Size == 1
+		}
+	}
+	return false
+}
+
+// readEstargzCandidate reads a candidate entry into a file of the layer, noting it should it be eStargz metadata. The
+// contents are captured as they are read (candidates are small, see isEstargzCandidate). The entry being the last of
+// the layer is checked by strip.
+func (entries *estargzEntries) readEstargzCandidate(reader *tar.Reader, header *tar.Header, name string, hashContents bool, index int) (filetree.FileInfo, error) {
+	var captured bytes.Buffer
+	info, err := filetree.NewFileInfo(io.TeeReader(reader, &captured), header, name, hashContents)
+	if err != nil {
+		return info, err
+	}
+	// the contents are not read when not hashed
+	if _, err := io.Copy(&captured, reader); err != nil {
+		return info, err
+	}
+	contents := captured.Bytes()
+
+	if path.Clean(strings.TrimPrefix(name, "/")) == estargzTOCName {
+		var toc estargzTOC
+		entries.toc = index
+		entries.valid = json.Unmarshal(contents, &toc) == nil && toc.Version > 0 && toc.Entries != nil
+	} else if bytes.Equal(contents, []byte{estargzLandmarkContents}) {
+		entries.landmarks = append(entries.landmarks, index)
+	}
+	return info, nil
+}
+
+// strip removes the eStargz metadata from the given files of a layer, if the layer is an eStargz layer.
+func (entries *estargzEntries) strip(files []filetree.FileInfo) []filetree.FileInfo {
+	if !entries.valid || entries.toc != len(files)-1 {
+		return files
+	}
+	logrus.Debug("eStargz layer found, ignoring its table of contents and landmarks")
+
+	remove := map[int]bool{entries.toc: true}
+	for _, index := range entries.landmarks {
+		remove[index] = true
+	}
+	stripped := make([]filetree.FileInfo, 0, len(files)-len(remove))
+	for index, file := range files {
+		if !remove[index] {
+			stripped = append(stripped, file)
+		}
+	}
+	return stripped
+}
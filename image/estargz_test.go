@@ -0,0 +1,100 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+// tarEntry is a regular file of a test layer
+type tarEntry struct {
+	name     string
+	contents string
+}
+
+// estargzLayer writes the given entries as a layer, compressing each entry as its own gzip member like eStargz does
+func estargzLayer(entries ...tarEntry) []byte {
+	var compressed bytes.Buffer
+	for idx, entry := range entries {
+		var buf bytes.Buffer
+		writer := tar.NewWriter(&buf)
+		writer.WriteHeader(&tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.contents))})
+		writer.Write([]byte(entry.contents))
+		writer.Flush()
+		if idx == len(entries)-1 {
+			writer.Close()
+		}
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(buf.Bytes())
+		gz.Close()
+	}
+	return compressed.Bytes()
+}
+
+func readLayerFiles(t *testing.T, layer []byte) map[string]filetree.FileInfo {
+	reader, err := decompressLayer("application/vnd.oci.image.layer.v1.tar+gzip", bytes.NewReader(layer))
+	if err != nil {
+		t.Fatalf("could not decompress the layer: %v", err)
+	}
+	files, err := getFileList(tar.NewReader(reader))
+	if err != nil {
+		t.Fatalf("could not read the layer: %v", err)
+	}
+	paths := make(map[string]filetree.FileInfo)
+	for _, file := range files {
+		paths[file.Path] = file
+	}
+	return paths
+}
+
+func TestEstargzLayers(t *testing.T) {
+	const toc = `{"version": 1, "entries": [{"name": "bin/sh", "type": "reg"}]}`
+	landmark := string([]byte{estargzLandmarkContents})
+	plain := readLayerFiles(t, estargzLayer(tarEntry{"bin/sh", "#!/bin/busybox"}, tarEntry{"etc/os-release", "alpine"}))
+
+	cases := []struct {
+		name     string
+		entries  []tarEntry
+		expected []string
+	}{
+		{"estargz", []tarEntry{
+			{"bin/sh", "#!/bin/busybox"}, {".prefetch.landmark", landmark}, {"etc/os-release", "alpine"}, {estargzTOCName, toc},
+		}, []string{"bin/sh", "etc/os-release"}},
+		{"estargz without prefetch", []tarEntry{
+			{".no.prefetch.landmark", landmark}, {"bin/sh", "#!/bin/busybox"}, {"etc/os-release", "alpine"}, {estargzTOCName, toc},
+		}, []string{"bin/sh", "etc/os-release"}},
+		// ordinary layers holding files of the same names are left alone
+		{"toc not last", []tarEntry{
+			{".prefetch.landmark", landmark}, {estargzTOCName, toc}, {"bin/sh", "#!/bin/busybox"},
+		}, []string{".prefetch.landmark", estargzTOCName, "bin/sh"}},
+		{"not a toc", []tarEntry{
+			{".prefetch.landmark", landmark}, {"bin/sh", "#!/bin/busybox"}, {estargzTOCName, `{"name": "app"}`},
+		}, []string{".prefetch.landmark", "bin/sh", estargzTOCName}},
+		{"nested toc", []tarEntry{
+			{"bin/sh", "#!/bin/busybox"}, {"app/" + estargzTOCName, toc},
+		}, []string{"bin/sh", "app/" + estargzTOCName}},
+		{"not a landmark", []tarEntry{
+			{".prefetch.landmark", "x"}, {"bin/sh", "#!/bin/busybox"}, {estargzTOCName, toc},
+		}, []string{".prefetch.landmark", "bin/sh"}},
+	}
+	for _, test := range cases {
+		files := readLayerFiles(t, estargzLayer(test.entries...))
+		var paths []string
+		for _, entry := range test.entries {
+			if _, ok := files[entry.name]; ok {
+				paths = append(paths, entry.name)
+			}
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("[%s] expected files %v, got %v", test.name, test.expected, paths)
+		}
+		// the real files are read, and hashed, as in an ordinary layer
+		if !reflect.DeepEqual(files["bin/sh"], plain["bin/sh"]) {
+			t.Errorf("[%s] expected bin/sh to be read as %+v, got %+v", test.name, plain["bin/sh"], files["bin/sh"])
+		}
+	}
+}
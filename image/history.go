@@ -0,0 +1,64 @@
+package image
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// shellPrefix starts the commands of RUN steps (and, with nopMarker, of every other step) of the classic builder
+	shellPrefix = "/bin/sh -c "
+	// nopMarker follows the shell prefix of steps that did not run anything (e.g. "/bin/sh -c #(nop)  ENV A=b")
+	nopMarker = "#(nop)"
+	// buildkitSuffix ends the commands of steps built by BuildKit
+	buildkitSuffix = "# buildkit"
+)
+
+// CleanCommand returns the history command (created_by) of an image step in the form it was written in the
+// Dockerfile, without the noise added by the builders: the shell prefix and "#(nop)" marker of the classic builder,
+// and the "# buildkit" suffix and "|N ARG=value ..." build argument prefix of BuildKit. Shell commands are shown as RUN
+// steps, commands that are not recognized are returned as given (with surrounding space trimmed).
+func CleanCommand(createdBy string) string {
+	command := strings.TrimSpace(createdBy)
+	command = strings.TrimSpace(strings.TrimSuffix(command, buildkitSuffix))
+
+	run := false
+	if strings.HasPrefix(command, "RUN ") {
+		command = strings.TrimSpace(strings.TrimPrefix(command, "RUN "))
+		run = true
+	}
+	command = stripBuildArgs(command)
+
+	if strings.HasPrefix(command, shellPrefix) {
+		command = strings.TrimSpace(strings.TrimPrefix(command, shellPrefix))
+		if strings.HasPrefix(command, nopMarker) {
+			return strings.TrimSpace(strings.TrimPrefix(command, nopMarker))
+		}
+		run = true
+	}
+	if run {
+		return "RUN " + command
+	}
+	return command
+}
+
+// stripBuildArgs removes the "|N ARG=value ..." prefix BuildKit adds to RUN commands using N build arguments.
+func stripBuildArgs(command string) string {
+	if !strings.HasPrefix(command, "|") {
+		return command
+	}
+	fields := strings.SplitN(command, " ", 2)
+	count, err := strconv.Atoi(strings.TrimPrefix(fields[0], "|"))
+	if err != nil || len(fields) < 2 {
+		return command
+	}
+	rest := fields[1]
+	for idx := 0; idx < count; idx++ {
+		parts := strings.SplitN(rest, " ", 2)
+		if len(parts) < 2 || !strings.Contains(parts[0], "=") {
+			return command
+		}
+		rest = parts[1]
+	}
+	return strings.TrimSpace(rest)
+}
@@ -0,0 +1,27 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestCleanCommand(t *testing.T) {
+	cases := []struct {
+		createdBy, expected string
+	}{
+		{"/bin/sh -c apt-get update && apt-get install -y curl", "RUN apt-get update && apt-get install -y curl"},
+		{"/bin/sh -c #(nop)  CMD [\"sh\"]", "CMD [\"sh\"]"},
+		{"/bin/sh -c #(nop) ADD file:0123abcd in / ", "ADD file:0123abcd in /"},
+		{"RUN /bin/sh -c make install # buildkit", "RUN make install"},
+		{"RUN |2 VERSION=1.2 TARGET=linux /bin/sh -c make VERSION=$VERSION # buildkit", "RUN make VERSION=$VERSION"},
+		{"COPY dist/ /app # buildkit", "COPY dist/ /app"},
+		{"WORKDIR /app", "WORKDIR /app"},
+		{"ENV PATH=/usr/local/bin:/usr/bin", "ENV PATH=/usr/local/bin:/usr/bin"},
+		{"|x not a build arg", "|x not a build arg"},
+		{"", ""},
+	}
+	for _, test := range cases {
+		if cleaned := CleanCommand(test.createdBy); cleaned != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.createdBy, test.expected, cleaned)
+		}
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 
-	"github.com/docker/docker/client"
+	"github.com/spf13/viper"
 	"github.com/wagoodman/dive/filetree"
 	"github.com/wagoodman/dive/utils"
 	"github.com/wagoodman/jotframe"
@@ -19,6 +23,9 @@ import (
 // TODO: this file should be rethought... but since it's only for preprocessing it'll be tech debt for now.
 var dockerVersion string
 
+// progressInterval is the minimum time between layer read progress updates
+const progressInterval = 100 * time.Millisecond
+
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -71,11 +78,25 @@ type ImageManifest struct {
 	ConfigPath    string   `json:"Config"`
 	RepoTags      []string `json:"RepoTags"`
 	LayerTarPaths []string `json:"Layers"`
+	// LayerSizes are the sizes of the layer blobs given by the descriptors of the image manifest (as LayerTarPaths),
+	// empty when the source does not provide them (e.g. the manifest.json of a docker save archive)
+	LayerSizes []int64 `json:"-"`
 }
 
 type ImageConfig struct {
-	History []ImageHistoryEntry `json:"history"`
-	RootFs  RootFs              `json:"rootfs"`
+	History      []ImageHistoryEntry `json:"history"`
+	RootFs       RootFs              `json:"rootfs"`
+	OS           string              `json:"os"`
+	Architecture string              `json:"architecture"`
+	Variant      string              `json:"variant"`
+}
+
+// Platform of the image, as "os/arch[/variant]" (empty when the config does not say)
+func (config ImageConfig) Platform() string {
+	if config.OS == "" || config.Architecture == "" {
+		return ""
+	}
+	return ociPlatform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}.String()
 }
 
 type RootFs struct {
@@ -89,9 +110,20 @@ type ImageHistoryEntry struct {
 	Created    string `json:"created"`
 	Author     string `json:"author"`
 	CreatedBy  string `json:"created_by"`
+	Comment    string `json:"comment"`
 	EmptyLayer bool   `json:"empty_layer"`
 }
 
+// CreatedTime returns the time the step was run at (the zero time when the history does not say, or says so in an
+// unknown format).
+func (entry ImageHistoryEntry) CreatedTime() time.Time {
+	created, err := time.Parse(time.RFC3339Nano, entry.Created)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
 func NewImageManifest(manifestBytes []byte) ImageManifest {
 	var manifest []ImageManifest
 	err := json.Unmarshal(manifestBytes, &manifest)
@@ -118,20 +150,68 @@ func NewImageConfig(configBytes []byte) ImageConfig {
 		}
 	}
 
+	// not every builder records history, layers without an entry get a blank one
+	for ; layerIdx < len(imageConfig.RootFs.DiffIds); layerIdx++ {
+		imageConfig.History = append(imageConfig.History, ImageHistoryEntry{ID: imageConfig.RootFs.DiffIds[layerIdx]})
+	}
+
 	return imageConfig
 }
 
-func processLayerTar(line *jotframe.Line, layerMap map[string]*filetree.FileTree, name string, reader *tar.Reader) {
+// shortLayerNameLength is the number of characters of a layer name (or digest) shown on the progress lines
+const shortLayerNameLength = 15
+
+// shortLayerName returns the start of a layer name (or digest) shown on the progress lines, the whole name when it is
+// shorter than that (e.g. the layers of a directory image)
+func shortLayerName(name string) string {
+	if len(name) > shortLayerNameLength {
+		return name[:shortLayerNameLength]
+	}
+	return name
+}
+
+// processLayerTar builds the tree of a layer tar into the given layer map, showing the progress on the given line. A
+// layer that cannot be fully read is an error, unless errors are ignored (ignore-errors): the tree then holds what
+// could be read, counting the failures in UnreadableEntries.
+func processLayerTar(line *jotframe.Line, layerMap map[string]*filetree.FileTree, name string, reader *tar.Reader) error {
 	tree := filetree.NewFileTree()
 	tree.Name = name
+	shortName := shortLayerName(name)
+
+	// report read progress on the layer line, throttled so huge layers don't spend their time redrawing
+	var filesRead int
+	var bytesRead int64
+	var lastUpdate time.Time
+	progress := func(path string, size int64) {
+		logrus.Debugf("reading %s of layer %s (%d bytes)", path, shortName, size)
+		filesRead++
+		bytesRead += size
+		if time.Since(lastUpdate) < progressInterval {
+			return
+		}
+		lastUpdate = time.Now()
+		io.WriteString(line, fmt.Sprintf("    ├─ %s : reading... %d files (%s)", shortName, filesRead, humanize.Bytes(uint64(bytesRead))))
+	}
 
-	fileInfos := getFileList(reader)
+	fileInfos, err := readFileList(reader, progress)
+	if err != nil {
+		if !viper.GetBool("ignore-errors") {
+			line.Close()
+			return fmt.Errorf("could not read layer %s: %v (use --ignore-errors to analyze what can be read)", name, err)
+		}
+		// show what could be read: the rest of the layer is lost, the last entry read may be the one that broke
+		logrus.Warnf("could not fully read layer %s: %v", name, err)
+		if len(fileInfos) == 0 || !fileInfos[len(fileInfos)-1].Unreadable {
+			tree.UnreadableEntries++
+		}
+	}
 
-	shortName := name[:15]
 	pb := NewProgressBar(int64(len(fileInfos)))
 	for idx, element := range fileInfos {
-		tree.FileSize += uint64(element.TarHeader.FileInfo().Size())
-		tree.AddPath(element.Path, element)
+		tree.AddEntry(element.Path, element)
+		if element.Unreadable {
+			tree.UnreadableEntries++
+		}
 
 		if pb.Update(int64(idx)) {
 			io.WriteString(line, fmt.Sprintf("    ├─ %s : %s", shortName, pb.String()))
@@ -142,27 +222,262 @@ func processLayerTar(line *jotframe.Line, layerMap map[string]*filetree.FileTree
 
 	layerMap[tree.Name] = tree
 	line.Close()
+	return nil
 }
 
-func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, filetree.EfficiencySlice) {
-	var layerMap = make(map[string]*filetree.FileTree)
-	var trees = make([]*filetree.FileTree, 0)
+// InitializeData reads the given image and builds the tree of each of its layers, returning the layers, their trees,
+// the efficiency analysis of the image, and the platform of the image.
+func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, filetree.EfficiencySlice, string) {
+	configureFileTree()
+	configureLayerCache()
+
+	// multi-platform images are read for the platform of the host, unless one is asked for
+	requestedPlatform := viper.GetString("platform")
+	platform := requestedPlatform
+	if platform == "" {
+		platform = hostPlatform()
+	}
+	if _, err := parsePlatform(platform); err != nil {
+		logrus.Error("Invalid config value for 'platform': " + err.Error())
+		utils.Exit(1)
+	}
 
-	// pull the image if it does not exist
-	ctx := context.Background()
-	dockerClient, err := client.NewClientWithOpts(client.WithVersion(dockerVersion), client.FromEnv)
+	var manifest ImageManifest
+	var config ImageConfig
+	var layerMap map[string]*filetree.FileTree
+	source, ref := parseImageSource(imageID)
+	if !validSource(source) {
+		logrus.Error("Invalid config value for 'source': unknown image source '" + source + "' (supported: " + strings.Join(sources, ", ") + ")")
+		utils.Exit(1)
+	}
+	// without a source, local archives and layouts come first, then the container engines
+	var localAttempt *sourceAttempt
+	if source == "" {
+		detected, err := detectLocalSource(ref)
+		if err != nil {
+			localAttempt = &sourceAttempt{SourceArchive + ", " + SourceOCI, err}
+		}
+		source = detected
+	}
+	switch source {
+	case SourceOCI:
+		if ref == stdinRef {
+			// OCI layout archives are read like docker-archives holding only an index
+			manifest, config, layerMap = fetchArchiveStream(os.Stdin, platform)
+		} else {
+			manifest, config, layerMap = fetchOCILayout(ref, platform)
+		}
+	case SourceContainerd:
+		manifest, config, layerMap = fetchContainerdImage(ref, platform)
+	case SourceArchive:
+		if ref == stdinRef {
+			manifest, config, layerMap = fetchArchiveStream(os.Stdin, platform)
+		} else {
+			manifest, config, layerMap = fetchArchiveImage(ref, platform)
+		}
+	case SourceRegistry:
+		manifest, config, layerMap = fetchRegistryImage(ref, platform)
+	case SourceDir:
+		manifest, config, layerMap = fetchDirImage(ref)
+	default:
+		var containerEngine engine
+		engines, unreachable, err := systemEngineEnv.resolveEngines(source)
+		if err == nil {
+			containerEngine, err = findEngineImage(engines, unreachable, func(containerEngine engine) error {
+				return prepareEngineImage(containerEngine, ref, requestedPlatform)
+			})
+		}
+		if attempts, ok := err.(sourceAttempts); ok && localAttempt != nil {
+			attempts.attempts = append([]sourceAttempt{*localAttempt}, attempts.attempts...)
+			err = attempts
+		}
+		if err != nil {
+			logrus.Error("Could not find a source for '" + ref + "': " + err.Error())
+			utils.Exit(1)
+		}
+		manifest, config, layerMap = fetchEngineImage(containerEngine, ref)
+	}
+
+	// windows layers hold the container filesystem under Files/, next to the registry hives and the utility VM
+	if strings.EqualFold(config.OS, "windows") {
+		for name, tree := range layerMap {
+			layerMap[name] = filetree.WindowsLayerTree(tree)
+		}
+	}
+
+	// build the content tree
+	logrus.Info("  Building tree...")
+	trees := layerTrees(manifest, layerMap)
+	var duplicates int
+	for _, tree := range trees {
+		duplicates += tree.DuplicateEntries
+	}
+	if duplicates > 0 {
+		logrus.Infof("  Found %d duplicate tar entries, using the last entry of each path", duplicates)
+	}
+	logUnreadableEntries(trees)
+
+	// build the layers array
+	layers := make([]*Layer, len(trees))
+
+	// note that the image config stores images in reverse chronological order, so iterate backwards through layers
+	// as you iterate chronologically through history (ignoring history items that have no layer contents)
+	layerIdx := len(trees) - 1
+	tarPathIdx := 0
+	var previous *Layer
+	var metadata []ImageHistoryEntry
+	for idx := 0; idx < len(config.History); idx++ {
+		// empty layers only change metadata: they belong to the layer they follow (or the first layer)
+		if config.History[idx].EmptyLayer {
+			if previous != nil {
+				previous.Metadata = append(previous.Metadata, config.History[idx])
+			} else {
+				metadata = append(metadata, config.History[idx])
+			}
+			continue
+		}
+
+		tree := trees[(len(trees)-1)-layerIdx]
+		config.History[idx].Size = uint64(tree.FileSize)
+
+		var blobSize uint64
+		if layerIdx < len(manifest.LayerSizes) && manifest.LayerSizes[layerIdx] > 0 {
+			blobSize = uint64(manifest.LayerSizes[layerIdx])
+		}
+
+		layers[layerIdx] = &Layer{
+			History:  config.History[idx],
+			Index:    layerIdx,
+			Tree:     trees[layerIdx],
+			RefTrees: trees,
+			TarPath:  manifest.LayerTarPaths[tarPathIdx],
+			BlobSize: blobSize,
+			Metadata: metadata,
+		}
+		previous, metadata = layers[layerIdx], nil
+
+		layerIdx--
+		tarPathIdx++
+	}
+
+	logrus.Info("  Analyzing layers...")
+	efficiency, inefficiencies := filetree.Efficiency(trees)
+
+	return layers, trees, efficiency, inefficiencies, config.Platform()
+}
+
+// layerTrees returns the tree of every layer of the manifest (from the given trees by layer), lowest first, each
+// recording its layer index (see filetree.FileTree.SetLayer). A layer listed several times (e.g. the same empty layer
+// blob) gets a copy of its tree for every repetition, so each occurrence keeps its own index.
+func layerTrees(manifest ImageManifest, layerMap map[string]*filetree.FileTree) []*filetree.FileTree {
+	trees := make([]*filetree.FileTree, 0, len(manifest.LayerTarPaths))
+	seen := make(map[*filetree.FileTree]bool)
+	for idx, treeName := range manifest.LayerTarPaths {
+		tree := layerMap[treeName]
+		if seen[tree] {
+			tree = tree.Copy()
+		}
+		seen[tree] = true
+		tree.SetLayer(idx)
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+// logUnreadableEntries warns about the layer entries that could not be read (when errors are ignored), by layer.
+func logUnreadableEntries(trees []*filetree.FileTree) {
+	var total int
+	var layers []string
+	for idx, tree := range trees {
+		if tree.UnreadableEntries > 0 {
+			total += tree.UnreadableEntries
+			layers = append(layers, fmt.Sprintf("layer %d: %d", idx, tree.UnreadableEntries))
+		}
+	}
+	if total > 0 {
+		logrus.Warnf("%d layer entries could not be read (%s), they are marked as unreadable and their sizes are unknown", total, strings.Join(layers, ", "))
+	}
+}
+
+// configureFileTree applies the filetree and diff settings of the config, exiting on invalid values.
+func configureFileTree() {
+	err := filetree.SetHashAlgorithm(viper.GetString("filetree.hash-algorithm"))
 	if err != nil {
-		fmt.Println("Could not connect to the Docker daemon:" + err.Error())
+		logrus.Error("Invalid config value for 'filetree.hash-algorithm': " + err.Error())
 		utils.Exit(1)
 	}
-	_, _, err = dockerClient.ImageInspectWithRaw(ctx, imageID)
+
+	err = filetree.SetReadChunkSize(viper.GetInt("filetree.read-chunk-size"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'filetree.read-chunk-size': " + err.Error())
+		utils.Exit(1)
+	}
+
+	err = filetree.SetMaxHashSize(viper.GetInt64("filetree.max-hash-size"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'filetree.max-hash-size': " + err.Error())
+		utils.Exit(1)
+	}
+
+	err = filetree.SetSizeMode(viper.GetString("filetree.size-mode"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'filetree.size-mode': " + err.Error())
+		utils.Exit(1)
+	}
+
+	err = filetree.SetCompareAttributes(viper.GetStringSlice("diff.compare-attributes"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'diff.compare-attributes': " + err.Error())
+		utils.Exit(1)
+	}
+	filetree.SetStrictCompare(viper.GetBool("diff.strict-compare"))
+
+	err = filetree.SetComparison(viper.GetString("diff.comparator"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'diff.comparator': " + err.Error())
+		utils.Exit(1)
+	}
+
+	err = filetree.SetIgnoreGlobs(viper.GetStringSlice("filetree.ignore"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'filetree.ignore': " + err.Error())
+		utils.Exit(1)
+	}
+}
+
+// prepareEngineImage makes sure the container engine holds the given image, pulling it when it does not. The engine
+// holds a single platform of an image: when a platform is given and the image held is of another one, the image of
+// that platform is pulled.
+func prepareEngineImage(containerEngine engine, imageID, platform string) error {
+	ctx := context.Background()
+	dockerClient, err := containerEngine.newClient()
+	if err != nil {
+		return fmt.Errorf("could not connect: %v", err)
+	}
+	defer dockerClient.Close()
+
+	inspect, _, err := dockerClient.ImageInspectWithRaw(ctx, imageID)
 	if err != nil {
 		// don't use the API, the CLI has more informative output
-		fmt.Println("Image not available locally... Trying to pull '" + imageID + "'")
-		utils.RunDockerCmd("pull", imageID)
+		logrus.Info("Image not available locally in " + containerEngine.name + "... Trying to pull '" + imageID + "'")
+		if err := utils.RunEngineCmd(containerEngine.name, "pull", pullArgs(imageID, platform)...); err != nil {
+			return fmt.Errorf("not available locally, and pulling it failed (%v)", err)
+		}
+	} else if platform != "" && !engineImageMatches(inspect.Os, inspect.Architecture, platform) {
+		logrus.Info("Image available locally for another platform... Trying to pull '" + imageID + "' for " + platform)
+		if err := utils.RunEngineCmd(containerEngine.name, "pull", pullArgs(imageID, platform)...); err != nil {
+			return fmt.Errorf("only available locally for %s/%s, and pulling it for %s failed (%v)", inspect.Os, inspect.Architecture, platform, err)
+		}
 	}
+	return nil
+}
+
+// fetchEngineImage reads the given image from a container engine holding it (see prepareEngineImage), returning the
+// image manifest and config along with the tree of every layer (by tar path).
+func fetchEngineImage(containerEngine engine, imageID string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	var layerMap = make(map[string]*filetree.FileTree)
 
-	tarFile, totalSize := getImageReader(imageID)
+	tarFile, totalSize := getImageReader(containerEngine, imageID)
 	defer tarFile.Close()
 
 	var observedBytes int64
@@ -187,7 +502,7 @@ func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, fi
 		}
 
 		if err != nil {
-			fmt.Println(err)
+			logrus.Error(err)
 			utils.Exit(1)
 		}
 
@@ -196,7 +511,6 @@ func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, fi
 		io.WriteString(frame.Header(), fmt.Sprintf("  Discovering layers... %d %%", percent))
 
 		name := header.Name
-		var n int
 
 		// some layer tars can be relative layer symlinks to other layer tars
 		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeReg {
@@ -206,15 +520,26 @@ func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, fi
 				if err != nil {
 					logrus.Panic(err)
 				}
-				shortName := name[:15]
+				shortName := shortLayerName(name)
 				io.WriteString(line, "    ├─ "+shortName+" : loading...")
 
-				layerReader := tar.NewReader(tarReader)
-				processLayerTar(line, layerMap, name, layerReader)
+				if header.Typeflag == tar.TypeSymlink {
+					err = processLayerTar(line, layerMap, name, tar.NewReader(tarReader))
+				} else {
+					err = loadLayerTree(line, layerMap, name, func() (io.ReadCloser, error) {
+						// layers are saved uncompressed, the blob is the tar itself
+						blob := &blobCounter{Reader: tarReader}
+						return layerStream{ioutil.NopCloser(blob), nil, blob}, nil
+					})
+				}
+				if err != nil {
+					frame.Close()
+					logrus.Error("Could not read the image: " + err.Error())
+					utils.Exit(1)
+				}
 			} else if strings.HasSuffix(name, ".json") {
-				var fileBuffer = make([]byte, header.Size)
-				n, err = tarReader.Read(fileBuffer)
-				if err != nil && err != io.EOF && int64(n) != header.Size {
+				fileBuffer, err := ioutil.ReadAll(tarReader)
+				if err != nil {
 					logrus.Panic(err)
 				}
 				jsonFiles[name] = fileBuffer
@@ -224,56 +549,36 @@ func InitializeData(imageID string) ([]*Layer, []*filetree.FileTree, float64, fi
 	frame.Header().Close()
 	frame.Wait()
 	frame.Remove(lastLine)
-	fmt.Println("")
 
 	manifest := NewImageManifest(jsonFiles["manifest.json"])
 	config := NewImageConfig(jsonFiles[manifest.ConfigPath])
+	return manifest, config, layerMap
+}
 
-	// build the content tree
-	fmt.Println("  Building tree...")
-	for _, treeName := range manifest.LayerTarPaths {
-		trees = append(trees, layerMap[treeName])
+// pullArgs returns the arguments of the engine pull command for the given image (for the given platform, if any).
+func pullArgs(imageID, platform string) []string {
+	if platform == "" {
+		return []string{imageID}
 	}
+	return []string{"--platform", platform, imageID}
+}
 
-	// build the layers array
-	layers := make([]*Layer, len(trees))
-
-	// note that the image config stores images in reverse chronological order, so iterate backwards through layers
-	// as you iterate chronologically through history (ignoring history items that have no layer contents)
-	layerIdx := len(trees) - 1
-	tarPathIdx := 0
-	for idx := 0; idx < len(config.History); idx++ {
-		// ignore empty layers, we are only observing layers with content
-		if config.History[idx].EmptyLayer {
-			continue
-		}
-
-		tree := trees[(len(trees)-1)-layerIdx]
-		config.History[idx].Size = uint64(tree.FileSize)
-
-		layers[layerIdx] = &Layer{
-			History:  config.History[idx],
-			Index:    layerIdx,
-			Tree:     trees[layerIdx],
-			RefTrees: trees,
-			TarPath:  manifest.LayerTarPaths[tarPathIdx],
-		}
-
-		layerIdx--
-		tarPathIdx++
+// engineImageMatches indicates if an image of the given os and architecture (as inspected, engines do not report
+// the variant) is of the given platform.
+func engineImageMatches(os, architecture, platform string) bool {
+	wanted, err := parsePlatform(platform)
+	if err != nil {
+		return false
 	}
-
-	fmt.Println("  Analyzing layers...")
-	efficiency, inefficiencies := filetree.Efficiency(trees)
-
-	return layers, trees, efficiency, inefficiencies
+	wanted.Variant = ""
+	return ociPlatform{OS: os, Architecture: architecture}.matches(wanted)
 }
 
-func getImageReader(imageID string) (io.ReadCloser, int64) {
+func getImageReader(containerEngine engine, imageID string) (io.ReadCloser, int64) {
 	ctx := context.Background()
-	dockerClient, err := client.NewClientWithOpts(client.WithVersion(dockerVersion), client.FromEnv)
+	dockerClient, err := containerEngine.newClient()
 	if err != nil {
-		fmt.Println("Could not connect to the Docker daemon:" + err.Error())
+		logrus.Error("Could not connect to " + containerEngine.name + ": " + err.Error())
 		utils.Exit(1)
 	}
 
@@ -297,8 +602,27 @@ func getImageReader(imageID string) (io.ReadCloser, int64) {
 	return readCloser, totalSize
 }
 
-func getFileList(tarReader *tar.Reader) []filetree.FileInfo {
+func getFileList(tarReader *tar.Reader) ([]filetree.FileInfo, error) {
+	return readFileList(tarReader, nil)
+}
+
+// readFileList reads the entries of a layer tar like getFileList, invoking the given progress callback (when not nil)
+// after every entry read. An entry that cannot be read stops the reading with an error, unless errors are ignored
+// (ignore-errors): the entry is then kept, marked as unreadable. Unlike filetree.SetProgressHandler, the callback only
+// sees the entries of this layer, so layers can be read concurrently. The tar is consumed as a stream: file contents
+// are hashed a chunk at a time and never held in memory, so only the file list grows with the size of the layer.
+func readFileList(tarReader *tar.Reader, progress filetree.ProgressHandler) ([]filetree.FileInfo, error) {
 	var files []filetree.FileInfo
+	var ignored int
+	defer func() {
+		if ignored > 0 {
+			logrus.Infof("ignored %d layer entries matching filetree.ignore", ignored)
+		}
+	}()
+	var longName, longLink string
+	var estargz estargzEntries
+	hashContents := !viper.GetBool("filetree.metadata-only")
+	ignoreErrors := viper.GetBool("ignore-errors")
 
 	for {
 		header, err := tarReader.Next()
@@ -308,20 +632,72 @@ func getFileList(tarReader *tar.Reader) []filetree.FileInfo {
 		}
 
 		if err != nil {
-			fmt.Println(err)
-			utils.Exit(1)
+			return estargz.strip(files), err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeGNULongName, tar.TypeGNULongLink:
+			// archive/tar normally applies these itself, but should one surface it only describes the next entry
+			value, err := readLongName(tarReader)
+			if err != nil {
+				return estargz.strip(files), err
+			}
+			if header.Typeflag == tar.TypeGNULongName {
+				longName = value
+			} else {
+				longLink = value
+			}
+			continue
 		}
 
+		if longName != "" {
+			header.Name = longName
+		}
+		if longLink != "" {
+			header.Linkname = longLink
+		}
+		longName, longLink = "", ""
 		name := header.Name
 
 		switch header.Typeflag {
 		case tar.TypeXGlobalHeader:
-			fmt.Printf("ERRG: XGlobalHeader: %v: %s\n", header.Typeflag, name)
+			logrus.Debugf("skipping XGlobalHeader: %v: %s", header.Typeflag, name)
 		case tar.TypeXHeader:
-			fmt.Printf("ERRG: XHeader: %v: %s\n", header.Typeflag, name)
+			logrus.Debugf("skipping XHeader: %v: %s", header.Typeflag, name)
 		default:
-			files = append(files, filetree.NewFileInfo(tarReader, header, name))
+			if filetree.IsIgnored(name) {
+				// skipped before reading the contents, the tar reader moves past them
+				logrus.Debugf("ignoring layer entry %s", name)
+				ignored++
+				continue
+			}
+			var fileInfo filetree.FileInfo
+			if isEstargzCandidate(header, name) {
+				fileInfo, err = estargz.readEstargzCandidate(tarReader, header, name, hashContents, len(files))
+			} else {
+				fileInfo, err = filetree.NewFileInfo(tarReader, header, name, hashContents)
+			}
+			if err != nil {
+				if !ignoreErrors {
+					return estargz.strip(files), err
+				}
+				// keep the entry (marked as unreadable) so it is still represented in the tree
+				logrus.Warnf("unable to read tar entry: %v", err)
+			}
+			if progress != nil {
+				progress(name, header.Size)
+			}
+			files = append(files, fileInfo)
 		}
 	}
-	return files
+	return estargz.strip(files), nil
+}
+
+// readLongName reads the (NUL terminated) path held by a GNU long name or long link entry.
+func readLongName(tarReader *tar.Reader) (string, error) {
+	value, err := ioutil.ReadAll(tarReader)
+	if err != nil {
+		return "", fmt.Errorf("could not read long name entry: %v", err)
+	}
+	return strings.TrimRight(string(value), "\x00"), nil
 }
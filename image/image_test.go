@@ -0,0 +1,244 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+)
+
+// rawHeader builds a GNU format tar header block, archive/tar refuses to write the pseudo-entry typeflags itself.
+func rawHeader(name string, typeFlag byte, size int64, link string) []byte {
+	block := make([]byte, 512)
+	field := func(offset, length int, value int64) {
+		copy(block[offset:offset+length], fmt.Sprintf("%0*o", length-1, value))
+	}
+	copy(block[0:100], name)
+	field(100, 8, 0777)
+	field(108, 8, 0)
+	field(116, 8, 0)
+	field(124, 12, size)
+	field(136, 12, 0)
+	block[156] = typeFlag
+	copy(block[157:257], link)
+	copy(block[257:], "ustar  \x00")
+
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+	return block
+}
+
+// gnuLongNameTar builds a layer tar with GNU long name/link pseudo-entries ahead of the (symlink) entry they describe.
+func gnuLongNameTar(name, link string) []byte {
+	var buf bytes.Buffer
+	for typeFlag, value := range map[byte]string{tar.TypeGNULongName: name, tar.TypeGNULongLink: link} {
+		data := []byte(value + "\x00")
+		buf.Write(rawHeader("././@LongLink", typeFlag, int64(len(data)), ""))
+		buf.Write(data)
+		buf.Write(make([]byte, 512-len(data)%512))
+	}
+	buf.Write(rawHeader(name[:100], tar.TypeSymlink, 0, link[:100]))
+	buf.Write(make([]byte, 1024))
+	return buf.Bytes()
+}
+
+func TestGetFileListLongNames(t *testing.T) {
+	name := "usr/share/" + strings.Repeat("a", 190)
+	link := "usr/lib/" + strings.Repeat("b", 190)
+
+	cases := map[string][]byte{
+		"pseudo entries": gnuLongNameTar(name, link),
+	}
+
+	// the usual round trip, where archive/tar writes and consumes the pseudo-entries itself
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	writer.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: link, Format: tar.FormatGNU})
+	writer.Close()
+	cases["archive/tar"] = buf.Bytes()
+
+	for label, data := range cases {
+		files, err := getFileList(tar.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("[%s] Expected no error reading the layer, got: %v", label, err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("[%s] Expected a single entry, got %d", label, len(files))
+		}
+		if files[0].Path != name || files[0].Linkname != link {
+			t.Errorf("[%s] Expected the long name and link to be applied, got '%s' -> '%s'", label, files[0].Path, files[0].Linkname)
+		}
+	}
+}
+
+func TestNewImageConfigHistory(t *testing.T) {
+	config := NewImageConfig([]byte(`{
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:aaa", "sha256:bbb", "sha256:ccc"]},
+		"history": [
+			{"created": "2019-03-12T10:04:05.123Z", "author": "packer", "created_by": "/bin/sh -c #(nop) ADD file:abc in /", "comment": "base"},
+			{"created": "2019-03-12T10:05:00Z", "created_by": "/bin/sh -c #(nop)  ENV A=b", "empty_layer": true},
+			{"created": "not a time", "created_by": "/bin/sh -c make"}
+		]
+	}`))
+
+	if len(config.History) != 4 {
+		t.Fatalf("expected 4 history entries, got %d", len(config.History))
+	}
+	first := config.History[0]
+	if first.ID != "sha256:aaa" || first.Author != "packer" || first.Comment != "base" {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	if created := first.CreatedTime(); created.Year() != 2019 || created.Minute() != 4 {
+		t.Errorf("unexpected creation time: %v", created)
+	}
+	if entry := config.History[1]; entry.ID != "<missing>" || !entry.EmptyLayer {
+		t.Errorf("unexpected empty entry: %+v", entry)
+	}
+	if created := config.History[2].CreatedTime(); !created.IsZero() {
+		t.Errorf("expected a zero creation time, got %v", created)
+	}
+	// the last layer has no history of its own
+	if entry := config.History[3]; entry.ID != "sha256:ccc" || entry.CreatedBy != "" || entry.EmptyLayer {
+		t.Errorf("unexpected blank entry: %+v", entry)
+	}
+}
+
+// syntheticLayer streams a gzipped layer tar of the given number of files (of zeroes) of the given size, generated as
+// it is read so the layer itself is never held in memory.
+func syntheticLayer(files int, size int64) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		compressed, _ := gzip.NewWriterLevel(writer, gzip.BestSpeed)
+		layer := tar.NewWriter(compressed)
+		zeroes := &io.LimitedReader{}
+		for idx := 0; idx < files; idx++ {
+			layer.WriteHeader(&tar.Header{Name: fmt.Sprintf("data/%d.bin", idx), Typeflag: tar.TypeReg, Mode: 0644, Size: size})
+			zeroes.R, zeroes.N = zeroReader{}, size
+			io.Copy(layer, zeroes)
+		}
+		layer.Close()
+		compressed.Close()
+		writer.Close()
+	}()
+	return reader
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for idx := range p {
+		p[idx] = 0
+	}
+	return len(p), nil
+}
+
+func TestReadFileListStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads a large synthetic layer")
+	}
+	const files = 8
+	const size = 32 * 1024 * 1024
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	stream, err := sniffLayer(syntheticLayer(files, size))
+	if err != nil {
+		t.Fatalf("unable to decompress the layer: %v", err)
+	}
+	list, err := readFileList(tar.NewReader(stream), nil)
+	if err != nil {
+		t.Fatalf("unable to read the layer: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if len(list) != files || list[files-1].StoredBytes != size {
+		t.Fatalf("expected %d files of %d bytes, got %d", files, size, len(list))
+	}
+	// the layer is 256MB: reading it should only cost the decompressor and the read chunk
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16*1024*1024 {
+		t.Errorf("expected the layer to be streamed, but %d bytes were allocated reading it", allocated)
+	}
+}
+
+func TestReadFileListIgnored(t *testing.T) {
+	if err := filetree.SetIgnoreGlobs([]string{"/proc/*"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer filetree.SetIgnoreGlobs(nil)
+
+	files, err := getFileList(tar.NewReader(bytes.NewReader(layerTar("proc/1/status", "proc/self", "etc/hosts"))))
+	if err != nil {
+		t.Fatalf("unable to read the layer: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "etc/hosts" {
+		t.Errorf("expected only etc/hosts to be read, got %+v", files)
+	}
+}
+
+func TestReadFileListErrors(t *testing.T) {
+	// the contents of the last file are cut short
+	layer := layerTar("etc/hosts", "usr/bin/tool")
+	truncated := layer[:3*512+5]
+
+	files, err := getFileList(tar.NewReader(bytes.NewReader(truncated)))
+	if err == nil || !strings.Contains(err.Error(), "could not read 'usr/bin/tool'") {
+		t.Errorf("expected the broken entry to stop the reading, got: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "etc/hosts" {
+		t.Errorf("expected only the entries before the broken one, got %+v", files)
+	}
+
+	viper.Set("ignore-errors", true)
+	defer viper.Set("ignore-errors", false)
+	files, err = getFileList(tar.NewReader(bytes.NewReader(truncated)))
+	if err == nil {
+		t.Error("expected the end of the layer to be reported as unreadable")
+	}
+	if len(files) != 2 || files[0].Unreadable || !files[1].Unreadable {
+		t.Errorf("expected the broken entry to be kept and marked as unreadable, got %+v", files)
+	}
+}
+
+func TestShortLayerName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"sha256:0123456789abcdef": "sha256:01234567",
+		"layer0":                  "layer0",
+		"":                        "",
+	} {
+		if short := shortLayerName(name); short != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, short)
+		}
+	}
+}
+
+func TestLayerTreesRepeatedLayer(t *testing.T) {
+	empty, app := testLayerTree("/etc/hosts"), testLayerTree("/app/run")
+	manifest := ImageManifest{LayerTarPaths: []string{"sha256:empty", "sha256:app", "sha256:empty"}}
+	trees := layerTrees(manifest, map[string]*filetree.FileTree{"sha256:empty": empty, "sha256:app": app})
+
+	if len(trees) != 3 || trees[0] == trees[2] {
+		t.Fatalf("expected a tree of its own for the repeated layer, got %v", trees)
+	}
+	for idx, path := range []string{"/etc/hosts", "/app/run", "/etc/hosts"} {
+		node, err := trees[idx].GetNode(path)
+		if err != nil {
+			t.Fatalf("[%d] missing %s: %v", idx, path, err)
+		}
+		if node.Data.AddedLayer != idx || node.Data.ModifiedLayer != idx {
+			t.Errorf("[%d] expected the layer index %d, got %d/%d", idx, idx, node.Data.AddedLayer, node.Data.ModifiedLayer)
+		}
+	}
+}
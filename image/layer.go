@@ -18,6 +18,10 @@ type Layer struct {
 	Index    int
 	Tree     *filetree.FileTree
 	RefTrees []*filetree.FileTree
+	// BlobSize is the size of the layer blob given by the image manifest, 0 when the source does not provide it
+	BlobSize uint64
+	// Metadata holds the history entries of the metadata-only steps (e.g. ENV, CMD) following this layer
+	Metadata []ImageHistoryEntry
 }
 
 // ShortId returns the truncated id of the current layer.
@@ -47,11 +51,21 @@ func (layer *Layer) ShortId() string {
 	return id
 }
 
+// Command returns the command that created the layer, as written in the Dockerfile (see CleanCommand).
+func (layer *Layer) Command() string {
+	return CleanCommand(layer.History.CreatedBy)
+}
+
+// RawCommand returns the command that created the layer, as recorded in the image history.
+func (layer *Layer) RawCommand() string {
+	return layer.History.CreatedBy
+}
+
 // String represents a layer in a columnar format.
 func (layer *Layer) String() string {
 
 	return fmt.Sprintf(LayerFormat,
 		layer.ShortId(),
 		humanize.Bytes(uint64(layer.History.Size)),
-		strings.TrimPrefix(layer.History.CreatedBy, "/bin/sh -c "))
+		layer.Command())
 }
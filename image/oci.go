@@ -0,0 +1,315 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+	"github.com/wagoodman/jotframe"
+)
+
+const (
+	ociIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociManifestMediaType        = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// ociRefNameAnnotation names an image within an OCI layout (e.g. "v1")
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+	// containerdNameAnnotation holds the full image name (e.g. "docker.io/library/alpine:3.8") in layouts exported
+	// by containerd and buildkit
+	containerdNameAnnotation = "io.containerd.image.name"
+)
+
+// ociDigestPattern matches the digests that can be mapped to a blob path (without escaping the layout)
+var ociDigestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+// ociDescriptor references a blob of an OCI layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ociPlatform is the platform of an image referenced by an image index
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String of a platform, as "os/arch[/variant]"
+func (platform ociPlatform) String() string {
+	if platform.Variant != "" {
+		return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+	}
+	return platform.OS + "/" + platform.Architecture
+}
+
+// ociIndex is the index.json of an OCI layout, or an image index (manifest list) blob
+type ociIndex struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI (or Docker v2) image manifest
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// layerOpener opens the (decompressed) tar stream of a layer
+type layerOpener func() (io.ReadCloser, error)
+
+// ociLayerReader reads a single layer, identified by its digest. The layer is only opened when its contents are
+// needed (e.g. not when its tree is cached).
+type ociLayerReader func(digest string, open layerOpener) error
+
+// splitLayoutRef splits an OCI layout reference ("path/to/layout:v1") into the layout directory and the name of the
+// image within it (empty when not given).
+func splitLayoutRef(ref string) (string, string) {
+	idx := strings.LastIndex(ref, ":")
+	if idx <= 0 || strings.ContainsAny(ref[idx+1:], `/\`) {
+		return ref, ""
+	}
+	return ref[:idx], ref[idx+1:]
+}
+
+// fetchOCILayout reads the image of an OCI layout directory ("path/to/layout[:name]"), returning the image manifest
+// and config along with the tree of every layer (by layer digest).
+func fetchOCILayout(ref, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	dir, name := splitLayoutRef(ref)
+	return fetchOCILayoutImage(dir, name, platform)
+}
+
+// fetchOCILayoutImage reads the image with the given name (see selectOCIManifest) and platform of an OCI layout
+// directory.
+func fetchOCILayoutImage(dir, name, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	manifest, configBytes, layerMap, err := readImageLayers(layerWorkers(), func(readLayer ociLayerReader) (ImageManifest, []byte, error) {
+		return readOCILayout(dir, name, platform, readLayer)
+	})
+	if err != nil {
+		logrus.Error("Could not read the OCI layout '" + dir + "': " + err.Error())
+		utils.Exit(1)
+	}
+	return manifest, NewImageConfig(configBytes), layerMap
+}
+
+// readImageLayers builds the tree of every layer the given function reads (by layer digest), showing the progress of
+// each layer on its own line. Returns the manifest and config read by the function. With more than one worker, layers
+// are read concurrently (on at most that many goroutines): the function must then hand out layer openers that do not
+// depend on each other, or on the function itself once it returned.
+func readImageLayers(workers int, read func(readLayer ociLayerReader) (ImageManifest, []byte, error)) (ImageManifest, []byte, map[string]*filetree.FileTree, error) {
+	var layerMap = make(map[string]*filetree.FileTree)
+
+	frame := jotframe.NewFixedFrame(1, true, false, false)
+	lastLine := frame.Lines()[0]
+	io.WriteString(lastLine, "    ╧")
+	lastLine.Close()
+	io.WriteString(frame.Header(), "  Discovering layers...")
+
+	pool := newLayerPool(workers)
+	pool.onDone = func(done int) {
+		io.WriteString(frame.Header(), fmt.Sprintf("  Discovering layers... %d read", done))
+	}
+	manifest, configBytes, err := read(func(digest string, open layerOpener) error {
+		line, err := frame.Prepend()
+		if err != nil {
+			logrus.Panic(err)
+		}
+		io.WriteString(line, "    ├─ "+shortLayerName(digest)+" : loading...")
+		if workers <= 1 {
+			return loadLayerTree(line, layerMap, digest, open)
+		}
+
+		pool.run(digest, func() error {
+			trees := make(map[string]*filetree.FileTree)
+			err := loadLayerTree(line, trees, digest, open)
+			pool.lock.Lock()
+			layerMap[digest] = trees[digest]
+			pool.lock.Unlock()
+			return err
+		})
+		return nil
+	})
+	if poolErr := pool.wait(); err == nil {
+		err = poolErr
+	}
+	if err != nil {
+		frame.Close()
+		return manifest, nil, nil, err
+	}
+	io.WriteString(frame.Header(), "  Discovering layers... Done!")
+	frame.Header().Close()
+	frame.Wait()
+	frame.Remove(lastLine)
+
+	return manifest, configBytes, layerMap, nil
+}
+
+// readOCILayout reads the index of an OCI layout directory, selects the image with the given name (any image when
+// the layout holds just one) and platform, and passes each of its layers to the given reader, lowest first. Returns
+// the manifest of the image (listing the layers by digest) and its config.
+func readOCILayout(dir, name, platform string, readLayer ociLayerReader) (ImageManifest, []byte, error) {
+	var manifest ImageManifest
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		return manifest, nil, fmt.Errorf("not an OCI image layout (%v)", err)
+	}
+
+	var index ociIndex
+	indexBytes, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return manifest, nil, err
+	}
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return manifest, nil, fmt.Errorf("could not parse index.json: %v", err)
+	}
+
+	imageManifest, _, err := resolveOCIImage(index, name, platform, func(digest string) ([]byte, error) {
+		return readOCIBlob(dir, digest)
+	})
+	if err != nil {
+		return manifest, nil, err
+	}
+	configBytes, err := readOCIBlob(dir, imageManifest.Config.Digest)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	manifest.ConfigPath = imageManifest.Config.Digest
+	for _, layer := range imageManifest.Layers {
+		if err := readOCILayer(dir, layer, readLayer); err != nil {
+			return manifest, nil, fmt.Errorf("could not read layer %s: %v", layer.Digest, err)
+		}
+		manifest.LayerTarPaths = append(manifest.LayerTarPaths, layer.Digest)
+		manifest.LayerSizes = append(manifest.LayerSizes, layer.Size)
+	}
+	return manifest, configBytes, nil
+}
+
+// resolveOCIImage selects the image with the given name and platform from an image index (following nested indexes),
+// and returns its manifest, read with the given function. Also indicates if the platform had to be selected among
+// several (even when failing to).
+func resolveOCIImage(index ociIndex, name, platform string, readBlob func(digest string) ([]byte, error)) (ociManifest, bool, error) {
+	var imageManifest ociManifest
+	readJSON := func(digest string, value interface{}) error {
+		data, err := readBlob(digest)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, value); err != nil {
+			return fmt.Errorf("could not parse blob %s: %v", digest, err)
+		}
+		return nil
+	}
+
+	multiPlatform := name == "" && isMultiPlatform(index.Manifests)
+	descriptor, err := selectOCIManifest(index.Manifests, name, platform)
+	if err != nil {
+		return imageManifest, multiPlatform, err
+	}
+	// an image index (e.g. of a multi-platform image) references the manifests of the image itself
+	for descriptor.MediaType == ociIndexMediaType || descriptor.MediaType == dockerManifestListMediaType {
+		var nested ociIndex
+		if err := readJSON(descriptor.Digest, &nested); err != nil {
+			return imageManifest, multiPlatform, err
+		}
+		multiPlatform = multiPlatform || isMultiPlatform(nested.Manifests)
+		indexDigest := descriptor.Digest
+		descriptor, err = selectOCIManifest(nested.Manifests, "", platform)
+		if err != nil {
+			return imageManifest, multiPlatform, fmt.Errorf("cannot select an image from index %s: %v", indexDigest, err)
+		}
+	}
+
+	err = readJSON(descriptor.Digest, &imageManifest)
+	return imageManifest, multiPlatform, err
+}
+
+// selectOCIManifest returns the descriptor of the image with the given name, which is either the ref name annotation
+// (e.g. "v1") or the full image name annotation (e.g. "docker.io/library/alpine:3.8", or just its tag). Without a
+// name, the single image of the list is returned. When the matching images are those of several platforms, the one of
+// the given platform is returned.
+func selectOCIManifest(descriptors []ociDescriptor, name, platform string) (ociDescriptor, error) {
+	var names []string
+	var matches []ociDescriptor
+	for _, descriptor := range descriptors {
+		refName, fullName := descriptor.Annotations[ociRefNameAnnotation], descriptor.Annotations[containerdNameAnnotation]
+		if refName != "" {
+			names = append(names, refName)
+		} else if fullName != "" {
+			names = append(names, fullName)
+		}
+		if name == "" || name == refName || name == fullName || (fullName != "" && strings.HasSuffix(fullName, ":"+name)) {
+			matches = append(matches, descriptor)
+		}
+	}
+	sort.Strings(names)
+
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case isMultiPlatform(matches):
+		return selectOCIPlatform(matches, platform)
+	case len(descriptors) == 0:
+		return ociDescriptor{}, fmt.Errorf("no images found")
+	case name == "":
+		return ociDescriptor{}, fmt.Errorf("%d images found, select one by name (available: %s)", len(descriptors), strings.Join(names, ", "))
+	case len(matches) == 0:
+		return ociDescriptor{}, fmt.Errorf("no image named '%s' (available: %s)", name, strings.Join(names, ", "))
+	default:
+		return ociDescriptor{}, fmt.Errorf("%d images named '%s'", len(matches), name)
+	}
+}
+
+// ociBlobPath returns the path of the blob with the given digest within an OCI layout.
+func ociBlobPath(dir, digest string) (string, error) {
+	if !ociDigestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+	parts := strings.SplitN(digest, ":", 2)
+	return filepath.Join(dir, "blobs", parts[0], parts[1]), nil
+}
+
+// readOCIBlob reads the whole blob with the given digest.
+func readOCIBlob(dir, digest string) ([]byte, error) {
+	path, err := ociBlobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// readOCILayer passes the layer blob referenced by the given descriptor to the given reader, to be decompressed (per
+// its media type) when opened.
+func readOCILayer(dir string, descriptor ociDescriptor, readLayer ociLayerReader) error {
+	path, err := ociBlobPath(dir, descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	return readLayer(descriptor.Digest, func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		blob := &blobCounter{Reader: file}
+		reader, err := decompressLayer(descriptor.MediaType, blob)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return layerStream{reader, file, blob}, nil
+	})
+}
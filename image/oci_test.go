@@ -0,0 +1,278 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/wagoodman/dive/filetree"
+)
+
+// ociTestLayout builds OCI layouts in a temporary directory
+type ociTestLayout struct {
+	t   *testing.T
+	dir string
+}
+
+func newOCITestLayout(t *testing.T) *ociTestLayout {
+	dir, err := ioutil.TempDir("", "dive-oci")
+	if err != nil {
+		t.Fatalf("could not create the layout: %v", err)
+	}
+	layout := &ociTestLayout{t, dir}
+	os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	return layout
+}
+
+// blob stores the given contents, returning the descriptor of the blob
+func (layout *ociTestLayout) blob(mediaType string, data []byte) ociDescriptor {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if err := ioutil.WriteFile(filepath.Join(layout.dir, "blobs", "sha256", digest[7:]), data, 0644); err != nil {
+		layout.t.Fatalf("could not write blob: %v", err)
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// jsonBlob stores the JSON encoding of the given value
+func (layout *ociTestLayout) jsonBlob(mediaType string, value interface{}) ociDescriptor {
+	data, _ := json.Marshal(value)
+	return layout.blob(mediaType, data)
+}
+
+// layer stores a layer tar holding the given files, gzip compressed if asked
+func (layout *ociTestLayout) layer(compress bool, files ...string) ociDescriptor {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range files {
+		writer.WriteHeader(&tar.Header{Name: file, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file))})
+		writer.Write([]byte(file))
+	}
+	writer.Close()
+	if !compress {
+		return layout.blob("application/vnd.oci.image.layer.v1.tar", buf.Bytes())
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(buf.Bytes())
+	gz.Close()
+	return layout.blob("application/vnd.oci.image.layer.v1.tar+gzip", compressed.Bytes())
+}
+
+// zstdLayer stores a zstd compressed layer tar holding the given files
+func (layout *ociTestLayout) zstdLayer(files ...string) ociDescriptor {
+	var compressed bytes.Buffer
+	encoder, _ := zstd.NewWriter(&compressed)
+	encoder.Write(layerTar(files...))
+	encoder.Close()
+	return layout.blob("application/vnd.oci.image.layer.v1.tar+zstd", compressed.Bytes())
+}
+
+// image stores the manifest and config of an image with the given layers
+func (layout *ociTestLayout) image(layers ...ociDescriptor) ociDescriptor {
+	var config ImageConfig
+	for idx, layer := range layers {
+		config.RootFs.DiffIds = append(config.RootFs.DiffIds, layer.Digest)
+		config.History = append(config.History, ImageHistoryEntry{CreatedBy: fmt.Sprintf("layer %d", idx)})
+	}
+	return layout.jsonBlob("application/vnd.oci.image.manifest.v1+json", ociManifest{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Config:    layout.jsonBlob("application/vnd.oci.image.config.v1+json", config),
+		Layers:    layers,
+	})
+}
+
+// index writes the index.json of the layout
+func (layout *ociTestLayout) index(manifests ...ociDescriptor) {
+	data, _ := json.Marshal(ociIndex{MediaType: ociIndexMediaType, Manifests: manifests})
+	ioutil.WriteFile(filepath.Join(layout.dir, "index.json"), data, 0644)
+}
+
+// read reads the layout, returning the files of every layer
+func (layout *ociTestLayout) read(name string) ([][]string, ImageManifest, error) {
+	return layout.readPlatform(name, "linux/amd64")
+}
+
+// readPlatform reads the image of the given platform from the layout, returning the files of every layer
+func (layout *ociTestLayout) readPlatform(name, platform string) ([][]string, ImageManifest, error) {
+	var layers [][]string
+	manifest, _, err := readOCILayout(layout.dir, name, platform, func(digest string, open layerOpener) error {
+		files, err := openFileList(open)
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths)
+		layers = append(layers, paths)
+		return err
+	})
+	return layers, manifest, err
+}
+
+// openFileList opens a layer and reads its files
+func openFileList(open layerOpener) ([]filetree.FileInfo, error) {
+	stream, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return getFileList(tar.NewReader(stream))
+}
+
+func named(descriptor ociDescriptor, annotation, name string) ociDescriptor {
+	descriptor.Annotations = map[string]string{annotation: name}
+	return descriptor
+}
+
+func TestReadOCILayout(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	base := layout.layer(true, "etc/os-release", "bin/sh")
+	v1 := layout.image(base, layout.layer(false, "app/v1"))
+	v2 := layout.image(base, layout.zstdLayer("app/v2"))
+	layout.index(named(v1, ociRefNameAnnotation, "v1"), named(v2, containerdNameAnnotation, "docker.io/org/app:v2"))
+
+	for _, name := range []string{"v1", "v2", "docker.io/org/app:v2"} {
+		layers, manifest, err := layout.read(name)
+		if err != nil {
+			t.Fatalf("[%s] could not read the layout: %v", name, err)
+		}
+		app := "app/" + name[len(name)-2:]
+		expected := [][]string{{"bin/sh", "etc/os-release"}, {app}}
+		if !reflect.DeepEqual(layers, expected) {
+			t.Errorf("[%s] expected layers %v, got %v", name, expected, layers)
+		}
+		if len(manifest.LayerTarPaths) != 2 || manifest.LayerTarPaths[0] != base.Digest {
+			t.Errorf("[%s] expected the layers by digest, got %v", name, manifest.LayerTarPaths)
+		}
+		if len(manifest.LayerSizes) != 2 || manifest.LayerSizes[0] != base.Size {
+			t.Errorf("[%s] expected the layer sizes of the manifest, got %v", name, manifest.LayerSizes)
+		}
+	}
+
+	// the image must be named when there are several
+	if _, _, err := layout.read(""); err == nil || !strings.Contains(err.Error(), "docker.io/org/app:v2, v1") {
+		t.Errorf("expected an error listing the images, got: %v", err)
+	}
+	if _, _, err := layout.read("v3"); err == nil {
+		t.Errorf("expected an error for an unknown image")
+	}
+
+	// a single image needs no name, also behind an image index
+	nested := layout.jsonBlob(ociIndexMediaType, ociIndex{Manifests: []ociDescriptor{v1}})
+	layout.index(nested)
+	if layers, _, err := layout.read(""); err != nil || len(layers) != 2 {
+		t.Errorf("expected the single image to be read, got %v (%v)", layers, err)
+	}
+
+	// unknown compressions are reported
+	bzip2 := layout.image(ociDescriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+bzip2", Digest: base.Digest})
+	layout.index(bzip2)
+	if _, _, err := layout.read(""); err == nil || !strings.Contains(err.Error(), "unsupported layer media type 'application/vnd.oci.image.layer.v1.tar+bzip2'") {
+		t.Errorf("expected an unsupported media type error, got: %v", err)
+	}
+
+	// as are layers not compressed as their media type says
+	mislabeled := layout.image(ociDescriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+zstd", Digest: base.Digest})
+	layout.index(mislabeled)
+	if _, _, err := layout.read(""); err == nil || !strings.Contains(err.Error(), "could not decompress layer of media type 'application/vnd.oci.image.layer.v1.tar+zstd'") {
+		t.Errorf("expected a decompression error, got: %v", err)
+	}
+}
+
+func TestParseImageSource(t *testing.T) {
+	cases := []struct {
+		image, source, ref, dir, name string
+	}{
+		{"alpine:3.8", "", "alpine:3.8", "", ""},
+		{"docker://alpine:3.8", SourceDocker, "alpine:3.8", "", ""},
+		{"podman://localhost/app", SourcePodman, "localhost/app", "", ""},
+		{"oci://./build/layout", SourceOCI, "./build/layout", "./build/layout", ""},
+		{"oci:///tmp/layout:v1", SourceOCI, "/tmp/layout:v1", "/tmp/layout", "v1"},
+		{"docker-archive://app.tar", SourceArchive, "app.tar", "", ""},
+		{"dir://./rootfs", SourceDir, "./rootfs", "", ""},
+		{"dir:/srv/rootfs", SourceDir, "/srv/rootfs", "", ""},
+	}
+	for _, test := range cases {
+		source, ref := parseImageSource(test.image)
+		if source != test.source || ref != test.ref {
+			t.Errorf("[%s] expected %s '%s', got %s '%s'", test.image, test.source, test.ref, source, ref)
+		}
+		if source != SourceOCI {
+			continue
+		}
+		if dir, name := splitLayoutRef(ref); dir != test.dir || name != test.name {
+			t.Errorf("[%s] expected layout '%s' and name '%s', got '%s' and '%s'", test.image, test.dir, test.name, dir, name)
+		}
+	}
+}
+
+func TestReadOCILayoutPlatforms(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	base := layout.layer(true, "etc/os-release")
+	amd64 := platformOf(layout.image(base, layout.layer(true, "app/amd64")), "linux", "amd64", "")
+	arm64 := platformOf(layout.image(base, layout.layer(true, "app/arm64")), "linux", "arm64", "v8")
+	attestation := platformOf(layout.jsonBlob("application/vnd.oci.image.manifest.v1+json", ociManifest{}), "unknown", "unknown", "")
+
+	// the platforms are listed either by index.json itself, or by a (named) nested index
+	nested := layout.jsonBlob(ociIndexMediaType, ociIndex{Manifests: []ociDescriptor{amd64, arm64, attestation}})
+	nested.MediaType = ociIndexMediaType
+	indexes := map[string][]ociDescriptor{
+		"top-level": {amd64, arm64},
+		"nested":    {named(nested, ociRefNameAnnotation, "v1")},
+	}
+	for name, manifests := range indexes {
+		layout.index(manifests...)
+		for platform, app := range map[string]string{"linux/amd64": "app/amd64", "linux/arm64": "app/arm64", "linux/arm64/v8": "app/arm64"} {
+			layers, _, err := layout.readPlatform("", platform)
+			if err != nil {
+				t.Fatalf("[%s %s] could not read the layout: %v", name, platform, err)
+			}
+			if len(layers) != 2 || !reflect.DeepEqual(layers[1], []string{app}) {
+				t.Errorf("[%s %s] expected the %s image, got %v", name, platform, app, layers)
+			}
+		}
+		if _, _, err := layout.readPlatform("", "linux/arm/v7"); err == nil || !strings.Contains(err.Error(), "available: linux/amd64, linux/arm64/v8") {
+			t.Errorf("[%s] expected an error listing the platforms, got: %v", name, err)
+		}
+	}
+}
+
+func TestReadOCILayerCountsBlobBytes(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	for _, descriptor := range []ociDescriptor{layout.layer(true, "etc/hosts", "bin/sh"), layout.layer(false, "etc/hosts")} {
+		err := readOCILayer(layout.dir, descriptor, func(digest string, open layerOpener) error {
+			stream, err := open()
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			if _, err := ioutil.ReadAll(stream); err != nil {
+				return err
+			}
+			if counted := stream.(layerStream).blob.bytes; counted != descriptor.Size {
+				t.Errorf("%s: expected %d blob bytes read, counted %d", descriptor.MediaType, descriptor.Size, counted)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("could not read the layer: %v", err)
+		}
+	}
+}
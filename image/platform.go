@@ -0,0 +1,71 @@
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// parsePlatform parses a platform given as "os/arch[/variant]" (e.g. "linux/arm64/v8").
+func parsePlatform(platform string) (ociPlatform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ociPlatform{}, fmt.Errorf("invalid platform '%s' (expected os/arch[/variant])", platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return ociPlatform{}, fmt.Errorf("invalid platform '%s' (expected os/arch[/variant])", platform)
+		}
+	}
+	parsed := ociPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+	return parsed, nil
+}
+
+// hostPlatform is the platform selected from multi-platform images unless configured otherwise: Linux images of the
+// architecture of the host, like the Docker CLI selects.
+func hostPlatform() string {
+	return "linux/" + runtime.GOARCH
+}
+
+// matches indicates if this platform is the given wanted platform. Without a wanted variant, any variant matches.
+func (platform ociPlatform) matches(wanted ociPlatform) bool {
+	return platform.OS == wanted.OS && platform.Architecture == wanted.Architecture &&
+		(wanted.Variant == "" || platform.Variant == wanted.Variant)
+}
+
+// selectOCIPlatform returns the descriptor of the image for the given platform ("os/arch[/variant]") from an image
+// index. Without a variant, the first image of the os and architecture is selected. Entries that are not images of
+// a platform (e.g. the attestations an index may hold, listed as "unknown/unknown") are never selected.
+func selectOCIPlatform(descriptors []ociDescriptor, platform string) (ociDescriptor, error) {
+	wanted, err := parsePlatform(platform)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	var available []string
+	for _, descriptor := range descriptors {
+		if descriptor.Platform == nil || descriptor.Platform.OS == "unknown" {
+			continue
+		}
+		available = append(available, descriptor.Platform.String())
+		if descriptor.Platform.matches(wanted) {
+			return descriptor, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("no image for platform '%s' (available: %s)", platform, strings.Join(available, ", "))
+}
+
+// isMultiPlatform indicates if the given descriptors are the images of several platforms (of a single image).
+func isMultiPlatform(descriptors []ociDescriptor) bool {
+	if len(descriptors) < 2 {
+		return false
+	}
+	for _, descriptor := range descriptors {
+		if descriptor.Platform == nil {
+			return false
+		}
+	}
+	return true
+}
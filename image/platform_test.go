@@ -0,0 +1,72 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	cases := []struct {
+		platform   string
+		expected   ociPlatform
+		shouldFail bool
+	}{
+		{"linux/amd64", ociPlatform{OS: "linux", Architecture: "amd64"}, false},
+		{"linux/arm64/v8", ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"}, false},
+		{"windows/amd64", ociPlatform{OS: "windows", Architecture: "amd64"}, false},
+		{"linux", ociPlatform{}, true},
+		{"linux//v8", ociPlatform{}, true},
+		{"linux/arm/v7/extra", ociPlatform{}, true},
+	}
+	for _, test := range cases {
+		platform, err := parsePlatform(test.platform)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("[%s] expected an error", test.platform)
+			}
+			continue
+		}
+		if err != nil || platform != test.expected {
+			t.Errorf("[%s] expected %+v, got %+v (%v)", test.platform, test.expected, platform, err)
+		}
+		if platform.String() != test.platform {
+			t.Errorf("[%s] expected the platform string back, got '%s'", test.platform, platform.String())
+		}
+	}
+}
+
+func TestSelectOCIPlatform(t *testing.T) {
+	descriptors := []ociDescriptor{
+		platformOf(ociDescriptor{Digest: "sha256:attestation"}, "unknown", "unknown", ""),
+		platformOf(ociDescriptor{Digest: "sha256:v6"}, "linux", "arm", "v6"),
+		platformOf(ociDescriptor{Digest: "sha256:v7"}, "linux", "arm", "v7"),
+		{Digest: "sha256:none"},
+	}
+	cases := map[string]string{
+		"linux/arm":    "sha256:v6",
+		"linux/arm/v7": "sha256:v7",
+	}
+	for platform, digest := range cases {
+		descriptor, err := selectOCIPlatform(descriptors, platform)
+		if err != nil || descriptor.Digest != digest {
+			t.Errorf("[%s] expected %s, got %s (%v)", platform, digest, descriptor.Digest, err)
+		}
+	}
+
+	if _, err := selectOCIPlatform(descriptors, "unknown/unknown"); err == nil || !strings.Contains(err.Error(), "available: linux/arm/v6, linux/arm/v7)") {
+		t.Errorf("expected an error listing the platforms, got: %v", err)
+	}
+	if _, err := selectOCIPlatform(descriptors, "arm"); err == nil || !strings.Contains(err.Error(), "invalid platform") {
+		t.Errorf("expected an invalid platform error, got: %v", err)
+	}
+}
+
+func TestImageConfigPlatform(t *testing.T) {
+	config := NewImageConfig([]byte(`{"os": "linux", "architecture": "arm", "variant": "v7", "rootfs": {"diff_ids": []}}`))
+	if platform := config.Platform(); platform != "linux/arm/v7" {
+		t.Errorf("expected the platform of the config, got '%s'", platform)
+	}
+	if platform := NewImageConfig([]byte(`{}`)).Platform(); platform != "" {
+		t.Errorf("expected no platform, got '%s'", platform)
+	}
+}
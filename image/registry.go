@@ -0,0 +1,340 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/utils"
+)
+
+const (
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	defaultTag        = "latest"
+)
+
+// registryManifestMediaTypes are the manifest formats accepted from registries (legacy schema1 manifests last, so
+// registries that can convert them serve schema2 instead)
+var registryManifestMediaTypes = []string{ociIndexMediaType, dockerManifestListMediaType, ociManifestMediaType, dockerManifestMediaType, dockerSchema1SignedMediaType, dockerSchema1MediaType}
+
+// challengeParamPattern matches the parameters of a WWW-Authenticate challenge (e.g. realm="https://auth.docker.io")
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryRef is a parsed image reference (e.g. "ghcr.io/org/app:tag")
+type registryRef struct {
+	// host is the registry API host (e.g. "ghcr.io" or "registry-1.docker.io")
+	host string
+	// repository is the image name within the registry (e.g. "org/app" or "library/alpine")
+	repository string
+	// reference is the tag or digest of the image
+	reference string
+}
+
+// parseRegistryRef parses an image reference, defaulting to Docker Hub (and its "library" images) and the latest tag
+// like the Docker CLI does.
+func parseRegistryRef(ref string) (registryRef, error) {
+	name, reference := ref, defaultTag
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		name, reference = name[:idx], name[idx+1:]
+	} else if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name, reference = name[:idx], name[idx+1:]
+	}
+	if name == "" || reference == "" {
+		return registryRef{}, fmt.Errorf("invalid image reference '%s'", ref)
+	}
+
+	host := dockerHubDomain
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		if first := name[:idx]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, name = first, name[idx+1:]
+		}
+	}
+	if host == dockerHubDomain {
+		host = dockerHubRegistry
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	return registryRef{host: host, repository: name, reference: reference}, nil
+}
+
+// registryClient reads manifests and blobs from registries, authenticating anonymously (or with the given
+// credentials) as the registry asks for it.
+type registryClient struct {
+	client   *http.Client
+	username string
+	password string
+	// lock guards the authentication state below, as the layers are fetched concurrently
+	lock sync.Mutex
+	// token is the bearer token of the last authentication, basic holds whether to use basic authentication instead
+	token string
+	basic bool
+}
+
+// authorization returns the authentication state requests are sent with
+func (c *registryClient) authorization() (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.token, c.basic
+}
+
+// reauthenticate answers a challenge to a request sent with the given authentication state, unless another request
+// replaced that state meanwhile: requests challenged together then share a single token.
+func (c *registryClient) reauthenticate(challenge, token string, basic bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != token || c.basic != basic {
+		return nil
+	}
+	return c.authenticate(challenge)
+}
+
+// url returns the URL of the given API path of the repository. Registries on the local host are reached over plain
+// HTTP, like the Docker daemon allows by default.
+func (c *registryClient) url(ref registryRef, path string) string {
+	scheme := "https"
+	if host := strings.Split(ref.host, ":")[0]; host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.host, ref.repository, path)
+}
+
+// get requests the given API path of the repository, authenticating when challenged. The caller closes the body of
+// the returned (successful) response.
+func (c *registryClient) get(ref registryRef, path string, accept ...string) (*http.Response, error) {
+	location := c.url(ref, path)
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest("GET", location, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range accept {
+			request.Header.Add("Accept", mediaType)
+		}
+		token, basic := c.authorization()
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		} else if basic {
+			request.SetBasicAuth(c.username, c.password)
+		}
+
+		response, err := c.client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode == http.StatusOK {
+			return response, nil
+		}
+		response.Body.Close()
+
+		if response.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := c.reauthenticate(response.Header.Get("WWW-Authenticate"), token, basic); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, fmt.Errorf("GET %s: %s", location, response.Status)
+	}
+}
+
+// authenticate answers the given WWW-Authenticate challenge, fetching a bearer token from the realm it names (with
+// the credentials, if any) or switching to basic authentication. The caller holds the lock.
+func (c *registryClient) authenticate(challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("the registry requires credentials (set registry.username and registry.password)")
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported registry authentication '%s'", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParamPattern.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid registry authentication realm '%s'", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	request, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not authenticate with %s: %s", realm.Host, response.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return fmt.Errorf("could not read the token from %s: %v", realm.Host, err)
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("no token received from %s", realm.Host)
+	}
+	return nil
+}
+
+// registryManifest is either an image manifest or an image index, as served by a registry
+type registryManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+	// raw is the manifest as served, for formats read separately (see readSchema1Manifest)
+	raw []byte
+}
+
+// manifest fetches the manifest (or index) with the given tag or digest.
+func (c *registryClient) manifest(ref registryRef, reference string) (registryManifest, error) {
+	var manifest registryManifest
+	response, err := c.get(ref, "manifests/"+reference, registryManifestMediaTypes...)
+	if err != nil {
+		return manifest, err
+	}
+	defer response.Body.Close()
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return manifest, fmt.Errorf("could not parse manifest %s: %v", reference, err)
+	}
+	manifest.raw = raw
+	if manifest.MediaType == "" {
+		manifest.MediaType = strings.Split(response.Header.Get("Content-Type"), ";")[0]
+	}
+	return manifest, nil
+}
+
+// readRegistryImage resolves the manifest of an image (selecting the given platform from multi-platform images), and
+// streams each of its layers, lowest first, to the given reader. Returns the manifest of the image (listing the
+// layers by digest) and its config.
+func readRegistryImage(c *registryClient, ref registryRef, platform string, readLayer ociLayerReader) (ImageManifest, []byte, error) {
+	var result ImageManifest
+	manifest, err := c.manifest(ref, ref.reference)
+	if err != nil {
+		return result, nil, err
+	}
+	if manifest.MediaType == ociIndexMediaType || manifest.MediaType == dockerManifestListMediaType {
+		descriptor, err := selectOCIPlatform(manifest.Manifests, platform)
+		if err != nil {
+			return result, nil, err
+		}
+		if manifest, err = c.manifest(ref, descriptor.Digest); err != nil {
+			return result, nil, err
+		}
+	}
+	var configBytes []byte
+	switch {
+	case isSchema1(manifest.MediaType, manifest.SchemaVersion):
+		logrus.Warn("the image has a legacy schema1 manifest, it is analyzed on a best-effort basis")
+		if manifest.Layers, configBytes, err = readSchema1Manifest(manifest.raw); err != nil {
+			return result, nil, err
+		}
+	case manifest.MediaType == ociManifestMediaType || manifest.MediaType == dockerManifestMediaType:
+		response, err := c.get(ref, "blobs/"+manifest.Config.Digest)
+		if err != nil {
+			return result, nil, err
+		}
+		configBytes, err = ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return result, nil, err
+		}
+		result.ConfigPath = manifest.Config.Digest
+	default:
+		return result, nil, fmt.Errorf("unsupported manifest media type '%s'", manifest.MediaType)
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := c.readLayer(ref, layer, readLayer); err != nil {
+			return result, nil, fmt.Errorf("could not read layer %s: %v", layer.Digest, err)
+		}
+		result.LayerTarPaths = append(result.LayerTarPaths, layer.Digest)
+		result.LayerSizes = append(result.LayerSizes, layer.Size)
+	}
+	return result, configBytes, nil
+}
+
+// readLayer passes the layer blob referenced by the given descriptor to the given reader, to be downloaded (and
+// decompressed as it streams in) when opened.
+func (c *registryClient) readLayer(ref registryRef, descriptor ociDescriptor, readLayer ociLayerReader) error {
+	return readLayer(descriptor.Digest, func() (io.ReadCloser, error) {
+		response, err := c.get(ref, "blobs/"+descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		blob := &blobCounter{Reader: response.Body}
+		reader, err := decompressLayer(descriptor.MediaType, blob)
+		if err != nil {
+			response.Body.Close()
+			return nil, err
+		}
+		return layerStream{reader, response.Body, blob}, nil
+	})
+}
+
+// fetchRegistryImage reads the given image straight from its registry, returning the image manifest and config along
+// with the tree of every layer (by layer digest). Layers are analyzed as they are downloaded, nothing is written to
+// disk.
+func fetchRegistryImage(imageRef, platform string) (ImageManifest, ImageConfig, map[string]*filetree.FileTree) {
+	ref, err := parseRegistryRef(imageRef)
+	if err != nil {
+		logrus.Error(err.Error())
+		utils.Exit(1)
+	}
+	client := &registryClient{
+		client:   http.DefaultClient,
+		username: viper.GetString("registry.username"),
+		password: viper.GetString("registry.password"),
+	}
+
+	logrus.Info("  Fetching " + ref.repository + ":" + ref.reference + " from " + ref.host + "...")
+	manifest, configBytes, layerMap, err := readImageLayers(layerWorkers(), func(readLayer ociLayerReader) (ImageManifest, []byte, error) {
+		return readRegistryImage(client, ref, platform, readLayer)
+	})
+	if err != nil {
+		logrus.Error("Could not read the image from the registry: " + err.Error())
+		utils.Exit(1)
+	}
+	return manifest, NewImageConfig(configBytes), layerMap
+}
@@ -0,0 +1,207 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRegistry serves the blobs of an OCI test layout as the repository "org/app", requiring a bearer token from
+// its token endpoint (which requires the credentials "user" and "pass").
+func testRegistry(layout *ociTestLayout, tags map[string]ociDescriptor) *httptest.Server {
+	mediaTypes := make(map[string]string)
+	for _, descriptor := range tags {
+		mediaTypes[descriptor.Digest] = descriptor.MediaType
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" || r.URL.Query().Get("scope") != "repository:org/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:org/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/org/app/"), "/", 2)
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		digest := parts[1]
+		if tagged, ok := tags[digest]; ok {
+			digest = tagged.Digest
+		}
+		data, err := ioutil.ReadFile(filepath.Join(layout.dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if parts[0] == "manifests" {
+			w.Header().Set("Content-Type", mediaTypes[digest])
+		}
+		w.Write(data)
+	}))
+	return server
+}
+
+func platformOf(descriptor ociDescriptor, os, arch, variant string) ociDescriptor {
+	descriptor.Platform = &ociPlatform{OS: os, Architecture: arch, Variant: variant}
+	return descriptor
+}
+
+func TestReadRegistryImage(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	base := layout.layer(true, "etc/os-release")
+	amd64 := layout.image(base, layout.layer(true, "app/amd64"))
+	arm64 := layout.image(base, layout.layer(false, "app/arm64"))
+	index := layout.jsonBlob(ociIndexMediaType, ociIndex{Manifests: []ociDescriptor{
+		platformOf(arm64, "linux", "arm64", "v8"),
+		platformOf(amd64, "linux", "amd64", ""),
+	}})
+	index.MediaType = ociIndexMediaType
+	server := testRegistry(layout, map[string]ociDescriptor{"v1": index, "v1-amd64": amd64, amd64.Digest: amd64, arm64.Digest: arm64})
+	defer server.Close()
+
+	read := func(tag, platform, username string) ([][]string, error) {
+		ref, err := parseRegistryRef(strings.TrimPrefix(server.URL, "http://") + "/org/app:" + tag)
+		if err != nil {
+			t.Fatalf("could not parse the reference: %v", err)
+		}
+		client := &registryClient{client: server.Client(), username: username, password: "pass"}
+		var layers [][]string
+		_, _, err = readRegistryImage(client, ref, platform, func(digest string, open layerOpener) error {
+			files, err := openFileList(open)
+			var paths []string
+			for _, file := range files {
+				paths = append(paths, file.Path)
+			}
+			sort.Strings(paths)
+			layers = append(layers, paths)
+			return err
+		})
+		return layers, err
+	}
+
+	cases := []struct {
+		tag, platform, app string
+	}{
+		{"v1", "linux/amd64", "app/amd64"},
+		{"v1", "linux/arm64", "app/arm64"},
+		{"v1", "linux/arm64/v8", "app/arm64"},
+		{"v1-amd64", "linux/arm64", "app/amd64"},
+	}
+	for _, test := range cases {
+		layers, err := read(test.tag, test.platform, "user")
+		if err != nil {
+			t.Fatalf("[%s %s] could not read the image: %v", test.tag, test.platform, err)
+		}
+		expected := [][]string{{"etc/os-release"}, {test.app}}
+		if !reflect.DeepEqual(layers, expected) {
+			t.Errorf("[%s %s] expected layers %v, got %v", test.tag, test.platform, expected, layers)
+		}
+	}
+
+	if _, err := read("v1", "linux/s390x", "user"); err == nil || !strings.Contains(err.Error(), "linux/arm64/v8, linux/amd64") {
+		t.Errorf("expected an error listing the platforms, got: %v", err)
+	}
+	if _, err := read("v1", "linux/amd64", "nobody"); err == nil || !strings.Contains(err.Error(), "could not authenticate") {
+		t.Errorf("expected an authentication error, got: %v", err)
+	}
+	if _, err := read("v2", "linux/amd64", "user"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected an unknown tag error, got: %v", err)
+	}
+}
+
+func TestRegistryConcurrentAuthentication(t *testing.T) {
+	var tokens int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			atomic.AddInt32(&tokens, 1)
+			time.Sleep(20 * time.Millisecond)
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "blob")
+	}))
+	defer server.Close()
+
+	ref, _ := parseRegistryRef(strings.TrimPrefix(server.URL, "http://") + "/org/app:v1")
+	client := &registryClient{client: server.Client()}
+	var wait sync.WaitGroup
+	errs := make(chan error, 8)
+	for idx := 0; idx < 8; idx++ {
+		wait.Add(1)
+		go func(idx int) {
+			defer wait.Done()
+			response, err := client.get(ref, fmt.Sprintf("blobs/sha256:%d", idx))
+			if err == nil {
+				response.Body.Close()
+			}
+			errs <- err
+		}(idx)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("could not fetch a blob: %v", err)
+		}
+	}
+	// the requests challenged together share the token
+	if tokens := atomic.LoadInt32(&tokens); tokens != 1 {
+		t.Errorf("expected a single token fetch, got %d", tokens)
+	}
+}
+
+func TestParseRegistryRef(t *testing.T) {
+	cases := []struct {
+		ref        string
+		expected   registryRef
+		shouldFail bool
+	}{
+		{"alpine", registryRef{dockerHubRegistry, "library/alpine", "latest"}, false},
+		{"wagoodman/dive:v0.5", registryRef{dockerHubRegistry, "wagoodman/dive", "v0.5"}, false},
+		{"docker.io/alpine:3.8", registryRef{dockerHubRegistry, "library/alpine", "3.8"}, false},
+		{"ghcr.io/org/app:tag", registryRef{"ghcr.io", "org/app", "tag"}, false},
+		{"localhost:5000/app", registryRef{"localhost:5000", "app", "latest"}, false},
+		{"localhost/app@sha256:abcd", registryRef{"localhost", "app", "sha256:abcd"}, false},
+		{"app:", registryRef{}, true},
+	}
+	for _, test := range cases {
+		ref, err := parseRegistryRef(test.ref)
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("[%s] expected an error", test.ref)
+			}
+			continue
+		}
+		if err != nil || ref != test.expected {
+			t.Errorf("[%s] expected %+v, got %+v (%v)", test.ref, test.expected, ref, err)
+		}
+	}
+}
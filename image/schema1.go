@@ -0,0 +1,92 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerSchema1MediaType       = "application/vnd.docker.distribution.manifest.v1+json"
+	dockerSchema1SignedMediaType = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// schema1LayerMediaType is the format of every schema1 layer blob (the manifest does not say)
+	schema1LayerMediaType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	// schema1EmptyLayer is the digest of the (gzipped) empty tar schema1 manifests list for steps without contents
+	schema1EmptyLayer = "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+)
+
+// schema1Manifest is a legacy (schema version 1) image manifest. Layers and history are both listed newest first,
+// the history entries being the (JSON encoded) v1 image config of each layer.
+type schema1Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Architecture  string `json:"architecture"`
+	FSLayers      []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1Compatibility is the v1 image config held by a schema1 history entry
+type schema1Compatibility struct {
+	Created         string `json:"created"`
+	Author          string `json:"author"`
+	Comment         string `json:"comment"`
+	OS              string `json:"os"`
+	Architecture    string `json:"architecture"`
+	Throwaway       bool   `json:"throwaway"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// isSchema1 indicates if the given manifest media type (or schema version) is that of a legacy schema1 manifest.
+func isSchema1(mediaType string, schemaVersion int) bool {
+	return mediaType == dockerSchema1MediaType || mediaType == dockerSchema1SignedMediaType || schemaVersion == 1
+}
+
+// readSchema1Manifest converts a schema1 manifest to the layers (oldest first) and image config of a schema2 image.
+// Steps without contents (marked as throwaway, or holding the empty layer) become empty history entries instead of
+// layers. The config is best effort: the layers are identified by their (compressed) blob digests.
+func readSchema1Manifest(data []byte) ([]ociDescriptor, []byte, error) {
+	var manifest schema1Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("could not parse schema1 manifest: %v", err)
+	}
+	if len(manifest.FSLayers) != len(manifest.History) {
+		return nil, nil, fmt.Errorf("invalid schema1 manifest: %d layers but %d history entries", len(manifest.FSLayers), len(manifest.History))
+	}
+
+	var layers []ociDescriptor
+	config := ImageConfig{RootFs: RootFs{Type: "layers"}, Architecture: manifest.Architecture}
+	for idx := len(manifest.FSLayers) - 1; idx >= 0; idx-- {
+		var compatibility schema1Compatibility
+		if err := json.Unmarshal([]byte(manifest.History[idx].V1Compatibility), &compatibility); err != nil {
+			return nil, nil, fmt.Errorf("could not parse schema1 history entry %d: %v", idx, err)
+		}
+		if compatibility.OS != "" {
+			config.OS = compatibility.OS
+		}
+		if compatibility.Architecture != "" {
+			config.Architecture = compatibility.Architecture
+		}
+
+		digest := manifest.FSLayers[idx].BlobSum
+		empty := compatibility.Throwaway || digest == schema1EmptyLayer
+		config.History = append(config.History, ImageHistoryEntry{
+			Created:    compatibility.Created,
+			Author:     compatibility.Author,
+			CreatedBy:  strings.Join(compatibility.ContainerConfig.Cmd, " "),
+			Comment:    compatibility.Comment,
+			EmptyLayer: empty,
+		})
+		if !empty {
+			layers = append(layers, ociDescriptor{MediaType: schema1LayerMediaType, Digest: digest})
+			config.RootFs.DiffIds = append(config.RootFs.DiffIds, digest)
+		}
+	}
+
+	configBytes, err := json.Marshal(config)
+	return layers, configBytes, err
+}
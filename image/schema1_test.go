@@ -0,0 +1,115 @@
+package image
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schema1Test builds a schema1 manifest from the given layers and v1 configs (both oldest first)
+func schema1Test(t *testing.T, digests []string, configs []string) []byte {
+	var manifest schema1Manifest
+	manifest.SchemaVersion = 1
+	manifest.Architecture = "amd64"
+	for idx := len(digests) - 1; idx >= 0; idx-- {
+		manifest.FSLayers = append(manifest.FSLayers, struct {
+			BlobSum string `json:"blobSum"`
+		}{digests[idx]})
+		manifest.History = append(manifest.History, struct {
+			V1Compatibility string `json:"v1Compatibility"`
+		}{configs[idx]})
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadSchema1Manifest(t *testing.T) {
+	data := schema1Test(t,
+		[]string{"sha256:base", schema1EmptyLayer, "sha256:app", "sha256:unused"},
+		[]string{
+			`{"created": "2016-01-02T03:04:05Z", "author": "packer", "os": "linux", "container_config": {"Cmd": ["/bin/sh", "-c", "#(nop) ADD file:abc in /"]}}`,
+			`{"container_config": {"Cmd": ["/bin/sh", "-c", "#(nop) ENV A=b"]}}`,
+			`{"container_config": {"Cmd": ["/bin/sh", "-c", "make install"]}}`,
+			`{"throwaway": true, "container_config": {"Cmd": ["/bin/sh", "-c", "#(nop) CMD [\"app\"]"]}}`,
+		})
+
+	layers, configBytes, err := readSchema1Manifest(data)
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	var digests []string
+	for _, layer := range layers {
+		digests = append(digests, layer.Digest)
+	}
+	if !reflect.DeepEqual(digests, []string{"sha256:base", "sha256:app"}) {
+		t.Errorf("unexpected layers: %v", digests)
+	}
+
+	config := NewImageConfig(configBytes)
+	if config.Platform() != "linux/amd64" {
+		t.Errorf("unexpected platform: %s", config.Platform())
+	}
+	var commands []string
+	for _, entry := range config.History {
+		commands = append(commands, CleanCommand(entry.CreatedBy))
+	}
+	if !reflect.DeepEqual(commands, []string{"ADD file:abc in /", "ENV A=b", "RUN make install", "CMD [\"app\"]"}) {
+		t.Errorf("unexpected commands: %v", commands)
+	}
+	if first := config.History[0]; first.ID != "sha256:base" || first.Author != "packer" || first.CreatedTime().Year() != 2016 {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	if !config.History[1].EmptyLayer || config.History[2].ID != "sha256:app" || !config.History[3].EmptyLayer {
+		t.Errorf("unexpected history: %+v", config.History)
+	}
+
+	if _, _, err := readSchema1Manifest([]byte(`{"schemaVersion": 1, "fsLayers": [{"blobSum": "sha256:a"}]}`)); err == nil {
+		t.Error("expected an error for mismatched layers and history")
+	}
+}
+
+func TestReadRegistrySchema1Image(t *testing.T) {
+	layout := newOCITestLayout(t)
+	defer os.RemoveAll(layout.dir)
+
+	base := layout.layer(true, "etc/os-release")
+	app := layout.layer(true, "app/bin")
+	manifest := layout.blob(dockerSchema1SignedMediaType, schema1Test(t,
+		[]string{base.Digest, app.Digest},
+		[]string{`{"os": "linux"}`, `{"container_config": {"Cmd": ["/bin/sh", "-c", "make"]}}`}))
+	server := testRegistry(layout, map[string]ociDescriptor{"legacy": manifest})
+	defer server.Close()
+
+	ref, err := parseRegistryRef(strings.TrimPrefix(server.URL, "http://") + "/org/app:legacy")
+	if err != nil {
+		t.Fatalf("could not parse the reference: %v", err)
+	}
+	client := &registryClient{client: server.Client(), username: "user", password: "pass"}
+	var layers [][]string
+	result, configBytes, err := readRegistryImage(client, ref, "linux/amd64", func(digest string, open layerOpener) error {
+		files, err := openFileList(open)
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		layers = append(layers, paths)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("could not read the image: %v", err)
+	}
+	if !reflect.DeepEqual(layers, [][]string{{"etc/os-release"}, {"app/bin"}}) {
+		t.Errorf("unexpected layers: %v", layers)
+	}
+	if !reflect.DeepEqual(result.LayerTarPaths, []string{base.Digest, app.Digest}) {
+		t.Errorf("unexpected layer paths: %v", result.LayerTarPaths)
+	}
+	if config := NewImageConfig(configBytes); len(config.History) != 2 || config.History[1].CreatedBy != "/bin/sh -c make" {
+		t.Errorf("unexpected history: %+v", config.History)
+	}
+}
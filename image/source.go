@@ -0,0 +1,125 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// SourceDocker reads images from the Docker daemon
+	SourceDocker = "docker"
+	// SourcePodman reads images from the podman API socket
+	SourcePodman = "podman"
+	// SourceContainerd reads images from containerd (through its ctr client)
+	SourceContainerd = "containerd"
+	// SourceOCI reads images from an OCI image layout directory
+	SourceOCI = "oci"
+	// SourceArchive reads images from a tar file written by "docker save"
+	SourceArchive = "docker-archive"
+	// SourceRegistry reads images straight from a registry, without any daemon
+	SourceRegistry = "registry"
+	// SourceDir reads a local directory (e.g. an unpacked root filesystem) as an image with a single layer
+	SourceDir = "dir"
+)
+
+// sources lists the image sources that can be selected with a "<source>://" prefix on the image argument, or the
+// source setting
+var sources = []string{SourceDocker, SourcePodman, SourceContainerd, SourceOCI, SourceArchive, SourceRegistry, SourceDir}
+
+// parseImageSource splits an image argument into the source to read it from and the reference of the image within
+// that source (e.g. "oci://./build/layout:v1" is the reference "./build/layout:v1" of the OCI source). Arguments
+// without a source prefix are read from the configured source, which is empty when the source is to be detected (see
+// detectLocalSource and engineEnv.resolveEngine). Directories may also be given as "dir:<path>" (e.g. "dir:/srv/rootfs").
+func parseImageSource(image string) (string, string) {
+	for _, source := range sources {
+		if strings.HasPrefix(image, source+"://") {
+			return source, strings.TrimPrefix(image, source+"://")
+		}
+	}
+	if strings.HasPrefix(image, SourceDir+":") {
+		return SourceDir, strings.TrimPrefix(image, SourceDir+":")
+	}
+	return strings.ToLower(strings.TrimSpace(viper.GetString("source"))), image
+}
+
+// validSource indicates if the given source is known (or empty, to detect the engine).
+func validSource(source string) bool {
+	if source == "" {
+		return true
+	}
+	for _, known := range sources {
+		if source == known {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveExtensions are the file extensions of image archives detected without a source (see detectLocalSource)
+var archiveExtensions = []string{".tar", ".tar.gz", ".tgz"}
+
+// detectLocalSource returns the source of an image argument given without a source, when it names a local image: an
+// existing file named like an archive (or given as a path, e.g. "./image") is read as a docker-archive, an existing
+// directory holding an "oci-layout" file (optionally followed by ":<name>") as an OCI layout. Anything else is left
+// to the container engine (an empty source), with the reason it is not a local image. Plain directories are never
+// detected, as they could shadow an image name: they are selected with "dir:<path>". "-" is an archive read from stdin.
+func detectLocalSource(ref string) (string, error) {
+	if ref == stdinRef {
+		return SourceArchive, nil
+	}
+	if info, err := os.Stat(ref); err == nil && info.Mode().IsRegular() {
+		if hasArchiveExtension(ref) || strings.ContainsRune(ref, os.PathSeparator) {
+			return SourceArchive, nil
+		}
+		return "", fmt.Errorf("'%s' is a file, but not named like an archive (%s)", ref, strings.Join(archiveExtensions, ", "))
+	}
+
+	dir, _ := splitLayoutRef(ref)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("no file or directory named '%s'", dir)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("'%s' is not a regular file or directory", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		return "", fmt.Errorf("'%s' is a directory without an oci-layout file (use dir:%s to analyze it as a filesystem)", dir, dir)
+	}
+	return SourceOCI, nil
+}
+
+// hasArchiveExtension indicates if the given path is named like an image archive.
+func hasArchiveExtension(path string) bool {
+	for _, extension := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(path), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAttempt is a source tried for an image, and the reason it could not be used
+type sourceAttempt struct {
+	source string
+	err    error
+}
+
+// sourceAttempts is the error reported when none of the sources tried for an image could be used
+type sourceAttempts struct {
+	summary  string
+	attempts []sourceAttempt
+}
+
+// Error lists every attempted source with the reason it failed, followed by how to select a source explicitly.
+func (err sourceAttempts) Error() string {
+	lines := []string{err.summary + ", tried (in order):"}
+	for _, attempt := range err.attempts {
+		lines = append(lines, fmt.Sprintf("  - %s: %v", attempt.source, attempt.err))
+	}
+	lines = append(lines, "select a source with --source ("+strings.Join(sources, ", ")+") or a prefix (e.g. registry://<image>)")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,80 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectLocalSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dive-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "layout"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "layout", "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	os.MkdirAll(filepath.Join(dir, "rootfs"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "app.tar"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "image"), nil, 0644)
+
+	// relative names are looked up from the working directory, like image names given on the command line
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	cases := []struct {
+		ref, source, err string
+	}{
+		{"app.tar", SourceArchive, ""},
+		{filepath.Join(dir, "image"), SourceArchive, ""},
+		{"image", "", "'image' is a file, but not named like an archive"},
+		{"layout", SourceOCI, ""},
+		{"layout:v1", SourceOCI, ""},
+		{"rootfs", "", "use dir:rootfs to analyze it as a filesystem"},
+		{"alpine:3.8", "", "no file or directory named 'alpine'"},
+	}
+	for _, test := range cases {
+		source, err := detectLocalSource(test.ref)
+		if source != test.source {
+			t.Errorf("[%s] expected source '%s', got '%s'", test.ref, test.source, source)
+		}
+		if test.err == "" && err != nil {
+			t.Errorf("[%s] expected no error, got: %v", test.ref, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("[%s] expected an error containing '%s', got: %v", test.ref, test.err, err)
+		}
+	}
+}
+
+func TestSourceAttemptsError(t *testing.T) {
+	_, err := fakeEngineEnv(nil).resolveEngine("")
+	attempts, ok := err.(sourceAttempts)
+	if !ok {
+		t.Fatalf("expected the attempted sources, got: %v", err)
+	}
+	attempts.attempts = append([]sourceAttempt{{SourceArchive + ", " + SourceOCI, os.ErrNotExist}}, attempts.attempts...)
+
+	lines := strings.Split(attempts.Error(), "\n")
+	expected := []string{
+		"neither Docker nor podman is reachable, tried (in order):",
+		"  - docker-archive, oci: file does not exist",
+		"  - docker: no Docker socket at /var/run/docker.sock (and DOCKER_HOST is not set)",
+		"  - podman: no podman API socket found (tried /run/user/1000/podman/podman.sock, /run/podman/podman.sock), " + podmanSocketHelpText,
+		"select a source with --source (docker, podman, containerd, oci, docker-archive, registry, dir) or a prefix (e.g. registry://<image>)",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got:\n%s", len(expected), attempts.Error())
+	}
+	for idx, line := range expected {
+		if lines[idx] != line {
+			t.Errorf("expected line %d to be %q, got %q", idx, line, lines[idx])
+		}
+	}
+}
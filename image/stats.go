@@ -0,0 +1,70 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+// minDivergentBlobSize is the blob size (in bytes) under which layers are never flagged as divergent: the overhead of
+// the tar and compression formats alone makes the blobs of layers holding (nearly) nothing larger than their contents
+const minDivergentBlobSize = 1024 * 1024
+
+// LayerLimits are the thresholds above which layers are flagged, 0 disabling a threshold
+type LayerLimits struct {
+	// MaxFiles is the number of entries a layer may hold
+	MaxFiles int
+	// SizeDivergence is the factor by which the blob of a layer may exceed the sum of the sizes of its entries
+	SizeDivergence float64
+}
+
+// layerStatsExport is the JSON representation of the entry statistics of a layer (see ExportLayerStatsJSON)
+type layerStatsExport struct {
+	Index   int    `json:"index"`
+	Id      string `json:"id"`
+	Command string `json:"command"`
+	filetree.EntryStats
+	BlobSize        uint64 `json:"blobSize"`
+	ContentSize     uint64 `json:"contentSize"`
+	ExceedsMaxFiles bool   `json:"exceedsMaxFiles"`
+	SizeDivergent   bool   `json:"sizeDivergent"`
+}
+
+// ExceedsMaxFiles indicates if the layer holds more entries than the given number, 0 meaning no limit.
+func (layer *Layer) ExceedsMaxFiles(maxFiles int) bool {
+	return maxFiles > 0 && layer.Tree != nil && layer.Tree.EntryStats().Entries > maxFiles
+}
+
+// SizeDivergent indicates if the (possibly compressed) blob of the layer is larger than the sum of the sizes of its
+// entries by more than the given factor (0 meaning no limit), a sign of tar padding, data hidden past the end of the
+// tar, or compression pathologies. Layers whose blob size is unknown (or small) are never divergent.
+func (layer *Layer) SizeDivergent(factor float64) bool {
+	if factor <= 0 || layer.Tree == nil || layer.Tree.BlobSize < minDivergentBlobSize {
+		return false
+	}
+	return float64(layer.Tree.BlobSize) > factor*float64(layer.Tree.ContentSize)
+}
+
+// ExportLayerStatsJSON writes the (indented) JSON list of the entry statistics and sizes of every layer (see
+// filetree.EntryStats) to the given writer, flagging the layers over the given limits.
+func ExportLayerStatsJSON(writer io.Writer, layers []*Layer, limits LayerLimits) error {
+	exported := make([]layerStatsExport, 0, len(layers))
+	for _, layer := range layers {
+		entry := layerStatsExport{
+			Index:           layer.Index,
+			Id:              layer.Id(),
+			Command:         layer.Command(),
+			ExceedsMaxFiles: layer.ExceedsMaxFiles(limits.MaxFiles),
+			SizeDivergent:   layer.SizeDivergent(limits.SizeDivergence),
+		}
+		if layer.Tree != nil {
+			entry.EntryStats = layer.Tree.EntryStats()
+			entry.BlobSize, entry.ContentSize = layer.Tree.BlobSize, layer.Tree.ContentSize
+		}
+		exported = append(exported, entry)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exported)
+}
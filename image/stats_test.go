@@ -0,0 +1,68 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+func TestExportLayerStatsJSON(t *testing.T) {
+	small := filetree.NewFileTree()
+	small.AddPath("/etc/hosts", filetree.FileInfo{Path: "/etc/hosts", TypeFlag: '0', LogicalBytes: 10})
+	large := filetree.NewFileTree()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		large.AddPath(path, filetree.FileInfo{Path: path, TypeFlag: '0', LogicalBytes: 1})
+	}
+	layers := []*Layer{
+		{Index: 0, Tree: small, History: ImageHistoryEntry{ID: "sha256:aaa", CreatedBy: "/bin/sh -c #(nop) ADD file:1 in /"}},
+		{Index: 1, Tree: large, History: ImageHistoryEntry{ID: "sha256:bbb", CreatedBy: "/bin/sh -c touch /a /b /c"}},
+	}
+
+	var buf bytes.Buffer
+	if err := ExportLayerStatsJSON(&buf, layers, LayerLimits{MaxFiles: 2}); err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+	var exported []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("invalid export: %v\n%s", err, buf.String())
+	}
+	if len(exported) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(exported))
+	}
+	if exported[0]["entries"] != 1.0 || exported[0]["bytes"] != 10.0 || exported[0]["exceedsMaxFiles"] != false {
+		t.Errorf("unexpected stats of the first layer: %v", exported[0])
+	}
+	if exported[1]["files"] != 3.0 || exported[1]["exceedsMaxFiles"] != true || exported[1]["command"] != "RUN touch /a /b /c" {
+		t.Errorf("unexpected stats of the second layer: %v", exported[1])
+	}
+	if layers[1].ExceedsMaxFiles(0) {
+		t.Errorf("expected no limit for a max of 0")
+	}
+}
+
+func TestSizeDivergent(t *testing.T) {
+	cases := []struct {
+		blob, content uint64
+		factor        float64
+		expected      bool
+	}{
+		{300 << 20, 40 << 20, 2, true},
+		{30 << 20, 40 << 20, 2, false},
+		{300 << 20, 40 << 20, 10, false},
+		{300 << 20, 40 << 20, 0, false},
+		// small blobs are mostly format overhead
+		{64 << 10, 0, 2, false},
+		// unknown blob sizes
+		{0, 40 << 20, 2, false},
+	}
+	for _, test := range cases {
+		tree := filetree.NewFileTree()
+		tree.BlobSize, tree.ContentSize = test.blob, test.content
+		layer := &Layer{Tree: tree}
+		if divergent := layer.SizeDivergent(test.factor); divergent != test.expected {
+			t.Errorf("blob of %d bytes, content of %d bytes, factor %v: expected %v, got %v", test.blob, test.content, test.factor, test.expected, divergent)
+		}
+	}
+}
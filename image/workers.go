@@ -0,0 +1,92 @@
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// layerWorkers returns the number of layers read at the same time per the config (one per CPU when unset).
+func layerWorkers() int {
+	workers := viper.GetInt("layer.workers")
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// layerError is the error reading a single layer, in the order the layer was submitted
+type layerError struct {
+	index int
+	name  string
+	err   error
+}
+
+// layerPool reads layers on a bounded number of goroutines. The errors of every layer are collected and reported
+// together (see wait), so one bad layer does not hide the others.
+type layerPool struct {
+	slots     chan struct{}
+	group     sync.WaitGroup
+	lock      sync.Mutex
+	submitted int
+	done      int
+	errs      []layerError
+	// onDone is invoked (under the pool lock) as every layer completes, with the number of layers completed so far
+	onDone func(done int)
+}
+
+// newLayerPool creates a pool reading at most the given number of layers at the same time.
+func newLayerPool(workers int) *layerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &layerPool{slots: make(chan struct{}, workers)}
+}
+
+// run reads the layer with the given name on its own goroutine, blocking while every worker is busy.
+func (pool *layerPool) run(name string, read func() error) {
+	pool.slots <- struct{}{}
+	pool.group.Add(1)
+	index := pool.submitted
+	pool.submitted++
+
+	go func() {
+		defer pool.group.Done()
+		err := read()
+		<-pool.slots
+
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+		if err != nil {
+			pool.errs = append(pool.errs, layerError{index, name, err})
+		}
+		pool.done++
+		if pool.onDone != nil {
+			pool.onDone(pool.done)
+		}
+	}()
+}
+
+// wait blocks until every layer is read, returning an error describing every layer that could not be read (in the
+// order the layers were submitted).
+func (pool *layerPool) wait() error {
+	pool.group.Wait()
+	if len(pool.errs) == 0 {
+		return nil
+	}
+	sort.Slice(pool.errs, func(i, j int) bool {
+		return pool.errs[i].index < pool.errs[j].index
+	})
+	if len(pool.errs) == 1 {
+		return fmt.Errorf("could not read layer %s: %v", pool.errs[0].name, pool.errs[0].err)
+	}
+	messages := make([]string, 0, len(pool.errs))
+	for _, layer := range pool.errs {
+		messages = append(messages, fmt.Sprintf("%s: %v", layer.name, layer.err))
+	}
+	return fmt.Errorf("could not read %d layers: %s", len(pool.errs), strings.Join(messages, "; "))
+}
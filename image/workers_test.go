@@ -0,0 +1,55 @@
+package image
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLayerPool(t *testing.T) {
+	pool := newLayerPool(2)
+	var running, maxRunning int32
+	var done []int
+	pool.onDone = func(count int) {
+		done = append(done, count)
+	}
+
+	for idx := 0; idx < 6; idx++ {
+		idx := idx
+		pool.run(fmt.Sprintf("layer-%d", idx), func() error {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&maxRunning)
+				if now <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, now) {
+					break
+				}
+			}
+			// later layers finish first, errors are still reported in order
+			time.Sleep(time.Duration(6-idx) * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			if idx%2 == 1 {
+				return fmt.Errorf("bad layer")
+			}
+			return nil
+		})
+	}
+
+	err := pool.wait()
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 layers read at the same time, got %d", maxRunning)
+	}
+	if len(done) != 6 || done[5] != 6 {
+		t.Errorf("expected every layer to be reported done, got %v", done)
+	}
+	expected := "could not read 3 layers: layer-1: bad layer; layer-3: bad layer; layer-5: bad layer"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+
+	pool = newLayerPool(0)
+	pool.run("layer", func() error { return fmt.Errorf("bad layer") })
+	if err := pool.wait(); err == nil || err.Error() != "could not read layer layer: bad layer" {
+		t.Errorf("expected the error of the single layer, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/wagoodman/dive/ci"
+)
+
+const (
+	// ResultPass is the aggregate result of a combined report when no CI rule failed on any image
+	ResultPass = "PASS"
+	// ResultFail is the aggregate result of a combined report when a CI rule failed on an image
+	ResultFail = "FAIL"
+)
+
+// Combined is the report of several images analyzed in a single run: the report of every image (see Report), in the
+// order they were given, and the aggregate result of the CI rules when they were evaluated.
+type Combined struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Images        []*Report `json:"images"`
+	// Result is ResultFail when a CI rule failed on an image, ResultPass otherwise, empty when no rule was evaluated
+	Result string `json:"result,omitempty"`
+	// FailedImages are the references of the images a CI rule failed on
+	FailedImages []string `json:"failedImages,omitempty"`
+}
+
+// NewCombined builds the combined report of the given reports, one per image.
+func NewCombined(reports []*Report) *Combined {
+	combined := &Combined{SchemaVersion: SchemaVersion, Images: reports}
+	for _, report := range reports {
+		if len(report.Rules) == 0 {
+			continue
+		}
+		combined.Result = ResultPass
+		if !ci.AllPassed(report.Rules) {
+			combined.FailedImages = append(combined.FailedImages, report.Image.Reference)
+		}
+	}
+	if len(combined.FailedImages) > 0 {
+		combined.Result = ResultFail
+	}
+	return combined
+}
+
+// resultOf returns the result of the CI rules for a report of the combined report, empty when none was evaluated
+func resultOf(report *Report) string {
+	switch {
+	case len(report.Rules) == 0:
+		return ""
+	case ci.AllPassed(report.Rules):
+		return ResultPass
+	}
+	return ResultFail
+}
+
+// aggregate describes the aggregate result of the combined report, e.g. "FAIL (2 of 5 images failed)"
+func (combined *Combined) aggregate() string {
+	if combined.Result == ResultFail {
+		return fmt.Sprintf("%s (%d of %d images failed)", ResultFail, len(combined.FailedImages), len(combined.Images))
+	}
+	return fmt.Sprintf("%s (%d %s)", combined.Result, len(combined.Images), ci.Pluralize(len(combined.Images), "image", "images"))
+}
+
+// WriteJSON writes the (indented) JSON representation of the combined report to the given writer.
+func (combined *Combined) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(combined)
+}
+
+// WriteMarkdown writes the combined report as (GitHub flavored) markdown to the given writer: an overview of the
+// images, the section of every image (see Report.WriteMarkdown), and the aggregate result.
+func (combined *Combined) WriteMarkdown(writer io.Writer, options MarkdownOptions) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "## Image analysis: %d %s\n\n", len(combined.Images), ci.Pluralize(len(combined.Images), "image", "images"))
+	out.WriteString("| Image | Total size | Efficiency | Wasted | Result |\n")
+	out.WriteString("|-------|-----------:|-----------:|-------:|:------:|\n")
+	for _, report := range combined.Images {
+		fmt.Fprintf(&out, "| %s | %s | %s | %s | %s |\n",
+			codeSpan(report.Image.Reference),
+			humanize.Bytes(report.Image.SizeBytes),
+			ci.FormatPercent(report.Efficiency.Score),
+			humanize.Bytes(report.Efficiency.WastedBytes),
+			resultOf(report))
+	}
+	if _, err := io.WriteString(writer, out.String()); err != nil {
+		return err
+	}
+
+	for _, report := range combined.Images {
+		if _, err := io.WriteString(writer, "\n"); err != nil {
+			return err
+		}
+		if err := report.WriteMarkdown(writer, options); err != nil {
+			return err
+		}
+	}
+
+	if combined.Result == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(writer, "\n**Overall result: %s**\n", combined.aggregate())
+	return err
+}
+
+// WriteSummary writes the combined report as plain text: an overview of the images, the summary of every image (see
+// Report.WriteSummary), and the aggregate result.
+func (combined *Combined) WriteSummary(writer io.Writer) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, summaryHeading.Sprintf("Images: %d", len(combined.Images)))
+	fmt.Fprintln(table, "  Image\tTotal size\tEfficiency\tWasted\tResult")
+	for _, report := range combined.Images {
+		result := resultOf(report)
+		if result == "" {
+			result = "-"
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t%s\n",
+			report.Image.Reference,
+			humanize.Bytes(report.Image.SizeBytes),
+			ci.FormatPercent(report.Efficiency.Score),
+			humanize.Bytes(report.Efficiency.WastedBytes),
+			result)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	for _, report := range combined.Images {
+		if _, err := io.WriteString(writer, "\n"); err != nil {
+			return err
+		}
+		if err := report.WriteSummary(writer); err != nil {
+			return err
+		}
+	}
+
+	if combined.Result == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(writer, "\n"+summaryHeading.Sprint("Result: "+combined.aggregate()))
+	return err
+}
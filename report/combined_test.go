@@ -0,0 +1,108 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/wagoodman/dive/ci"
+)
+
+func testCombined(t *testing.T) *Combined {
+	var reports []*Report
+	for _, reference := range []string{"api:1.0", "worker:1.0"} {
+		analysis := testAnalysis()
+		analysis.Image = reference
+		report, err := New(analysis, Options{WastedLimit: -1})
+		if err != nil {
+			t.Fatalf("could not build the report: %v", err)
+		}
+		reports = append(reports, report)
+	}
+	reports[0].Rules = []ci.Result{{Rule: "lowestEfficiency", Status: ci.Passed}}
+	reports[1].Rules = []ci.Result{{Rule: "lowestEfficiency", Status: ci.Failed}}
+	return NewCombined(reports)
+}
+
+func TestNewCombined(t *testing.T) {
+	combined := testCombined(t)
+	if combined.Result != ResultFail || len(combined.FailedImages) != 1 || combined.FailedImages[0] != "worker:1.0" {
+		t.Errorf("unexpected aggregate result: %s %v", combined.Result, combined.FailedImages)
+	}
+
+	// without rules, there is no result
+	report := combined.Images[0]
+	report.Rules = nil
+	if combined = NewCombined([]*Report{report}); combined.Result != "" {
+		t.Errorf("expected no result, got %q", combined.Result)
+	}
+}
+
+func TestCombinedWriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := testCombined(t).WriteJSON(&out); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	var decoded struct {
+		SchemaVersion int `json:"schemaVersion"`
+		Images        []struct {
+			Image ImageSummary `json:"image"`
+		} `json:"images"`
+		Result       string   `json:"result"`
+		FailedImages []string `json:"failedImages"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.SchemaVersion != SchemaVersion || len(decoded.Images) != 2 || decoded.Images[1].Image.Reference != "worker:1.0" || decoded.Result != ResultFail {
+		t.Errorf("unexpected report: %+v", decoded)
+	}
+}
+
+func TestCombinedWriters(t *testing.T) {
+	color.NoColor = true
+	combined := testCombined(t)
+
+	var markdown bytes.Buffer
+	if err := combined.WriteMarkdown(&markdown, MarkdownOptions{}); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	var summary bytes.Buffer
+	if err := combined.WriteSummary(&summary); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	var page bytes.Buffer
+	if err := combined.WriteHTML(&page); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+
+	for output, expected := range map[*bytes.Buffer][]string{
+		&markdown: {
+			"## Image analysis: 2 images",
+			"| `worker:1.0` | 600 B |",
+			"### Image analysis: `api:1.0`",
+			"### Image analysis: `worker:1.0`",
+			"**Overall result: FAIL (1 of 2 images failed)**",
+		},
+		&summary: {
+			"Images: 2",
+			"Image: api:1.0 (linux/amd64)",
+			"Image: worker:1.0 (linux/amd64)",
+			"Result: FAIL (1 of 2 images failed)",
+		},
+		&page: {
+			"<title>dive: 2 images</title>",
+			`<h2 class="image">Image: <code>worker:1.0</code></h2>`,
+			"<td><code>lowestEfficiency</code></td>",
+			"Result: <strong>FAIL</strong> (1 of 2 images failed)",
+		},
+	} {
+		for _, line := range expected {
+			if !strings.Contains(output.String(), line) {
+				t.Errorf("expected %q in:\n%s", line, output.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
+)
+
+// summaryChanges is the number of changed paths (the ones changing the size the most) listed by the text summary of
+// a comparison
+const summaryChanges = 20
+
+// WriteComparisonSummary writes an image-to-image comparison as a plain text summary with aligned columns (see
+// WriteSummary): the net size change and the number of files added, removed and changed, the changes beneath every
+// top-level directory, and the changed paths changing the size the most.
+func WriteComparisonSummary(writer io.Writer, comparison filetree.ImageComparison) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+
+	counts := make(map[filetree.DiffType]int)
+	var metadataOnly int
+	for _, change := range comparison.Changes {
+		files := 1
+		if change.Directory {
+			files = change.Files
+		}
+		switch change.Change {
+		case filetree.MetadataChanged:
+			metadataOnly++
+			counts[filetree.Changed]++
+		case filetree.Moved:
+			counts[filetree.Changed]++
+		default:
+			counts[change.Change] += files
+		}
+	}
+
+	fmt.Fprintln(table, summaryHeading.Sprint("Comparing: "+comparison.Base+" → "+comparison.Image))
+	fmt.Fprintf(table, "  Size:\t%s → %s (%s)\n", humanize.Bytes(uint64(comparison.SizeBefore)), humanize.Bytes(uint64(comparison.SizeAfter)), signedBytes(comparison.SizeAfter-comparison.SizeBefore))
+	fmt.Fprintf(table, "  Added:\t%d %s\n", counts[filetree.Added], ci.Pluralize(counts[filetree.Added], "file", "files"))
+	fmt.Fprintf(table, "  Removed:\t%d %s\n", counts[filetree.Removed], ci.Pluralize(counts[filetree.Removed], "file", "files"))
+	fmt.Fprintf(table, "  Changed:\t%d %s (%d metadata only)\n", counts[filetree.Changed], ci.Pluralize(counts[filetree.Changed], "file", "files"), metadataOnly)
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(comparison.Directories) > 0 {
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprint("Directories"))
+		fmt.Fprintln(table, "  Path\tAdded\tRemoved\tChanged\tSize")
+		for _, summary := range comparison.Directories {
+			fmt.Fprintf(table, "  %s\t%d\t%d\t%d\t%s\n", summary.Path, summary.Added, summary.Removed, summary.Changed, signedBytes(summary.SizeAfter-summary.SizeBefore))
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	changes := largestChanges(comparison.Changes, summaryChanges)
+	if len(changes) > 0 {
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprint("Largest changes"))
+		fmt.Fprintln(table, "  Change\tSize\tPath")
+		for _, change := range changes {
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", change.Change, signedBytes(change.SizeAfter-change.SizeBefore), describeChange(change))
+		}
+	}
+	return table.Flush()
+}
+
+// largestChanges returns (at most) the given number of changes changing the size the most, in that order (then by path).
+func largestChanges(changes []filetree.FileChange, rows int) []filetree.FileChange {
+	sorted := append([]filetree.FileChange{}, changes...)
+	delta := func(change filetree.FileChange) int64 {
+		if change.SizeAfter > change.SizeBefore {
+			return change.SizeAfter - change.SizeBefore
+		}
+		return change.SizeBefore - change.SizeAfter
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return delta(sorted[i]) > delta(sorted[j])
+	})
+	if len(sorted) > rows {
+		sorted = sorted[:rows]
+	}
+	return sorted
+}
+
+// describeChange returns the path of a change, along with what changed when the size does not tell (e.g. the target
+// of a retargeted link, or the attributes of a file with the same contents).
+func describeChange(change filetree.FileChange) string {
+	var details []string
+	switch {
+	case change.Directory:
+		details = append(details, fmt.Sprintf("directory, %d %s", change.Files, ci.Pluralize(change.Files, "file", "files")))
+	case change.MovedFrom != "":
+		details = append(details, "moved from "+change.MovedFrom)
+	case change.LinkBefore != change.LinkAfter && change.LinkBefore != "" && change.LinkAfter != "":
+		details = append(details, change.LinkBefore+" → "+change.LinkAfter)
+	}
+	if change.Change == filetree.MetadataChanged && len(change.Attributes) > 0 {
+		details = append(details, strings.Join(change.Attributes, ", "))
+	}
+	if len(details) == 0 {
+		return change.Path
+	}
+	return change.Path + " (" + strings.Join(details, "; ") + ")"
+}
+
+// signedBytes formats a size difference, with its sign (e.g. "+1.2 MB").
+func signedBytes(delta int64) string {
+	switch {
+	case delta > 0:
+		return "+" + humanize.Bytes(uint64(delta))
+	case delta < 0:
+		return "-" + humanize.Bytes(uint64(-delta))
+	}
+	return "0 B"
+}
@@ -0,0 +1,52 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+func TestWriteComparisonSummary(t *testing.T) {
+	comparison := filetree.ImageComparison{
+		Base:       "app:v1",
+		Image:      "app:v2",
+		SizeBefore: 3000,
+		SizeAfter:  2500,
+		Directories: []filetree.ChangeSummary{
+			{Path: "/etc", Changed: 3, SizeBefore: 100, SizeAfter: 100},
+			{Path: "/opt", Removed: 2, SizeBefore: 900},
+			{Path: "/srv", Added: 1, SizeAfter: 400},
+		},
+		Changes: []filetree.FileChange{
+			{Path: "/etc/alternatives/python", Change: filetree.Changed, LinkBefore: "/usr/bin/python2", LinkAfter: "/usr/bin/python3"},
+			{Path: "/etc/config", Change: filetree.Changed, SizeBefore: 10, SizeAfter: 20},
+			{Path: "/etc/secret", Change: filetree.MetadataChanged, SizeBefore: 7, SizeAfter: 7, Attributes: []string{"mode", "uid"}},
+			{Path: "/opt", Change: filetree.Removed, SizeBefore: 900, Directory: true, Files: 2},
+			{Path: "/srv", Change: filetree.Added, SizeAfter: 400, Directory: true, Files: 1},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := WriteComparisonSummary(&out, comparison); err != nil {
+		t.Fatalf("could not write the summary: %v", err)
+	}
+	summary := out.String()
+
+	for _, expected := range []string{
+		"Comparing: app:v1 → app:v2\n",
+		"  Size:     3.0 kB → 2.5 kB (-500 B)\n",
+		"  Added:    1 file\n",
+		"  Removed:  2 files\n",
+		"  Changed:  3 files (1 metadata only)\n",
+		"  /opt  0      2        0        -900 B\n",
+		"Largest changes\n  Change           Size    Path\n  Removed          -900 B  /opt (directory, 2 files)\n  Added            +400 B  /srv (directory, 1 file)\n  Changed          +10 B   /etc/config\n",
+		"/etc/alternatives/python (/usr/bin/python2 → /usr/bin/python3)\n",
+		"MetadataChanged  0 B     /etc/secret (mode, uid)\n",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected %q in the summary:\n%s", expected, summary)
+		}
+	}
+}
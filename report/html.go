@@ -0,0 +1,302 @@
+package report
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+
+	"github.com/dustin/go-humanize"
+	"github.com/wagoodman/dive/ci"
+)
+
+// htmlPage is the data of the HTML template
+type htmlPage struct {
+	*Report
+	// TreeJSON is the final file tree as exported by filetree.FileTree.MarshalJSON. The encoding escapes the characters
+	// (<, >, &) that could end the script holding it.
+	TreeJSON template.JS
+	// LastLayer is the index of the top layer, selected when the page loads
+	LastLayer int
+}
+
+var htmlFuncs = template.FuncMap{
+	"bytes": func(size interface{}) string {
+		switch size := size.(type) {
+		case uint64:
+			return humanize.Bytes(size)
+		case int64:
+			return humanize.Bytes(uint64(size))
+		}
+		return ""
+	},
+	"percent": ci.FormatPercent,
+	"result":  resultOf,
+}
+
+// WriteHTML writes the combined report as a single, self-contained HTML page to the given writer: an overview of the
+// images and the aggregate result, then the summary, layers, rule results and wasted files of every image. The file
+// trees are left out, as the page of a single image (see Report.WriteHTML) holds them.
+func (combined *Combined) WriteHTML(writer io.Writer) error {
+	return htmlCombinedTemplate.Execute(writer, combined)
+}
+
+// WriteHTML writes the report as a single, self-contained HTML page (its styles and script inlined, fetching nothing)
+// to the given writer: the efficiency summary, the layers, the wasted files, and the final file tree. The tree is
+// rendered by the page as directories are expanded, so it stays responsive for images with many files. Selecting a
+// layer shows the tree as of that layer, colored by what the layer added or modified.
+func (report *Report) WriteHTML(writer io.Writer) error {
+	page := htmlPage{Report: report, LastLayer: len(report.Layers) - 1}
+	tree := []byte("null")
+	if report.final != nil {
+		encoded, err := json.Marshal(report.final)
+		if err != nil {
+			return err
+		}
+		tree = encoded
+	}
+	page.TreeJSON = template.JS(tree)
+	return htmlTemplate.Execute(writer, page)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>dive: {{.Image.Reference}}</title>
+{{template "style"}}
+</head>
+<body>
+<h1>Image analysis: <code>{{.Image.Reference}}</code></h1>
+{{if .Image.Platform}}<p>Platform: <code>{{.Image.Platform}}</code></p>{{end}}
+
+{{template "summary" .}}
+
+<h2>Layers</h2>
+<table id="layers">
+<thead><tr><th class="num">#</th><th>Command</th><th class="num">Size</th><th class="num">Wasted</th><th class="num">Added</th><th class="num">Changed</th><th class="num">Removed</th></tr></thead>
+<tbody>
+{{range .Layers}}<tr data-layer="{{.Index}}" title="{{.Digest}}">
+<td class="num">{{.Index}}</td>
+<td><code>{{.Command}}</code></td>
+<td class="num">{{bytes .SizeBytes}}</td>
+<td class="num">{{bytes .WastedBytes}}</td>
+<td class="num">{{.Changes.Added.Files}}</td>
+<td class="num">{{.Changes.Changed.Files}}</td>
+<td class="num">{{.Changes.Removed.Files}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+
+{{template "rules" .}}
+
+{{template "wasted" .}}
+
+<h2>File tree <span id="tree-layer"></span></h2>
+<p class="legend"><span class="added">added by the layer</span><span class="modified">modified by the layer</span><span>unchanged</span></p>
+<div class="tree" id="tree"></div>
+
+<script>
+(function () {
+  var tree = {{.TreeJSON}};
+  var selected = {{.LastLayer}};
+
+  function formatBytes(size) {
+    var units = ["B", "kB", "MB", "GB", "TB", "PB"];
+    var unit = 0;
+    while (size >= 1000 && unit < units.length - 1) {
+      size /= 1000;
+      unit++;
+    }
+    if (unit === 0) {
+      return size + " B";
+    }
+    return (size < 10 ? size.toFixed(1) : size.toFixed(0)) + " " + units[unit];
+  }
+
+  // the aggregate size of every directory, computed once
+  function total(node) {
+    var size = node.size || 0;
+    var children = node.children || [];
+    for (var i = 0; i < children.length; i++) {
+      size += total(children[i]);
+    }
+    node.total = size;
+    return size;
+  }
+
+  function visible(node) {
+    return node.addedLayer === undefined || node.addedLayer <= selected;
+  }
+
+  function status(node) {
+    if (node.addedLayer === selected) {
+      return "added";
+    }
+    if (node.modifiedLayer === selected) {
+      return "modified";
+    }
+    return "";
+  }
+
+  // renders the children of a node, their own children are only rendered once expanded
+  function renderChildren(parent, node) {
+    var list = document.createElement("ul");
+    var children = node.children || [];
+    for (var i = 0; i < children.length; i++) {
+      if (visible(children[i])) {
+        list.appendChild(renderNode(children[i]));
+      }
+    }
+    parent.appendChild(list);
+  }
+
+  function renderNode(node) {
+    var item = document.createElement("li");
+    var label = document.createElement("span");
+    var isDir = node.children && node.children.length > 0;
+    label.className = "label " + status(node);
+    label.textContent = (isDir ? "▸ " : "  ") + node.name;
+    var size = document.createElement("span");
+    size.className = "size";
+    size.textContent = formatBytes(node.total);
+    label.appendChild(size);
+    item.appendChild(label);
+    if (isDir) {
+      item.className = "dir";
+      label.addEventListener("click", function () {
+        var expanded = item.lastChild.tagName === "UL";
+        if (expanded) {
+          item.removeChild(item.lastChild);
+        } else {
+          renderChildren(item, node);
+        }
+        label.firstChild.textContent = (expanded ? "▸ " : "▾ ") + node.name;
+      });
+    }
+    return item;
+  }
+
+  function select(layer) {
+    selected = layer;
+    var rows = document.querySelectorAll("#layers tbody tr");
+    for (var i = 0; i < rows.length; i++) {
+      rows[i].className = Number(rows[i].getAttribute("data-layer")) === layer ? "selected" : "";
+    }
+    document.getElementById("tree-layer").textContent = "(as of layer " + layer + ")";
+    var container = document.getElementById("tree");
+    container.textContent = "";
+    if (tree) {
+      renderChildren(container, {children: tree.children});
+    }
+  }
+
+  if (tree) {
+    total({children: tree.children});
+  }
+  var rows = document.querySelectorAll("#layers tbody tr");
+  for (var i = 0; i < rows.length; i++) {
+    rows[i].addEventListener("click", function () {
+      select(Number(this.getAttribute("data-layer")));
+    });
+  }
+  select(selected);
+})();
+</script>
+</body>
+</html>
+
+{{define "style"}}<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292e; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.15em; margin-top: 2em; }
+code, .tree { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: 0.9em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #e1e4e8; text-align: left; vertical-align: top; }
+td.num, th.num { text-align: right; white-space: nowrap; }
+#layers tbody tr { cursor: pointer; }
+#layers tbody tr:hover { background: #f6f8fa; }
+#layers tbody tr.selected { background: #dbedff; }
+.summary td { font-size: 1.1em; }
+.tree ul { list-style: none; margin: 0; padding-left: 1.4em; }
+.tree > ul { padding-left: 0; }
+.tree li { white-space: nowrap; }
+.tree .dir > .label { cursor: pointer; }
+.tree .size { color: #6a737d; margin-left: 0.8em; }
+.added { color: #22863a; }
+.modified { color: #b08800; }
+.legend span { margin-right: 1.5em; }
+</style>{{end}}
+
+{{define "summary"}}<table class="summary">
+<tr><th class="num">Total size</th><th class="num">Efficiency</th><th class="num">Wasted</th><th class="num">Wasted (user layers)</th><th class="num">Layers</th></tr>
+<tr>
+<td class="num">{{bytes .Image.SizeBytes}}</td>
+<td class="num">{{percent .Efficiency.Score}}</td>
+<td class="num">{{bytes .Efficiency.WastedBytes}}</td>
+<td class="num">{{percent .Efficiency.WastedUserFraction}}</td>
+<td class="num">{{.Image.LayerCount}}</td>
+</tr>
+</table>{{end}}
+
+{{define "rules"}}{{if .Rules}}<h2>CI rules</h2>
+<table>
+<thead><tr><th>Rule</th><th class="num">Measured</th><th class="num">Threshold</th><th>Severity</th><th>Result</th></tr></thead>
+<tbody>
+{{range .Rules}}<tr><td><code>{{.Rule}}</code></td><td class="num">{{.Measured}}</td><td class="num">{{.Threshold}}</td><td>{{.Severity}}</td><td>{{.Status}}</td></tr>
+{{end}}</tbody>
+</table>
+{{range .Rules}}{{if .Excluded}}<p><code>{{.Rule}}</code>, excluded by allowlist:</p>
+<ul>
+{{range .Excluded}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{end}}{{end}}{{end}}
+
+{{define "wasted"}}<h2>Wasted space</h2>
+{{if .Wasted.Files}}<table>
+<thead><tr><th>Path</th><th class="num">Wasted</th><th class="num">Copies</th><th>Layers</th></tr></thead>
+<tbody>
+{{range .Wasted.Files}}<tr><td><code>{{.Path}}</code></td><td class="num">{{bytes .TotalBytes}}</td><td class="num">{{.Occurrences}}</td><td>{{range $i, $layer := .Layers}}{{if $i}}, {{end}}{{$layer}}{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p>No wasted space found.</p>
+{{end}}{{end}}
+`))
+
+var htmlCombinedTemplate = template.Must(template.Must(htmlTemplate.Clone()).New("combined").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>dive: {{len .Images}} images</title>
+{{template "style"}}
+<style>
+h2.image { font-size: 1.3em; margin-top: 2.5em; padding-top: 1em; border-top: 2px solid #e1e4e8; }
+</style>
+</head>
+<body>
+<h1>Image analysis: {{len .Images}} images</h1>
+{{if .Result}}<p>Result: <strong>{{.Result}}</strong>{{if .FailedImages}} ({{len .FailedImages}} of {{len .Images}} images failed){{end}}</p>{{end}}
+
+<table>
+<thead><tr><th>Image</th><th class="num">Total size</th><th class="num">Efficiency</th><th class="num">Wasted</th><th>Result</th></tr></thead>
+<tbody>
+{{range .Images}}<tr><td><code>{{.Image.Reference}}</code></td><td class="num">{{bytes .Image.SizeBytes}}</td><td class="num">{{percent .Efficiency.Score}}</td><td class="num">{{bytes .Efficiency.WastedBytes}}</td><td>{{result .}}</td></tr>
+{{end}}</tbody>
+</table>
+
+{{range .Images}}<h2 class="image">Image: <code>{{.Image.Reference}}</code></h2>
+{{if .Image.Platform}}<p>Platform: <code>{{.Image.Platform}}</code></p>{{end}}
+{{template "summary" .}}
+
+<h2>Layers</h2>
+<table>
+<thead><tr><th class="num">#</th><th>Command</th><th class="num">Size</th><th class="num">Wasted</th><th class="num">Added</th><th class="num">Changed</th><th class="num">Removed</th></tr></thead>
+<tbody>
+{{range .Layers}}<tr title="{{.Digest}}"><td class="num">{{.Index}}</td><td><code>{{.Command}}</code></td><td class="num">{{bytes .SizeBytes}}</td><td class="num">{{bytes .WastedBytes}}</td><td class="num">{{.Changes.Added.Files}}</td><td class="num">{{.Changes.Changed.Files}}</td><td class="num">{{.Changes.Removed.Files}}</td></tr>
+{{end}}</tbody>
+</table>
+
+{{template "rules" .}}
+{{template "wasted" .}}
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,48 @@
+package report
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wagoodman/dive/filetree"
+)
+
+func TestWriteHTML(t *testing.T) {
+	analysis := testAnalysis()
+	analysis.Trees[1].AddPath("/srv/</script><b>x", filetree.FileInfo{Path: "srv/</script><b>x", TypeFlag: tar.TypeReg})
+	analysis.Layers[1].History.CreatedBy = "/bin/sh -c echo '<b>hi</b>'"
+	report, err := New(analysis, Options{WastedLimit: 10})
+	if err != nil {
+		t.Fatalf("could not build the report: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteHTML(&out); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	page := out.String()
+
+	for _, expected := range []string{
+		"<title>dive: app:latest</title>",
+		`<tr data-layer="1" title="sha256:bbb">`,
+		"RUN echo &#39;&lt;b&gt;hi&lt;/b&gt;&#39;",
+		`"name":"app.conf"`,
+		`"name":"script\u003e\u003cb\u003ex"`,
+		`"addedLayer":1,"modifiedLayer":1`,
+		"var selected =  1 ;",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the page:\n%s", expected, page)
+		}
+	}
+	if strings.Count(page, "</script>") != 1 || strings.Contains(page, "<b>") {
+		t.Error("expected the contents of the image to be escaped")
+	}
+	for _, external := range []string{"src=", "href=", "http://", "https://", "@import"} {
+		if strings.Contains(page, external) {
+			t.Errorf("expected a self-contained page, found %q", external)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/wagoodman/dive/ci"
+)
+
+// DefaultMarkdownRows is the number of layers and wasted files listed by a markdown report unless told otherwise
+const DefaultMarkdownRows = 10
+
+// maxCommandLength is the number of characters of a layer command shown in a markdown table, longer commands are cut
+const maxCommandLength = 80
+
+// MarkdownOptions select what goes into a markdown report
+type MarkdownOptions struct {
+	// Rows is the number of layers (the largest ones) and wasted files listed, 0 or less listing them all
+	Rows int
+	// NoLayers leaves out the layer table
+	NoLayers bool
+}
+
+// WriteMarkdown writes the report as (GitHub flavored) markdown to the given writer, a compact summary meant for pull
+// request comments: the size and efficiency of the image, its largest layers and the files wasting the most space (and
+// the rule results, if any). The output holds no color codes.
+func (report *Report) WriteMarkdown(writer io.Writer, options MarkdownOptions) error {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "### Image analysis: %s\n\n", codeSpan(report.Image.Reference))
+	out.WriteString("| Total size | Efficiency | Wasted | Wasted (user layers) |\n")
+	out.WriteString("|-----------:|-----------:|-------:|---------------------:|\n")
+	fmt.Fprintf(&out, "| %s | %s | %s | %s |\n",
+		humanize.Bytes(report.Image.SizeBytes),
+		ci.FormatPercent(report.Efficiency.Score),
+		humanize.Bytes(report.Efficiency.WastedBytes),
+		ci.FormatPercent(report.Efficiency.WastedUserFraction))
+
+	if !options.NoLayers && len(report.Layers) > 0 {
+		layers := largestLayers(report.Layers, options.Rows)
+		out.WriteString("\n#### Layers\n\n")
+		out.WriteString("| # | Command | Size | Wasted |\n")
+		out.WriteString("|--:|---------|-----:|-------:|\n")
+		for _, layer := range layers {
+			fmt.Fprintf(&out, "| %d | %s | %s | %s |\n",
+				layer.Index,
+				codeSpan(truncate(layer.Command, maxCommandLength)),
+				humanize.Bytes(layer.SizeBytes),
+				humanize.Bytes(uint64(layer.WastedBytes)))
+		}
+		if hidden := len(report.Layers) - len(layers); hidden > 0 {
+			fmt.Fprintf(&out, "\n_%d smaller %s not shown._\n", hidden, ci.Pluralize(hidden, "layer", "layers"))
+		}
+	}
+
+	files := report.Wasted.Files
+	if options.Rows > 0 && len(files) > options.Rows {
+		files = files[:options.Rows]
+	}
+	if len(files) > 0 {
+		out.WriteString("\n#### Wasted space\n\n")
+		out.WriteString("| Path | Wasted | Copies |\n")
+		out.WriteString("|------|-------:|-------:|\n")
+		for _, file := range files {
+			fmt.Fprintf(&out, "| %s | %s | %d |\n", codeSpan(file.Path), humanize.Bytes(uint64(file.TotalBytes)), file.Occurrences)
+		}
+	}
+
+	if len(report.Rules) > 0 {
+		out.WriteString("\n#### CI rules\n\n")
+		out.WriteString("| Rule | Measured | Threshold | Severity | Result |\n")
+		out.WriteString("|------|---------:|----------:|----------|:------:|\n")
+		for _, result := range report.Rules {
+			fmt.Fprintf(&out, "| %s | %s | %s | %s | %s |\n",
+				codeSpan(result.Rule), escapeCell(result.Measured), escapeCell(result.Threshold), result.Severity, result.Status)
+		}
+		for _, result := range report.Rules {
+			if len(result.Excluded) > 0 {
+				fmt.Fprintf(&out, "\n%s, excluded by allowlist:\n\n", codeSpan(result.Rule))
+				for _, excluded := range result.Excluded {
+					fmt.Fprintf(&out, "- %s\n", codeSpan(excluded))
+				}
+			}
+		}
+		var warnings string
+		if warned := ci.CountStatus(report.Rules, ci.Warned); warned > 0 {
+			warnings = fmt.Sprintf(" (%d %s)", warned, ci.Pluralize(warned, "warning", "warnings"))
+		}
+		if ci.AllPassed(report.Rules) {
+			out.WriteString("\n**Result: PASS" + warnings + "**\n")
+		} else {
+			out.WriteString("\n**Result: FAIL" + warnings + "**\n")
+		}
+	}
+
+	_, err := io.WriteString(writer, out.String())
+	return err
+}
+
+// largestLayers returns the given number of the largest layers (all of them when the number is 0 or less), in the
+// order of the image. Ties are broken by index.
+func largestLayers(layers []LayerSummary, rows int) []LayerSummary {
+	if rows <= 0 || len(layers) <= rows {
+		return layers
+	}
+	largest := append([]LayerSummary(nil), layers...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].SizeBytes > largest[j].SizeBytes
+	})
+	largest = largest[:rows]
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Index < largest[j].Index
+	})
+	return largest
+}
+
+// codeSpan formats a value as an inline code span usable in a table cell: on a single line, with its pipes escaped,
+// fenced with enough backticks to hold those in the value
+func codeSpan(value string) string {
+	value = escapeCell(value)
+	if value == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(value, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(value, "`") || strings.HasSuffix(value, "`") || len(fence) > 1 {
+		return fence + " " + value + " " + fence
+	}
+	return fence + value + fence
+}
+
+// escapeCell puts a value on a single line (collapsing its whitespace) and escapes the pipes that would end a cell
+func escapeCell(value string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(value), " "), "|", "\\|")
+}
+
+// truncate cuts a value to the given number of characters, marking the cut with an ellipsis
+func truncate(value string, length int) string {
+	value = strings.Join(strings.Fields(value), " ")
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return strings.TrimSpace(string(runes[:length-1])) + "…"
+}
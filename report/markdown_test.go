@@ -0,0 +1,67 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	report, err := New(testAnalysis(), Options{WastedLimit: -1})
+	if err != nil {
+		t.Fatalf("could not build the report: %v", err)
+	}
+	report.Layers[1].Command = "RUN make install | tee build.log && " + strings.Repeat("x", 100)
+
+	var out bytes.Buffer
+	if err := report.WriteMarkdown(&out, MarkdownOptions{Rows: 1}); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	markdown := out.String()
+
+	for _, expected := range []string{
+		"### Image analysis: `app:latest`",
+		"| 600 B |",
+		"#### Layers",
+		"| 0 | `ADD file:abc in /` | 400 B | 0 B |",
+		"_1 smaller layer not shown._",
+		"#### Wasted space",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("expected %q in the report:\n%s", expected, markdown)
+		}
+	}
+	if strings.Contains(markdown, "make install") {
+		t.Errorf("expected only the largest layer:\n%s", markdown)
+	}
+	if strings.Contains(markdown, "\x1b[") {
+		t.Errorf("expected no color codes:\n%s", markdown)
+	}
+	if rows := strings.Count(markdown[strings.Index(markdown, "#### Wasted space"):], "\n| `"); rows != 1 {
+		t.Errorf("expected a single wasted file, got %d:\n%s", rows, markdown)
+	}
+
+	out.Reset()
+	if err := report.WriteMarkdown(&out, MarkdownOptions{NoLayers: true}); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	if strings.Contains(out.String(), "#### Layers") {
+		t.Errorf("expected no layer table:\n%s", out.String())
+	}
+}
+
+func TestMarkdownCells(t *testing.T) {
+	for value, expected := range map[string]string{
+		"RUN a | b":     "`RUN a \\| b`",
+		"echo `date`":   "`` echo `date` ``",
+		"RUN a\n\tb  c": "`RUN a b c`",
+		"":              "",
+	} {
+		if actual := codeSpan(value); actual != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, actual)
+		}
+	}
+	if actual := truncate("RUN "+strings.Repeat("a", 20), 10); actual != "RUN aaaaa…" {
+		t.Errorf("unexpected truncation: %q", actual)
+	}
+}
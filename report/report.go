@@ -0,0 +1,261 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/image"
+)
+
+// SchemaVersion is the version of the layout of the report. Fields may be added within a version, it is bumped
+// whenever a field is removed, renamed or changes meaning.
+const SchemaVersion = 1
+
+const (
+	// FormatJSON is the format of the JSON report (see Report.WriteJSON)
+	FormatJSON = "json"
+	// FormatMarkdown is the format of the markdown summary (see Report.WriteMarkdown)
+	FormatMarkdown = "markdown"
+	// FormatHTML is the format of the self-contained HTML page (see Report.WriteHTML)
+	FormatHTML = "html"
+	// FormatText is the format of the plain text summary (see Report.WriteSummary)
+	FormatText = "text"
+)
+
+// ParseFormat validates the name of a report format (one of: json, markdown, or md for markdown, html, text).
+func ParseFormat(format string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(format)); format {
+	case FormatJSON, FormatMarkdown, FormatHTML, FormatText:
+		return format, nil
+	case "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown report format '%s' (supported: json, markdown, html, text)", format)
+}
+
+// Options select what goes into a report beyond the analysis itself
+type Options struct {
+	// WastedLimit is the number of wasted files (and directories) to list, -1 for all (see filetree.ParseWastedLimit)
+	WastedLimit int
+	// RemovablePatterns are the patterns of the likely removable paths (see filetree.RemovablePatterns)
+	RemovablePatterns []string
+	// LayerLimits are the thresholds above which layers are flagged
+	LayerLimits image.LayerLimits
+	// LargeFileSize is the size from which the files stored by the layers are listed as large (see filetree.LargeFiles),
+	// 0 lists none
+	LargeFileSize uint64
+	// DetectMoves pairs up the files removed and added by a layer as moves (see filetree.FileTree.DetectMoves)
+	DetectMoves bool
+	// IncludeTree adds the final file tree of the image
+	IncludeTree bool
+	// Rules are the results of the CI rules, if they were evaluated
+	Rules []ci.Result
+}
+
+// Report is the full analysis of an image, as written by WriteJSON. Every size is in raw bytes, and every list is in a
+// deterministic order: layers bottom up, wasted files by the bytes they cost and then by path, large files by size,
+// rules in the order of ci.Rules.
+type Report struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	Image         ImageSummary          `json:"image"`
+	Efficiency    EfficiencySummary     `json:"efficiency"`
+	Layers        []LayerSummary        `json:"layers"`
+	Wasted        filetree.WastedReport `json:"wasted"`
+	// LargeFiles are the files of at least Options.LargeFileSize stored by any layer, including the removed ones
+	LargeFiles filetree.LargeFileSlice `json:"largeFiles"`
+	Rules      []ci.Result             `json:"rules,omitempty"`
+	Tree       *filetree.FileTree      `json:"tree,omitempty"`
+
+	// final is the final file tree of the image, whether or not it is included in the JSON report
+	final *filetree.FileTree
+}
+
+// ImageSummary describes the analyzed image
+type ImageSummary struct {
+	Reference     string `json:"reference"`
+	Platform      string `json:"platform,omitempty"`
+	SizeBytes     uint64 `json:"sizeBytes"`
+	UserSizeBytes uint64 `json:"userSizeBytes"`
+	LayerCount    int    `json:"layerCount"`
+}
+
+// EfficiencySummary is the efficiency of the image, and the bytes wasted across its layers
+type EfficiencySummary struct {
+	Score       float64 `json:"score"`
+	WastedBytes uint64  `json:"wastedBytes"`
+	// WastedUserFraction is the wasted bytes as a fraction (0 to 1) of the layers above the base layer
+	WastedUserFraction float64 `json:"wastedUserFraction"`
+}
+
+// LayerSummary describes a single layer: where it comes from, what it holds, and what it changes on top of the layers
+// below it
+type LayerSummary struct {
+	Index           int                 `json:"index"`
+	Digest          string              `json:"digest"`
+	TarId           string              `json:"tarId"`
+	Command         string              `json:"command"`
+	CreatedBy       string              `json:"createdBy"`
+	Created         string              `json:"created,omitempty"`
+	SizeBytes       uint64              `json:"sizeBytes"`
+	BlobBytes       uint64              `json:"blobBytes"`
+	ContentBytes    uint64              `json:"contentBytes"`
+	Entries         filetree.EntryStats `json:"entries"`
+	Changes         LayerChanges        `json:"changes"`
+	WastedBytes     int64               `json:"wastedBytes"`
+	ExceedsMaxFiles bool                `json:"exceedsMaxFiles"`
+	SizeDivergent   bool                `json:"sizeDivergent"`
+}
+
+// LayerChanges are the files (and their bytes) a layer adds, removes and changes on top of the layers below it (see
+// filetree.DiffStats). The files of the first layer are all added.
+type LayerChanges struct {
+	Added           ChangeStats `json:"added"`
+	Removed         ChangeStats `json:"removed"`
+	Changed         ChangeStats `json:"changed"`
+	MetadataChanged ChangeStats `json:"metadataChanged"`
+	Moved           ChangeStats `json:"moved"`
+	Unchanged       ChangeStats `json:"unchanged"`
+}
+
+// Wasted returns the bytes of the lower layers that the layer made unreachable by removing, moving or rewriting
+// them: they are still stored (and pulled) but no longer part of the filesystem.
+func (changes LayerChanges) Wasted() int64 {
+	return changes.Removed.Bytes + changes.Changed.Bytes + changes.MetadataChanged.Bytes + changes.Moved.Bytes
+}
+
+// ChangeStats is a number of files and the bytes they hold
+type ChangeStats struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// New builds the report of the given analysis. An error is returned when the removable patterns are invalid or the
+// layers cannot be compared.
+func New(analysis *ci.Analysis, options Options) (*Report, error) {
+	trees := analysis.Trees
+	final := filetree.StackRange(trees, 0, len(trees)-1)
+	removable, err := filetree.FindRemovable(final, options.RemovablePatterns, filetree.MinRemovableSize)
+	if err != nil {
+		return nil, err
+	}
+
+	layers, err := summarizeLayers(analysis.Layers, trees, options)
+	if err != nil {
+		return nil, err
+	}
+
+	wasted := filetree.WastedFiles(trees, analysis.Inefficiencies, filetree.FindDuplicates(trees))
+	report := &Report{
+		SchemaVersion: SchemaVersion,
+		Image: ImageSummary{
+			Reference:     analysis.Image,
+			Platform:      analysis.Platform,
+			SizeBytes:     analysis.SizeBytes(),
+			UserSizeBytes: analysis.UserSizeBytes(),
+			LayerCount:    len(analysis.Layers),
+		},
+		Efficiency: EfficiencySummary{
+			Score:              analysis.Efficiency,
+			WastedBytes:        analysis.WastedBytes(),
+			WastedUserFraction: analysis.WastedUserPercent(),
+		},
+		Layers: layers,
+		Wasted: filetree.WastedReport{
+			Files:            wasted.Top(options.WastedLimit),
+			Directories:      wasted.Directories().Top(options.WastedLimit),
+			Churn:            filetree.FindChurn(trees),
+			Removable:        removable,
+			MetadataRewrites: filetree.FindMetadataRewrites(trees),
+		},
+		LargeFiles: filetree.LargeFileSlice{},
+		Rules:      options.Rules,
+		final:      final,
+	}
+	if options.LargeFileSize > 0 {
+		report.LargeFiles = filetree.LargeFiles(trees, int64(options.LargeFileSize))
+	}
+	if options.IncludeTree {
+		report.Tree = final
+	}
+	report.Wasted = emptyLists(report.Wasted)
+	return report, nil
+}
+
+// summarizeLayers describes every layer, comparing each against the stack of the layers below it
+func summarizeLayers(layers []*image.Layer, trees []*filetree.FileTree, options Options) ([]LayerSummary, error) {
+	summaries := make([]LayerSummary, 0, len(layers))
+	stacks := filetree.NewStackCache(trees)
+	for _, layer := range layers {
+		summary := LayerSummary{
+			Index:           layer.Index,
+			Digest:          layer.Id(),
+			TarId:           layer.TarId(),
+			Command:         layer.Command(),
+			CreatedBy:       layer.RawCommand(),
+			Created:         layer.History.Created,
+			SizeBytes:       layer.History.Size,
+			ExceedsMaxFiles: layer.ExceedsMaxFiles(options.LayerLimits.MaxFiles),
+			SizeDivergent:   layer.SizeDivergent(options.LayerLimits.SizeDivergence),
+		}
+		if upper := layer.Tree; upper != nil {
+			summary.BlobBytes, summary.ContentBytes = upper.BlobSize, upper.ContentSize
+			summary.Entries = upper.EntryStats()
+
+			var lower *filetree.FileTree
+			if layer.Index == 0 {
+				lower = filetree.NewFileTree()
+				lower.HashAlgorithm = upper.HashAlgorithm
+			} else {
+				lower = stacks.StackRange(0, layer.Index-1)
+			}
+			if err := lower.Compare(upper); err != nil {
+				return nil, err
+			}
+			if options.DetectMoves {
+				lower.DetectMoves()
+			}
+			summary.Changes = layerChanges(lower.DiffStats())
+			summary.WastedBytes = summary.Changes.Wasted()
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// layerChanges converts the per DiffType counts of a compared tree into their fixed (named) fields
+func layerChanges(stats filetree.DiffStats) LayerChanges {
+	of := func(diff filetree.DiffType) ChangeStats {
+		return ChangeStats{Files: stats.Files[diff], Bytes: stats.Bytes[diff]}
+	}
+	return LayerChanges{
+		Added:           of(filetree.Added),
+		Removed:         of(filetree.Removed),
+		Changed:         of(filetree.Changed),
+		MetadataChanged: of(filetree.MetadataChanged),
+		Moved:           of(filetree.Moved),
+		Unchanged:       of(filetree.Unchanged),
+	}
+}
+
+// emptyLists replaces the missing lists of a wasted report with empty ones, so they are written as [] rather than
+// null and consumers need not tell the two apart
+func emptyLists(wasted filetree.WastedReport) filetree.WastedReport {
+	if wasted.Files == nil {
+		wasted.Files = filetree.WastedFileSlice{}
+	}
+	if wasted.Directories == nil {
+		wasted.Directories = filetree.WastedDirectorySlice{}
+	}
+	if wasted.Churn == nil {
+		wasted.Churn = filetree.ChurnSlice{}
+	}
+	if wasted.Removable == nil {
+		wasted.Removable = filetree.RemovableSlice{}
+	}
+	if wasted.MetadataRewrites == nil {
+		wasted.MetadataRewrites = filetree.MetadataRewriteSlice{}
+	}
+	return wasted
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wagoodman/dive/ci"
+	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/image"
+)
+
+func testAnalysis() *ci.Analysis {
+	base := filetree.NewFileTree()
+	base.AddPath("/etc/app.conf", filetree.FileInfo{Path: "etc/app.conf", TypeFlag: tar.TypeReg, LogicalBytes: 100, StoredBytes: 100})
+	base.AddPath("/tmp/build.log", filetree.FileInfo{Path: "tmp/build.log", TypeFlag: tar.TypeReg, LogicalBytes: 300, StoredBytes: 300})
+	upper := filetree.NewFileTree()
+	upper.AddPath("/etc/app.conf", filetree.FileInfo{Path: "etc/app.conf", TypeFlag: tar.TypeReg, LogicalBytes: 150, StoredBytes: 150, Mode: 0600})
+	upper.AddPath("/tmp/.wh.build.log", filetree.FileInfo{Path: "tmp/.wh.build.log", TypeFlag: tar.TypeReg})
+	upper.AddPath("/usr/bin/tool", filetree.FileInfo{Path: "usr/bin/tool", TypeFlag: tar.TypeReg, LogicalBytes: 50, StoredBytes: 50})
+	trees := []*filetree.FileTree{base, upper}
+	for idx, tree := range trees {
+		tree.SetLayer(idx)
+	}
+	efficiency, inefficiencies := filetree.Efficiency(trees)
+
+	return &ci.Analysis{
+		Image: "app:latest",
+		Layers: []*image.Layer{
+			{Index: 0, Tree: base, History: image.ImageHistoryEntry{ID: "sha256:aaa", Size: 400, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "}},
+			{Index: 1, Tree: upper, History: image.ImageHistoryEntry{ID: "sha256:bbb", Size: 200, CreatedBy: "/bin/sh -c make install"}},
+		},
+		Trees:          trees,
+		Efficiency:     efficiency,
+		Inefficiencies: inefficiencies,
+		Platform:       "linux/amd64",
+	}
+}
+
+func TestNew(t *testing.T) {
+	report, err := New(testAnalysis(), Options{WastedLimit: -1})
+	if err != nil {
+		t.Fatalf("could not build the report: %v", err)
+	}
+
+	if report.SchemaVersion != SchemaVersion || report.Image.Reference != "app:latest" || report.Image.LayerCount != 2 {
+		t.Errorf("unexpected report header: %+v", report)
+	}
+	if report.Image.SizeBytes != 600 || report.Image.UserSizeBytes != 200 {
+		t.Errorf("unexpected image sizes: %+v", report.Image)
+	}
+	if len(report.Layers) != 2 || report.Layers[1].Digest != "sha256:bbb" || report.Layers[1].Command != "RUN make install" {
+		t.Fatalf("unexpected layers: %+v", report.Layers)
+	}
+
+	base, upper := report.Layers[0].Changes, report.Layers[1].Changes
+	if base.Added != (ChangeStats{Files: 2, Bytes: 400}) || base.Removed.Files != 0 {
+		t.Errorf("expected every file of the first layer to be added: %+v", base)
+	}
+	if upper.Added != (ChangeStats{Files: 1, Bytes: 50}) || upper.Changed.Files != 1 || upper.Removed.Files != 1 {
+		t.Errorf("unexpected changes of the second layer: %+v", upper)
+	}
+	if report.Efficiency.WastedBytes == 0 || len(report.Wasted.Files) == 0 {
+		t.Errorf("expected wasted bytes: %+v %+v", report.Efficiency, report.Wasted)
+	}
+	if report.Tree != nil || report.Rules != nil {
+		t.Error("expected no tree and no rules unless asked for")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	analysis := testAnalysis()
+	results, err := ci.Evaluate(analysis, nil)
+	if err != nil {
+		t.Fatalf("could not evaluate the rules: %v", err)
+	}
+	report, err := New(analysis, Options{WastedLimit: 10, IncludeTree: true, Rules: results})
+	if err != nil {
+		t.Fatalf("could not build the report: %v", err)
+	}
+
+	var first, second bytes.Buffer
+	if err := report.WriteJSON(&first); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	if err := report.WriteJSON(&second); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	if first.String() != second.String() {
+		t.Error("expected the same report to be written the same way every time")
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(first.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded["schemaVersion"] != float64(SchemaVersion) {
+		t.Errorf("expected the schema version, got %v", decoded["schemaVersion"])
+	}
+	for _, key := range []string{"image", "efficiency", "layers", "wasted", "rules", "tree"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("expected a '%s' section", key)
+		}
+	}
+	for _, expected := range []string{`"sizeBytes": 600`, `"metadataRewrites": []`, `"rule": "lowestEfficiency"`} {
+		if !strings.Contains(first.String(), expected) {
+			t.Errorf("expected %s in the report:\n%s", expected, first.String())
+		}
+	}
+}
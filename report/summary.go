@@ -0,0 +1,107 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"github.com/wagoodman/dive/ci"
+)
+
+const (
+	// summaryLayers is the number of layers (the largest ones) listed by the text summary
+	summaryLayers = 5
+	// summaryWastedFiles is the number of files wasting the most space listed by the text summary
+	summaryWastedFiles = 10
+	// summaryChurnFiles is the number of files added then removed (the largest ones) listed by the text summary
+	summaryChurnFiles = 10
+	// summaryLargeFiles is the number of large files (the largest ones) listed by the text summary
+	summaryLargeFiles = 10
+)
+
+var summaryHeading = color.New(color.Bold)
+
+// WriteSummary writes the report as a plain text summary with aligned columns, meant for terminals and tickets: the
+// size and efficiency of the image, its largest layers with their commands, the files wasting the most space, the
+// largest of the files added then removed by a later layer, and the largest of the large files.
+// Headings are bold unless colors are disabled (see color.NoColor).
+func (report *Report) WriteSummary(writer io.Writer) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+
+	image := report.Image.Reference
+	if report.Image.Platform != "" {
+		image += " (" + report.Image.Platform + ")"
+	}
+	fmt.Fprintln(table, summaryHeading.Sprint("Image: "+image))
+	fmt.Fprintf(table, "  Total size:\t%s (%d %s)\n", humanize.Bytes(report.Image.SizeBytes), report.Image.LayerCount, ci.Pluralize(report.Image.LayerCount, "layer", "layers"))
+	fmt.Fprintf(table, "  Efficiency:\t%s\n", ci.FormatPercent(report.Efficiency.Score))
+	fmt.Fprintf(table, "  Wasted:\t%s (%s of the user layers)\n", humanize.Bytes(report.Efficiency.WastedBytes), ci.FormatPercent(report.Efficiency.WastedUserFraction))
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.Layers) > 0 {
+		layers := largestLayers(report.Layers, summaryLayers)
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprint("Largest layers"))
+		fmt.Fprintln(table, "  #\tSize\tWasted\tCommand")
+		for _, layer := range layers {
+			fmt.Fprintf(table, "  %d\t%s\t%s\t%s\n",
+				layer.Index,
+				humanize.Bytes(layer.SizeBytes),
+				humanize.Bytes(uint64(layer.WastedBytes)),
+				truncate(layer.Command, maxCommandLength))
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	files := report.Wasted.Files
+	if len(files) > summaryWastedFiles {
+		files = files[:summaryWastedFiles]
+	}
+	if len(files) > 0 {
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprint("Largest wasted files"))
+		fmt.Fprintln(table, "  Wasted\tCopies\tPath")
+		for _, file := range files {
+			fmt.Fprintf(table, "  %s\t%d\t%s\n", humanize.Bytes(uint64(file.TotalBytes)), file.Occurrences, file.Path)
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	churn := report.Wasted.Churn
+	if len(churn) > summaryChurnFiles {
+		churn = churn[:summaryChurnFiles]
+	}
+	if len(churn) > 0 {
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprintf("Files added then removed (%s)", humanize.Bytes(uint64(report.Wasted.Churn.TotalSize()))))
+		fmt.Fprintln(table, "  Size\tAdded\tRemoved\tPath")
+		for _, file := range churn {
+			fmt.Fprintf(table, "  %s\t%d\t%d\t%s\n", humanize.Bytes(uint64(file.Size)), file.AddedLayer, file.RemovedLayer, file.Path)
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	large := report.LargeFiles
+	if len(large) > summaryLargeFiles {
+		large = large[:summaryLargeFiles]
+	}
+	if len(large) > 0 {
+		fmt.Fprintln(table, "\n"+summaryHeading.Sprint("Large files"))
+		fmt.Fprintln(table, "  Size\tLayer\tPath")
+		for _, file := range large {
+			path := file.Path
+			if file.Removed {
+				path += " (removed)"
+			}
+			fmt.Fprintf(table, "  %s\t%d\t%s\n", humanize.Bytes(uint64(file.Size)), file.Layer, path)
+		}
+	}
+	return table.Flush()
+}
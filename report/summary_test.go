@@ -0,0 +1,42 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSummary(t *testing.T) {
+	report, err := New(testAnalysis(), Options{WastedLimit: -1, LargeFileSize: 150})
+	if err != nil {
+		t.Fatalf("could not build the report: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteSummary(&out); err != nil {
+		t.Fatalf("could not write the summary: %v", err)
+	}
+	summary := out.String()
+
+	for _, expected := range []string{
+		"Image: app:latest (linux/amd64)\n",
+		"  Total size:  600 B (2 layers)\n",
+		"  Efficiency:  ",
+		"Largest layers\n  #  Size   Wasted  Command\n",
+		"  0  400 B  0 B     ADD file:abc in /\n",
+		"  1  200 B  ",
+		"RUN make install\n",
+		"Largest wasted files\n  Wasted  Copies  Path\n",
+		"/etc/app.conf\n",
+		// the removed build log is still stored by the first layer
+		"Files added then removed (300 B)\n  Size   Added  Removed  Path\n  300 B  0      1        /tmp/build.log\n",
+		"Large files\n  Size   Layer  Path\n  300 B  0      /tmp/build.log (removed)\n  150 B  1      /etc/app.conf\n",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected %q in the summary:\n%s", expected, summary)
+		}
+	}
+	if strings.Contains(summary, "\x1b[") {
+		t.Errorf("expected no color codes:\n%s", summary)
+	}
+}
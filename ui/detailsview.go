@@ -6,6 +6,7 @@ import (
 	"github.com/jroimartin/gocui"
 	"github.com/lunixbochs/vtclean"
 	"github.com/wagoodman/dive/filetree"
+	"github.com/wagoodman/dive/image"
 	"strconv"
 	"strings"
 )
@@ -19,10 +20,20 @@ type DetailsView struct {
 	header         *gocui.View
 	efficiency     float64
 	inefficiencies filetree.EfficiencySlice
+	duplicates     filetree.DuplicateSlice
+	wasted         filetree.WastedFileSlice
+	wastedDirs     filetree.WastedDirectorySlice
+	churn          filetree.ChurnSlice
+	removable      filetree.RemovableSlice
+	permissions    filetree.PermissionChangeSlice
+	largeFiles     filetree.LargeFileSlice
+	largeFileSize  uint64
+	layerLimits    image.LayerLimits
+	platform       string
 }
 
 // NewDetailsView creates a new view object attached the the global [gocui] screen object.
-func NewDetailsView(name string, gui *gocui.Gui, efficiency float64, inefficiencies filetree.EfficiencySlice) (detailsView *DetailsView) {
+func NewDetailsView(name string, gui *gocui.Gui, efficiency float64, inefficiencies filetree.EfficiencySlice, platform string) (detailsView *DetailsView) {
 	detailsView = new(DetailsView)
 
 	// populate main fields
@@ -30,6 +41,7 @@ func NewDetailsView(name string, gui *gocui.Gui, efficiency float64, inefficienc
 	detailsView.gui = gui
 	detailsView.efficiency = efficiency
 	detailsView.inefficiencies = inefficiencies
+	detailsView.platform = platform
 
 	return detailsView
 }
@@ -84,31 +96,123 @@ func (view *DetailsView) Update() error {
 }
 
 // Render flushes the state objects to the screen. The details pane reports:
-// 1. the current selected layer's command string
-// 2. the image efficiency score
-// 3. the estimated wasted image space
-// 4. a list of inefficient file allocations
+// 1. the current selected layer's history (creation time, author, comment, and command string as recorded), the
+// metadata-only steps following it, the number of entries of the layer (warning about layers holding too many), and
+// the size of the layer blob (warning when it is much larger than the entries)
+// 2. the image platform
+// 3. the image efficiency score
+// 4. the estimated wasted image space
+// 5. a list of the paths wasting the most space (see filetree.WastedFiles)
+// 6. a list of the directories wasting the most space
+// 7. a list of the files added then removed by a later layer
+// 8. a list of the likely removable paths (caches and temporary files, see filetree.FindRemovable)
+// 9. a list of file contents duplicated across layers
+// 10. a list of the files over the large file size
 func (view *DetailsView) Render() error {
+	if Views.Layer.comparison != nil {
+		return view.renderComparison(Views.Layer.comparison)
+	}
 	currentLayer := Views.Layer.currentLayer()
 
 	var wastedSpace int64
 
-	template := "%5s  %12s  %-s\n"
-	inefficiencyReport := fmt.Sprintf(Formatting.Header(template), "Count", "Total Space", "Path")
+	template := "%5s  %12s  %-12s  %-s\n"
+	inefficiencyReport := fmt.Sprintf(Formatting.Header(template), "Count", "Total Space", "Layers", "Path")
 
 	height := 100
 	if view.view != nil {
 		_, height = view.view.Size()
 	}
 
-	for idx := 0; idx < len(view.inefficiencies); idx++ {
-		data := view.inefficiencies[len(view.inefficiencies)-1-idx]
+	for _, data := range view.inefficiencies {
 		wastedSpace += data.CumulativeSize
+	}
+
+	// todo: make this report scrollable
+	for idx, file := range view.wasted {
+		if idx >= height {
+			break
+		}
+		inefficiencyReport += fmt.Sprintf(template, strconv.Itoa(file.Occurrences), humanize.Bytes(uint64(file.TotalBytes)), joinLayers(file.Layers), file.Path)
+	}
+
+	dirTemplate := "%5s  %12s  %-s\n"
+	dirReport := fmt.Sprintf(Formatting.Header(dirTemplate), "Files", "Total Space", "Directory")
+	for idx, dir := range view.wastedDirs {
+		if idx >= height {
+			break
+		}
+		dirReport += fmt.Sprintf(dirTemplate, strconv.Itoa(dir.Files), humanize.Bytes(uint64(dir.TotalBytes)), dir.Path)
+	}
+
+	churnTemplate := "%5s  %7s  %12s  %-s\n"
+	churnReport := fmt.Sprintf(Formatting.Header(churnTemplate), "Added", "Removed", "Size", "Path")
+	for idx, file := range view.churn {
+		if idx >= height {
+			break
+		}
+		churnReport += fmt.Sprintf(churnTemplate, strconv.Itoa(file.AddedLayer), strconv.Itoa(file.RemovedLayer), humanize.Bytes(uint64(file.Size)), file.Path)
+	}
+
+	removableTemplate := "%5s  %12s  %-s\n"
+	removableReport := fmt.Sprintf(Formatting.Header(removableTemplate), "Files", "Size", "Path")
+	for idx, removable := range view.removable {
+		if idx >= height {
+			break
+		}
+		removableReport += fmt.Sprintf(removableTemplate, strconv.Itoa(removable.Files), humanize.Bytes(uint64(removable.Size)), removable.Path)
+	}
 
-		// todo: make this report scrollable and exportable
-		if idx < height {
-			inefficiencyReport += fmt.Sprintf(template, strconv.Itoa(len(data.Nodes)), humanize.Bytes(uint64(data.CumulativeSize)), data.Path)
+	rewriteTemplate := "%5s  %5s  %12s  %5s  %-s\n"
+	rewriteReport := fmt.Sprintf(Formatting.Header(rewriteTemplate), "Layer", "Files", "Duplicated", "Share", "Command")
+	for _, rewrite := range Views.Layer.metadataRewrites {
+		var command string
+		for _, layer := range Views.Layer.Layers {
+			if layer.Index == rewrite.Layer {
+				command = layer.Command()
+			}
+		}
+		rewriteReport += fmt.Sprintf(rewriteTemplate, strconv.Itoa(rewrite.Layer), strconv.Itoa(rewrite.Files), humanize.Bytes(uint64(rewrite.Bytes)), fmt.Sprintf("%d%%", int(100*rewrite.Fraction())), command)
+	}
+
+	permissionTemplate := "%5s  %11s  %-21s  %-s\n"
+	permissionReport := fmt.Sprintf(Formatting.Header(permissionTemplate), "Layer", "Mode", "Owner", "Path")
+	for idx, change := range view.permissions {
+		if idx >= height {
+			break
+		}
+		row := fmt.Sprintf(permissionTemplate, strconv.Itoa(change.Layer), change.ModeTransition(), change.OwnerTransition(), change.Path)
+		if change.Risky() {
+			row = Formatting.Warning(row)
 		}
+		permissionReport += row
+	}
+
+	duplicateTemplate := "%5s  %12s  %-12s  %-s\n"
+	duplicateReport := fmt.Sprintf(Formatting.Header(duplicateTemplate), "Count", "Wasted Space", "Layers", "Paths")
+	for idx, data := range view.duplicates {
+		if idx >= height {
+			break
+		}
+		duplicateReport += fmt.Sprintf(duplicateTemplate, strconv.Itoa(len(data.Occurrences)), humanize.Bytes(uint64(data.WastedSize)), joinLayers(data.Layers()), strings.Join(data.Paths(), ", "))
+	}
+
+	largeTemplate := "%5s  %12s  %-s\n"
+	largeReport := fmt.Sprintf(Formatting.Header(largeTemplate), "Layer", "Size", "Path")
+	for idx, file := range view.largeFiles {
+		if idx >= height {
+			break
+		}
+		path := file.Path
+		if file.Removed {
+			path += " (removed)"
+		}
+		largeReport += fmt.Sprintf(largeTemplate, strconv.Itoa(file.Layer), humanize.Bytes(uint64(file.Size)), path)
+	}
+
+	var selected *filetree.FileNode
+	if Views.Tree != nil && Views.Filter != nil && Views.Filter.view != nil {
+		selected = Views.Tree.getAbsPositionNode()
 	}
 
 	imageSizeStr := fmt.Sprintf("%s %s", Formatting.Header("Total Image size:"), humanize.Bytes(Views.Layer.ImageSize))
@@ -129,21 +233,145 @@ func (view *DetailsView) Render() error {
 		view.view.Clear()
 		fmt.Fprintln(view.view, Formatting.Header("Digest: ")+currentLayer.Id())
 		fmt.Fprintln(view.view, Formatting.Header("Tar ID: ")+currentLayer.TarId())
+		if created := currentLayer.History.CreatedTime(); !created.IsZero() {
+			fmt.Fprintln(view.view, Formatting.Header("Created:")+" "+created.UTC().Format("2006-01-02 15:04:05 MST"))
+		}
+		if currentLayer.History.Author != "" {
+			fmt.Fprintln(view.view, Formatting.Header("Author: ")+currentLayer.History.Author)
+		}
+		if currentLayer.History.Comment != "" {
+			fmt.Fprintln(view.view, Formatting.Header("Comment:")+" "+currentLayer.History.Comment)
+		}
 		fmt.Fprintln(view.view, Formatting.Header("Command:"))
-		fmt.Fprintln(view.view, currentLayer.History.CreatedBy)
+		fmt.Fprintln(view.view, currentLayer.RawCommand())
+		if len(currentLayer.Metadata) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header("Metadata:"))
+			for _, entry := range currentLayer.Metadata {
+				fmt.Fprintln(view.view, image.CleanCommand(entry.CreatedBy))
+			}
+		}
+		if currentLayer.Tree != nil {
+			stats := currentLayer.Tree.EntryStats()
+			fmt.Fprintln(view.view, Formatting.Header("Entries:")+fmt.Sprintf(" %d (%d files of %s, %d directories, %d symlinks, %d hardlinks, %d whiteouts, %d other)",
+				stats.Entries, stats.Files, humanize.Bytes(uint64(stats.Bytes)), stats.Directories, stats.Symlinks, stats.Hardlinks, stats.Whiteouts, stats.Other))
+			if currentLayer.ExceedsMaxFiles(view.layerLimits.MaxFiles) {
+				fmt.Fprintln(view.view, Formatting.Header("Warning:")+fmt.Sprintf(" the layer holds more than %d entries", view.layerLimits.MaxFiles))
+			}
+			if currentLayer.Tree.BlobSize > 0 {
+				fmt.Fprintln(view.view, Formatting.Header("Blob size:")+fmt.Sprintf(" %s (the entries hold %s)", humanize.Bytes(currentLayer.Tree.BlobSize), humanize.Bytes(currentLayer.Tree.ContentSize)))
+			}
+			if currentLayer.SizeDivergent(view.layerLimits.SizeDivergence) {
+				fmt.Fprintln(view.view, Formatting.Header("Warning:")+fmt.Sprintf(" the blob is over %v times the size of the entries (tar padding, data past the end of the tar, or poor compression?)", view.layerLimits.SizeDivergence))
+			}
+		}
+
+		if selected != nil {
+			fmt.Fprintln(view.view, Formatting.Header("Selected:")+" "+selected.Path()+selectedLayers(selected))
+		}
 
 		fmt.Fprintln(view.view, "\n"+Formatting.Header(vtclean.Clean(imageHeaderStr, false)))
 
+		if view.platform != "" {
+			fmt.Fprintln(view.view, Formatting.Header("Platform:")+" "+view.platform)
+		}
+
 		fmt.Fprintln(view.view, imageSizeStr)
 		fmt.Fprintln(view.view, wastedSpaceStr)
 		fmt.Fprintln(view.view, effStr+"\n")
 
+		if len(Views.Layer.metadataRewrites) > 0 {
+			fmt.Fprintln(view.view, Formatting.Warning(fmt.Sprintf("Metadata-only rewrites (%s duplicated only to change ownership or permissions, use COPY --chown or change the files in the step adding them):", humanize.Bytes(uint64(Views.Layer.metadataRewrites.TotalSize())))))
+			fmt.Fprintln(view.view, rewriteReport)
+		}
+
 		fmt.Fprintln(view.view, inefficiencyReport)
+
+		if len(view.wastedDirs) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header("Wasted space by directory:"))
+			fmt.Fprintln(view.view, dirReport)
+		}
+
+		if len(view.churn) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header(fmt.Sprintf("Files added then removed by a later layer (%s):", humanize.Bytes(uint64(view.churn.TotalSize())))))
+			fmt.Fprintln(view.view, churnReport)
+		}
+
+		if len(view.removable) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header(fmt.Sprintf("Likely removable (caches and temporary files, %s):", humanize.Bytes(uint64(view.removable.TotalSize())))))
+			fmt.Fprintln(view.view, removableReport)
+		}
+
+		if len(view.permissions) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header(fmt.Sprintf("Permission and ownership changes (%d newly setuid, setgid, or world-writable):", len(view.permissions.Risky(-1)))))
+			fmt.Fprintln(view.view, permissionReport)
+		}
+
+		if len(view.duplicates) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header("Duplicate files (across layers):"))
+			fmt.Fprintln(view.view, duplicateReport)
+		}
+
+		if len(view.largeFiles) > 0 {
+			fmt.Fprintln(view.view, Formatting.Header(fmt.Sprintf("Large files (over %s):", humanize.Bytes(view.largeFileSize))))
+			fmt.Fprintln(view.view, largeReport)
+		}
 		return nil
 	})
 	return nil
 }
 
+// selectedLayers describes the layers adding and last modifying a node, when known (see filetree.FileTree.SetLayer).
+func selectedLayers(node *filetree.FileNode) string {
+	if node.Data.AddedLayer < 0 {
+		return ""
+	}
+	if node.Data.ModifiedLayer == node.Data.AddedLayer {
+		return fmt.Sprintf(" (added in layer %d)", node.Data.AddedLayer)
+	}
+	return fmt.Sprintf(" (added in layer %d, last modified in layer %d)", node.Data.AddedLayer, node.Data.ModifiedLayer)
+}
+
+// renderComparison flushes the totals of an image comparison to the screen: the compared images, the image platform,
+// the number of added, removed, and changed files, and the net size change.
+func (view *DetailsView) renderComparison(comparison *Comparison) error {
+	var added, removed, changed int
+	var sizeBefore, sizeAfter int64
+	for _, summary := range comparison.Summaries {
+		added += summary.Added
+		removed += summary.Removed
+		changed += summary.Changed
+		sizeBefore += summary.SizeBefore
+		sizeAfter += summary.SizeAfter
+	}
+
+	view.gui.Update(func(g *gocui.Gui) error {
+		view.header.Clear()
+		width, _ := view.view.Size()
+		headerStr := fmt.Sprintf("[Comparison Details]%s", strings.Repeat("─", width-20))
+		fmt.Fprintln(view.header, Formatting.Header(vtclean.Clean(headerStr, false)))
+
+		view.view.Clear()
+		fmt.Fprintln(view.view, Formatting.Header("Base image:")+" "+comparison.Base)
+		fmt.Fprintln(view.view, Formatting.Header("Compared image:")+" "+comparison.Image)
+		if view.platform != "" {
+			fmt.Fprintln(view.view, Formatting.Header("Platform:")+" "+view.platform)
+		}
+		fmt.Fprintln(view.view, fmt.Sprintf("%s %d added, %d removed, %d changed", Formatting.Header("Files:"), added, removed, changed))
+		fmt.Fprintln(view.view, Formatting.Header("Size change:")+" "+sizeChange(sizeBefore, sizeAfter))
+		return nil
+	})
+	return nil
+}
+
+// joinLayers lists layer indexes for the details reports (e.g. "0,2,5")
+func joinLayers(indexes []int) string {
+	layers := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		layers = append(layers, strconv.Itoa(idx))
+	}
+	return strings.Join(layers, ",")
+}
+
 // KeyHelp indicates all the possible actions a user can take while the current pane is selected (currently does nothing).
 func (view *DetailsView) KeyHelp() string {
 	return "TBD"
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/wagoodman/dive/utils"
@@ -20,6 +21,9 @@ const (
 
 type CompareType int
 
+// minSizeSteps are the minimum file sizes (in bytes) cycled through in the filetree pane, 0 shows all files
+var minSizeSteps = []int64{0, 1000, 100 * 1000, 1000 * 1000, 10 * 1000 * 1000, 100 * 1000 * 1000}
+
 // FileTreeView holds the UI objects and data models for populating the right pane. Specifically the pane that
 // shows selected layer or aggregate file ASCII tree.
 type FileTreeView struct {
@@ -30,17 +34,28 @@ type FileTreeView struct {
 	ModelTree             *filetree.FileTree
 	ViewTree              *filetree.FileTree
 	RefTrees              []*filetree.FileTree
+	stacks                *filetree.StackCache
 	HiddenDiffTypes       []bool
+	HideGlobs             []string
+	MinSizeIndex          int
+	SortOrder             filetree.SortOrder
+	CompressChains        bool
+	HideDotfiles          bool
 	TreeIndex             uint
 	bufferIndex           uint
 	bufferIndexUpperBound uint
 	bufferIndexLowerBound uint
+	// selected is the node whose details were last rendered (see renderSelection)
+	selected *filetree.FileNode
 
 	keybindingToggleCollapse  []Key
 	keybindingToggleAdded     []Key
 	keybindingToggleRemoved   []Key
 	keybindingToggleModified  []Key
 	keybindingToggleUnchanged []Key
+	keybindingCycleMinSize    []Key
+	keybindingCycleSortOrder  []Key
+	keybindingToggleDotfiles  []Key
 	keybindingPageDown        []Key
 	keybindingPageUp          []Key
 }
@@ -54,7 +69,8 @@ func NewFileTreeView(name string, gui *gocui.Gui, tree *filetree.FileTree, refTr
 	treeView.gui = gui
 	treeView.ModelTree = tree
 	treeView.RefTrees = refTrees
-	treeView.HiddenDiffTypes = make([]bool, 4)
+	treeView.stacks = filetree.NewStackCache(refTrees)
+	treeView.HiddenDiffTypes = make([]bool, 6)
 
 	hiddenTypes := viper.GetStringSlice("diff.hide")
 	for _, hType := range hiddenTypes {
@@ -67,16 +83,32 @@ func NewFileTreeView(name string, gui *gocui.Gui, tree *filetree.FileTree, refTr
 			treeView.HiddenDiffTypes[filetree.Changed] = true
 		case "unchanged":
 			treeView.HiddenDiffTypes[filetree.Unchanged] = true
+		case "metadata-changed":
+			treeView.HiddenDiffTypes[filetree.MetadataChanged] = true
+		case "moved":
+			treeView.HiddenDiffTypes[filetree.Moved] = true
 		default:
 			utils.PrintAndExit(fmt.Sprintf("unknown diff.hide value: %s", t))
 		}
 	}
 
+	treeView.CompressChains = viper.GetBool("filetree.compress-chains")
+	treeView.HideDotfiles = viper.GetBool("filetree.hide-dotfiles")
+	treeView.HideGlobs = viper.GetStringSlice("filetree.hide")
+	for _, pattern := range treeView.HideGlobs {
+		if err := filetree.ValidateGlob(pattern); err != nil {
+			utils.PrintAndExit(fmt.Sprintf("invalid filetree.hide pattern '%s': %v", pattern, err))
+		}
+	}
+
 	treeView.keybindingToggleCollapse = getKeybindings(viper.GetString("keybinding.toggle-collapse-dir"))
 	treeView.keybindingToggleAdded = getKeybindings(viper.GetString("keybinding.toggle-added-files"))
 	treeView.keybindingToggleRemoved = getKeybindings(viper.GetString("keybinding.toggle-removed-files"))
 	treeView.keybindingToggleModified = getKeybindings(viper.GetString("keybinding.toggle-modified-files"))
 	treeView.keybindingToggleUnchanged = getKeybindings(viper.GetString("keybinding.toggle-unchanged-files"))
+	treeView.keybindingCycleMinSize = getKeybindings(viper.GetString("keybinding.cycle-min-size"))
+	treeView.keybindingCycleSortOrder = getKeybindings(viper.GetString("keybinding.cycle-sort-order"))
+	treeView.keybindingToggleDotfiles = getKeybindings(viper.GetString("keybinding.toggle-dotfiles"))
 	treeView.keybindingPageUp = getKeybindings(viper.GetString("keybinding.page-up"))
 	treeView.keybindingPageDown = getKeybindings(viper.GetString("keybinding.page-down"))
 
@@ -146,6 +178,21 @@ func (view *FileTreeView) Setup(v *gocui.View, header *gocui.View) error {
 			return err
 		}
 	}
+	for _, key := range view.keybindingCycleMinSize {
+		if err := view.gui.SetKeybinding(view.Name, key.value, key.modifier, func(*gocui.Gui, *gocui.View) error { return view.cycleMinSize() }); err != nil {
+			return err
+		}
+	}
+	for _, key := range view.keybindingCycleSortOrder {
+		if err := view.gui.SetKeybinding(view.Name, key.value, key.modifier, func(*gocui.Gui, *gocui.View) error { return view.cycleSortOrder() }); err != nil {
+			return err
+		}
+	}
+	for _, key := range view.keybindingToggleDotfiles {
+		if err := view.gui.SetKeybinding(view.Name, key.value, key.modifier, func(*gocui.Gui, *gocui.View) error { return view.toggleDotfiles() }); err != nil {
+			return err
+		}
+	}
 
 	view.bufferIndexLowerBound = 0
 	view.bufferIndexUpperBound = view.height() // don't include the header or footer in the view size
@@ -184,17 +231,20 @@ func (view *FileTreeView) setTreeByLayer(bottomTreeStart, bottomTreeStop, topTre
 	if topTreeStop > len(view.RefTrees)-1 {
 		return fmt.Errorf("invalid layer index given: %d of %d", topTreeStop, len(view.RefTrees)-1)
 	}
-	newTree := filetree.StackRange(view.RefTrees, bottomTreeStart, bottomTreeStop)
+	newTree := view.stacks.StackRange(bottomTreeStart, bottomTreeStop)
 
 	for idx := topTreeStart; idx <= topTreeStop; idx++ {
 		newTree.Compare(view.RefTrees[idx])
 	}
+	if viper.GetBool("diff.detect-moves") {
+		newTree.DetectMoves()
+	}
 
 	// preserve view state on copy
 	visitor := func(node *filetree.FileNode) error {
 		newNode, err := newTree.GetNode(node.Path())
 		if err == nil {
-			newNode.Data.ViewInfo = node.Data.ViewInfo
+			newNode.SetViewInfo(node.Data.ViewInfo)
 		}
 		return nil
 	}
@@ -239,7 +289,7 @@ func (view *FileTreeView) doCursorDown() {
 // this range into the view buffer. This is much faster when tree sizes are large.
 func (view *FileTreeView) CursorDown() error {
 	view.doCursorDown()
-	return view.Render()
+	return view.renderSelection()
 }
 
 // CursorUp moves the cursor up and renders the view.
@@ -249,7 +299,7 @@ func (view *FileTreeView) CursorDown() error {
 func (view *FileTreeView) CursorUp() error {
 	if view.TreeIndex > 0 {
 		view.doCursorUp()
-		return view.Render()
+		return view.renderSelection()
 	}
 	return nil
 }
@@ -264,9 +314,12 @@ func (view *FileTreeView) CursorLeft() error {
 	if currentNode == nil {
 		return nil
 	}
-	parentPath := currentNode.Parent.Path()
+	parentPath := currentNode.ChainHead().Parent.ChainHead().Path()
 
 	visitor = func(curNode *filetree.FileNode) error {
+		if curNode.InChain() {
+			return nil
+		}
 		if strings.Compare(parentPath, curNode.Path()) == 0 {
 			newIndex = dfsCounter
 		}
@@ -311,7 +364,7 @@ func (view *FileTreeView) CursorLeft() error {
 	}
 
 	view.Update()
-	return view.Render()
+	return view.renderSelection()
 }
 
 // CursorRight descends into directory expanding it if needed
@@ -320,15 +373,13 @@ func (view *FileTreeView) CursorRight() error {
 	if node == nil {
 		return nil
 	}
-	if !node.Data.FileInfo.TarHeader.FileInfo().IsDir() {
+	if !node.Data.FileInfo.IsDir() {
 		return nil
 	}
 	if len(node.Children) == 0 {
 		return nil
 	}
-	if node.Data.ViewInfo.Collapsed {
-		node.Data.ViewInfo.Collapsed = false
-	}
+	node.SetCollapsed(false)
 	view.TreeIndex++
 	if view.TreeIndex > view.bufferIndexUpperBound {
 		view.bufferIndexUpperBound++
@@ -339,7 +390,7 @@ func (view *FileTreeView) CursorRight() error {
 		view.bufferIndex = view.height()
 	}
 	view.Update()
-	return view.Render()
+	return view.renderSelection()
 }
 
 // PageDown moves to next page putting the cursor on top
@@ -392,6 +443,20 @@ func (view *FileTreeView) PageUp() error {
 	return view.Render()
 }
 
+// renderSelection renders the view, and the details of the node now under the cursor when it is not the one they
+// already show.
+func (view *FileTreeView) renderSelection() error {
+	if err := view.Render(); err != nil {
+		return err
+	}
+	selected := view.getAbsPositionNode()
+	if selected == view.selected {
+		return nil
+	}
+	view.selected = selected
+	return Views.Details.Render()
+}
+
 // getAbsPositionNode determines the selected screen cursor's location in the file tree, returning the selected FileNode.
 func (view *FileTreeView) getAbsPositionNode() (node *filetree.FileNode) {
 	var visitor func(*filetree.FileNode) error
@@ -399,8 +464,13 @@ func (view *FileTreeView) getAbsPositionNode() (node *filetree.FileNode) {
 	var dfsCounter uint
 
 	visitor = func(curNode *filetree.FileNode) error {
+		// nodes within a compressed chain share the row of the chain head
+		if curNode.InChain() {
+			return nil
+		}
 		if dfsCounter == view.TreeIndex {
-			node = curNode
+			node = curNode.ChainEnd()
+			return filetree.StopWalk
 		}
 		dfsCounter++
 		return nil
@@ -436,12 +506,45 @@ func (view *FileTreeView) getAbsPositionNode() (node *filetree.FileNode) {
 func (view *FileTreeView) toggleCollapse() error {
 	node := view.getAbsPositionNode()
 	if node != nil {
-		node.Data.ViewInfo.Collapsed = !node.Data.ViewInfo.Collapsed
+		node.SetCollapsed(!node.Data.ViewInfo.Collapsed)
 	}
 	view.Update()
 	return view.Render()
 }
 
+// cycleMinSize moves to the next minimum file size shown in the filetree pane (wrapping back to showing all sizes).
+func (view *FileTreeView) cycleMinSize() error {
+	view.MinSizeIndex = (view.MinSizeIndex + 1) % len(minSizeSteps)
+
+	view.resetCursor()
+
+	Update()
+	Render()
+	return nil
+}
+
+// cycleSortOrder moves to the next order of the files listed in the filetree pane (name, size, diff status).
+func (view *FileTreeView) cycleSortOrder() error {
+	view.SortOrder = view.SortOrder.Next()
+
+	view.resetCursor()
+
+	Update()
+	Render()
+	return nil
+}
+
+// toggleDotfiles will show/hide files and directories whose name starts with a "." in the filetree pane.
+func (view *FileTreeView) toggleDotfiles() error {
+	view.HideDotfiles = !view.HideDotfiles
+
+	view.resetCursor()
+
+	Update()
+	Render()
+	return nil
+}
+
 // toggleShowDiffType will show/hide the selected DiffType in the filetree pane.
 func (view *FileTreeView) toggleShowDiffType(diffType filetree.DiffType) error {
 	view.HiddenDiffTypes[diffType] = !view.HiddenDiffTypes[diffType]
@@ -474,22 +577,42 @@ func filterRegex() *regexp.Regexp {
 // Update refreshes the state objects for future rendering.
 func (view *FileTreeView) Update() error {
 	regex := filterRegex()
+	view.ModelTree.SetSortOrder(view.SortOrder)
+	view.ModelTree.SetCompressChains(view.CompressChains)
 
 	// keep the view selection in parity with the current DiffType selection
-	view.ModelTree.VisitDepthChildFirst(func(node *filetree.FileNode) error {
-		node.Data.ViewInfo.Hidden = view.HiddenDiffTypes[node.Data.DiffType]
-		visibleChild := false
-		for _, child := range node.Children {
-			if !child.Data.ViewInfo.Hidden {
-				visibleChild = true
+	visibleDiffTypes := make(map[filetree.DiffType]bool)
+	for diffType, hidden := range view.HiddenDiffTypes {
+		visibleDiffTypes[filetree.DiffType(diffType)] = !hidden
+	}
+	view.ModelTree.SetDiffTypeVisibility(visibleDiffTypes)
+
+	if regex != nil {
+		view.ModelTree.VisitDepthChildFirst(func(node *filetree.FileNode) error {
+			if node.Data.ViewInfo.Hidden {
+				return nil
 			}
-		}
-		if regex != nil && !visibleChild {
-			match := regex.FindString(node.Path())
-			node.Data.ViewInfo.Hidden = len(match) == 0
-		}
-		return nil
-	}, nil)
+			visibleChild := false
+			for _, child := range node.Children {
+				if !child.Data.ViewInfo.Hidden {
+					visibleChild = true
+				}
+			}
+			if !visibleChild {
+				match := regex.FindString(node.Path())
+				node.SetHidden(len(match) == 0)
+			}
+			return nil
+		}, nil)
+	}
+
+	view.ModelTree.HideFilesSmallerThan(minSizeSteps[view.MinSizeIndex])
+	if view.HideDotfiles {
+		view.ModelTree.HideDotfiles()
+	}
+
+	// paths the user never wants to see are hidden regardless of the other filters
+	view.ModelTree.ApplyHideGlobs(view.HideGlobs, true)
 
 	// make a new tree with only visible nodes
 	view.ViewTree = view.ModelTree.Copy()
@@ -513,7 +636,9 @@ func (view *FileTreeView) Render() error {
 	}
 
 	title := "Current Layer Contents"
-	if Views.Layer.CompareMode == CompareAll {
+	if Views.Layer.comparison != nil {
+		title = "Image Comparison"
+	} else if Views.Layer.CompareMode == CompareAll {
 		title = "Aggregated Layer Contents"
 	}
 
@@ -551,5 +676,16 @@ func (view *FileTreeView) KeyHelp() string {
 		renderStatusOption(view.keybindingToggleAdded[0].String(), "Added files", !view.HiddenDiffTypes[filetree.Added]) +
 		renderStatusOption(view.keybindingToggleRemoved[0].String(), "Removed files", !view.HiddenDiffTypes[filetree.Removed]) +
 		renderStatusOption(view.keybindingToggleModified[0].String(), "Modified files", !view.HiddenDiffTypes[filetree.Changed]) +
-		renderStatusOption(view.keybindingToggleUnchanged[0].String(), "Unmodified files", !view.HiddenDiffTypes[filetree.Unchanged])
+		renderStatusOption(view.keybindingToggleUnchanged[0].String(), "Unmodified files", !view.HiddenDiffTypes[filetree.Unchanged]) +
+		renderStatusOption(view.keybindingCycleMinSize[0].String(), view.minSizeTitle(), view.MinSizeIndex > 0) +
+		renderStatusOption(view.keybindingCycleSortOrder[0].String(), "Sort by "+view.SortOrder.String(), view.SortOrder != filetree.SortByName) +
+		renderStatusOption(view.keybindingToggleDotfiles[0].String(), "Dotfiles", !view.HideDotfiles)
+}
+
+// minSizeTitle describes the current minimum file size filter
+func (view *FileTreeView) minSizeTitle() string {
+	if view.MinSizeIndex == 0 {
+		return "All sizes"
+	}
+	return "Files ≥ " + humanize.Bytes(uint64(minSizeSteps[view.MinSizeIndex]))
 }
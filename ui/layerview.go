@@ -8,10 +8,15 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/jroimartin/gocui"
 	"github.com/lunixbochs/vtclean"
+	"github.com/wagoodman/dive/filetree"
 	"github.com/wagoodman/dive/image"
+	"strconv"
 	"strings"
 )
 
+// comparisonFormat lays out the rows of the layer pane when comparing images
+const comparisonFormat = "%-25s %7s %7s %7s  %s"
+
 // LayerView holds the UI objects and data models for populating the lower-left pane. Specifically the pane that
 // shows the image layers and layer selector.
 type LayerView struct {
@@ -25,6 +30,13 @@ type LayerView struct {
 	CompareStartIndex int
 	ImageSize         uint64
 
+	// metadataRewrites are the layers only rewriting files of lower layers with new metadata (flagged in the list)
+	metadataRewrites filetree.MetadataRewriteSlice
+
+	// comparison is set when comparing two images, the pane then lists the changes beneath each top-level directory
+	// instead of layers
+	comparison *Comparison
+
 	keybindingCompareAll   []Key
 	keybindingCompareLayer []Key
 }
@@ -98,9 +110,17 @@ func (view *LayerView) IsVisible() bool {
 	return true
 }
 
+// rows returns the number of rows listed by the pane (layers, or directories when comparing images).
+func (view *LayerView) rows() int {
+	if view.comparison != nil {
+		return len(view.comparison.Summaries)
+	}
+	return len(view.Layers)
+}
+
 // CursorDown moves the cursor down in the layer pane (selecting a higher layer).
 func (view *LayerView) CursorDown() error {
-	if view.LayerIndex < len(view.Layers) {
+	if view.LayerIndex < view.rows() {
 		err := CursorDown(view.gui, view.view)
 		if err == nil {
 			view.SetCursor(view.LayerIndex + 1)
@@ -123,6 +143,10 @@ func (view *LayerView) CursorUp() error {
 // SetCursor resets the cursor and orients the file tree view based on the given layer index.
 func (view *LayerView) SetCursor(layer int) error {
 	view.LayerIndex = layer
+	if view.comparison != nil {
+		// the compared tree does not depend on the selected directory
+		return view.Render()
+	}
 	Views.Tree.setTreeByLayer(view.getCompareIndexes())
 	Views.Details.Render()
 	view.Render()
@@ -137,6 +161,9 @@ func (view *LayerView) currentLayer() *image.Layer {
 
 // setCompareMode switches the layer comparison between a single-layer comparison to an aggregated comparison.
 func (view *LayerView) setCompareMode(compareMode CompareType) error {
+	if view.comparison != nil {
+		return nil
+	}
 	view.CompareMode = compareMode
 	Update()
 	Render()
@@ -190,6 +217,9 @@ func (view *LayerView) Update() error {
 // 1. the layers of the image + metadata
 // 2. the current selected image
 func (view *LayerView) Render() error {
+	if view.comparison != nil {
+		return view.renderComparison()
+	}
 
 	// indicate when selected
 	title := "Layers"
@@ -223,6 +253,10 @@ func (view *LayerView) Render() error {
 				layerStr = fmt.Sprintf(image.LayerFormat, layerId, humanize.Bytes(uint64(layer.History.Size)), "FROM "+layer.ShortId())
 			}
 
+			if rewrite, ok := view.metadataRewrites.ForLayer(layer.Index); ok {
+				layerStr += Formatting.Warning(fmt.Sprintf(" [metadata-only rewrite: %s]", humanize.Bytes(uint64(rewrite.Bytes))))
+			}
+
 			compareBar := view.renderCompareBar(idx)
 
 			if idx == view.LayerIndex {
@@ -237,8 +271,47 @@ func (view *LayerView) Render() error {
 	return nil
 }
 
+// renderComparison flushes the changes beneath each top-level directory of an image comparison to the screen.
+func (view *LayerView) renderComparison() error {
+	title := "Directories"
+	if view.gui.CurrentView() == view.view {
+		title = "● " + title
+	}
+
+	view.gui.Update(func(g *gocui.Gui) error {
+		view.header.Clear()
+		width, _ := g.Size()
+		headerStr := fmt.Sprintf("[%s]%s\n", title, strings.Repeat("─", width*2))
+		headerStr += fmt.Sprintf(comparisonFormat, "Directory", "Added", "Removed", "Changed", "Size Change")
+		fmt.Fprintln(view.header, Formatting.Header(vtclean.Clean(headerStr, false)))
+
+		view.view.Clear()
+		for idx, summary := range view.comparison.Summaries {
+			row := fmt.Sprintf(comparisonFormat, summary.Path, strconv.Itoa(summary.Added), strconv.Itoa(summary.Removed), strconv.Itoa(summary.Changed), sizeChange(summary.SizeBefore, summary.SizeAfter))
+			if idx == view.LayerIndex {
+				fmt.Fprintln(view.view, Formatting.Selected(row))
+			} else {
+				fmt.Fprintln(view.view, row)
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// sizeChange formats the difference between two sizes (e.g. "+1.2 MB", "-300 B")
+func sizeChange(before, after int64) string {
+	if after >= before {
+		return "+" + humanize.Bytes(uint64(after-before))
+	}
+	return "-" + humanize.Bytes(uint64(before-after))
+}
+
 // KeyHelp indicates all the possible actions a user can take while the current pane is selected.
 func (view *LayerView) KeyHelp() string {
+	if view.comparison != nil {
+		return ""
+	}
 	return renderStatusOption(view.keybindingCompareLayer[0].String(), "Show layer changes", view.CompareMode == CompareLayer) +
 		renderStatusOption(view.keybindingCompareAll[0].String(), "Show aggregated changes", view.CompareMode == CompareAll)
 }
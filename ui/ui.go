@@ -3,6 +3,7 @@ package ui
 import (
 	"errors"
 	"fmt"
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/jroimartin/gocui"
 	"github.com/sirupsen/logrus"
@@ -40,6 +41,7 @@ var Formatting struct {
 	StatusControlNormal   func(...interface{}) string
 	CompareTop            func(...interface{}) string
 	CompareBottom         func(...interface{}) string
+	Warning               func(...interface{}) string
 }
 
 // Views contains all rendered UI panes.
@@ -209,7 +211,7 @@ func layout(g *gocui.Gui) error {
 	statusBarIndex := 1
 	filterBarIndex := 2
 
-	layersHeight := len(Views.Layer.Layers) + headerRows + 1 // layers + header + base image layer row
+	layersHeight := Views.Layer.rows() + headerRows + 1 // layers + header + base image layer row
 	maxLayerHeight := int(0.75 * float64(maxY))
 	if layersHeight > maxLayerHeight {
 		layersHeight = maxLayerHeight
@@ -300,9 +302,96 @@ func renderStatusOption(control, title string, selected bool) string {
 	}
 }
 
+// Comparison is an image-to-image comparison (see filetree.CompareImages), shown in place of the layers of a single
+// image by RunCompare.
+type Comparison struct {
+	Base      string
+	Image     string
+	Tree      *filetree.FileTree
+	Summaries []filetree.ChangeSummary
+	Platform  string
+}
+
 // Run is the UI entrypoint.
-func Run(layers []*image.Layer, refTrees []*filetree.FileTree, efficiency float64, inefficiencies filetree.EfficiencySlice) {
+func Run(layers []*image.Layer, refTrees []*filetree.FileTree, efficiency float64, inefficiencies filetree.EfficiencySlice, platform string) {
+	g := newGui()
+	defer g.Close()
+
+	Views.Layer = NewLayerView("side", g, layers)
+	Views.Layer.metadataRewrites = filetree.FindMetadataRewrites(refTrees)
+	Views.lookup[Views.Layer.Name] = Views.Layer
+
+	Views.Tree = NewFileTreeView("main", g, filetree.StackRange(refTrees, 0, 0), refTrees)
+	Views.lookup[Views.Tree.Name] = Views.Tree
+
+	Views.Status = NewStatusView("status", g)
+	Views.lookup[Views.Status.Name] = Views.Status
+
+	Views.Filter = NewFilterView("command", g)
+	Views.lookup[Views.Filter.Name] = Views.Filter
+
+	Views.Details = NewDetailsView("details", g, efficiency, inefficiencies, platform)
+	Views.Details.duplicates = filetree.FindDuplicates(refTrees)
+	wastedLimit, err := filetree.ParseWastedLimit(viper.GetString("efficiency.wasted-files"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.wasted-files': " + err.Error())
+		utils.Exit(1)
+	}
+	wasted := filetree.WastedFiles(refTrees, inefficiencies, Views.Details.duplicates)
+	Views.Details.wasted = wasted.Top(wastedLimit)
+	Views.Details.wastedDirs = wasted.Directories().Top(wastedLimit)
+	Views.Details.churn = filetree.FindChurn(refTrees)
+	removable, err := filetree.FindRemovable(filetree.StackRange(refTrees, 0, len(refTrees)-1), filetree.RemovablePatterns(viper.GetStringSlice("efficiency.removable-paths")), filetree.MinRemovableSize)
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.removable-paths': " + err.Error())
+		utils.Exit(1)
+	}
+	Views.Details.removable = removable
+	Views.Details.permissions = filetree.FindPermissionChanges(refTrees)
+	Views.Details.layerLimits = image.LayerLimits{
+		MaxFiles:       viper.GetInt("layer.max-files"),
+		SizeDivergence: viper.GetFloat64("layer.size-divergence"),
+	}
+	largeFileSize, err := humanize.ParseBytes(viper.GetString("efficiency.large-file-size"))
+	if err != nil {
+		logrus.Error("Invalid config value for 'efficiency.large-file-size': " + err.Error())
+		utils.Exit(1)
+	}
+	Views.Details.largeFileSize = largeFileSize
+	Views.Details.largeFiles = filetree.LargeFiles(refTrees, int64(largeFileSize))
+	Views.lookup[Views.Details.Name] = Views.Details
+
+	mainLoop(g)
+}
+
+// RunCompare is the UI entrypoint for image-to-image comparisons: the tree pane shows the compared tree, and the layer
+// pane the changes beneath each top-level directory. The given trees are the final trees of the base and compared
+// images.
+func RunCompare(comparison Comparison, refTrees []*filetree.FileTree) {
+	g := newGui()
+	defer g.Close()
+
+	Views.Layer = NewLayerView("side", g, nil)
+	Views.Layer.comparison = &comparison
+	Views.lookup[Views.Layer.Name] = Views.Layer
 
+	Views.Tree = NewFileTreeView("main", g, comparison.Tree, refTrees)
+	Views.lookup[Views.Tree.Name] = Views.Tree
+
+	Views.Status = NewStatusView("status", g)
+	Views.lookup[Views.Status.Name] = Views.Status
+
+	Views.Filter = NewFilterView("command", g)
+	Views.lookup[Views.Filter.Name] = Views.Filter
+
+	Views.Details = NewDetailsView("details", g, 0, nil, comparison.Platform)
+	Views.lookup[Views.Details.Name] = Views.Details
+
+	mainLoop(g)
+}
+
+// newGui sets up the formatting and global keybindings and creates the [gocui] screen object the views are attached to.
+func newGui() *gocui.Gui {
 	Formatting.Selected = color.New(color.ReverseVideo, color.Bold).SprintFunc()
 	Formatting.Header = color.New(color.Bold).SprintFunc()
 	Formatting.StatusSelected = color.New(color.BgMagenta, color.FgWhite).SprintFunc()
@@ -311,35 +400,29 @@ func Run(layers []*image.Layer, refTrees []*filetree.FileTree, efficiency float6
 	Formatting.StatusControlNormal = color.New(color.ReverseVideo, color.Bold).SprintFunc()
 	Formatting.CompareTop = color.New(color.BgMagenta).SprintFunc()
 	Formatting.CompareBottom = color.New(color.BgGreen).SprintFunc()
+	Formatting.Warning = color.New(color.FgRed, color.Bold).SprintFunc()
 
 	GlobalKeybindings.quit = getKeybindings(viper.GetString("keybinding.quit"))
 	GlobalKeybindings.toggleView = getKeybindings(viper.GetString("keybinding.toggle-view"))
 	GlobalKeybindings.filterView = getKeybindings(viper.GetString("keybinding.filter-files"))
 
+	// while the UI owns the terminal only errors are logged, unless another level is configured
+	if viper.GetString("log.level") == "" {
+		logrus.SetLevel(logrus.ErrorLevel)
+	}
+
 	g, err := gocui.NewGui(gocui.OutputNormal)
 	if err != nil {
 		log.Panicln(err)
 	}
 	utils.SetUi(g)
-	defer g.Close()
 
 	Views.lookup = make(map[string]View)
+	return g
+}
 
-	Views.Layer = NewLayerView("side", g, layers)
-	Views.lookup[Views.Layer.Name] = Views.Layer
-
-	Views.Tree = NewFileTreeView("main", g, filetree.StackRange(refTrees, 0, 0), refTrees)
-	Views.lookup[Views.Tree.Name] = Views.Tree
-
-	Views.Status = NewStatusView("status", g)
-	Views.lookup[Views.Status.Name] = Views.Status
-
-	Views.Filter = NewFilterView("command", g)
-	Views.lookup[Views.Filter.Name] = Views.Filter
-
-	Views.Details = NewDetailsView("details", g, efficiency, inefficiencies)
-	Views.lookup[Views.Details.Name] = Views.Details
-
+// mainLoop renders the views and handles user input until the user quits.
+func mainLoop(g *gocui.Gui) {
 	g.Cursor = false
 	//g.Mouse = true
 	g.SetManagerFunc(layout)
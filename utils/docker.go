@@ -1,17 +1,34 @@
 package utils
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 )
 
+// buildImagePatterns match the lines of a build output naming the built image (as their first submatch): the
+// classic Docker builder, BuildKit, then podman (which ends its output with the image ID)
+var buildImagePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^Successfully built ([0-9a-f]{12,64})$`),
+	regexp.MustCompile(`writing image (sha256:[0-9a-f]{64})`),
+	regexp.MustCompile(`^([0-9a-f]{64})$`),
+}
+
 // RunDockerCmd runs a given Docker command in the current tty
 func RunDockerCmd(cmdStr string, args ...string) error {
+	return RunEngineCmd("docker", cmdStr, args...)
+}
+
+// RunEngineCmd runs a given command of a container engine CLI (e.g. "podman") in the current tty
+func RunEngineCmd(engine string, cmdStr string, args ...string) error {
 
 	allArgs := cleanArgs(append([]string{cmdStr}, args...))
 
-	cmd := exec.Command("docker", allArgs...)
+	cmd := exec.Command(engine, allArgs...)
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -20,6 +37,64 @@ func RunDockerCmd(cmdStr string, args ...string) error {
 	return cmd.Run()
 }
 
+// RunEngineBuild runs the build command of a container engine CLI (e.g. "podman") in the current tty with the given
+// arguments, returning the ID of the built image: as written to the --iidfile passed to the builder, or else as named
+// by its output. The output is shown untouched: it is only looked at when it does not go to a terminal (the builder
+// would not show it any differently then). Failed builds return the *exec.ExitError of the builder.
+func RunEngineBuild(engine string, args ...string) (string, error) {
+	iidfile, err := ioutil.TempFile("", "dive.*.iid")
+	if err != nil {
+		return "", err
+	}
+	iidfile.Close()
+	defer os.Remove(iidfile.Name())
+
+	allArgs := cleanArgs(append([]string{"build", "--iidfile", iidfile.Name()}, args...))
+	cmd := exec.Command(engine, allArgs...)
+
+	var output bytes.Buffer
+	cmd.Stdout = captureOutput(os.Stdout, &output)
+	cmd.Stderr = captureOutput(os.Stderr, &output)
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	imageID, err := ioutil.ReadFile(iidfile.Name())
+	if err != nil {
+		return "", err
+	}
+	if id := strings.TrimSpace(string(imageID)); id != "" {
+		return id, nil
+	}
+	return BuildImageID(output.Bytes()), nil
+}
+
+// captureOutput returns the writer an output of a command goes to: the given file, also copied to the given buffer
+// when the file is not a terminal.
+func captureOutput(file *os.File, buffer io.Writer) io.Writer {
+	if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return file
+	}
+	return io.MultiWriter(file, buffer)
+}
+
+// BuildImageID returns the ID of the image built according to the given build output (the last one named), empty when
+// there is none.
+func BuildImageID(output []byte) string {
+	var id string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		for _, pattern := range buildImagePatterns {
+			if match := pattern.FindStringSubmatch(line); match != nil {
+				id = match[1]
+				break
+			}
+		}
+	}
+	return id
+}
+
 // cleanArgs trims the whitespace from the given set of strings.
 func cleanArgs(s []string) []string {
 	var r []string
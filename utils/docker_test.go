@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+func TestBuildImageID(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	cases := []struct {
+		name, output, expected string
+	}{
+		{"classic", "Step 2/2 : RUN make\n ---> 5f2a2d3c1b0e\nSuccessfully built 5f2a2d3c1b0e\nSuccessfully tagged app:latest\n", "5f2a2d3c1b0e"},
+		{"buildkit", "#8 exporting layers done\n#8 writing image sha256:" + id + " done\n#8 naming to docker.io/library/app:latest done\n", "sha256:" + id},
+		{"buildkit tty", " => => writing image sha256:" + id + "  0.0s\n", "sha256:" + id},
+		{"podman", "STEP 2/2: RUN make\nCOMMIT app\n--> 0123456789a\nSuccessfully tagged localhost/app:latest\n" + id + "\n", id},
+		{"none", "ERROR: failed to solve\n", ""},
+	}
+	for _, test := range cases {
+		if actual := BuildImageID([]byte(test.output)); actual != test.expected {
+			t.Errorf("[%s] expected image ID %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"github.com/jroimartin/gocui"
 	"github.com/k0kubun/go-ansi"
 	"github.com/sirupsen/logrus"
@@ -10,14 +9,20 @@ import (
 
 var ui *gocui.Gui
 
+// cursorHidden indicates if the cursor of the terminal was hidden (see HideCursor)
+var cursorHidden bool
+
+// SetUi registers the UI owning the terminal, until Cleanup closes it. Log entries are held back meanwhile (see
+// Console).
 func SetUi(g *gocui.Gui) {
+	Console.mutex.Lock()
+	defer Console.mutex.Unlock()
 	ui = g
 }
 
 func PrintAndExit(args ...interface{}) {
-	logrus.Println(args...)
 	Cleanup()
-	fmt.Println(args...)
+	logrus.Error(args...)
 	os.Exit(1)
 }
 
@@ -28,8 +33,26 @@ func Exit(rc int) {
 }
 
 func Cleanup() {
+	Console.mutex.Lock()
 	if ui != nil {
 		ui.Close()
+		ui = nil
+	}
+	Console.mutex.Unlock()
+	Console.flush()
+	if cursorHidden {
+		ansi.CursorShow()
+		cursorHidden = false
+	}
+}
+
+// HideCursor hides the cursor of the terminal while the progress of the analysis is shown, until Cleanup. Hiding it
+// writes to stdout: commands writing a report to stdout must redirect it first, and nothing is written when stdout is
+// not a terminal (e.g. piped to another tool).
+func HideCursor() {
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
 	}
-	ansi.CursorShow()
+	ansi.CursorHide()
+	cursorHidden = true
 }
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+// HeadingField is the field marking a log entry as the heading of a step (e.g. "Analyzing Image"), shown in bold on
+// the terminal (see ConsoleFormatter) and as plain text elsewhere
+const HeadingField = "heading"
+
+// consoleWriter writes the log entries shown on the terminal to stderr, holding them back while the UI owns the
+// terminal (see SetUi) so they do not garble the screen: they are written once the UI is closed (see Cleanup)
+type consoleWriter struct {
+	mutex sync.Mutex
+	held  bytes.Buffer
+}
+
+// Console is where the log entries shown on the terminal are written (see consoleWriter)
+var Console = &consoleWriter{}
+
+func (console *consoleWriter) Write(p []byte) (int, error) {
+	console.mutex.Lock()
+	defer console.mutex.Unlock()
+	if ui != nil {
+		return console.held.Write(p)
+	}
+	return os.Stderr.Write(p)
+}
+
+// flush writes the entries held back while the UI owned the terminal
+func (console *consoleWriter) flush() {
+	console.mutex.Lock()
+	defer console.mutex.Unlock()
+	if console.held.Len() > 0 {
+		os.Stderr.Write(console.held.Bytes())
+		console.held.Reset()
+	}
+}
+
+// ConsoleFormatter formats log entries for the terminal, where they make up the progress and error output of dive:
+// info and error entries are shown as their message (they are phrased for users), warnings prefixed with "Warning:",
+// and the other levels with their level. Headings (see HeadingField) are bold. Fields follow the message as key=value
+// pairs.
+type ConsoleFormatter struct{}
+
+// Format renders a single log entry as a line.
+func (ConsoleFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var out bytes.Buffer
+	switch entry.Level {
+	case logrus.InfoLevel, logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
+	case logrus.WarnLevel:
+		out.WriteString("  Warning: ")
+	default:
+		out.WriteString("  " + entry.Level.String() + ": ")
+	}
+	if heading, _ := entry.Data[HeadingField].(bool); heading {
+		out.WriteString(color.New(color.Bold).Sprint(entry.Message))
+	} else {
+		out.WriteString(entry.Message)
+	}
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		if key != HeadingField {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&out, " %s=%v", key, entry.Data[key])
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// fileHook copies every log entry to a log file, with its time and level
+type fileHook struct {
+	mutex     sync.Mutex
+	writer    io.Writer
+	formatter logrus.Formatter
+}
+
+// NewFileHook returns a hook copying every log entry (that the level of the logger lets through) to the given writer.
+func NewFileHook(writer io.Writer) logrus.Hook {
+	return &fileHook{
+		writer:    writer,
+		formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true},
+	}
+}
+
+func (hook *fileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *fileHook) Fire(entry *logrus.Entry) error {
+	line, err := hook.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	_, err = hook.writer.Write(line)
+	return err
+}
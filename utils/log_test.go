@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+func TestConsoleFormatter(t *testing.T) {
+	cases := []struct {
+		level    logrus.Level
+		message  string
+		data     logrus.Fields
+		expected string
+	}{
+		{logrus.InfoLevel, "  Building tree...", nil, "  Building tree...\n"},
+		{logrus.ErrorLevel, "Could not read the image", nil, "Could not read the image\n"},
+		{logrus.WarnLevel, "could not fully read layer", nil, "  Warning: could not fully read layer\n"},
+		{logrus.DebugLevel, "reading /etc", logrus.Fields{"layer": 2, "size": 0}, "  debug: reading /etc layer=2 size=0\n"},
+	}
+	for _, test := range cases {
+		line, err := ConsoleFormatter{}.Format(&logrus.Entry{Level: test.level, Message: test.message, Data: test.data})
+		if err != nil {
+			t.Fatalf("could not format: %v", err)
+		}
+		if string(line) != test.expected {
+			t.Errorf("[%s] expected %q, got %q", test.level, test.expected, line)
+		}
+	}
+
+	// headings are bold, without showing the field
+	noColor := color.NoColor
+	defer func() { color.NoColor = noColor }()
+	color.NoColor = false
+	line, _ := ConsoleFormatter{}.Format(&logrus.Entry{Level: logrus.InfoLevel, Message: "Analyzing Image", Data: logrus.Fields{HeadingField: true}})
+	if expected := "\x1b[1mAnalyzing Image\x1b[0m\n"; string(line) != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+func TestFileHook(t *testing.T) {
+	var out bytes.Buffer
+	hook := NewFileHook(&out)
+	if len(hook.Levels()) != len(logrus.AllLevels) {
+		t.Errorf("expected every level to be written, got %v", hook.Levels())
+	}
+	if err := hook.Fire(&logrus.Entry{Level: logrus.WarnLevel, Message: "could not fully read layer", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("could not write the entry: %v", err)
+	}
+	if !strings.Contains(out.String(), "could not fully read layer") || !strings.Contains(out.String(), "level=warning") {
+		t.Errorf("unexpected log line: %q", out.String())
+	}
+}